@@ -52,7 +52,48 @@ var connectCmd = &cobra.Command{
 	},
 }
 
+var uninstallChangeLogCmd = &cobra.Command{
+	Use:   "uninstall-change-log {json-settings} {schema-id}",
+	Short: "Drops the change log table, sequence and trigger installed for a table by the Change Log Triggers real time mode.",
+	Args:cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		_, err := server.Connect(context.Background(), &pub.ConnectRequest{
+			SettingsJson:args[0],
+		})
+		if err != nil {
+			return err
+		}
+
+		return server.(*internal.Server).UninstallChangeLog(context.Background(), args[1])
+	},
+}
+
+var replayDeadLettersCmd = &cobra.Command{
+	Use:   "replay-dead-letters {json-settings} {json-schema}",
+	Short: "Writes the dead letters of a write schema, as returned by ConfigureWrite, which have their STATUS set to REPLAY.",
+	Args:cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		var schema pub.Schema
+		if err := json.Unmarshal([]byte(args[1]), &schema); err != nil {
+			return err
+		}
+
+		_, err := server.Connect(context.Background(), &pub.ConnectRequest{
+			SettingsJson:args[0],
+		})
+		if err != nil {
+			return err
+		}
+
+		return server.(*internal.Server).ReplayDeadLetters(context.Background(), &schema)
+	},
+}
+
 func init(){
 	debugCmd.AddCommand(connectCmd)
+	debugCmd.AddCommand(uninstallChangeLogCmd)
+	debugCmd.AddCommand(replayDeadLettersCmd)
 	RootCmd.AddCommand(debugCmd)
 }
\ No newline at end of file
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// changeLogVersion is the version of the change log objects generated by ChangeLog.
+// It must be incremented whenever the generated DDL changes, so that
+// installed change logs are upgraded.
+const changeLogVersion = 1
+
+// The columns added to the change log table in front of the copies of the table's columns.
+const (
+	changeLogIDColumn        = `"NAVEEGO$CHANGE_ID"`
+	changeLogOperationColumn = `"NAVEEGO$OPERATION"`
+	changeLogChangedAtColumn = `"NAVEEGO$CHANGED_AT"`
+)
+
+// ChangeLog generates the DDL of the objects which capture the changes to a table
+// using triggers: a sequence, a change log table with a copy of each column
+// of the table, and an AFTER trigger which writes each changed row to the change log.
+type ChangeLog struct {
+	Owner   string
+	Table   string
+	Columns []ChangeLogColumn
+}
+
+// ChangeLogColumn is a column of the table whose changes are captured.
+type ChangeLogColumn struct {
+	Name string
+	// Type is the full data type, such as VARCHAR2(40 CHAR).
+	Type string
+}
+
+// InstalledChangeLog describes change log objects which already exist.
+type InstalledChangeLog struct {
+	Version int
+	// ColumnsHash identifies the columns the trigger was generated for.
+	ColumnsHash string
+	// Columns are the columns in the change log table,
+	// other than the columns added by the change log.
+	Columns []ChangeLogColumn
+}
+
+// TableName returns the name of the change log table. Object names are derived
+// from a hash of the table so that they fit in 30 characters.
+func (c ChangeLog) TableName() string {
+	return fmt.Sprintf("NG$CL_%s", c.hash())
+}
+
+func (c ChangeLog) SequenceName() string {
+	return fmt.Sprintf("NG$CS_%s", c.hash())
+}
+
+func (c ChangeLog) TriggerName() string {
+	return fmt.Sprintf("NG$CT_%s", c.hash())
+}
+
+// Comment returns the comment which identifies the change log table, its version
+// and the columns its trigger was generated for.
+func (c ChangeLog) Comment() string {
+	return fmt.Sprintf("Naveego change log v%d for %s columns %s", changeLogVersion, c.source(), c.ColumnsHash())
+}
+
+// ParseChangeLogComment parses the version and columns hash from a change log table's comment.
+func ParseChangeLogComment(comment string) (InstalledChangeLog, bool) {
+	var installed InstalledChangeLog
+	if _, err := fmt.Sscanf(comment, "Naveego change log v%d", &installed.Version); err != nil {
+		return installed, false
+	}
+	if i := strings.LastIndex(comment, " columns "); i >= 0 {
+		installed.ColumnsHash = comment[i+len(" columns "):]
+	}
+	return installed, true
+}
+
+// ColumnsHash returns a hash of the names and types of the columns.
+func (c ChangeLog) ColumnsHash() string {
+	h := crc32.NewIEEE()
+	for _, col := range c.Columns {
+		fmt.Fprintf(h, "%s %s\n", col.Name, col.Type)
+	}
+	return fmt.Sprintf("%08X", h.Sum32())
+}
+
+func (c ChangeLog) hash() string {
+	return fmt.Sprintf("%08X", crc32.ChecksumIEEE([]byte(c.source())))
+}
+
+func (c ChangeLog) source() string {
+	return fmt.Sprintf(`"%s"."%s"`, c.Owner, c.Table)
+}
+
+func (c ChangeLog) qualify(name string) string {
+	return fmt.Sprintf(`"%s"."%s"`, c.Owner, name)
+}
+
+// InstallDDL returns the statements which create the change log.
+func (c ChangeLog) InstallDDL() []string {
+	var columns []string
+	columns = append(columns,
+		fmt.Sprintf("  %s NUMBER NOT NULL PRIMARY KEY", changeLogIDColumn),
+		fmt.Sprintf("  %s CHAR(1) NOT NULL", changeLogOperationColumn),
+		fmt.Sprintf("  %s TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL", changeLogChangedAtColumn))
+	for _, col := range c.Columns {
+		columns = append(columns, fmt.Sprintf(`  "%s" %s`, col.Name, col.Type))
+	}
+
+	return []string{
+		fmt.Sprintf(`CREATE SEQUENCE %s CACHE 1000`, c.qualify(c.SequenceName())),
+		// ROWDEPENDENCIES gives each row its own ORA_ROWSCN, so that the rows committed
+		// after a checkpoint can be selected and the rows before it purged
+		fmt.Sprintf("CREATE TABLE %s\n(\n%s\n) ROWDEPENDENCIES", c.qualify(c.TableName()), strings.Join(columns, ",\n")),
+		c.commentDDL(),
+		c.triggerDDL(),
+	}
+}
+
+// UninstallDDL returns the statements which drop the change log.
+func (c ChangeLog) UninstallDDL() []string {
+	return []string{
+		fmt.Sprintf(`DROP TRIGGER %s`, c.qualify(c.TriggerName())),
+		fmt.Sprintf(`DROP TABLE %s PURGE`, c.qualify(c.TableName())),
+		fmt.Sprintf(`DROP SEQUENCE %s`, c.qualify(c.SequenceName())),
+	}
+}
+
+// UpgradeDDL returns the statements which bring an installed change log up to date
+// with this version and with the columns of the table, or nothing if it is up to date.
+// Columns which were added to the table are added to the change log table, and columns
+// whose type changed, such as a widened VARCHAR2, are modified to the new type. Columns which
+// were dropped from the table are left in the change log table, but are no longer written.
+func (c ChangeLog) UpgradeDDL(installed InstalledChangeLog) []string {
+	existing := make(map[string]string, len(installed.Columns))
+	for _, col := range installed.Columns {
+		existing[col.Name] = col.Type
+	}
+
+	var added, modified []string
+	for _, col := range c.Columns {
+		columnType, ok := existing[col.Name]
+		switch {
+		case !ok:
+			added = append(added, fmt.Sprintf(`"%s" %s`, col.Name, col.Type))
+		case columnType != col.Type:
+			modified = append(modified, fmt.Sprintf(`"%s" %s`, col.Name, col.Type))
+		}
+	}
+
+	if installed.Version == changeLogVersion && installed.ColumnsHash == c.ColumnsHash() {
+		return nil
+	}
+
+	var ddl []string
+	if len(added) > 0 {
+		ddl = append(ddl, fmt.Sprintf("ALTER TABLE %s ADD (\n  %s\n)", c.qualify(c.TableName()), strings.Join(added, ",\n  ")))
+	}
+	if len(modified) > 0 {
+		ddl = append(ddl, fmt.Sprintf("ALTER TABLE %s MODIFY (\n  %s\n)", c.qualify(c.TableName()), strings.Join(modified, ",\n  ")))
+	}
+
+	return append(ddl, c.commentDDL(), c.triggerDDL())
+}
+
+func (c ChangeLog) commentDDL() string {
+	return fmt.Sprintf(`COMMENT ON TABLE %s IS '%s'`, c.qualify(c.TableName()), strings.Replace(c.Comment(), "'", "''", -1))
+}
+
+func (c ChangeLog) triggerDDL() string {
+	names := []string{changeLogIDColumn, changeLogOperationColumn}
+	var newValues, oldValues []string
+	for _, col := range c.Columns {
+		names = append(names, fmt.Sprintf(`"%s"`, col.Name))
+		newValues = append(newValues, fmt.Sprintf(`:NEW."%s"`, col.Name))
+		oldValues = append(oldValues, fmt.Sprintf(`:OLD."%s"`, col.Name))
+	}
+
+	insert := func(operation string, values []string) string {
+		return fmt.Sprintf("INSERT INTO %s (%s)\n    VALUES (%s.NEXTVAL, '%s', %s);",
+			c.qualify(c.TableName()),
+			strings.Join(names, ", "),
+			c.qualify(c.SequenceName()),
+			operation,
+			strings.Join(values, ", "))
+	}
+
+	return fmt.Sprintf(`CREATE OR REPLACE TRIGGER %s
+AFTER INSERT OR UPDATE OR DELETE ON %s
+FOR EACH ROW
+BEGIN
+  IF INSERTING THEN
+    %s
+  ELSIF UPDATING THEN
+    %s
+  ELSE
+    %s
+  END IF;
+END;`,
+		c.qualify(c.TriggerName()),
+		c.source(),
+		insert("I", newValues),
+		insert("U", newValues),
+		insert("D", oldValues))
+}
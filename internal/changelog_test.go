@@ -0,0 +1,78 @@
+package internal_test
+
+import (
+	. "github.com/naveego/plugin-oracle/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ChangeLog", func() {
+
+	var sut ChangeLog
+
+	BeforeEach(func() {
+		sut = ChangeLog{
+			Owner: "C##NAVEEGO",
+			Table: "AGENTS",
+			Columns: []ChangeLogColumn{
+				{Name: "AGENT_CODE", Type: "CHAR(4 BYTE)"},
+				{Name: "AGENT_NAME", Type: "VARCHAR2(40 BYTE)"},
+				{Name: "COMMISSION", Type: "BINARY_FLOAT"},
+				{Name: "UPDATED_AT", Type: "TIMESTAMP(6) WITH TIME ZONE"},
+			},
+		}
+	})
+
+	It("should name objects within 30 characters", func() {
+		Expect(sut.TableName()).To(HavePrefix("NG$CL_"))
+		Expect(len(sut.TableName())).To(BeNumerically("<=", 30))
+		Expect(sut.TriggerName()).ToNot(Equal(sut.TableName()))
+	})
+
+	It("should generate install DDL", func() {
+		expectGolden("changelog/install.sql", sut.InstallDDL())
+	})
+
+	It("should generate uninstall DDL", func() {
+		expectGolden("changelog/uninstall.sql", sut.UninstallDDL())
+	})
+
+	It("should generate upgrade DDL for added columns", func() {
+		installed, ok := ParseChangeLogComment("Naveego change log v1 for \"C##NAVEEGO\".\"AGENTS\" columns 00000000")
+		Expect(ok).To(BeTrue())
+		installed.Columns = []ChangeLogColumn{
+			{Name: "AGENT_CODE", Type: "CHAR(4 BYTE)"},
+			{Name: "AGENT_NAME", Type: "VARCHAR2(40 BYTE)"},
+			{Name: "DROPPED", Type: "NUMBER"},
+		}
+
+		expectGolden("changelog/upgrade.sql", sut.UpgradeDDL(installed))
+	})
+
+	It("should generate upgrade DDL for a widened column", func() {
+		installed, ok := ParseChangeLogComment(sut.Comment())
+		Expect(ok).To(BeTrue())
+		installed.Columns = sut.Columns
+		sut.Columns = []ChangeLogColumn{
+			{Name: "AGENT_CODE", Type: "CHAR(4 BYTE)"},
+			{Name: "AGENT_NAME", Type: "VARCHAR2(80 BYTE)"},
+			{Name: "COMMISSION", Type: "BINARY_FLOAT"},
+			{Name: "UPDATED_AT", Type: "TIMESTAMP(6) WITH TIME ZONE"},
+		}
+
+		expectGolden("changelog/upgrade_widened.sql", sut.UpgradeDDL(installed))
+	})
+
+	It("should not upgrade an up to date change log", func() {
+		installed, ok := ParseChangeLogComment(sut.Comment())
+		Expect(ok).To(BeTrue())
+		installed.Columns = sut.Columns
+
+		Expect(sut.UpgradeDDL(installed)).To(BeEmpty())
+	})
+
+	It("should not recognize other comments", func() {
+		_, ok := ParseChangeLogComment("Customer agents")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+)
+
+// This file exposes unexported helpers to the internal_test package.
+
+// WatermarkPoll exposes watermarkPoll for testing.
+type WatermarkPoll struct {
+	poll *watermarkPoll
+}
+
+func NewWatermarkPoll(schema *pub.Schema, settings RealTimeSettings, previous RealTimeState) (*WatermarkPoll, error) {
+	poll, err := newWatermarkPoll(schema, settings, previous)
+	if err != nil {
+		return nil, err
+	}
+	return &WatermarkPoll{poll: poll}, nil
+}
+
+func (w *WatermarkPoll) Condition() (string, error) { return w.poll.condition() }
+
+func (w *WatermarkPoll) Accept(record *pub.Record) (bool, error) { return w.poll.accept(record) }
+
+func (w *WatermarkPoll) State() (RealTimeState, error) { return w.poll.state() }
+
+// FakeChangeNotifier is a changeNotifier whose notifications are sent by the test.
+type FakeChangeNotifier struct {
+	Events chan struct{}
+	Closed bool
+}
+
+func NewFakeChangeNotifier() *FakeChangeNotifier {
+	return &FakeChangeNotifier{Events: make(chan struct{}, 1)}
+}
+
+func (f *FakeChangeNotifier) Notifications() <-chan struct{} { return f.Events }
+
+func (f *FakeChangeNotifier) Close() error {
+	f.Closed = true
+	return nil
+}
+
+// UseChangeNotifier makes the server use the notifier instead of Continuous Query Notification.
+func UseChangeNotifier(server pub.PublisherServer, notifier *FakeChangeNotifier) {
+	server.(*Server).newChangeNotifier = func(ctx context.Context, owner, table string) (changeNotifier, error) {
+		return notifier, nil
+	}
+}
+
+// WaitForNextPoll waits between polls as a real time read does.
+func WaitForNextPoll(ctx context.Context, settings RealTimeSettings, notifier *FakeChangeNotifier) bool {
+	var n changeNotifier
+	if notifier != nil {
+		n = notifier
+	}
+	return newPollWaiter(settings, n).wait(ctx)
+}
+
+// QueueLayout exposes queueLayout for testing.
+type QueueLayout struct {
+	layout queueLayout
+}
+
+func NewQueueLayout(schema *pub.Schema, meta QueueMeta) QueueLayout {
+	return QueueLayout{layout: newQueueLayout(schema, meta)}
+}
+
+func (q QueueLayout) DequeueBlock(settings RealTimeSettings) string {
+	return q.layout.dequeueBlock(settings)
+}
+
+func (q QueueLayout) Record(fields []*string, payload []byte) (*pub.Record, error) {
+	return q.layout.record(pub.Record_INSERT, fields, payload)
+}
+
+func BuildQueueQuery(schema *pub.Schema, meta QueueMeta) string { return buildQueueQuery(schema, meta) }
+
+// JSONPayloadFields returns the names and types of the fields found in the payloads.
+func JSONPayloadFields(payloads [][]byte) ([]string, []pub.PropertyType, bool) {
+	fields, ok := jsonPayloadFields(payloads)
+	var names []string
+	var types []pub.PropertyType
+	for _, f := range fields {
+		names = append(names, f.name)
+		types = append(types, f.propertyType)
+	}
+	return names, types, ok
+}
+
+// PayloadAttribute describes an attribute of a queue's payload type for testing.
+type PayloadAttribute struct {
+	Name      string
+	DataType  string
+	Composite bool
+}
+
+// QueueWriteSchema exposes queueWriteSchema for testing.
+func QueueWriteSchema(queueID string, queue QueueMeta, attributes []PayloadAttribute, fields []QueueField, target QueueWriteMeta) (*pub.Schema, error) {
+	var as []payloadAttribute
+	for _, a := range attributes {
+		as = append(as, payloadAttribute{columnInfo: columnInfo{ColumnName: a.Name, DataType: a.DataType}, composite: a.Composite})
+	}
+	var fs []jsonPayloadField
+	for _, f := range fields {
+		fs = append(fs, jsonPayloadField{name: f.Name, propertyType: jsonFieldTypes[f.Type]})
+	}
+	return queueWriteSchema(queueID, queue, as, fs, target)
+}
+
+func EnqueueArgs(schema *pub.Schema, target QueueWriteMeta, record *pub.Record) ([]interface{}, error) {
+	return enqueueArgs(schema, target, record)
+}
+
+// ReceiveRecords and NextBatch expose write batching for testing.
+func ReceiveRecords(ctx context.Context, stream pub.Publisher_WriteStreamServer) <-chan receivedRecord {
+	return receiveRecords(ctx, stream)
+}
+
+func NextBatch(ctx context.Context, received <-chan receivedRecord, size int, window time.Duration) ([]*pub.Record, error) {
+	batch, _, err := nextBatch(ctx, received, size, window, nil)
+	return batch, err
+}
+
+// TableStatements returns the statements which write to the table, in the order of their actions.
+func TableStatements(target TableWriteMeta, properties ...*pub.Property) ([]string, error) {
+	if _, err := tableStatements(target, properties); err != nil {
+		return nil, err
+	}
+	return tableStatementList(target, properties), nil
+}
+
+func TableArgs(schema *pub.Schema, target TableWriteMeta, record *pub.Record) (string, []interface{}, error) {
+	statements, err := tableStatements(target, schema.Properties)
+	if err != nil {
+		return "", nil, err
+	}
+	return tableArgs(schema, statements, record)
+}
+
+// UseDB makes the server write to the database as if it had connected to it.
+func UseDB(server pub.PublisherServer, db *sql.DB) {
+	s := server.(*Server)
+	s.db = db
+	s.connected = true
+}
+
+// UseSettings makes the server use the settings as if it had connected with them.
+func UseSettings(server pub.PublisherServer, settings *Settings) {
+	server.(*Server).settings = settings
+}
+
+// ClassifyError returns "transient" if an operation which failed with the error should be retried, otherwise "permanent".
+func ClassifyError(err error) string { return classifyError(err).String() }
+
+// WriteRetryWaits returns how long write-back waits after each failed attempt of a batch.
+func WriteRetryWaits(settings *Settings) []time.Duration {
+	policy := settings.writeRetryPolicy()
+	var waits []time.Duration
+	for attempt := 1; attempt < policy.attempts; attempt++ {
+		waits = append(waits, policy.wait(attempt))
+	}
+	return waits
+}
+
+// DeadLetterDDL returns the statements which create a dead-letter table.
+func DeadLetterDDL(table string) []string { return deadLetterDDL(table) }
+
+// IdempotencyDDL returns the statements which create a table for tracking correlation IDs.
+func IdempotencyDDL(table string) []string { return idempotencyDDL(table) }
+
+// OracleColumnType returns the type of the column created for the property, with a string of the length.
+func OracleColumnType(p *pub.Property, length int) (string, error) {
+	column, err := oracleColumn(p, length)
+	return column.TypeAtSource(), err
+}
+
+// ReadBackType returns the type of the property read from the column created for the property.
+func ReadBackType(p *pub.Property) pub.PropertyType {
+	column, err := oracleColumn(p, 0)
+	if err != nil {
+		panic(err)
+	}
+	return convertSQLType(column)
+}
+
+// ConvertSQLType returns the type of the property read from a column of the data type, with the length
+// of a string, or the precision and scale of a number.
+func ConvertSQLType(dataType string, size ...int64) pub.PropertyType {
+	c := columnInfo{DataType: dataType}
+	switch {
+	case len(size) == 1:
+		c.DataLength = &size[0]
+	case len(size) == 2:
+		c.DataPrecision, c.DataScale = &size[0], &size[1]
+	}
+	return convertSQLType(c)
+}
+
+// CreateTableDDL returns the statement which creates a table with a column for each property.
+func CreateTableDDL(table string, properties []*pub.Property, lengths []int, tablespace, compression string) (string, error) {
+	var columns []columnInfo
+	for i, p := range properties {
+		column, err := oracleColumn(p, lengths[i])
+		if err != nil {
+			return "", err
+		}
+		columns = append(columns, column)
+	}
+	return createTableDDL(table, columns, tableStorage{tablespace: tablespace, compression: tableCompressions[compression]}), nil
+}
+
+// StagingDDL returns the statements which create the staging table of the table write.
+func StagingDDL(target TableWriteMeta, notNull []string) []string {
+	return stagingDDL(target, notNull)(target.Staging.Table)
+}
+
+// StagingStatements returns the statements which insert records into the staging
+// table of the table write, conventionally and by direct path, and merge them.
+func StagingStatements(target TableWriteMeta) []string {
+	return append([]string{stagingInsert(target, nil, false), stagingInsert(target, nil, true)}, stagingMerge(target)...)
+}
+
+// KeyPartition returns the partition a record is written by when the schema is written concurrently.
+func KeyPartition(schema *pub.Schema, partitions int, record *pub.Record) int {
+	return keyPartitioner(schema, partitions)(record)
+}
+
+// ProcedureArgument describes an argument of a stored procedure for testing.
+type ProcedureArgument struct {
+	Name      string
+	DataType  string
+	Length    string
+	InOut     string
+	Position  int
+	Defaulted bool
+	Level     int
+	TypeName  string
+}
+
+func procedureArguments(arguments []ProcedureArgument) []procedureArgument {
+	var as []procedureArgument
+	for _, a := range arguments {
+		as = append(as, procedureArgument{
+			name:      a.Name,
+			dataType:  a.DataType,
+			length:    sql.NullString{String: a.Length, Valid: a.Length != ""},
+			inOut:     a.InOut,
+			position:  a.Position,
+			defaulted: a.Defaulted,
+			level:     a.Level,
+			typeName:  a.TypeName,
+		})
+	}
+	return nestArguments(as)
+}
+
+// ProcedureCallFor exposes procedureCall for testing.
+func ProcedureCallFor(procedure string, arguments []ProcedureArgument) ProcedureCall {
+	return procedureCall(procedure, procedureArguments(arguments))
+}
+
+// PackagedProcedureCallFor returns the call of an overload of a packaged procedure.
+func PackagedProcedureCallFor(owner, pkg, name, overload string, arguments []ProcedureArgument) (ProcedureCall, error) {
+	p := storedProcedure{owner: owner, pkg: pkg, name: name, overload: overload}
+	return p.call(procedureArguments(arguments))
+}
+
+func ProcedureSignature(arguments []ProcedureArgument) string {
+	return procedureSignature(procedureArguments(arguments))
+}
+
+// WriteValue converts a value from a record's data as it is decoded by the write back.
+func WriteValue(prop *pub.Property, dataJson string) (interface{}, error) {
+	data, err := decodeRecordData(&pub.Record{DataJson: `{"value":` + dataJson + `}`})
+	if err != nil {
+		return nil, err
+	}
+	return writeValue(prop, data["value"])
+}
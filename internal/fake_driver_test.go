@@ -0,0 +1,356 @@
+package internal_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/gomega"
+	"gopkg.in/goracle.v2"
+)
+
+// newWriteServer returns a server which writes back to a new fakeDB.
+func newWriteServer(log hclog.Logger) (pub.PublisherServer, *fakeDB) {
+	sut := NewServer(log)
+	db, conn := newFakeDB()
+	UseDB(sut, conn)
+	return sut, db
+}
+
+// writeRecord returns a record to write back.
+func writeRecord(action pub.Record_Action, id string, data string) *pub.Record {
+	return &pub.Record{Action: action, CorrelationId: id, DataJson: data}
+}
+
+// writeBack writes the records back to the schema with a commit SLA of a minute, returning their acks.
+func writeBack(sut pub.PublisherServer, schema *pub.Schema, records ...*pub.Record) []*pub.RecordAck {
+	return writeBackWithin(sut, schema, 60, records...)
+}
+
+// writeBackWithin writes the records back to the schema with the commit SLA, returning their acks.
+func writeBackWithin(sut pub.PublisherServer, schema *pub.Schema, commitSLA int32, records ...*pub.Record) []*pub.RecordAck {
+	stream := &writeStream{records: records}
+	Expect(writeStreamBack(sut, schema, commitSLA, stream)).To(Succeed())
+	return stream.recordAcks
+}
+
+// writeStreamBack writes the stream back to the schema with the commit SLA.
+func writeStreamBack(sut pub.PublisherServer, schema *pub.Schema, commitSLA int32, stream pub.Publisher_WriteStreamServer) error {
+	_, err := sut.PrepareWrite(context.Background(), &pub.PrepareWriteRequest{Schema: schema, CommitSlaSeconds: commitSLA})
+	Expect(err).ToNot(HaveOccurred())
+	return sut.WriteStream(stream)
+}
+
+// fakeDB is a database which records the statements executed against it. Each row of an
+// array bind is written in turn, and an execution stops at the first row which fails,
+// keeping the rows before it, as Oracle does without batch error mode. The rows written
+// by each connection are pending until its transaction is committed or rolled back.
+type fakeDB struct {
+	mu sync.Mutex
+	// fail returns the error for a row of a statement, or nil if it can be written.
+	fail func(query string, row map[string]interface{}) error
+	// out returns the value of an OUT bind after a row has been written.
+	out func(name string, row map[string]interface{}) string
+	// query returns the columns and rows of a query, which return nothing if it is not set.
+	query func(query string, args map[string]interface{}) ([]string, [][]driver.Value)
+	// delay is how long a statement takes to execute, during which it can be cancelled.
+	delay func(query string) time.Duration
+	// commitDelay is how long a commit takes, which cannot be cancelled.
+	commitDelay time.Duration
+	// commitErr is returned by a commit after it has committed, as when the connection is lost.
+	commitErr error
+
+	executions []fakeExecution
+	pending    []fakeRow
+	committed  []fakeRow
+}
+
+// fakeExecution is an execution of a statement, with the number of rows bound to it.
+type fakeExecution struct {
+	query string
+	rows  int
+}
+
+// fakeRow is a row written by a statement, with the values bound to it by name.
+type fakeRow struct {
+	query  string
+	values map[string]interface{}
+	conn   *fakeConn
+}
+
+func newFakeDB() (*fakeDB, *sql.DB) {
+	f := &fakeDB{}
+	return f, sql.OpenDB(f)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+
+func (f *fakeDB) Driver() driver.Driver { return nil }
+
+// Executions returns the queries executed, other than savepoints.
+func (f *fakeDB) Executions() []fakeExecution {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var executions []fakeExecution
+	for _, e := range f.executions {
+		if !strings.Contains(e.query, "SAVEPOINT") {
+			executions = append(executions, e)
+		}
+	}
+	return executions
+}
+
+func (f *fakeDB) Committed() []fakeRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeRow{}, f.committed...)
+}
+
+// CommittedBy returns the committed rows written by statements with the prefix.
+func (f *fakeDB) CommittedBy(prefix string) []fakeRow {
+	var rows []fakeRow
+	for _, row := range f.Committed() {
+		if strings.HasPrefix(row.query, prefix) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func (f *fakeDB) exec(conn *fakeConn, query string, args []driver.NamedValue) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "SAVEPOINT "):
+		conn.savepoint = len(f.pendingOf(conn))
+		f.executions = append(f.executions, fakeExecution{query: query})
+		return nil
+	case strings.HasPrefix(query, "ROLLBACK TO SAVEPOINT "):
+		f.rollback(conn, conn.savepoint)
+		f.executions = append(f.executions, fakeExecution{query: query})
+		return nil
+	}
+
+	rows := fakeRows(args)
+	f.executions = append(f.executions, fakeExecution{query: query, rows: len(rows)})
+	for _, row := range rows {
+		if f.fail != nil {
+			if err := f.fail(query, row); err != nil {
+				return err
+			}
+		}
+		f.pending = append(f.pending, fakeRow{query: query, values: row, conn: conn})
+	}
+
+	for _, a := range args {
+		if out, ok := a.Value.(sql.Out); ok && f.out != nil {
+			if dest, ok := out.Dest.(*string); ok {
+				*dest = f.out(a.Name, rows[0])
+			}
+		}
+	}
+	return nil
+}
+
+// pendingOf returns the rows written by the connection which have not been committed.
+func (f *fakeDB) pendingOf(conn *fakeConn) []fakeRow {
+	var rows []fakeRow
+	for _, r := range f.pending {
+		if r.conn == conn {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// rollback discards the rows written by the connection after the first keep rows.
+func (f *fakeDB) rollback(conn *fakeConn, keep int) {
+	var pending []fakeRow
+	for _, r := range f.pending {
+		if r.conn != conn {
+			pending = append(pending, r)
+		} else if keep > 0 {
+			pending = append(pending, r)
+			keep--
+		}
+	}
+	f.pending = pending
+}
+
+// fakeRows splits the binds into a row for each element of their arrays.
+func fakeRows(args []driver.NamedValue) []map[string]interface{} {
+	n := 1
+	for _, a := range args {
+		if v := reflect.ValueOf(a.Value); v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+			n = v.Len()
+		}
+	}
+
+	rows := make([]map[string]interface{}, n)
+	for i := range rows {
+		rows[i] = make(map[string]interface{})
+		for _, a := range args {
+			value := a.Value
+			if v := reflect.ValueOf(value); v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+				value = v.Index(i).Interface()
+			}
+			rows[i][a.Name] = fakeValue(value)
+		}
+	}
+	return rows
+}
+
+// fakeValue converts an element of an array to the value bound for a single row.
+func fakeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case sql.Out:
+		if !v.In {
+			return nil
+		}
+		return fakeValue(reflect.ValueOf(v.Dest).Elem().Interface())
+	case sql.NullFloat64:
+		if v.Valid {
+			return v.Float64
+		}
+		return nil
+	case sql.NullInt64:
+		if v.Valid {
+			return int(v.Int64)
+		}
+		return nil
+	case string:
+		if v == "" {
+			return nil
+		}
+	case goracle.Number:
+		if v == "" {
+			return nil
+		}
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+	case goracle.Lob:
+		// the driver reads a LOB from its reader when it executes the statement
+		b, _ := ioutil.ReadAll(v.Reader)
+		if v.IsClob {
+			return string(b)
+		}
+		return b
+	}
+	return value
+}
+
+type fakeConn struct {
+	db        *fakeDB
+	savepoint int
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+// fakeStmt is a prepared statement, which is executed or queried as its connection executes or queries it.
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error { return nil }
+
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec without a context is not supported")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("query without a context is not supported")
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *fakeConn) Commit() error {
+	time.Sleep(c.db.commitDelay)
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.committed = append(c.db.committed, c.db.pendingOf(c)...)
+	c.db.rollback(c, 0)
+	return c.db.commitErr
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.rollback(c, 0)
+	return nil
+}
+
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.db.delay != nil {
+		select {
+		case <-time.After(c.db.delay(query)):
+		case <-ctx.Done():
+			return nil, errors.New("ORA-01013: user requested cancel of current operation")
+		}
+	}
+	if err := c.db.exec(c, query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := make(map[string]interface{})
+	for _, a := range args {
+		values[a.Name] = a.Value
+	}
+
+	rows := &fakeQueryRows{}
+	if c.db.query != nil {
+		rows.columns, rows.rows = c.db.query(query, values)
+	}
+	return rows, nil
+}
+
+// fakeQueryRows are the rows returned by a query.
+type fakeQueryRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeQueryRows) Columns() []string { return r.columns }
+
+func (r *fakeQueryRows) Close() error { return nil }
+
+func (r *fakeQueryRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
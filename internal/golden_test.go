@@ -0,0 +1,27 @@
+package internal_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	. "github.com/onsi/gomega"
+)
+
+// expectGolden compares the statements with a golden file under test.
+// Set UPDATE_GOLDEN=1 to rewrite the golden files.
+func expectGolden(name string, statements []string) {
+	_, thisPath, _, _ := runtime.Caller(0)
+	path := filepath.Join(thisPath, "../../test", name)
+	actual := strings.Join(statements, "\n/\n") + "\n/\n"
+
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		Expect(ioutil.WriteFile(path, []byte(actual), 0666)).To(Succeed())
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(actual).To(Equal(string(expected)))
+}
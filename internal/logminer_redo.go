@@ -0,0 +1,434 @@
+package internal
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// Operation codes of V$LOGMNR_CONTENTS.
+const (
+	logMinerInsert      = 1
+	logMinerDelete      = 2
+	logMinerUpdate      = 3
+	logMinerCommit      = 7
+	logMinerUnsupported = 255
+)
+
+// The NLS formats set on the mining session, which determine how
+// date and time values are written in SQL_REDO.
+const (
+	logMinerDateFormat        = "YYYY-MM-DD HH24:MI:SS"
+	logMinerTimestampFormat   = "YYYY-MM-DD HH24:MI:SS.FF9"
+	logMinerTimestampTZFormat = "YYYY-MM-DD HH24:MI:SS.FF9 TZH:TZM"
+)
+
+// LogMinerRow is a row of V$LOGMNR_CONTENTS.
+type LogMinerRow struct {
+	SCN           uint64 `json:"SCN"`
+	CommitSCN     uint64 `json:"COMMIT_SCN"`
+	XID           string `json:"XID"`
+	OperationCode int    `json:"OPERATION_CODE"`
+	Operation     string `json:"OPERATION"`
+	SegOwner      string `json:"SEG_OWNER"`
+	TableName     string `json:"TABLE_NAME"`
+	RowID         string `json:"ROW_ID"`
+	SQLRedo       string `json:"SQL_REDO"`
+	// CSF is set when SQL_REDO is continued in the next row.
+	CSF int `json:"CSF"`
+}
+
+// RedoTranslator translates the redo of a table, as mined by LogMiner,
+// into records of the table's schema.
+type RedoTranslator struct {
+	properties map[string]*pub.Property
+	// continued holds the start of a SQL_REDO statement which is split across rows.
+	continued *LogMinerRow
+}
+
+func NewRedoTranslator(schema *pub.Schema) *RedoTranslator {
+	t := &RedoTranslator{
+		properties: make(map[string]*pub.Property, len(schema.Properties)),
+	}
+	for _, p := range schema.Properties {
+		t.properties[p.Id] = p
+	}
+	return t
+}
+
+// Translate returns the record for a row, or nil if the row is not a change to the table's data
+// or is the start of a statement which is continued in the next row.
+// Inserts have the inserted values, deletes have the values in the deleted row which
+// were logged, and updates have the logged values of the row overlaid with the changed values.
+func (t *RedoTranslator) Translate(row LogMinerRow) (*pub.Record, error) {
+	if t.continued != nil {
+		previous := *t.continued
+		previous.SQLRedo += row.SQLRedo
+		previous.CSF = row.CSF
+		row = previous
+		t.continued = nil
+	}
+	if row.CSF != 0 {
+		t.continued = &row
+		return nil, nil
+	}
+
+	var action pub.Record_Action
+	switch row.OperationCode {
+	case logMinerInsert:
+		action = pub.Record_INSERT
+	case logMinerUpdate:
+		action = pub.Record_UPDATE
+	case logMinerDelete:
+		action = pub.Record_DELETE
+	case logMinerUnsupported:
+		return nil, errors.Errorf("LogMiner could not translate the change at SCN %d to %s.%s, which may use an unsupported data type", row.SCN, row.SegOwner, row.TableName)
+	default:
+		return nil, nil
+	}
+
+	stmt, err := parseRedo(row.SQLRedo)
+	if err != nil {
+		return nil, errors.Errorf("could not parse redo at SCN %d: %s: %s", row.SCN, err, row.SQLRedo)
+	}
+
+	data := make(map[string]interface{}, len(t.properties))
+	for _, values := range []map[string]textValue{stmt.where, stmt.values} {
+		for column, value := range values {
+			p, ok := t.properties[column]
+			if !ok {
+				// not published, or the pseudo column ROWID
+				continue
+			}
+			data[column], err = value.convert(p)
+			if err != nil {
+				return nil, errors.Errorf("could not convert %s at SCN %d: %s", column, row.SCN, err)
+			}
+		}
+	}
+
+	record, err := pub.NewRecord(action, data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	record.Cause = fmt.Sprintf("%s committed at SCN %d in transaction %s", row.Operation, row.CommitSCN, row.XID)
+
+	return record, nil
+}
+
+// redoStatement is a parsed SQL_REDO statement.
+type redoStatement struct {
+	// values are the inserted values, or the values set by an update.
+	values map[string]textValue
+	// where are the values which identify the updated or deleted row.
+	where map[string]textValue
+}
+
+// textValue is a value written as text, such as a value in a SQL_REDO statement.
+type textValue struct {
+	null bool
+	// function is the function wrapping the literal, such as TO_DATE.
+	function string
+	literal  string
+}
+
+// convert converts the value to the type it would have been read as by ReadStream.
+func (v textValue) convert(p *pub.Property) (interface{}, error) {
+	if v.null {
+		return nil, nil
+	}
+
+	switch p.Type {
+	case pub.PropertyType_INTEGER, pub.PropertyType_DECIMAL:
+		n := v.literal
+		switch {
+		case strings.HasPrefix(n, "."):
+			n = "0" + n
+		case strings.HasPrefix(n, "-."):
+			n = "-0" + n[1:]
+		}
+		if _, err := strconv.ParseFloat(n, 64); err != nil {
+			return nil, errors.Errorf("%q is not a number", v.literal)
+		}
+		return json.Number(n), nil
+	case pub.PropertyType_FLOAT:
+		f, err := strconv.ParseFloat(v.literal, 64)
+		if err != nil {
+			return nil, errors.Errorf("%q is not a number", v.literal)
+		}
+		return f, nil
+	case pub.PropertyType_DATE, pub.PropertyType_DATETIME:
+		if strings.Contains(strings.ToUpper(p.TypeAtSource), "TIME ZONE") {
+			t, err := time.Parse("2006-01-02 15:04:05.999999999 -07:00", v.literal)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02 15:04:05.999999999", v.literal)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// published without a time zone, as by scanRecords
+		return t.Format("2006-01-02T15:04:05.999999999Z"), nil
+	case pub.PropertyType_BLOB:
+		if v.function == "HEXTORAW" {
+			b, err := hex.DecodeString(v.literal)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return b, nil
+		}
+		return v.literal, nil
+	default:
+		return v.literal, nil
+	}
+}
+
+// parseRedo parses the INSERT, UPDATE and DELETE statements which LogMiner writes to SQL_REDO.
+func parseRedo(sql string) (*redoStatement, error) {
+	p := &redoParser{tokens: tokenizeRedo(sql)}
+	stmt := &redoStatement{
+		values: make(map[string]textValue),
+		where:  make(map[string]textValue),
+	}
+
+	switch strings.ToUpper(p.next()) {
+	case "INSERT":
+		if err := p.expect("INTO"); err != nil {
+			return nil, err
+		}
+		p.skipTableName()
+		columns, err := p.columnList()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("VALUES"); err != nil {
+			return nil, err
+		}
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		for i, column := range columns {
+			if i > 0 {
+				if err = p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			if stmt.values[column], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+	case "UPDATE":
+		p.skipTableName()
+		if err := p.expect("SET"); err != nil {
+			return nil, err
+		}
+		for {
+			column := p.next()
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			stmt.values[column] = value
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		if err := p.whereClause(stmt.where); err != nil {
+			return nil, err
+		}
+	case "DELETE":
+		if err := p.expect("FROM"); err != nil {
+			return nil, err
+		}
+		p.skipTableName()
+		if err := p.whereClause(stmt.where); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("not an insert, update or delete")
+	}
+
+	return stmt, nil
+}
+
+// tokenizeRedo splits a statement into quoted identifiers, string literals,
+// words and punctuation. Quoted tokens keep their quotes.
+func tokenizeRedo(sql string) []string {
+	var tokens []string
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(sql) {
+				if sql[j] == c {
+					// a doubled quote is an escaped quote
+					if j+1 < len(sql) && sql[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j < len(sql) {
+				j++
+			}
+			tokens = append(tokens, sql[i:j])
+			i = j
+		case strings.IndexByte("(),=", c) >= 0 || c == '.' && i+1 < len(sql) && sql[i+1] == '"':
+			// a dot only separates names when it is followed by a quoted name, otherwise it is in a number
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(sql) && strings.IndexByte(" \t\r\n;\"'(),=", sql[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, sql[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type redoParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *redoParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *redoParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *redoParser) expect(token string) error {
+	if t := p.next(); !strings.EqualFold(t, token) {
+		return errors.Errorf("expected %s but found %q", token, t)
+	}
+	return nil
+}
+
+// skipTableName skips a name such as "OWNER"."TABLE".
+func (p *redoParser) skipTableName() {
+	p.next()
+	for p.peek() == "." {
+		p.next()
+		p.next()
+	}
+}
+
+func (p *redoParser) columnList() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var columns []string
+	for {
+		columns = append(columns, p.next())
+		switch t := p.next(); t {
+		case ",":
+		case ")":
+			return columns, nil
+		default:
+			return nil, errors.Errorf("expected , or ) but found %q", t)
+		}
+	}
+}
+
+// whereClause parses a list of conditions on columns joined by AND.
+func (p *redoParser) whereClause(where map[string]textValue) error {
+	if p.peek() == "" {
+		return nil
+	}
+	if err := p.expect("WHERE"); err != nil {
+		return err
+	}
+	for {
+		column := p.next()
+		if strings.EqualFold(p.peek(), "IS") {
+			p.next()
+			if err := p.expect("NULL"); err != nil {
+				return err
+			}
+			where[column] = textValue{null: true}
+		} else {
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+			where[column] = value
+		}
+		if !strings.EqualFold(p.peek(), "AND") {
+			return nil
+		}
+		p.next()
+	}
+}
+
+// value parses a literal, NULL, or a function such as TO_DATE wrapping a literal.
+func (p *redoParser) value() (textValue, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return textValue{}, errors.New("unexpected end of statement")
+	case strings.EqualFold(t, "NULL"):
+		return textValue{null: true}, nil
+	case strings.HasPrefix(t, "'"):
+		return textValue{literal: unquoteRedo(t)}, nil
+	case p.peek() == "(":
+		p.next()
+		v := textValue{function: strings.ToUpper(t)}
+		// the literal is the first argument, the others are formats
+		found := false
+		for depth := 1; depth > 0; {
+			a := p.next()
+			switch {
+			case a == "":
+				return textValue{}, errors.Errorf("unterminated call to %s", t)
+			case a == "(":
+				depth++
+			case a == ")":
+				depth--
+			case strings.HasPrefix(a, "'") && !found:
+				v.literal, found = unquoteRedo(a), true
+			}
+		}
+		return v, nil
+	default:
+		return textValue{literal: t}, nil
+	}
+}
+
+func unquoteRedo(literal string) string {
+	literal = strings.TrimPrefix(literal, "'")
+	literal = strings.TrimSuffix(literal, "'")
+	return strings.Replace(literal, "''", "'", -1)
+}
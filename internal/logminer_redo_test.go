@@ -0,0 +1,119 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RedoTranslator", func() {
+
+	var (
+		sut  *RedoTranslator
+		rows []LogMinerRow
+	)
+
+	BeforeEach(func() {
+		sut = NewRedoTranslator(&pub.Schema{
+			Id: `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{
+				{Id: `"AGENT_CODE"`, Type: pub.PropertyType_STRING, TypeAtSource: "CHAR(4)", IsKey: true},
+				{Id: `"AGENT_NAME"`, Type: pub.PropertyType_STRING, TypeAtSource: "VARCHAR2(40)"},
+				{Id: `"WORKING_AREA"`, Type: pub.PropertyType_STRING, TypeAtSource: "VARCHAR2(35)"},
+				{Id: `"COMMISSION"`, Type: pub.PropertyType_FLOAT, TypeAtSource: "BINARY_FLOAT"},
+				{Id: `"PHONE_NO"`, Type: pub.PropertyType_STRING, TypeAtSource: "CHAR(12)"},
+				{Id: `"UPDATED_AT"`, Type: pub.PropertyType_DATETIME, TypeAtSource: "TIMESTAMP(6) WITH TIME ZONE"},
+				{Id: `"BIOGRAPHY"`, Type: pub.PropertyType_TEXT, TypeAtSource: "VARCHAR2(2056)"},
+			},
+		})
+
+		_, thisPath, _, _ := runtime.Caller(0)
+		b, err := ioutil.ReadFile(filepath.Join(thisPath, "../../test/logminer/agents_contents.json"))
+		Expect(err).ToNot(HaveOccurred())
+		rows = nil
+		Expect(json.Unmarshal(b, &rows)).To(Succeed())
+	})
+
+	translateAll := func() []*pub.Record {
+		var records []*pub.Record
+		for _, row := range rows {
+			record, err := sut.Translate(row)
+			Expect(err).ToNot(HaveOccurred())
+			if record != nil {
+				records = append(records, record)
+			}
+		}
+		return records
+	}
+
+	It("should translate recorded redo into records", func() {
+		records := translateAll()
+		Expect(records).To(HaveLen(3))
+
+		Expect(records[0].Action).To(Equal(pub.Record_INSERT))
+		Expect(records[0].DataJson).To(MatchJSON(`{
+			"\"AGENT_CODE\"": "A999",
+			"\"AGENT_NAME\"": "O'Brien",
+			"\"WORKING_AREA\"": "Dublin",
+			"\"COMMISSION\"": 0.15,
+			"\"PHONE_NO\"": "012-34567890",
+			"\"UPDATED_AT\"": "2019-03-04T05:06:07.123+01:00",
+			"\"BIOGRAPHY\"": null
+		}`))
+		Expect(records[0].Cause).To(ContainSubstring("2457105"))
+
+		Expect(records[1].Action).To(Equal(pub.Record_UPDATE))
+		Expect(records[1].DataJson).To(MatchJSON(`{
+			"\"AGENT_CODE\"": "A007",
+			"\"WORKING_AREA\"": "Cork",
+			"\"BIOGRAPHY\"": "Started in 1998, (still here)"
+		}`), "should join continued rows and overlay the new values on the logged values")
+
+		Expect(records[2].Action).To(Equal(pub.Record_DELETE))
+		Expect(records[2].DataJson).To(MatchJSON(`{
+			"\"AGENT_CODE\"": "A003",
+			"\"AGENT_NAME\"": "Alex",
+			"\"WORKING_AREA\"": "London",
+			"\"COMMISSION\"": 0.129999995,
+			"\"PHONE_NO\"": "075-12458969",
+			"\"UPDATED_AT\"": "1969-01-02T00:00:00Z",
+			"\"BIOGRAPHY\"": null
+		}`))
+	})
+
+	It("should ignore commits", func() {
+		record, err := sut.Translate(rows[3])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(record).To(BeNil())
+	})
+
+	It("should publish dates without a time zone as by ReadStream", func() {
+		sut = NewRedoTranslator(&pub.Schema{Properties: []*pub.Property{
+			{Id: `"ID"`, Type: pub.PropertyType_INTEGER, TypeAtSource: "NUMBER(16,0)"},
+			{Id: `"AMOUNT"`, Type: pub.PropertyType_DECIMAL, TypeAtSource: "NUMBER(10,2)"},
+			{Id: `"CREATED"`, Type: pub.PropertyType_DATETIME, TypeAtSource: "DATE"},
+		}})
+		record, err := sut.Translate(LogMinerRow{
+			OperationCode: 1,
+			SQLRedo:       `insert into "C##NAVEEGO"."T"("ID","AMOUNT","CREATED") values ('42','-.5',TO_DATE('2019-01-02 03:04:05', 'YYYY-MM-DD HH24:MI:SS'));`,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(record.DataJson).To(MatchJSON(`{"\"ID\"": 42, "\"AMOUNT\"": -0.5, "\"CREATED\"": "2019-01-02T03:04:05Z"}`))
+	})
+
+	It("should error on unsupported changes", func() {
+		_, err := sut.Translate(LogMinerRow{OperationCode: 255, Operation: "UNSUPPORTED"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should error on redo it cannot parse", func() {
+		_, err := sut.Translate(LogMinerRow{OperationCode: 1, SQLRedo: `insert into "C##NAVEEGO"."T"("ID") select 1 from dual;`})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// errorClass is how an operation which failed with an error is handled.
+type errorClass int
+
+const (
+	// errorPermanent errors fail again if the operation is retried.
+	errorPermanent errorClass = iota
+	// errorTransient errors may not happen again if the operation is retried.
+	errorTransient
+)
+
+func (c errorClass) String() string {
+	if c == errorTransient {
+		return "transient"
+	}
+	return "permanent"
+}
+
+// oraErrorClasses classifies errors by their ORA codes. Errors which are not listed are permanent.
+var oraErrorClasses = map[int]errorClass{
+	// contention
+	60:    errorTransient, // deadlock detected while waiting for resource
+	8177:  errorTransient, // can't serialize access for this transaction
+	54:    errorTransient, // resource busy and acquire with NOWAIT specified or timeout expired
+	51:    errorTransient, // timeout occurred while waiting for a resource
+	30006: errorTransient, // resource busy; acquire with WAIT timeout expired
+	4068:  errorTransient, // existing state of packages has been discarded
+	4061:  errorTransient, // existing state of package has been invalidated
+	// connection loss
+	28:    errorTransient, // your session has been killed
+	1012:  errorTransient, // not logged on
+	1033:  errorTransient, // ORACLE initialization or shutdown in progress
+	1034:  errorTransient, // ORACLE not available
+	1089:  errorTransient, // immediate shutdown or close in progress
+	2396:  errorTransient, // exceeded maximum idle time
+	3113:  errorTransient, // end-of-file on communication channel
+	3114:  errorTransient, // not connected to ORACLE
+	3135:  errorTransient, // connection lost contact
+	12170: errorTransient, // TNS:Connect timeout occurred
+	12514: errorTransient, // TNS:listener does not currently know of service
+	12528: errorTransient, // TNS:listener: all appropriate instances are blocking new connections
+	12537: errorTransient, // TNS:connection closed
+	12541: errorTransient, // TNS:no listener
+	12543: errorTransient, // TNS:destination host unreachable
+	12571: errorTransient, // TNS:packet writer failure
+	25408: errorTransient, // can not safely replay call
+
+	// constraint violations
+	1:    errorPermanent, // unique constraint violated
+	1400: errorPermanent, // cannot insert NULL
+	1407: errorPermanent, // cannot update to NULL
+	2290: errorPermanent, // check constraint violated
+	2291: errorPermanent, // integrity constraint violated - parent key not found
+	2292: errorPermanent, // integrity constraint violated - child record found
+	// invalid values
+	1438:  errorPermanent, // value larger than specified precision allowed for this column
+	1722:  errorPermanent, // invalid number
+	1830:  errorPermanent, // date format picture ends before converting entire input string
+	1861:  errorPermanent, // literal does not match format string
+	6502:  errorPermanent, // PL/SQL: numeric or value error
+	12899: errorPermanent, // value too large for column
+}
+
+// classifiedError is implemented by errors whose class does not depend on their cause.
+type classifiedError interface {
+	errorClass() errorClass
+}
+
+var oraCodePattern = regexp.MustCompile(`ORA-(\d{5})`)
+
+// oraErrorCode returns the ORA- error code of the error, or 0 if it is not an Oracle error.
+func oraErrorCode(err error) int {
+	if coded, ok := errors.Cause(err).(interface{ Code() int }); ok && coded.Code() != 0 {
+		return coded.Code()
+	}
+	if m := oraCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code
+	}
+	return 0
+}
+
+// classifyError returns whether an operation which failed with the error may succeed if it is retried,
+// because the error was caused by contention with other sessions or by losing the connection.
+func classifyError(err error) errorClass {
+	if err == nil {
+		return errorPermanent
+	}
+	if c, ok := err.(classifiedError); ok {
+		return c.errorClass()
+	}
+
+	switch errors.Cause(err) {
+	case context.Canceled, context.DeadlineExceeded:
+		return errorPermanent
+	case driver.ErrBadConn, sql.ErrConnDone, io.EOF, io.ErrUnexpectedEOF:
+		return errorTransient
+	}
+
+	return oraErrorClasses[oraErrorCode(err)]
+}
+
+// oraFailureKinds groups the permanent errors which reject a record, for reporting.
+var oraFailureKinds = map[int]string{
+	1:     failureConstraint,
+	1400:  failureConstraint,
+	1407:  failureConstraint,
+	2290:  failureConstraint,
+	2291:  failureConstraint,
+	2292:  failureConstraint,
+	1438:  failureValue,
+	1722:  failureValue,
+	1830:  failureValue,
+	1861:  failureValue,
+	6502:  failureValue,
+	12899: failureValue,
+}
+
+// The kinds of errors which reject a record.
+const (
+	// failureConstraint is the violation of a constraint.
+	failureConstraint = "constraint"
+	// failureValue is a value which does not fit its column or argument.
+	failureValue = "value"
+	failureOther = "other"
+)
+
+// oraFailureKind returns the kind of an error which rejected a record.
+func oraFailureKind(err error) string {
+	if kind, ok := oraFailureKinds[oraErrorCode(err)]; ok {
+		return kind
+	}
+	return failureOther
+}
@@ -0,0 +1,49 @@
+package internal_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+
+	. "github.com/naveego/plugin-oracle/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// codedError is an error with an ORA code, like the errors returned by the driver.
+type codedError int
+
+func (c codedError) Code() int { return int(c) }
+
+func (c codedError) Error() string { return fmt.Sprintf("ORA-%05d: error", int(c)) }
+
+var _ = Describe("Oracle error classification", func() {
+
+	DescribeTable("classifyError",
+		func(err error, class string) {
+			Expect(ClassifyError(err)).To(Equal(class))
+		},
+		Entry("deadlock", codedError(60), "transient"),
+		Entry("serialization failure", codedError(8177), "transient"),
+		Entry("resource busy", codedError(54), "transient"),
+		Entry("end-of-file on communication channel", codedError(3113), "transient"),
+		Entry("not connected", codedError(3114), "transient"),
+		Entry("lost contact", codedError(3135), "transient"),
+		Entry("no listener", codedError(12541), "transient"),
+		Entry("wrapped deadlock", errors.Wrap(codedError(60), "could not write back"), "transient"),
+		Entry("deadlock message", errors.New("dpiStmt_execute: ORA-00060: deadlock detected while waiting for resource"), "transient"),
+		Entry("bad connection", driver.ErrBadConn, "transient"),
+		Entry("unexpected EOF", io.ErrUnexpectedEOF, "transient"),
+		Entry("unique constraint", codedError(1), "permanent"),
+		Entry("cannot insert null", codedError(1400), "permanent"),
+		Entry("parent key not found", codedError(2291), "permanent"),
+		Entry("invalid number", codedError(1722), "permanent"),
+		Entry("value too large", errors.New("ORA-12899: value too large for column"), "permanent"),
+		Entry("unknown code", codedError(20001), "permanent"),
+		Entry("not an Oracle error", errors.New("something went wrong"), "permanent"),
+		Entry("cancelled", context.Canceled, "permanent"),
+	)
+})
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// readSlice is an independently readable part of a schema,
+// such as a single partition or a ROWID range of a table.
+type readSlice struct {
+	name  string
+	query string
+	// estimatedRows is the expected size of the slice, if known.
+	estimatedRows *int64
+}
+
+// sliceBufferSize is the number of records buffered for each slice
+// which is being read ahead of the slice currently being sent.
+const sliceBufferSize = 1000
+
+// sliceProgressInterval is the number of rows between progress log entries.
+const sliceProgressInterval = 100000
+
+// planRead splits the read into slices which can be read concurrently,
+// each of which is restricted to the scope. Reads which cannot be split
+// return a single slice.
+func (s *Server) planRead(req *pub.ReadRequest, scope readScope) ([]readSlice, error) {
+	single := func() ([]readSlice, error) {
+		query, err := buildScopedQuery(req, scope)
+		if err != nil {
+			return nil, errors.Errorf("could not build query: %v", err)
+		}
+
+		if req.Limit > 0 {
+			query = fmt.Sprintf(`SELECT SRC.* FROM (
+%s
+) SRC 
+WHERE rownum <= %d `, query, req.Limit)
+		}
+
+		return []readSlice{{name: req.Schema.Id, query: query}}, nil
+	}
+
+	if req.Limit > 0 || req.Schema.Query != "" || s.settings == nil || s.settings.GetParallelReadSessions() < 2 {
+		return single()
+	}
+
+	meta, err := getSchemaMeta(req.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	partitioning := meta.Partitioning
+	if partitioning == nil {
+		slices, err := s.planRowIDSlices(req, scope)
+		if err != nil || len(slices) > 0 {
+			return slices, err
+		}
+		return single()
+	}
+
+	if len(partitioning.Partitions) < 2 {
+		return single()
+	}
+
+	var slices []readSlice
+	for _, partition := range partitioning.Partitions {
+		partitionScope := scope
+		partitionScope.partition = partition.Clause(partitioning.Strategy)
+		query, err := buildScopedQuery(req, partitionScope)
+		if err != nil {
+			return nil, errors.Errorf("could not build query for partition %s: %v", partition.Name, err)
+		}
+		slices = append(slices, readSlice{
+			name:          partition.Name,
+			query:         query,
+			estimatedRows: partition.Rows,
+		})
+	}
+
+	return slices, nil
+}
+
+// readSlices reads the slices concurrently, each on its own session, and sends
+// the records to out in slice order. Slices which are read ahead of the
+// slice being sent are buffered, and block when their buffer is full.
+func (s *Server) readSlices(ctx context.Context, slices []readSlice, properties []*pub.Property, out chan<- *pub.Record) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan *pub.Record, len(slices))
+	errs := make([]error, len(slices))
+	for i := range results {
+		results[i] = make(chan *pub.Record, sliceBufferSize)
+	}
+
+	work := make(chan int)
+	go func() {
+		defer close(work)
+		for i := range slices {
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var completed int32
+	wait := new(sync.WaitGroup)
+	sessions := s.settings.GetParallelReadSessions()
+	for w := 0; w < sessions && w < len(slices); w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range work {
+				errs[i] = s.readSliceOnSession(ctx, slices[i], properties, results[i])
+				close(results[i])
+
+				s.log.Info("Finished reading slice.", "slice", slices[i].name, "completed", atomic.AddInt32(&completed, 1), "total", len(slices))
+			}
+		}()
+	}
+
+	var err error
+Slices:
+	for i := range slices {
+		for {
+			select {
+			case record, ok := <-results[i]:
+				if !ok {
+					if errs[i] != nil {
+						err = errors.WithMessage(errs[i], fmt.Sprintf("error reading %s", slices[i].name))
+						break Slices
+					}
+					continue Slices
+				}
+				select {
+				case out <- record:
+				case <-ctx.Done():
+					break Slices
+				}
+			case <-ctx.Done():
+				break Slices
+			}
+		}
+	}
+
+	cancel()
+	wait.Wait()
+
+	return err
+}
+
+// readSliceOnSession reads a single slice using a dedicated session from the pool.
+func (s *Server) readSliceOnSession(ctx context.Context, slice readSlice, properties []*pub.Property, out chan<- *pub.Record) error {
+	log := s.log.With("slice", slice.name)
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return errors.Errorf("could not open session: %s", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if slice.estimatedRows != nil {
+		log.Info("Reading slice...", "estimatedRows", *slice.estimatedRows)
+	} else {
+		log.Info("Reading slice...")
+	}
+
+	rows, err := s.executeQueryContext(ctx, conn, slice.query)
+	if err != nil {
+		return errors.Errorf("error executing query %q: %v", slice.query, err)
+	}
+
+	count, err := s.scanRecords(ctx, rows, properties, out, func(count int) {
+		if count%sliceProgressInterval == 0 {
+			log.Info("Reading slice...", "rows", count, "elapsed", time.Since(start).Seconds())
+		}
+	})
+
+	log.Info("Read slice.", "rows", count, "elapsed", time.Since(start).Seconds())
+
+	return err
+}
+
+// queryer is implemented by both *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// Partitioning describes how a table is partitioned.
+type Partitioning struct {
+	// Strategy is the partitioning type, such as RANGE, LIST or HASH.
+	Strategy string `json:"strategy"`
+	// SubpartitionStrategy is the subpartitioning type, or NONE.
+	SubpartitionStrategy string      `json:"subpartitionStrategy,omitempty"`
+	Partitions           []Partition `json:"partitions"`
+}
+
+// Partition is a single partition of a partitioned table.
+type Partition struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	// HighValue is the partition bound expression as reported by Oracle.
+	HighValue string `json:"highValue,omitempty"`
+	// Rows is the row count from the optimizer statistics, if they have been gathered.
+	Rows *int64 `json:"rows,omitempty"`
+}
+
+var singleLiteral = regexp.MustCompile(`^('[^']*'|-?\d+(\.\d+)?)$`)
+
+// Clause returns the partition extension clause which restricts a query
+// against the table to this partition. List partitions holding a single
+// value are addressed using PARTITION FOR, because automatic list
+// partitions are created with system generated names.
+func (p Partition) Clause(strategy string) string {
+	highValue := strings.TrimSpace(p.HighValue)
+	if strategy == "LIST" && singleLiteral.MatchString(highValue) {
+		return fmt.Sprintf("PARTITION FOR (%s)", highValue)
+	}
+	return fmt.Sprintf(`PARTITION ("%s")`, p.Name)
+}
+
+// getPartitioning returns the partitioning of the table,
+// or nil if the table is not partitioned.
+func (s *Server) getPartitioning(owner, table string) (*Partitioning, error) {
+	rows, err := s.executeQuery(`
+SELECT PARTITIONING_TYPE, SUBPARTITIONING_TYPE
+FROM ALL_PART_TABLES
+WHERE OWNER = :owner AND TABLE_NAME = :name`, sql.Named("owner", owner), sql.Named("name", table))
+	if err != nil {
+		return nil, errors.Errorf("could not read partitioned tables: %s", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	partitioning := new(Partitioning)
+	if err = rows.Scan(&partitioning.Strategy, &partitioning.SubpartitionStrategy); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rows.Close()
+
+	rows, err = s.executeQuery(`
+SELECT PARTITION_NAME, PARTITION_POSITION, HIGH_VALUE, NUM_ROWS
+FROM ALL_TAB_PARTITIONS
+WHERE TABLE_OWNER = :owner AND TABLE_NAME = :name
+ORDER BY PARTITION_POSITION`, sql.Named("owner", owner), sql.Named("name", table))
+	if err != nil {
+		return nil, errors.Errorf("could not read table partitions: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			partition Partition
+			highValue sql.NullString
+			numRows   sql.NullInt64
+		)
+		if err = rows.Scan(&partition.Name, &partition.Position, &highValue, &numRows); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		partition.HighValue = highValue.String
+		if numRows.Valid {
+			partition.Rows = &numRows.Int64
+		}
+		partitioning.Partitions = append(partitioning.Partitions, partition)
+	}
+
+	return partitioning, rows.Err()
+}
+
+// populateShapePartitions records the partitioning of a table-based
+// shape in its metadata.
+func (s *Server) populateShapePartitions(shape *pub.Schema) error {
+	owner, table := decomposeSafeName(shape.Id)
+	if owner == "" || table == "" {
+		return errors.Errorf("ID %q did not have owner segment", shape.Id)
+	}
+
+	partitioning, err := s.getPartitioning(owner, table)
+	if err != nil {
+		return err
+	}
+
+	meta, err := getSchemaMeta(shape)
+	if err != nil {
+		return err
+	}
+	meta.Partitioning = partitioning
+
+	return setSchemaMeta(shape, meta)
+}
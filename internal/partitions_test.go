@@ -0,0 +1,39 @@
+package internal_test
+
+import (
+	. "github.com/naveego/plugin-oracle/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Partition", func() {
+
+	Describe("Clause", func() {
+
+		It("should address range partitions by name", func() {
+			p := Partition{Name: "ORDERS_2018_01", HighValue: "TO_DATE(' 2018-02-01 00:00:00', 'SYYYY-MM-DD HH24:MI:SS', 'NLS_CALENDAR=GREGORIAN')"}
+			Expect(p.Clause("RANGE")).To(Equal(`PARTITION ("ORDERS_2018_01")`))
+		})
+
+		It("should address hash partitions by name", func() {
+			p := Partition{Name: "SYS_P101"}
+			Expect(p.Clause("HASH")).To(Equal(`PARTITION ("SYS_P101")`))
+		})
+
+		It("should address single value list partitions by value", func() {
+			p := Partition{Name: "SYS_P102", HighValue: "'London'"}
+			Expect(p.Clause("LIST")).To(Equal(`PARTITION FOR ('London')`))
+
+			p = Partition{Name: "SYS_P103", HighValue: "42"}
+			Expect(p.Clause("LIST")).To(Equal(`PARTITION FOR (42)`))
+		})
+
+		It("should address multiple value and default list partitions by name", func() {
+			p := Partition{Name: "P_EUROPE", HighValue: "'London', 'Paris'"}
+			Expect(p.Clause("LIST")).To(Equal(`PARTITION ("P_EUROPE")`))
+
+			p = Partition{Name: "P_OTHER", HighValue: "DEFAULT"}
+			Expect(p.Clause("LIST")).To(Equal(`PARTITION ("P_OTHER")`))
+		})
+	})
+})
@@ -0,0 +1,641 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+	"gopkg.in/goracle.v2"
+)
+
+// QueueMeta describes the Advanced Queuing queue behind a queue schema.
+type QueueMeta struct {
+	// QueueTable is the name of the queue table the queue's messages are stored in.
+	QueueTable string `json:"queueTable"`
+	// PayloadType is the object type of the payload, such as "OWNER"."TYPE", or RAW.
+	PayloadType string `json:"payloadType"`
+	// MultipleConsumers is set if messages are dequeued by named subscribers.
+	MultipleConsumers bool `json:"multipleConsumers,omitempty"`
+	// JSONPayload is set if the properties are parsed from a RAW payload holding a JSON object.
+	JSONPayload bool `json:"jsonPayload,omitempty"`
+}
+
+// rawPayloadType is the PayloadType of a queue whose payload is RAW.
+const rawPayloadType = "RAW"
+
+// The properties of a queue schema which hold the message properties rather than the payload.
+const (
+	queueMsgIDProperty       = `"AQ$MSG_ID"`
+	queueCorrelationProperty = `"AQ$CORRELATION"`
+	queueEnqueueTimeProperty = `"AQ$ENQUEUE_TIME"`
+	queuePriorityProperty    = `"AQ$PRIORITY"`
+	// queuePayloadProperty holds a RAW payload which is not parsed.
+	queuePayloadProperty = `"PAYLOAD"`
+)
+
+// queuePayloadSampleSize is the number of messages sampled to decide
+// whether a RAW payload holds JSON objects.
+const queuePayloadSampleSize = 20
+
+// The largest RAW payload which can be dequeued, so that its hex fits in a PL/SQL VARCHAR2.
+const maxDequeuePayloadBytes = 16383
+
+// getAllQueues returns a schema for each queue which can be read,
+// excluding the queues that are part of Oracle.
+func (s *Server) getAllQueues() ([]*pub.Schema, error) {
+	rows, err := s.executeQuery(`
+SELECT q.OWNER, q.NAME, q.QUEUE_TABLE, t.TYPE, t.OBJECT_TYPE, t.RECIPIENTS
+FROM ALL_QUEUES q
+JOIN ALL_QUEUE_TABLES t ON t.OWNER = q.OWNER AND t.QUEUE_TABLE = q.QUEUE_TABLE
+WHERE q.QUEUE_TYPE = 'NORMAL_QUEUE'
+  AND t.TYPE IN ('OBJECT', 'RAW')
+  AND q.OWNER NOT IN ('SYS', 'SYSTEM', 'WMSYS', 'XDB', 'GSMADMIN_INTERNAL', 'DBSNMP', 'APPQOSSYS')
+`)
+	if err != nil {
+		return nil, errors.Errorf("could not list queues: %s", err)
+	}
+	defer rows.Close()
+
+	var shapes []*pub.Schema
+
+	for rows.Next() {
+		var (
+			owner, name, queueTable, payload, recipients string
+			objectType                                   sql.NullString
+		)
+		if err = rows.Scan(&owner, &name, &queueTable, &payload, &objectType, &recipients); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		meta := &SchemaMeta{Queue: &QueueMeta{
+			QueueTable:        queueTable,
+			PayloadType:       rawPayloadType,
+			MultipleConsumers: recipients == "MULTIPLE",
+		}}
+		if payload == "OBJECT" {
+			typeOwner, typeName := decomposeSafeName(objectType.String)
+			meta.Queue.PayloadType = fmt.Sprintf(`"%s"."%s"`, typeOwner, typeName)
+		}
+
+		shape := &pub.Schema{
+			Id:   fmt.Sprintf(`"%s"."%s"`, owner, name),
+			Name: fmt.Sprintf("%s.%s", owner, name),
+		}
+		if err = setSchemaMeta(shape, meta); err != nil {
+			return nil, err
+		}
+
+		shapes = append(shapes, shape)
+	}
+
+	return shapes, rows.Err()
+}
+
+// populateQueueProperties adds the message properties of a queue schema,
+// followed by the attributes of an object payload or the fields of a JSON payload.
+// A RAW payload is only parsed as JSON if all the messages sampled hold a JSON object.
+func (s *Server) populateQueueProperties(shape *pub.Schema, meta *SchemaMeta) error {
+	var properties []*pub.Property
+	add := func(id, name string, t pub.PropertyType, typeAtSource string) {
+		var property *pub.Property
+		for _, p := range shape.Properties {
+			if p.Id == id {
+				property = p
+				break
+			}
+		}
+		if property == nil {
+			property = &pub.Property{Id: id, Name: name}
+		}
+		property.Type = t
+		property.TypeAtSource = typeAtSource
+		property.IsNullable = id != queueMsgIDProperty
+		property.IsKey = id == queueMsgIDProperty
+		properties = append(properties, property)
+	}
+
+	add(queueMsgIDProperty, "AQ$MSG_ID", pub.PropertyType_STRING, "RAW(16)")
+	add(queueCorrelationProperty, "AQ$CORRELATION", pub.PropertyType_STRING, "VARCHAR2(128)")
+	add(queueEnqueueTimeProperty, "AQ$ENQUEUE_TIME", pub.PropertyType_DATETIME, "TIMESTAMP")
+	add(queuePriorityProperty, "AQ$PRIORITY", pub.PropertyType_INTEGER, "NUMBER")
+
+	if meta.Queue.PayloadType != rawPayloadType {
+		attributes, err := s.getPayloadAttributes(meta.Queue.PayloadType)
+		if err != nil {
+			return err
+		}
+		for _, a := range attributes {
+			if a.composite {
+				s.log.Warn("Payload attribute is an object or collection and will not be published.", "queue", shape.Id, "attribute", a.ColumnName, "type", a.DataType)
+				continue
+			}
+			add(fmt.Sprintf(`"%s"`, a.ColumnName), a.ColumnName, convertSQLType(a.columnInfo), a.TypeAtSource())
+		}
+
+		shape.Properties = properties
+		return nil
+	}
+
+	payloads, err := s.sampleQueuePayloads(shape, *meta.Queue)
+	if err != nil {
+		return errors.Errorf("could not sample messages: %s", err)
+	}
+
+	fields, ok := jsonPayloadFields(payloads)
+	meta.Queue.JSONPayload = ok
+	if ok {
+		for _, f := range fields {
+			add(fmt.Sprintf(`"%s"`, f.name), f.name, f.propertyType, "JSON")
+		}
+	} else {
+		add(queuePayloadProperty, "PAYLOAD", pub.PropertyType_BLOB, rawPayloadType)
+	}
+
+	shape.Properties = properties
+	return setSchemaMeta(shape, meta)
+}
+
+// payloadAttribute is an attribute of the object type of a queue's payload.
+type payloadAttribute struct {
+	columnInfo
+	// composite is set if the attribute is an object or collection.
+	composite bool
+}
+
+// getPayloadAttributes returns the attributes of a payload object type, in constructor order.
+func (s *Server) getPayloadAttributes(payloadType string) ([]payloadAttribute, error) {
+	typeOwner, typeName := decomposeSafeName(payloadType)
+	rows, err := s.executeQuery(`
+SELECT ATTR_NAME, ATTR_TYPE_NAME, LENGTH, PRECISION, SCALE, ATTR_TYPE_OWNER
+FROM ALL_TYPE_ATTRS
+WHERE OWNER = :owner AND TYPE_NAME = :name
+ORDER BY ATTR_NO`, sql.Named("owner", typeOwner), sql.Named("name", typeName))
+	if err != nil {
+		return nil, errors.Errorf("could not read attributes of payload type %s: %s", payloadType, err)
+	}
+	defer rows.Close()
+
+	var attributes []payloadAttribute
+	for rows.Next() {
+		var a payloadAttribute
+		var attrTypeOwner sql.NullString
+		if err = rows.Scan(&a.ColumnName, &a.DataType, &a.DataLength, &a.DataPrecision, &a.DataScale, &attrTypeOwner); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		a.composite = attrTypeOwner.Valid
+		a.DataType = deparameterizer.ReplaceAllString(a.DataType, "")
+		a.NullableChar = "Y"
+		attributes = append(attributes, a)
+	}
+
+	return attributes, errors.WithStack(rows.Err())
+}
+
+// sampleQueuePayloads returns the payloads of the first messages ready to be dequeued.
+func (s *Server) sampleQueuePayloads(shape *pub.Schema, meta QueueMeta) ([][]byte, error) {
+	owner, queue := decomposeSafeName(shape.Id)
+	rows, err := s.executeQuery(fmt.Sprintf(`SELECT SRC.* FROM (
+SELECT T.USER_DATA
+FROM "%s"."%s" T
+WHERE T.Q_NAME = :queue AND T.STATE = 0
+ORDER BY T.ENQ_TIME
+) SRC
+WHERE rownum <= %d`, owner, meta.QueueTable, queuePayloadSampleSize), sql.Named("queue", queue))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	var payloads [][]byte
+	for rows.Next() {
+		var value interface{}
+		if err = rows.Scan(&value); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		payload, err := payloadBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, payload)
+	}
+
+	return payloads, errors.WithStack(rows.Err())
+}
+
+// jsonPayloadField is a field found in a JSON payload.
+type jsonPayloadField struct {
+	name         string
+	propertyType pub.PropertyType
+	// typed is set once a value which is not null has been seen.
+	typed bool
+}
+
+// jsonPayloadFields returns the fields of the payloads, in the order they are first seen,
+// or false if there are no payloads or any of them is not a JSON object.
+// A field's type is taken from its first value which is not null.
+func jsonPayloadFields(payloads [][]byte) ([]jsonPayloadField, bool) {
+	if len(payloads) == 0 {
+		return nil, false
+	}
+
+	var fields []jsonPayloadField
+	index := make(map[string]int)
+
+	for _, payload := range payloads {
+		d := json.NewDecoder(bytes.NewReader(payload))
+		d.UseNumber()
+
+		var names []string
+		if t, err := d.Token(); err != nil || t != json.Delim('{') {
+			return nil, false
+		}
+		object := make(map[string]interface{})
+		for d.More() {
+			t, err := d.Token()
+			if err != nil {
+				return nil, false
+			}
+			name := t.(string)
+			var value interface{}
+			if err = d.Decode(&value); err != nil {
+				return nil, false
+			}
+			names = append(names, name)
+			object[name] = value
+		}
+
+		for _, name := range names {
+			t, known := jsonValueType(object[name])
+			i, ok := index[name]
+			if !ok {
+				index[name] = len(fields)
+				fields = append(fields, jsonPayloadField{name: name, propertyType: t, typed: known})
+				continue
+			}
+			if known && !fields[i].typed {
+				fields[i].propertyType, fields[i].typed = t, true
+			}
+		}
+	}
+
+	return fields, true
+}
+
+// jsonValueType returns the property type of a JSON value decoded with UseNumber,
+// or false if the value is null and its type is not known.
+func jsonValueType(value interface{}) (pub.PropertyType, bool) {
+	switch v := value.(type) {
+	case nil:
+		return pub.PropertyType_STRING, false
+	case bool:
+		return pub.PropertyType_BOOL, true
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return pub.PropertyType_FLOAT, true
+		}
+		return pub.PropertyType_INTEGER, true
+	case string:
+		return pub.PropertyType_STRING, true
+	default:
+		return pub.PropertyType_JSON, true
+	}
+}
+
+// payloadBytes returns the bytes of a RAW payload scanned from a queue table.
+func payloadBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case *goracle.Lob:
+		b, err := ioutil.ReadAll(v)
+		return b, errors.WithStack(err)
+	default:
+		return nil, errors.Errorf("unexpected payload type %T", value)
+	}
+}
+
+// queueSource holds the expressions for the parts of a message
+// which are read into the properties of a queue schema.
+type queueSource struct {
+	msgID       string
+	correlation string
+	enqueueTime string
+	priority    string
+	// payload is the object or RAW payload.
+	payload string
+}
+
+// queueTableSource reads a message from the queue table, aliased as T.
+var queueTableSource = queueSource{
+	msgID:       "T.MSGID",
+	correlation: "T.CORRID",
+	enqueueTime: "T.ENQ_TIME",
+	priority:    "T.PRIORITY",
+	payload:     "T.USER_DATA",
+}
+
+// dequeueSource reads a message from the variables of the dequeue block.
+var dequeueSource = queueSource{
+	msgID:       "msgid",
+	correlation: "message_properties.correlation",
+	enqueueTime: "CAST(message_properties.enqueue_time AS TIMESTAMP)",
+	priority:    "message_properties.priority",
+	payload:     "payload",
+}
+
+// queueLayout reads the properties of a queue schema from messages.
+// The message properties and the attributes of an object payload are read as text,
+// so that they can be returned from PL/SQL, and converted back by textValue.
+// A RAW payload is read separately, and is parsed if it holds JSON.
+type queueLayout struct {
+	meta QueueMeta
+	// fields are the properties read as text.
+	fields []*pub.Property
+	// payloadFields are the properties parsed from a JSON payload, or the
+	// property holding the unparsed RAW payload.
+	payloadFields []*pub.Property
+}
+
+func newQueueLayout(schema *pub.Schema, meta QueueMeta) queueLayout {
+	l := queueLayout{meta: meta}
+	for _, p := range schema.Properties {
+		switch {
+		case strings.HasPrefix(p.Id, `"AQ$`):
+			l.fields = append(l.fields, p)
+		case meta.PayloadType == rawPayloadType:
+			if meta.JSONPayload || p.Id == queuePayloadProperty {
+				l.payloadFields = append(l.payloadFields, p)
+			}
+		default:
+			l.fields = append(l.fields, p)
+		}
+	}
+	return l
+}
+
+// rawPayload returns true if the RAW payload is read.
+func (l queueLayout) rawPayload() bool {
+	return len(l.payloadFields) > 0
+}
+
+// expressions returns the text expressions for the fields, reading the message from the source.
+func (l queueLayout) expressions(source queueSource) []string {
+	var exprs []string
+	for _, p := range l.fields {
+		switch p.Id {
+		case queueMsgIDProperty:
+			exprs = append(exprs, fmt.Sprintf("RAWTOHEX(%s)", source.msgID))
+		case queueCorrelationProperty:
+			exprs = append(exprs, source.correlation)
+		case queueEnqueueTimeProperty:
+			exprs = append(exprs, textExpression(p, source.enqueueTime))
+		case queuePriorityProperty:
+			exprs = append(exprs, textExpression(p, source.priority))
+		default:
+			exprs = append(exprs, textExpression(p, fmt.Sprintf("%s.%s", source.payload, p.Id)))
+		}
+	}
+	return exprs
+}
+
+// textExpression returns an expression which converts the value
+// of the property to text which textValue can convert back.
+func textExpression(p *pub.Property, expr string) string {
+	typeAtSource := strings.ToUpper(p.TypeAtSource)
+
+	switch p.Type {
+	case pub.PropertyType_INTEGER, pub.PropertyType_DECIMAL, pub.PropertyType_FLOAT:
+		return fmt.Sprintf(`TO_CHAR(%s, 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,''')`, expr)
+	case pub.PropertyType_DATE, pub.PropertyType_DATETIME:
+		format := logMinerTimestampFormat
+		switch {
+		case strings.Contains(typeAtSource, "TIME ZONE"):
+			format = logMinerTimestampTZFormat
+		case typeAtSource == "DATE":
+			format = logMinerDateFormat
+		}
+		return fmt.Sprintf(`TO_CHAR(%s, '%s')`, expr, format)
+	case pub.PropertyType_BLOB:
+		return fmt.Sprintf(`RAWTOHEX(%s)`, expr)
+	}
+
+	if strings.Contains(typeAtSource, "CLOB") {
+		return fmt.Sprintf(`DBMS_LOB.SUBSTR(%s, 4000, 1)`, expr)
+	}
+	return expr
+}
+
+// record converts a message into a record. The fields are the text values of
+// the expressions, with nulls as nil, and payload is the RAW payload if it is read.
+func (l queueLayout) record(action pub.Record_Action, fields []*string, payload []byte) (*pub.Record, error) {
+	data := make(map[string]interface{}, len(l.fields)+len(l.payloadFields))
+
+	var msgID string
+	for i, p := range l.fields {
+		value := textValue{null: fields[i] == nil}
+		if fields[i] != nil {
+			value.literal = *fields[i]
+		}
+		if p.Type == pub.PropertyType_BLOB {
+			value.function = "HEXTORAW"
+		}
+		if p.Id == queueMsgIDProperty {
+			msgID = value.literal
+		}
+
+		converted, err := value.convert(p)
+		if err != nil {
+			return nil, errors.Errorf("could not convert %s of message %s: %s", p.Name, msgID, err)
+		}
+		data[p.Id] = converted
+	}
+
+	if l.meta.JSONPayload {
+		object := make(map[string]interface{})
+		if payload != nil {
+			d := json.NewDecoder(bytes.NewReader(payload))
+			d.UseNumber()
+			if err := d.Decode(&object); err != nil {
+				return nil, errors.Errorf("message %s does not have a JSON object payload: %s", msgID, err)
+			}
+		}
+		for _, p := range l.payloadFields {
+			data[p.Id] = object[p.Name]
+		}
+	} else if l.rawPayload() {
+		data[queuePayloadProperty] = payload
+	}
+
+	return pub.NewRecord(action, data)
+}
+
+// buildQueueQuery returns the query for the messages of a queue which are ready to be dequeued.
+// The text expressions are selected first, followed by the RAW payload if it is read.
+func buildQueueQuery(schema *pub.Schema, meta QueueMeta) string {
+	l := newQueueLayout(schema, meta)
+	columns := l.expressions(queueTableSource)
+	if l.rawPayload() {
+		columns = append(columns, queueTableSource.payload)
+	}
+
+	owner, queue := decomposeSafeName(schema.Id)
+	return fmt.Sprintf(`SELECT %s
+FROM "%s"."%s" T
+WHERE T.Q_NAME = '%s' AND T.STATE = 0
+ORDER BY T.ENQ_TIME`, strings.Join(columns, ", "), owner, meta.QueueTable, queue)
+}
+
+// readQueueMessages reads the messages of a queue which are ready
+// to be dequeued, without dequeuing them.
+func (s *Server) readQueueMessages(ctx context.Context, req *pub.ReadRequest, meta QueueMeta, out chan<- *pub.Record) error {
+	query := buildQueueQuery(req.Schema, meta)
+	if req.Limit > 0 {
+		query = fmt.Sprintf(`SELECT SRC.* FROM (
+%s
+) SRC
+WHERE rownum <= %d`, query, req.Limit)
+	}
+
+	rows, err := s.executeQueryContext(ctx, s.db, query)
+	if err != nil {
+		return errors.Errorf("error executing query %q: %v", query, err)
+	}
+	defer rows.Close()
+
+	l := newQueueLayout(req.Schema, meta)
+	fields := make([]sql.NullString, len(l.fields))
+	var payload interface{}
+
+	for rows.Next() {
+		if ctx.Err() != nil || !s.connected {
+			return nil
+		}
+
+		var dest []interface{}
+		for i := range fields {
+			dest = append(dest, &fields[i])
+		}
+		if l.rawPayload() {
+			dest = append(dest, &payload)
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return errors.WithStack(err)
+		}
+
+		text := make([]*string, len(fields))
+		for i := range fields {
+			if fields[i].Valid {
+				text[i] = &fields[i].String
+			}
+		}
+		b, err := payloadBytes(payload)
+		if err != nil {
+			return err
+		}
+
+		record, err := l.record(pub.Record_UPSERT, text, b)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case out <- record:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return errors.WithMessage(rows.Err(), "error while scanning data")
+}
+
+// dequeueBlock returns the PL/SQL block which dequeues a message and returns its text fields
+// in the binds :f1 to :fn, and its RAW payload as hex in :payload if it is read. The message ID
+// and enqueue time are also returned in :msgid and :enqueue_time, for browsing the queue.
+// The message is selected by the binds :queue_name, :consumer_name, :wait, :condition
+// and :first_message, which restarts navigation from the first message if it is 1.
+func (l queueLayout) dequeueBlock(settings RealTimeSettings) string {
+	w := new(strings.Builder)
+
+	mode := "DBMS_AQ.REMOVE"
+	if settings.DequeueMode == DequeueModeBrowse {
+		mode = "DBMS_AQ.BROWSE"
+	}
+	visibility := "DBMS_AQ.ON_COMMIT"
+	if settings.Visibility == DequeueVisibilityImmediate {
+		visibility = "DBMS_AQ.IMMEDIATE"
+	}
+
+	payloadType := l.meta.PayloadType
+	if payloadType == rawPayloadType {
+		payloadType = "RAW(32767)"
+	}
+
+	fmt.Fprintf(w, `DECLARE
+  dequeue_options    DBMS_AQ.DEQUEUE_OPTIONS_T;
+  message_properties DBMS_AQ.MESSAGE_PROPERTIES_T;
+  msgid              RAW(16);
+  payload            %s;
+BEGIN
+  dequeue_options.consumer_name := :consumer_name;
+  dequeue_options.dequeue_mode := %s;
+  dequeue_options.visibility := %s;
+  dequeue_options.wait := :wait;
+  dequeue_options.deq_condition := :condition;
+  IF :first_message = 1 THEN
+    dequeue_options.navigation := DBMS_AQ.FIRST_MESSAGE;
+  ELSE
+    dequeue_options.navigation := DBMS_AQ.NEXT_MESSAGE;
+  END IF;
+  DBMS_AQ.DEQUEUE(
+    queue_name         => :queue_name,
+    dequeue_options    => dequeue_options,
+    message_properties => message_properties,
+    payload            => payload,
+    msgid              => msgid);
+  :msgid := RAWTOHEX(msgid);
+  :enqueue_time := TO_CHAR(message_properties.enqueue_time, '%s');
+`, payloadType, mode, visibility, logMinerDateFormat)
+
+	for i, expr := range l.expressions(dequeueSource) {
+		fmt.Fprintf(w, "  :f%d := %s;\n", i+1, expr)
+	}
+
+	if l.rawPayload() {
+		fmt.Fprintf(w, `  IF UTL_RAW.LENGTH(payload) > %d THEN
+    RAISE_APPLICATION_ERROR(-20000, 'The payload of message ' || RAWTOHEX(msgid) || ' is larger than %d bytes.');
+  END IF;
+  :payload := RAWTOHEX(payload);
+`, maxDequeuePayloadBytes, maxDequeuePayloadBytes)
+	}
+
+	w.WriteString("END;")
+
+	return w.String()
+}
+
+// decodeHexPayload decodes a RAW payload returned as hex by the dequeue block.
+func decodeHexPayload(payload string) ([]byte, error) {
+	if payload == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(payload)
+	return b, errors.WithStack(err)
+}
+
+// schemaQueue returns the queue behind the schema, or nil if it is not a queue schema.
+func schemaQueue(schema *pub.Schema) *QueueMeta {
+	meta, err := getSchemaMeta(schema)
+	if err != nil {
+		return nil
+	}
+	return meta.Queue
+}
@@ -0,0 +1,206 @@
+package internal_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Queues", func() {
+
+	messageProperties := []*pub.Property{
+		{Id: `"AQ$MSG_ID"`, Name: "AQ$MSG_ID", Type: pub.PropertyType_STRING, TypeAtSource: "RAW(16)", IsKey: true},
+		{Id: `"AQ$CORRELATION"`, Name: "AQ$CORRELATION", Type: pub.PropertyType_STRING, TypeAtSource: "VARCHAR2(128)"},
+		{Id: `"AQ$ENQUEUE_TIME"`, Name: "AQ$ENQUEUE_TIME", Type: pub.PropertyType_DATETIME, TypeAtSource: "TIMESTAMP"},
+		{Id: `"AQ$PRIORITY"`, Name: "AQ$PRIORITY", Type: pub.PropertyType_INTEGER, TypeAtSource: "NUMBER"},
+	}
+
+	text := func(values ...string) []*string {
+		var fields []*string
+		for i := range values {
+			if values[i] != "" {
+				fields = append(fields, &values[i])
+			} else {
+				fields = append(fields, nil)
+			}
+		}
+		return fields
+	}
+
+	Describe("object payloads", func() {
+
+		var (
+			schema *pub.Schema
+			meta   QueueMeta
+		)
+
+		BeforeEach(func() {
+			schema = &pub.Schema{
+				Id: `"C##NAVEEGO"."ORDER_EVENTS"`,
+				Properties: append(append([]*pub.Property{}, messageProperties...),
+					&pub.Property{Id: `"ORDER_ID"`, Name: "ORDER_ID", Type: pub.PropertyType_INTEGER, TypeAtSource: "NUMBER(10,0)"},
+					&pub.Property{Id: `"STATUS"`, Name: "STATUS", Type: pub.PropertyType_STRING, TypeAtSource: "VARCHAR2(20)"},
+					&pub.Property{Id: `"AMOUNT"`, Name: "AMOUNT", Type: pub.PropertyType_DECIMAL, TypeAtSource: "NUMBER(12,2)"},
+					&pub.Property{Id: `"PLACED_AT"`, Name: "PLACED_AT", Type: pub.PropertyType_DATETIME, TypeAtSource: "DATE"},
+				),
+			}
+			meta = QueueMeta{
+				QueueTable:        "ORDER_EVENTS_QT",
+				PayloadType:       `"C##NAVEEGO"."ORDER_EVENT_T"`,
+				MultipleConsumers: true,
+			}
+		})
+
+		It("should read the ready messages from the queue table", func() {
+			expectGolden("queue/object_query.sql", []string{BuildQueueQuery(schema, meta)})
+		})
+
+		It("should dequeue in a transaction by default", func() {
+			settings := RealTimeSettings{Mode: RealTimeModeDequeue, ConsumerName: "PIPELINE"}
+			Expect(settings.Validate()).To(Succeed())
+
+			expectGolden("queue/object_dequeue.sql", []string{NewQueueLayout(schema, meta).DequeueBlock(settings)})
+		})
+
+		It("should convert a message to a record", func() {
+			record, err := NewQueueLayout(schema, meta).Record(
+				text("8F3E6A", "order-42", "2019-03-04 05:06:07.250000000", "1", "42", "SHIPPED", ".5", "2019-03-01 12:00:00"), nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			var data map[string]interface{}
+			Expect(json.Unmarshal([]byte(record.DataJson), &data)).To(Succeed())
+			Expect(data).To(Equal(map[string]interface{}{
+				`"AQ$MSG_ID"`:       "8F3E6A",
+				`"AQ$CORRELATION"`:  "order-42",
+				`"AQ$ENQUEUE_TIME"`: "2019-03-04T05:06:07.25Z",
+				`"AQ$PRIORITY"`:     float64(1),
+				`"ORDER_ID"`:        float64(42),
+				`"STATUS"`:          "SHIPPED",
+				`"AMOUNT"`:          0.5,
+				`"PLACED_AT"`:       "2019-03-01T12:00:00Z",
+			}))
+		})
+
+		It("should publish missing attributes as nulls", func() {
+			record, err := NewQueueLayout(schema, meta).Record(
+				text("8F3E6A", "", "2019-03-04 05:06:07.250000000", "1", "42", "", "", ""), nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			var data map[string]interface{}
+			Expect(json.Unmarshal([]byte(record.DataJson), &data)).To(Succeed())
+			Expect(data).To(HaveKeyWithValue(`"AQ$CORRELATION"`, BeNil()))
+			Expect(data).To(HaveKeyWithValue(`"STATUS"`, BeNil()))
+			Expect(data).To(HaveKeyWithValue(`"PLACED_AT"`, BeNil()))
+		})
+	})
+
+	Describe("RAW payloads", func() {
+
+		It("should find the fields of JSON payloads", func() {
+			names, types, ok := JSONPayloadFields([][]byte{
+				[]byte(`{"id": 1, "name": null, "tags": ["a"]}`),
+				[]byte(`{"id": 2, "name": "second", "total": 9.75, "paid": true}`),
+			})
+			Expect(ok).To(BeTrue())
+			Expect(names).To(Equal([]string{"id", "name", "tags", "total", "paid"}))
+			Expect(types).To(Equal([]pub.PropertyType{
+				pub.PropertyType_INTEGER,
+				pub.PropertyType_STRING,
+				pub.PropertyType_JSON,
+				pub.PropertyType_FLOAT,
+				pub.PropertyType_BOOL,
+			}))
+		})
+
+		It("should not parse payloads which are not all JSON objects", func() {
+			_, _, ok := JSONPayloadFields([][]byte{
+				[]byte(`{"id": 1}`),
+				[]byte(`not json`),
+			})
+			Expect(ok).To(BeFalse())
+
+			_, _, ok = JSONPayloadFields([][]byte{[]byte(`[1, 2]`)})
+			Expect(ok).To(BeFalse())
+
+			_, _, ok = JSONPayloadFields(nil)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should browse and publish the fields of a JSON payload", func() {
+			schema := &pub.Schema{
+				Id: `"C##NAVEEGO"."EVENTS"`,
+				Properties: append(append([]*pub.Property{}, messageProperties...),
+					&pub.Property{Id: `"id"`, Name: "id", Type: pub.PropertyType_INTEGER, TypeAtSource: "JSON"},
+					&pub.Property{Id: `"name"`, Name: "name", Type: pub.PropertyType_STRING, TypeAtSource: "JSON"},
+				),
+			}
+			meta := QueueMeta{QueueTable: "EVENTS_QT", PayloadType: "RAW", JSONPayload: true}
+			settings := RealTimeSettings{Mode: RealTimeModeDequeue, DequeueMode: DequeueModeBrowse}
+			Expect(settings.Validate()).To(Succeed())
+
+			layout := NewQueueLayout(schema, meta)
+			expectGolden("queue/raw_query.sql", []string{BuildQueueQuery(schema, meta)})
+			expectGolden("queue/raw_browse.sql", []string{layout.DequeueBlock(settings)})
+
+			record, err := layout.Record(text("01AB", "", "2019-03-04 05:06:07.000000000", "0"), []byte(`{"id": 12345678901234567890, "name": "first", "other": true}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			var data map[string]interface{}
+			d := json.NewDecoder(strings.NewReader(record.DataJson))
+			d.UseNumber()
+			Expect(d.Decode(&data)).To(Succeed())
+			Expect(data).To(HaveKeyWithValue(`"id"`, json.Number("12345678901234567890")))
+			Expect(data).To(HaveKeyWithValue(`"name"`, "first"))
+			Expect(data).ToNot(HaveKey(`"other"`))
+		})
+
+		It("should fail to publish a payload which is not JSON", func() {
+			schema := &pub.Schema{
+				Id:         `"C##NAVEEGO"."EVENTS"`,
+				Properties: append(append([]*pub.Property{}, messageProperties...), &pub.Property{Id: `"id"`, Name: "id", Type: pub.PropertyType_INTEGER}),
+			}
+			meta := QueueMeta{QueueTable: "EVENTS_QT", PayloadType: "RAW", JSONPayload: true}
+
+			_, err := NewQueueLayout(schema, meta).Record(text("01AB", "", "2019-03-04 05:06:07.000000000", "0"), []byte(`oops`))
+			Expect(err).To(MatchError(ContainSubstring("message 01AB does not have a JSON object payload")))
+		})
+
+		It("should publish a RAW payload which is not JSON as bytes", func() {
+			schema := &pub.Schema{
+				Id:         `"C##NAVEEGO"."BLOBS"`,
+				Properties: append(append([]*pub.Property{}, messageProperties...), &pub.Property{Id: `"PAYLOAD"`, Name: "PAYLOAD", Type: pub.PropertyType_BLOB, TypeAtSource: "RAW"}),
+			}
+			meta := QueueMeta{QueueTable: "BLOBS_QT", PayloadType: "RAW"}
+
+			record, err := NewQueueLayout(schema, meta).Record(text("01AB", "", "2019-03-04 05:06:07.000000000", "0"), []byte{0xCA, 0xFE})
+			Expect(err).ToNot(HaveOccurred())
+
+			var data map[string]interface{}
+			Expect(json.Unmarshal([]byte(record.DataJson), &data)).To(Succeed())
+			Expect(data).To(HaveKeyWithValue(`"PAYLOAD"`, "yv4="))
+		})
+	})
+
+	Describe("dequeue settings", func() {
+
+		It("should default to removing messages on commit", func() {
+			settings := RealTimeSettings{Mode: RealTimeModeDequeue}
+			Expect(settings.Validate()).To(Succeed())
+			Expect(settings.DequeueMode).To(Equal(DequeueModeRemove))
+			Expect(settings.Visibility).To(Equal(DequeueVisibilityOnCommit))
+			Expect(settings.PollingIntervalSeconds).To(Equal(60))
+		})
+
+		It("should reject unknown modes", func() {
+			settings := RealTimeSettings{Mode: RealTimeModeDequeue, DequeueMode: "Peek"}
+			Expect(settings.Validate()).To(MatchError(ContainSubstring(`unrecognized dequeueMode "Peek"`)))
+
+			settings = RealTimeSettings{Mode: RealTimeModeDequeue, Visibility: "Later"}
+			Expect(settings.Validate()).To(MatchError(ContainSubstring(`unrecognized visibility "Later"`)))
+		})
+	})
+})
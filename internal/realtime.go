@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// RealTimeState is the state returned to the host in Record.RealTimeStateJson,
+// which is passed back in ReadRequest.RealTimeStateJson to resume a real time read.
+type RealTimeState struct {
+	// SCN is the system change number the data was read at.
+	SCN uint64 `json:"scn,omitempty"`
+	// MiningSCN is the SCN LogMiner resumes mining from, which is before SCN
+	// if a transaction was open when the state was committed.
+	MiningSCN uint64 `json:"miningScn,omitempty"`
+	// Watermark is the highest value of the watermark column which has been read.
+	Watermark string `json:"watermark,omitempty"`
+	// Boundary holds a hash of each row in the overlap window below the watermark
+	// which has been published, keyed by the row's key, so that they are not published again.
+	Boundary map[string]string `json:"boundary,omitempty"`
+}
+
+// realTimeModeForm is the part of the ConfigureRealTime form for a single mode.
+type realTimeModeForm struct {
+	mode RealTimeMode
+	// properties is the JSON of the form properties for the mode, other than mode itself.
+	properties string
+}
+
+const pollingIntervalProperty = `"pollingIntervalSeconds": {
+  "type": "integer",
+  "title": "Polling Interval (Seconds)",
+  "description": "How often the schema is checked for changes.",
+  "default": 60,
+  "minimum": 1
+},
+"changeNotifications": {
+  "type": "boolean",
+  "title": "Wake on Change Notification",
+  "description": "Check for changes as soon as Oracle notifies the plugin that the table has changed, using Continuous Query Notification, as well as on the polling interval. Requires the CHANGE NOTIFICATION privilege, and that the database can connect to the plugin's host.",
+  "default": false
+}`
+
+// realTimeFormSchema returns the JSON schema for the ConfigureRealTime form,
+// offering the modes which are available for the schema.
+func (s *Server) realTimeFormSchema(schema *pub.Schema) string {
+	modes := []realTimeModeForm{
+		{
+			mode:       RealTimeModeSCN,
+			properties: pollingIntervalProperty,
+		},
+	}
+
+	if queue := schemaQueue(schema); queue != nil {
+		// a queue can only be read by dequeuing
+		modes = []realTimeModeForm{{
+			mode:       RealTimeModeDequeue,
+			properties: dequeueProperties(queue),
+		}}
+	} else if schema != nil && schema.Query == "" {
+		modes = append(modes, realTimeModeForm{
+			mode:       RealTimeModeLogMiner,
+			properties: pollingIntervalProperty,
+		}, realTimeModeForm{
+			mode: RealTimeModeTriggers,
+			properties: fmt.Sprintf(`"installChangeLog": {
+  "type": "boolean",
+  "title": "Install Change Log",
+  "description": %q,
+  "default": false
+},
+%s`, changeLogConsentDescription(schema.Id), pollingIntervalProperty),
+		})
+	}
+
+	if columns := watermarkColumns(schema); len(columns) > 0 && schemaQueue(schema) == nil {
+		enum, _ := json.Marshal(columns)
+		modes = append(modes, realTimeModeForm{
+			mode: RealTimeModeWatermark,
+			properties: fmt.Sprintf(`"watermarkColumn": {
+  "type": "string",
+  "title": "Watermark Column",
+  "description": "A column whose value increases whenever a row is inserted or changed, such as a last modified timestamp or a sequence backed ID.",
+  "enum": %s
+},
+"overlapWindow": {
+  "type": "number",
+  "title": "Overlap Window",
+  "description": "How far behind the highest watermark read to look for rows from transactions which committed late. This is in seconds for date columns, and in values for numeric columns.",
+  "default": 0,
+  "minimum": 0
+},
+%s`, enum, pollingIntervalProperty),
+		})
+	}
+
+	const description = "How changes to the schema are detected."
+	warnings := s.realTimeWarnings(schema)
+
+	var enum, oneOf []string
+	for _, m := range modes {
+		// the warnings of a mode are only shown once it is chosen
+		modeDescription := description
+		for _, warning := range warnings[m.mode] {
+			modeDescription = fmt.Sprintf("%s Warning: %s", modeDescription, warning)
+		}
+
+		enum = append(enum, fmt.Sprintf("%q", m.mode))
+		oneOf = append(oneOf, fmt.Sprintf(`{
+  "properties": {
+    "mode": {"enum": [%q], "description": %q},
+    %s
+  }
+}`, m.mode, modeDescription, m.properties))
+	}
+
+	return fmt.Sprintf(`{
+  "type": "object",
+  "properties": {
+    "mode": {
+      "type": "string",
+      "title": "Change Detection",
+      "description": %q,
+      "enum": [%s]
+    }
+  },
+  "required": [
+    "mode"
+  ],
+  "dependencies": {
+    "mode": {
+      "oneOf": [%s]
+    }
+  }
+}`, description, strings.Join(enum, ","), strings.Join(oneOf, ","))
+}
+
+// realTimeWarnings returns the problems which make real time reads
+// of the schema less efficient, but do not prevent them, by the mode they apply to.
+func (s *Server) realTimeWarnings(schema *pub.Schema) map[RealTimeMode][]string {
+	if schema == nil || schema.Query != "" || schemaQueue(schema) != nil {
+		return nil
+	}
+
+	warnings := map[RealTimeMode][]string{}
+
+	owner, table := decomposeSafeName(schema.Id)
+	enabled, err := s.hasRowDependencies(owner, table)
+	if err != nil {
+		s.log.Warn("Could not check row dependencies.", "schema", schema.Id, "err", err)
+	} else if !enabled {
+		warnings[RealTimeModeSCN] = append(warnings[RealTimeModeSCN], rowDependenciesWarning(schema.Id))
+	}
+
+	logged, err := s.hasSupplementalLogging(owner, table)
+	if err != nil {
+		s.log.Warn("Could not check supplemental logging.", "schema", schema.Id, "err", err)
+	} else if !logged {
+		warnings[RealTimeModeLogMiner] = append(warnings[RealTimeModeLogMiner], supplementalLoggingWarning(schema.Id))
+	}
+
+	return warnings
+}
+
+// validateRealTimeSettings returns the reasons the settings cannot be used for the schema.
+func (s *Server) validateRealTimeSettings(schema *pub.Schema, settings *RealTimeSettings) []string {
+	var errs []string
+
+	if err := settings.Validate(); err != nil {
+		return append(errs, err.Error())
+	}
+
+	queue := schemaQueue(schema)
+
+	if settings.ChangeNotifications && (schema == nil || schema.Query != "" || queue != nil) {
+		errs = append(errs, "change notifications can only be used with a table, not a query or a queue")
+	}
+
+	if queue != nil && settings.Mode != RealTimeModeDequeue {
+		return append(errs, fmt.Sprintf("%s is a queue, which can only be read in %s mode", schema.Id, RealTimeModeDequeue))
+	}
+
+	switch settings.Mode {
+	case RealTimeModeSCN:
+		if schema == nil || schema.Query != "" {
+			errs = append(errs, "SCN polling can only be used with a table, not a query")
+		}
+	case RealTimeModeLogMiner:
+		if schema == nil || schema.Query != "" {
+			errs = append(errs, "LogMiner can only be used with a table, not a query")
+		}
+	case RealTimeModeTriggers:
+		if schema == nil || schema.Query != "" {
+			errs = append(errs, "change log triggers can only be used with a table, not a query")
+		}
+	case RealTimeModeDequeue:
+		if queue == nil {
+			errs = append(errs, "dequeue can only be used with a queue")
+		} else if queue.MultipleConsumers && settings.ConsumerName == "" {
+			errs = append(errs, fmt.Sprintf("%s is a multi-consumer queue, so the consumerName property must be set to a subscriber of the queue", schema.Id))
+		} else if !queue.MultipleConsumers && settings.ConsumerName != "" {
+			errs = append(errs, fmt.Sprintf("%s is a single-consumer queue, so the consumerName property must not be set", schema.Id))
+		}
+	case RealTimeModeWatermark:
+		found := false
+		for _, c := range watermarkColumns(schema) {
+			found = found || c == settings.WatermarkColumn
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s cannot be used as a watermark column, it must be a number, date or timestamp property of the schema", settings.WatermarkColumn))
+		}
+	}
+
+	return errs
+}
+
+// watermarkColumns returns the IDs of the properties which can be used as a watermark column.
+func watermarkColumns(schema *pub.Schema) []string {
+	if schema == nil {
+		return nil
+	}
+
+	var columns []string
+	for _, p := range schema.Properties {
+		switch p.Type {
+		case pub.PropertyType_DATE, pub.PropertyType_DATETIME, pub.PropertyType_INTEGER, pub.PropertyType_DECIMAL, pub.PropertyType_FLOAT:
+			columns = append(columns, p.Id)
+		}
+	}
+	return columns
+}
+
+// readRealTime reads the schema continuously using the mode in the real time settings,
+// until the stream is closed.
+func (s *Server) readRealTime(req *pub.ReadRequest, stream pub.Publisher_ReadStreamServer) error {
+	var settings RealTimeSettings
+	if err := json.Unmarshal([]byte(req.RealTimeSettingsJson), &settings); err != nil {
+		return errors.Errorf("could not parse real time settings: %s", err)
+	}
+	if errs := s.validateRealTimeSettings(req.Schema, &settings); len(errs) > 0 {
+		return errors.Errorf("invalid real time settings: %s", strings.Join(errs, "; "))
+	}
+
+	var state RealTimeState
+	if req.RealTimeStateJson != "" {
+		if err := json.Unmarshal([]byte(req.RealTimeStateJson), &state); err != nil {
+			return errors.Errorf("could not parse real time state: %s", err)
+		}
+	}
+
+	s.log.Info("Starting real time read.", "schema", req.Schema.Id, "mode", settings.Mode, "state", req.RealTimeStateJson)
+
+	ctx := stream.Context()
+
+	notifier, err := s.startChangeNotifier(ctx, req.Schema.Id, settings)
+	if err != nil {
+		return err
+	}
+	if notifier != nil {
+		defer notifier.Close()
+	}
+	waiter := newPollWaiter(settings, notifier)
+
+	switch settings.Mode {
+	case RealTimeModeSCN:
+		return s.pollSCN(ctx, req, settings, state, waiter, stream)
+	case RealTimeModeWatermark:
+		return s.pollWatermark(ctx, req, settings, state, waiter, stream)
+	case RealTimeModeLogMiner:
+		return s.pollLogMiner(ctx, req, settings, state, waiter, stream)
+	case RealTimeModeTriggers:
+		return s.pollChangeLog(ctx, req, settings, state, waiter, stream)
+	case RealTimeModeDequeue:
+		return s.pollQueue(ctx, req, settings, state, waiter, stream)
+	default:
+		return errors.Errorf("unrecognized mode %q", settings.Mode)
+	}
+}
+
+// commitRealTimeState tells the host that all the records before this one
+// have been sent, and that the read can be resumed from the state.
+func commitRealTimeState(stream pub.Publisher_ReadStreamServer, state RealTimeState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return stream.Send(&pub.Record{
+		Action:            pub.Record_REAL_TIME_STATE_COMMIT,
+		RealTimeStateJson: string(b),
+	})
+}
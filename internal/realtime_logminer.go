@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// pollLogMiner publishes the changes to a table by mining the redo logs with DBMS_LOGMNR.
+// Without a checkpoint the whole table is published as of the current SCN first.
+// Each poll mines from the checkpoint to the current SCN, publishing the changes of
+// each committed transaction followed by a commit of the transaction's commit SCN.
+// Only committed data is mined, so mining resumes from the start of the oldest
+// transaction which was open at the end of the previous poll, skipping the
+// transactions which committed before the checkpoint.
+func (s *Server) pollLogMiner(ctx context.Context, req *pub.ReadRequest, settings RealTimeSettings, state RealTimeState, waiter pollWaiter, stream pub.Publisher_ReadStreamServer) error {
+	log := s.log.With("schema", req.Schema.Id)
+
+	if state.SCN == 0 {
+		scn, err := s.getCurrentSCN(ctx)
+		if err != nil {
+			return errors.Errorf("could not get current SCN: %s", err)
+		}
+
+		cause := fmt.Sprintf("Initial load as of SCN %d", scn)
+		_, err = s.sendRecords(ctx, req, readScope{asOfSCN: scn}, stream, func(record *pub.Record) (bool, error) {
+			record.Cause = cause
+			return true, nil
+		})
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+
+		state = RealTimeState{SCN: scn}
+		if state.MiningSCN, err = s.getOldestOpenSCN(ctx, scn); err != nil {
+			log.Warn("Could not find the oldest open transaction, changes made by transactions open during the initial load may be missed.", "err", err)
+			state.MiningSCN = scn
+		}
+		if err = commitRealTimeState(stream, state); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if !waiter.wait(ctx) {
+			return nil
+		}
+
+		end, err := s.getCurrentSCN(ctx)
+		if err != nil {
+			return errors.Errorf("could not get current SCN: %s", err)
+		}
+
+		count, err := s.mineChanges(ctx, req.Schema, state.MiningSCN, end, state.SCN, func(record *pub.Record, commitSCN uint64) error {
+			if record != nil {
+				return stream.Send(record)
+			}
+			state.SCN = commitSCN
+			return commitRealTimeState(stream, state)
+		})
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// every transaction which committed by the end SCN has now been published
+		state.SCN = end
+		state.MiningSCN, err = s.getOldestOpenSCN(ctx, end)
+		if err != nil {
+			log.Warn("Could not find the oldest open transaction, changes made by transactions which span polls may be missed.", "err", err)
+			state.MiningSCN = end
+		}
+		if err = commitRealTimeState(stream, state); err != nil {
+			return err
+		}
+
+		log.Debug("Mined changes.", "scn", end, "miningScn", state.MiningSCN, "changes", count)
+	}
+}
+
+// mineChanges mines the changes to the table between the SCNs, passing each record to send.
+// When a transaction which changed the table commits, send is called with a nil record
+// and the transaction's commit SCN. Transactions which committed at or before
+// the skip SCN have already been published and are ignored.
+func (s *Server) mineChanges(ctx context.Context, schema *pub.Schema, start, end, skip uint64, send func(record *pub.Record, commitSCN uint64) error) (int, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	for _, stmt := range []string{
+		fmt.Sprintf(`ALTER SESSION SET NLS_DATE_FORMAT = '%s'`, logMinerDateFormat),
+		fmt.Sprintf(`ALTER SESSION SET NLS_TIMESTAMP_FORMAT = '%s'`, logMinerTimestampFormat),
+		fmt.Sprintf(`ALTER SESSION SET NLS_TIMESTAMP_TZ_FORMAT = '%s'`, logMinerTimestampTZFormat),
+	} {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	logFiles, err := s.getLogFiles(ctx, conn, start, end)
+	if err != nil {
+		return 0, errors.Errorf("could not list redo logs: %s", err)
+	}
+	if len(logFiles) == 0 {
+		return 0, errors.Errorf("there are no redo logs containing SCN %d, they may have been deleted", start)
+	}
+
+	for i, name := range logFiles {
+		option := "DBMS_LOGMNR.ADDFILE"
+		if i == 0 {
+			option = "DBMS_LOGMNR.NEW"
+		}
+		_, err = conn.ExecContext(ctx, fmt.Sprintf(`BEGIN DBMS_LOGMNR.ADD_LOGFILE(LOGFILENAME => :name, OPTIONS => %s); END;`, option), sql.Named("name", name))
+		if err != nil {
+			return 0, errors.Errorf("could not add redo log %s: %s", name, err)
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, `BEGIN DBMS_LOGMNR.START_LOGMNR(
+  STARTSCN => :start_scn,
+  ENDSCN => :end_scn,
+  OPTIONS => DBMS_LOGMNR.DICT_FROM_ONLINE_CATALOG + DBMS_LOGMNR.COMMITTED_DATA_ONLY + DBMS_LOGMNR.NO_ROWID_IN_STMT); END;`,
+		sql.Named("start_scn", int64(start)), sql.Named("end_scn", int64(end)))
+	if err != nil {
+		return 0, errors.Errorf("could not start LogMiner: %s", err)
+	}
+	defer func() {
+		if _, endErr := conn.ExecContext(context.Background(), `BEGIN DBMS_LOGMNR.END_LOGMNR; END;`); endErr != nil {
+			s.log.Warn("Could not end LogMiner session.", "err", endErr)
+		}
+	}()
+
+	owner, table := decomposeSafeName(schema.Id)
+	rows, err := conn.QueryContext(ctx, `SELECT SCN, COMMIT_SCN, RAWTOHEX(XID), OPERATION_CODE, OPERATION, SEG_OWNER, TABLE_NAME, ROW_ID, SQL_REDO, CSF
+FROM V$LOGMNR_CONTENTS
+WHERE (SEG_OWNER = :owner AND TABLE_NAME = :name AND OPERATION_CODE IN (1, 2, 3, 255)) OR OPERATION_CODE = 7`,
+		sql.Named("owner", owner), sql.Named("name", table))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	translator := NewRedoTranslator(schema)
+	// changed holds the transactions which have changed the table and not yet committed
+	changed := make(map[string]bool)
+	count := 0
+
+	for rows.Next() {
+		if ctx.Err() != nil {
+			return count, nil
+		}
+
+		var row LogMinerRow
+		var commitSCN sql.NullInt64
+		var segOwner, tableName, rowID, redo sql.NullString
+		if err = rows.Scan(&row.SCN, &commitSCN, &row.XID, &row.OperationCode, &row.Operation, &segOwner, &tableName, &rowID, &redo, &row.CSF); err != nil {
+			return count, errors.WithStack(err)
+		}
+		row.CommitSCN = uint64(commitSCN.Int64)
+		row.SegOwner, row.TableName, row.RowID, row.SQLRedo = segOwner.String, tableName.String, rowID.String, redo.String
+
+		if row.CommitSCN <= skip {
+			continue
+		}
+
+		if row.OperationCode == logMinerCommit {
+			if changed[row.XID] {
+				delete(changed, row.XID)
+				if err = send(nil, row.CommitSCN); err != nil {
+					return count, err
+				}
+			}
+			continue
+		}
+
+		record, err := translator.Translate(row)
+		if err != nil {
+			return count, err
+		}
+		if record == nil {
+			continue
+		}
+
+		changed[row.XID] = true
+		if err = send(record, row.CommitSCN); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, errors.WithStack(rows.Err())
+}
+
+// getLogFiles returns the online and archived redo logs which contain changes between the SCNs.
+// An archived log is only used if its sequence is no longer in the online logs.
+func (s *Server) getLogFiles(ctx context.Context, q queryer, start, end uint64) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT MIN(F.MEMBER)
+FROM V$LOG L
+JOIN V$LOGFILE F ON F.GROUP# = L.GROUP#
+WHERE L.NEXT_CHANGE# > :start_scn AND L.FIRST_CHANGE# <= :end_scn
+GROUP BY L.SEQUENCE#
+UNION ALL
+SELECT MIN(A.NAME)
+FROM V$ARCHIVED_LOG A
+WHERE A.NEXT_CHANGE# > :start_scn AND A.FIRST_CHANGE# <= :end_scn
+  AND A.NAME IS NOT NULL AND A.DELETED = 'NO' AND A.STANDBY_DEST = 'NO'
+  AND A.SEQUENCE# NOT IN (SELECT SEQUENCE# FROM V$LOG)
+GROUP BY A.SEQUENCE#`,
+		sql.Named("start_scn", int64(start)), sql.Named("end_scn", int64(end)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		names = append(names, name)
+	}
+
+	return names, errors.WithStack(rows.Err())
+}
+
+// getOldestOpenSCN returns the start SCN of the oldest open transaction,
+// or the given SCN if it is older.
+func (s *Server) getOldestOpenSCN(ctx context.Context, scn uint64) (uint64, error) {
+	var oldest sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, `SELECT MIN(START_SCN) FROM V$TRANSACTION`).Scan(&oldest); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if oldest.Valid && uint64(oldest.Int64) < scn {
+		return uint64(oldest.Int64), nil
+	}
+	return scn, nil
+}
+
+// hasSupplementalLogging returns true if all the columns of the table are logged
+// on update and delete, so that LogMiner can publish the whole row.
+func (s *Server) hasSupplementalLogging(owner, table string) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM ALL_LOG_GROUPS WHERE OWNER = :owner AND TABLE_NAME = :name AND LOG_GROUP_TYPE = 'ALL COLUMN LOGGING'`,
+		sql.Named("owner", owner), sql.Named("name", table)).Scan(&count)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return count > 0, nil
+}
+
+func supplementalLoggingWarning(schemaID string) string {
+	return fmt.Sprintf("%s does not have supplemental logging of all columns, so LogMiner will only publish the key and changed columns of updates and deletes. Run ALTER TABLE %s ADD SUPPLEMENTAL LOG DATA (ALL) COLUMNS to publish whole rows.", schemaID, schemaID)
+}
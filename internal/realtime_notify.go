@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/goracle.v2"
+)
+
+// changeNotifier wakes a real time read when the table it is watching changes.
+type changeNotifier interface {
+	// Notifications receives a value after the table has changed. Changes which
+	// happen before the previous value has been received are coalesced into it.
+	Notifications() <-chan struct{}
+	Close() error
+}
+
+// changeNotifierFactory starts watching a table for changes.
+type changeNotifierFactory func(ctx context.Context, owner, table string) (changeNotifier, error)
+
+// pollWaiter waits between the polls of a real time read.
+type pollWaiter struct {
+	interval time.Duration
+	// wake is closed or receives a value to end the wait early, if set.
+	wake <-chan struct{}
+}
+
+func newPollWaiter(settings RealTimeSettings, notifier changeNotifier) pollWaiter {
+	w := pollWaiter{interval: time.Duration(settings.PollingIntervalSeconds) * time.Second}
+	if notifier != nil {
+		w.wake = notifier.Notifications()
+	}
+	return w
+}
+
+// wait waits for the polling interval to elapse, or for a change notification.
+// It returns false if the context was cancelled first.
+func (w pollWaiter) wait(ctx context.Context) bool {
+	select {
+	case <-time.After(w.interval):
+		return true
+	case <-w.wake:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// startChangeNotifier starts watching the table of the schema if change
+// notifications are enabled in the settings. It returns nil otherwise.
+func (s *Server) startChangeNotifier(ctx context.Context, schemaID string, settings RealTimeSettings) (changeNotifier, error) {
+	if !settings.ChangeNotifications {
+		return nil, nil
+	}
+
+	factory := s.newChangeNotifier
+	if factory == nil {
+		factory = s.newCQNNotifier
+	}
+
+	owner, table := decomposeSafeName(schemaID)
+	notifier, err := factory(ctx, owner, table)
+	if err != nil {
+		return nil, errors.Errorf("could not register for change notifications on %s: %s", schemaID, err)
+	}
+
+	return notifier, nil
+}
+
+// cqnNotifier is a changeNotifier using Oracle Continuous Query Notification.
+type cqnNotifier struct {
+	db           *sql.DB
+	subscription *goracle.Subscription
+	events       chan struct{}
+}
+
+// newCQNNotifier registers a Continuous Query Notification subscription for the table.
+// Subscriptions need a connection with events enabled, so they get their own pool.
+// The user needs the CHANGE NOTIFICATION privilege, and the database must
+// be able to connect back to the plugin's host to deliver notifications.
+func (s *Server) newCQNNotifier(ctx context.Context, owner, table string) (changeNotifier, error) {
+	connectionString, err := s.settings.GetConnectionString()
+	if err != nil {
+		return nil, err
+	}
+	params, err := goracle.ParseConnString(connectionString)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	params.EnableEvents = true
+
+	n := &cqnNotifier{
+		events: make(chan struct{}, 1),
+	}
+
+	n.db, err = sql.Open("goracle", params.StringWithPassword())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// the subscription belongs to the session it was created on
+	n.db.SetMaxOpenConns(1)
+
+	conn, err := goracle.DriverConn(n.db)
+	if err != nil {
+		n.db.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	log := s.log.With("table", table)
+	n.subscription, err = conn.NewSubscription(fmt.Sprintf("naveego_%s_%s", owner, table), func(event goracle.Event) {
+		if event.Err != nil {
+			log.Warn("Change notification error.", "err", event.Err)
+		}
+		select {
+		case n.events <- struct{}{}:
+		default:
+			// a notification is already pending
+		}
+	})
+	if err != nil {
+		n.db.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	if err = n.subscription.Register(fmt.Sprintf(`SELECT * FROM "%s"."%s"`, owner, table)); err != nil {
+		n.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	log.Debug("Registered for change notifications.")
+
+	return n, nil
+}
+
+func (n *cqnNotifier) Notifications() <-chan struct{} {
+	return n.events
+}
+
+func (n *cqnNotifier) Close() error {
+	err := n.subscription.Close()
+	if closeErr := n.db.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.WithStack(err)
+}
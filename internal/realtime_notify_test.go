@@ -0,0 +1,56 @@
+package internal_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/naveego/plugin-oracle/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Waiting for the next poll", func() {
+
+	var (
+		settings RealTimeSettings
+		notifier *FakeChangeNotifier
+	)
+
+	BeforeEach(func() {
+		settings = RealTimeSettings{Mode: RealTimeModeSCN, PollingIntervalSeconds: 3600, ChangeNotifications: true}
+		notifier = NewFakeChangeNotifier()
+	})
+
+	It("should wake when the table changes", func() {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			notifier.Events <- struct{}{}
+		}()
+
+		done := make(chan bool)
+		go func() { done <- WaitForNextPoll(context.Background(), settings, notifier) }()
+		Eventually(done).Should(Receive(BeTrue()))
+	})
+
+	It("should coalesce notifications received while polling", func() {
+		notifier.Events <- struct{}{}
+		Expect(notifier.Events).ToNot(BeSent(struct{}{}), "a notification is already pending")
+
+		Expect(WaitForNextPoll(context.Background(), settings, notifier)).To(BeTrue())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		Expect(WaitForNextPoll(ctx, settings, notifier)).To(BeFalse(), "there should be no notification left")
+	})
+
+	It("should stop waiting when the read is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(WaitForNextPoll(ctx, settings, notifier)).To(BeFalse())
+	})
+
+	It("should poll on the interval without notifications", func() {
+		settings.PollingIntervalSeconds = 0
+		Expect(WaitForNextPoll(context.Background(), settings, nil)).To(BeTrue())
+	})
+})
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// oraDequeueTimeout is the error raised by DBMS_AQ.DEQUEUE when no message arrives before the wait elapses.
+const oraDequeueTimeout = 25228
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// dequeueProperties returns the JSON of the form properties for RealTimeModeDequeue.
+func dequeueProperties(queue *QueueMeta) string {
+	consumer := ""
+	if queue.MultipleConsumers {
+		consumer = `"consumerName": {
+  "type": "string",
+  "title": "Subscriber",
+  "description": "The subscriber to dequeue messages as. It must have been added to the queue using DBMS_AQADM.ADD_SUBSCRIBER, and should not be used by any other consumer."
+},
+`
+	}
+
+	return fmt.Sprintf(`%s"dequeueMode": {
+  "type": "string",
+  "title": "Dequeue Mode",
+  "description": "Remove dequeues each message once it has been published. Browse publishes messages without dequeuing them, and is checked on the polling interval.",
+  "enum": [%q, %q],
+  "default": %q
+},
+"visibility": {
+  "type": "string",
+  "title": "Visibility",
+  "description": "On Commit only removes a message once the plugin has sent its record to the host, so that a message is never lost. Immediate removes a message as soon as it is dequeued, which is faster, but a message is lost if the plugin stops before it is sent.",
+  "enum": [%q, %q],
+  "default": %q
+},
+"pollingIntervalSeconds": {
+  "type": "integer",
+  "title": "Wait (Seconds)",
+  "description": "How long each dequeue waits for a message to arrive in Remove mode, and how often the queue is browsed in Browse mode.",
+  "default": 60,
+  "minimum": 1
+}`, consumer,
+		DequeueModeRemove, DequeueModeBrowse, DequeueModeRemove,
+		DequeueVisibilityOnCommit, DequeueVisibilityImmediate, DequeueVisibilityOnCommit)
+}
+
+// dequeueOptions select the message to dequeue.
+type dequeueOptions struct {
+	queueName    string
+	consumerName string
+	// condition is the dequeue condition, which can refer to the queue table as tab.
+	condition string
+	// wait is how long to wait for a message, in seconds.
+	wait         int
+	firstMessage bool
+}
+
+// dequeuedMessage is a message dequeued by the dequeue block.
+type dequeuedMessage struct {
+	msgID string
+	// enqueueTime is the time the message was enqueued, in logMinerDateFormat.
+	enqueueTime string
+	record      *pub.Record
+}
+
+// pollQueue publishes the messages of a queue by dequeuing them. In Remove mode each
+// message is dequeued in a transaction which is only committed after the record has been
+// sent to the host, unless the visibility is Immediate. A message whose transaction is
+// rolled back is retried, and moved to the exception queue after the queue's max retries.
+func (s *Server) pollQueue(ctx context.Context, req *pub.ReadRequest, settings RealTimeSettings, state RealTimeState, waiter pollWaiter, stream pub.Publisher_ReadStreamServer) error {
+	meta, err := getSchemaMeta(req.Schema)
+	if err != nil {
+		return err
+	}
+
+	l := newQueueLayout(req.Schema, *meta.Queue)
+	block := l.dequeueBlock(settings)
+	options := dequeueOptions{
+		queueName:    removeSafeName(req.Schema.Id),
+		consumerName: settings.ConsumerName,
+		wait:         settings.PollingIntervalSeconds,
+	}
+
+	// browsing continues from the previous message in the session
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	if settings.DequeueMode == DequeueModeBrowse {
+		return s.browseMessages(ctx, req.Schema, conn, block, l, options, state, waiter, stream)
+	}
+	return s.removeMessages(ctx, req.Schema, conn, block, l, options, stream)
+}
+
+// removeMessages dequeues messages until the context is cancelled, committing the
+// real time state whenever the queue has been emptied of the messages it held.
+func (s *Server) removeMessages(ctx context.Context, schema *pub.Schema, conn *sql.Conn, block string, l queueLayout, options dequeueOptions, stream pub.Publisher_ReadStreamServer) error {
+	log := s.log.With("schema", schema.Id)
+	cause := fmt.Sprintf("Dequeued from %s", schema.Id)
+	count := 0
+
+	for {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		message, err := s.dequeue(ctx, tx, block, l, options)
+		if err == nil && message != nil {
+			message.record.Cause = cause
+			err = stream.Send(message.record)
+		}
+		if err != nil || message == nil {
+			tx.Rollback()
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			// the wait elapsed without a message
+			if count > 0 {
+				if err = commitRealTimeState(stream, RealTimeState{}); err != nil {
+					return err
+				}
+				log.Debug("Dequeued messages.", "messages", count)
+				count = 0
+			}
+			continue
+		}
+
+		// the message is only removed from the queue once the host has its record
+		if err = tx.Commit(); err != nil {
+			return errors.Errorf("could not commit dequeue of message %s: %s", message.msgID, err)
+		}
+		count++
+	}
+}
+
+// browseMessages publishes the messages in the queue on each poll without dequeuing them.
+// The state holds the enqueue time of the latest message published as the watermark,
+// with the IDs of the messages enqueued at that time as the boundary, so that each poll
+// browses the messages enqueued from the watermark and skips those in the boundary.
+// Messages enqueued by a transaction which commits after a later message has been
+// browsed are not published.
+func (s *Server) browseMessages(ctx context.Context, schema *pub.Schema, conn *sql.Conn, block string, l queueLayout, options dequeueOptions, state RealTimeState, waiter pollWaiter, stream pub.Publisher_ReadStreamServer) error {
+	log := s.log.With("schema", schema.Id)
+	cause := fmt.Sprintf("Browsed from %s", schema.Id)
+	options.wait = 0
+
+	for {
+		options.firstMessage = true
+		options.condition = ""
+		if state.Watermark != "" {
+			options.condition = fmt.Sprintf("tab.enq_time >= TO_DATE('%s', '%s')", state.Watermark, logMinerDateFormat)
+		}
+
+		count := 0
+		for {
+			message, err := s.dequeue(ctx, conn, block, l, options)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if message == nil {
+				break
+			}
+			options.firstMessage = false
+
+			if _, ok := state.Boundary[message.msgID]; ok {
+				continue
+			}
+
+			message.record.Cause = cause
+			if err = stream.Send(message.record); err != nil {
+				return err
+			}
+			count++
+
+			switch {
+			case message.enqueueTime > state.Watermark:
+				state.Watermark = message.enqueueTime
+				state.Boundary = map[string]string{message.msgID: ""}
+			case message.enqueueTime == state.Watermark:
+				if state.Boundary == nil {
+					state.Boundary = make(map[string]string)
+				}
+				state.Boundary[message.msgID] = ""
+			}
+		}
+
+		if count > 0 {
+			if err := commitRealTimeState(stream, state); err != nil {
+				return err
+			}
+		}
+		log.Debug("Browsed queue.", "watermark", state.Watermark, "messages", count)
+
+		if !waiter.wait(ctx) {
+			return nil
+		}
+	}
+}
+
+// dequeue runs the dequeue block, returning nil if there
+// was no message before the wait elapsed.
+func (s *Server) dequeue(ctx context.Context, exec execer, block string, l queueLayout, options dequeueOptions) (*dequeuedMessage, error) {
+	firstMessage := 0
+	if options.firstMessage {
+		firstMessage = 1
+	}
+
+	var message dequeuedMessage
+	fields := make([]string, len(l.fields))
+	var payload string
+
+	args := []interface{}{
+		sql.Named("consumer_name", options.consumerName),
+		sql.Named("wait", options.wait),
+		sql.Named("condition", options.condition),
+		sql.Named("first_message", firstMessage),
+		sql.Named("queue_name", options.queueName),
+		sql.Named("msgid", sql.Out{Dest: &message.msgID}),
+		sql.Named("enqueue_time", sql.Out{Dest: &message.enqueueTime}),
+	}
+	for i := range fields {
+		args = append(args, sql.Named(fmt.Sprintf("f%d", i+1), sql.Out{Dest: &fields[i]}))
+	}
+	if l.rawPayload() {
+		args = append(args, sql.Named("payload", sql.Out{Dest: &payload}))
+	}
+
+	if _, err := exec.ExecContext(ctx, block, args...); err != nil {
+		if oraErrorCode(err) == oraDequeueTimeout {
+			return nil, nil
+		}
+		return nil, errors.Errorf("could not dequeue from %s: %s", options.queueName, err)
+	}
+
+	// Oracle does not distinguish empty strings from nulls
+	text := make([]*string, len(fields))
+	for i := range fields {
+		if fields[i] != "" {
+			text[i] = &fields[i]
+		}
+	}
+
+	b, err := decodeHexPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := l.record(pub.Record_INSERT, text, b)
+	if err != nil {
+		return nil, err
+	}
+	message.record = record
+
+	return &message, nil
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// pollSCN publishes the rows of a table which have been committed since the
+// last checkpoint SCN. Each poll reads the table as of the current SCN,
+// selecting the rows whose ORA_ROWSCN is after the checkpoint, and then
+// commits the current SCN as the new checkpoint. Without a checkpoint the
+// whole table is published as of the current SCN.
+func (s *Server) pollSCN(ctx context.Context, req *pub.ReadRequest, settings RealTimeSettings, state RealTimeState, waiter pollWaiter, stream pub.Publisher_ReadStreamServer) error {
+	log := s.log.With("schema", req.Schema.Id)
+
+	owner, table := decomposeSafeName(req.Schema.Id)
+	if enabled, err := s.hasRowDependencies(owner, table); err == nil && !enabled {
+		log.Warn(rowDependenciesWarning(req.Schema.Id))
+	}
+
+	for {
+		scn, err := s.getCurrentSCN(ctx)
+		if err != nil {
+			return errors.Errorf("could not get current SCN: %s", err)
+		}
+
+		cause := fmt.Sprintf("Committed after SCN %d", state.SCN)
+		if state.SCN == 0 {
+			cause = fmt.Sprintf("Initial load as of SCN %d", scn)
+		}
+
+		count, err := s.sendRecords(ctx, req, readScope{
+			asOfSCN:         scn,
+			changedAfterSCN: state.SCN,
+		}, stream, func(record *pub.Record) (bool, error) {
+			record.Cause = cause
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// a cancelled read may be incomplete, so it must not be checkpointed
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		state.SCN = scn
+		if err = commitRealTimeState(stream, state); err != nil {
+			return err
+		}
+
+		log.Debug("Polled for changes.", "scn", scn, "changes", count)
+
+		if !waiter.wait(ctx) {
+			return nil
+		}
+	}
+}
+
+// hasRowDependencies returns true if the table tracks ORA_ROWSCN for each row
+// rather than for each block.
+func (s *Server) hasRowDependencies(owner, table string) (bool, error) {
+	var dependencies string
+	err := s.db.QueryRow(`SELECT DEPENDENCIES FROM ALL_TABLES WHERE OWNER = :owner AND TABLE_NAME = :name`,
+		sql.Named("owner", owner), sql.Named("name", table)).Scan(&dependencies)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return dependencies == "ENABLED", nil
+}
+
+func rowDependenciesWarning(schemaID string) string {
+	return fmt.Sprintf("%s was not created with ROWDEPENDENCIES, so its SCN is only tracked per block and unchanged rows which share a block with a changed row will be published again.", schemaID)
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"github.com/pkg/errors"
+)
+
+// RealTimeSettings are the settings chosen in the ConfigureRealTime form,
+// which are passed back in ReadRequest.RealTimeSettingsJson.
+type RealTimeSettings struct {
+	Mode                   RealTimeMode `json:"mode"`
+	PollingIntervalSeconds int          `json:"pollingIntervalSeconds"`
+
+	// WatermarkColumn is the ID of the property used by RealTimeModeWatermark.
+	WatermarkColumn string `json:"watermarkColumn,omitempty"`
+	// OverlapWindow is how far behind the watermark RealTimeModeWatermark
+	// looks for late commits, in seconds for date/time watermarks.
+	OverlapWindow float64 `json:"overlapWindow,omitempty"`
+
+	// ChangeNotifications wakes the polling loop when Oracle
+	// notifies the plugin that the table has changed.
+	ChangeNotifications bool `json:"changeNotifications,omitempty"`
+
+	// InstallChangeLog is the user's consent for RealTimeModeTriggers to
+	// install, upgrade and purge the change log objects.
+	InstallChangeLog bool `json:"installChangeLog,omitempty"`
+
+	// ConsumerName is the subscriber RealTimeModeDequeue dequeues
+	// as, which is required for multi-consumer queues.
+	ConsumerName string `json:"consumerName,omitempty"`
+	// DequeueMode is whether RealTimeModeDequeue removes messages or only browses them.
+	DequeueMode DequeueMode `json:"dequeueMode,omitempty"`
+	// Visibility is whether a removed message is dequeued when the plugin commits,
+	// after the host has the record, or immediately.
+	Visibility DequeueVisibility `json:"visibility,omitempty"`
+}
+
+type RealTimeMode string
+
+const RealTimeModeSCN = RealTimeMode("SCN Polling")
+const RealTimeModeWatermark = RealTimeMode("Watermark Column")
+const RealTimeModeLogMiner = RealTimeMode("LogMiner")
+const RealTimeModeTriggers = RealTimeMode("Change Log Triggers")
+const RealTimeModeDequeue = RealTimeMode("Dequeue")
+
+type DequeueMode string
+
+const DequeueModeRemove = DequeueMode("Remove")
+const DequeueModeBrowse = DequeueMode("Browse")
+
+type DequeueVisibility string
+
+const DequeueVisibilityOnCommit = DequeueVisibility("On Commit")
+const DequeueVisibilityImmediate = DequeueVisibility("Immediate")
+
+// defaultPollingIntervalSeconds is used when the polling interval is not set.
+const defaultPollingIntervalSeconds = 60
+
+// Validate returns an error if the RealTimeSettings are not valid.
+// It also populates defaults for unset fields.
+func (r *RealTimeSettings) Validate() error {
+	if r.PollingIntervalSeconds == 0 {
+		r.PollingIntervalSeconds = defaultPollingIntervalSeconds
+	}
+	if r.PollingIntervalSeconds < 0 {
+		return errors.New("the pollingIntervalSeconds property must be positive")
+	}
+
+	switch r.Mode {
+	case RealTimeModeSCN, RealTimeModeLogMiner:
+		return nil
+	case RealTimeModeTriggers:
+		if !r.InstallChangeLog {
+			return errors.New("the change log objects can only be used if you allow them to be installed")
+		}
+		return nil
+	case RealTimeModeDequeue:
+		switch r.DequeueMode {
+		case "":
+			r.DequeueMode = DequeueModeRemove
+		case DequeueModeRemove, DequeueModeBrowse:
+		default:
+			return errors.Errorf("unrecognized dequeueMode %q", r.DequeueMode)
+		}
+		switch r.Visibility {
+		case "":
+			r.Visibility = DequeueVisibilityOnCommit
+		case DequeueVisibilityOnCommit, DequeueVisibilityImmediate:
+		default:
+			return errors.Errorf("unrecognized visibility %q", r.Visibility)
+		}
+		return nil
+	case RealTimeModeWatermark:
+		if r.WatermarkColumn == "" {
+			return errors.New("the watermarkColumn property must be set")
+		}
+		if r.OverlapWindow < 0 {
+			return errors.New("the overlapWindow property must not be negative")
+		}
+		return nil
+	case "":
+		return errors.New("the mode property must be set")
+	default:
+		return errors.Errorf("unrecognized mode %q", r.Mode)
+	}
+}
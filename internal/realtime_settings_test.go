@@ -0,0 +1,34 @@
+package internal_test
+
+import (
+	. "github.com/naveego/plugin-oracle/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RealTimeSettings", func() {
+
+	Describe("Validate", func() {
+
+		It("Should default the polling interval", func() {
+			settings := &RealTimeSettings{Mode: RealTimeModeSCN}
+			Expect(settings.Validate()).To(Succeed())
+			Expect(settings.PollingIntervalSeconds).To(Equal(60))
+		})
+
+		It("Should error if mode is not set", func() {
+			settings := &RealTimeSettings{}
+			Expect(settings.Validate()).ToNot(Succeed())
+		})
+
+		It("Should error if mode is not recognized", func() {
+			settings := &RealTimeSettings{Mode: "Telepathy"}
+			Expect(settings.Validate()).To(MatchError(ContainSubstring("unrecognized mode")))
+		})
+
+		It("Should error if polling interval is negative", func() {
+			settings := &RealTimeSettings{Mode: RealTimeModeSCN, PollingIntervalSeconds: -1}
+			Expect(settings.Validate()).ToNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,350 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// pollChangeLog publishes the changes captured by the change log triggers of a table.
+// Without a checkpoint the whole table is published as of the current SCN first.
+// Each poll reads the change log as of the current SCN, selecting the entries committed
+// after the checkpoint, and commits the current SCN as the new checkpoint. The entries
+// at or before a checkpoint which has been committed to the host are purged.
+func (s *Server) pollChangeLog(ctx context.Context, req *pub.ReadRequest, settings RealTimeSettings, state RealTimeState, waiter pollWaiter, stream pub.Publisher_ReadStreamServer) error {
+	log := s.log.With("schema", req.Schema.Id)
+
+	changeLog, err := s.installChangeLog(ctx, req.Schema.Id, settings)
+	if err != nil {
+		return err
+	}
+
+	if state.SCN == 0 {
+		scn, err := s.getCurrentSCN(ctx)
+		if err != nil {
+			return errors.Errorf("could not get current SCN: %s", err)
+		}
+
+		cause := fmt.Sprintf("Initial load as of SCN %d", scn)
+		_, err = s.sendRecords(ctx, req, readScope{asOfSCN: scn}, stream, func(record *pub.Record) (bool, error) {
+			record.Cause = cause
+			return true, nil
+		})
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+
+		state.SCN = scn
+		if err = commitRealTimeState(stream, state); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if !waiter.wait(ctx) {
+			return nil
+		}
+
+		purged, err := s.purgeChangeLog(ctx, changeLog, state.SCN)
+		if err != nil {
+			log.Warn("Could not purge change log.", "err", err)
+		}
+
+		scn, err := s.getCurrentSCN(ctx)
+		if err != nil {
+			return errors.Errorf("could not get current SCN: %s", err)
+		}
+
+		count, err := s.readChangeLog(ctx, req.Schema, changeLog, state.SCN, scn, stream)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		state.SCN = scn
+		if err = commitRealTimeState(stream, state); err != nil {
+			return err
+		}
+
+		log.Debug("Polled change log.", "scn", scn, "changes", count, "purged", purged)
+	}
+}
+
+// readChangeLog sends the entries of the change log which were committed after the
+// checkpoint SCN, reading as of the current SCN. It returns the number of records sent.
+func (s *Server) readChangeLog(ctx context.Context, schema *pub.Schema, changeLog ChangeLog, after, scn uint64, stream pub.Publisher_ReadStreamServer) (int, error) {
+	logged := make(map[string]bool, len(changeLog.Columns))
+	for _, col := range changeLog.Columns {
+		logged[fmt.Sprintf(`"%s"`, col.Name)] = true
+	}
+
+	columns := []string{changeLogOperationColumn}
+	var properties []*pub.Property
+	for _, p := range schema.Properties {
+		if logged[p.Id] {
+			columns = append(columns, p.Id)
+			properties = append(properties, p)
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s AS OF SCN %d WHERE ORA_ROWSCN > %d ORDER BY %s`,
+		strings.Join(columns, ", "), changeLog.qualify(changeLog.TableName()), scn, after, changeLogIDColumn)
+
+	rows, err := s.executeQueryContext(ctx, s.db, query)
+	if err != nil {
+		return 0, errors.Errorf("error executing query %q: %v", query, err)
+	}
+	defer rows.Close()
+
+	var count int
+	cause := fmt.Sprintf("Committed after SCN %d", after)
+	values := make([]interface{}, len(properties))
+	data := make(map[string]interface{}, len(properties))
+
+	for rows.Next() {
+		if ctx.Err() != nil {
+			return count, nil
+		}
+
+		var operation string
+		dest := []interface{}{&operation}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return count, errors.WithStack(err)
+		}
+
+		for i, p := range properties {
+			data[p.Id] = publishedValue(p, values[i])
+		}
+
+		action := pub.Record_UPDATE
+		switch operation {
+		case "I":
+			action = pub.Record_INSERT
+		case "D":
+			action = pub.Record_DELETE
+		}
+
+		record, err := pub.NewRecord(action, data)
+		if err != nil {
+			return count, errors.WithStack(err)
+		}
+		record.Cause = cause
+
+		if err = stream.Send(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, errors.WithStack(rows.Err())
+}
+
+// purgeChangeLog deletes the entries of the change log committed at or before the SCN.
+func (s *Server) purgeChangeLog(ctx context.Context, changeLog ChangeLog, scn uint64) (int64, error) {
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE ORA_ROWSCN <= :scn`, changeLog.qualify(changeLog.TableName())),
+		sql.Named("scn", int64(scn)))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return result.RowsAffected()
+}
+
+// installChangeLog installs the change log for the table, or upgrades it if it was installed
+// by an older version or the table's columns have changed. The objects are only created
+// or changed if the user has consented in the settings.
+func (s *Server) installChangeLog(ctx context.Context, schemaID string, settings RealTimeSettings) (ChangeLog, error) {
+	owner, table := decomposeSafeName(schemaID)
+	changeLog, err := s.getChangeLog(owner, table)
+	if err != nil {
+		return changeLog, err
+	}
+
+	installed, err := s.getInstalledChangeLog(ctx, changeLog)
+	if err != nil {
+		return changeLog, err
+	}
+
+	var ddl []string
+	if installed == nil {
+		ddl = changeLog.InstallDDL()
+	} else {
+		ddl = changeLog.UpgradeDDL(*installed)
+	}
+	if len(ddl) == 0 {
+		return changeLog, nil
+	}
+
+	if !settings.InstallChangeLog {
+		return changeLog, errors.Errorf("the change log for %s must be installed or upgraded, which requires consent in the real time settings", schemaID)
+	}
+
+	s.log.Info("Installing change log.", "schema", schemaID, "table", changeLog.TableName(), "upgrade", installed != nil)
+	for _, stmt := range ddl {
+		if _, err = s.db.ExecContext(ctx, stmt); err != nil {
+			return changeLog, errors.Errorf("could not install change log for %s: %s: %s", schemaID, err, stmt)
+		}
+	}
+
+	return changeLog, nil
+}
+
+// UninstallChangeLog drops the change log objects installed for a table.
+func (s *Server) UninstallChangeLog(ctx context.Context, schemaID string) error {
+	if !s.connected {
+		return errors.New("not connected")
+	}
+
+	owner, table := decomposeSafeName(schemaID)
+	changeLog := ChangeLog{Owner: owner, Table: table}
+
+	installed, err := s.getInstalledChangeLog(ctx, changeLog)
+	if err != nil {
+		return err
+	}
+	if installed == nil {
+		return errors.Errorf("there is no change log installed for %s", schemaID)
+	}
+
+	s.log.Info("Uninstalling change log.", "schema", schemaID, "table", changeLog.TableName())
+	for _, stmt := range changeLog.UninstallDDL() {
+		if _, err = s.db.ExecContext(ctx, stmt); err != nil {
+			return errors.Errorf("could not uninstall change log for %s: %s: %s", schemaID, err, stmt)
+		}
+	}
+
+	return nil
+}
+
+// getChangeLog returns the change log for the columns the table has now.
+func (s *Server) getChangeLog(owner, table string) (ChangeLog, error) {
+	changeLog := ChangeLog{Owner: owner, Table: table}
+
+	rows, err := s.db.Query(`SELECT COLUMN_NAME, DATA_TYPE, DATA_LENGTH, DATA_PRECISION, DATA_SCALE, CHAR_LENGTH, CHAR_USED
+FROM ALL_TAB_COLUMNS
+WHERE OWNER = :owner AND TABLE_NAME = :name
+ORDER BY COLUMN_ID`, sql.Named("owner", owner), sql.Named("name", table))
+	if err != nil {
+		return changeLog, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, dataType string
+		var length, charLength int64
+		var precision, scale sql.NullInt64
+		var charUsed sql.NullString
+		if err = rows.Scan(&name, &dataType, &length, &precision, &scale, &charLength, &charUsed); err != nil {
+			return changeLog, errors.WithStack(err)
+		}
+
+		columnType, ok := changeLogColumnType(dataType, length, precision, scale, charLength, charUsed.String)
+		if !ok {
+			s.log.Warn("Column cannot be captured by a trigger and will not be in the change log.", "table", table, "column", name, "type", dataType)
+			continue
+		}
+		changeLog.Columns = append(changeLog.Columns, ChangeLogColumn{Name: name, Type: columnType})
+	}
+	if err = rows.Err(); err != nil {
+		return changeLog, errors.WithStack(err)
+	}
+	if len(changeLog.Columns) == 0 {
+		return changeLog, errors.Errorf(`table "%s"."%s" does not exist or has no columns which can be captured`, owner, table)
+	}
+
+	return changeLog, nil
+}
+
+// changeLogColumnType returns the full data type of a column from ALL_TAB_COLUMNS,
+// or false if the column cannot be referenced in a row trigger.
+func changeLogColumnType(dataType string, length int64, precision, scale sql.NullInt64, charLength int64, charUsed string) (string, bool) {
+	switch dataType {
+	case "LONG", "LONG RAW":
+		return "", false
+	case "VARCHAR2", "CHAR":
+		semantics := "BYTE"
+		if charUsed == "C" {
+			semantics = "CHAR"
+		}
+		return fmt.Sprintf("%s(%d %s)", dataType, charLength, semantics), true
+	case "NVARCHAR2", "NCHAR":
+		return fmt.Sprintf("%s(%d)", dataType, charLength), true
+	case "RAW":
+		return fmt.Sprintf("RAW(%d)", length), true
+	case "NUMBER":
+		switch {
+		case precision.Valid && scale.Valid:
+			return fmt.Sprintf("NUMBER(%d,%d)", precision.Int64, scale.Int64), true
+		case scale.Valid:
+			return fmt.Sprintf("NUMBER(*,%d)", scale.Int64), true
+		default:
+			return "NUMBER", true
+		}
+	case "FLOAT":
+		if precision.Valid {
+			return fmt.Sprintf("FLOAT(%d)", precision.Int64), true
+		}
+		return "FLOAT", true
+	default:
+		return dataType, true
+	}
+}
+
+// getInstalledChangeLog returns the installed change log objects for the table,
+// or nil if they have not been installed.
+func (s *Server) getInstalledChangeLog(ctx context.Context, changeLog ChangeLog) (*InstalledChangeLog, error) {
+	var comment sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT COMMENTS FROM ALL_TAB_COMMENTS WHERE OWNER = :owner AND TABLE_NAME = :name`,
+		sql.Named("owner", changeLog.Owner), sql.Named("name", changeLog.TableName())).Scan(&comment)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	installed, ok := ParseChangeLogComment(comment.String)
+	if !ok {
+		return nil, errors.Errorf("%s exists but is not a change log installed by this plugin", changeLog.qualify(changeLog.TableName()))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT COLUMN_NAME, DATA_TYPE, DATA_LENGTH, DATA_PRECISION, DATA_SCALE, CHAR_LENGTH, CHAR_USED
+FROM ALL_TAB_COLUMNS
+WHERE OWNER = :owner AND TABLE_NAME = :name AND COLUMN_NAME NOT LIKE 'NAVEEGO$%'
+ORDER BY COLUMN_ID`, sql.Named("owner", changeLog.Owner), sql.Named("name", changeLog.TableName()))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, dataType string
+		var length, charLength int64
+		var precision, scale sql.NullInt64
+		var charUsed sql.NullString
+		if err = rows.Scan(&name, &dataType, &length, &precision, &scale, &charLength, &charUsed); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		columnType, _ := changeLogColumnType(dataType, length, precision, scale, charLength, charUsed.String)
+		installed.Columns = append(installed.Columns, ChangeLogColumn{Name: name, Type: columnType})
+	}
+
+	return &installed, errors.WithStack(rows.Err())
+}
+
+func changeLogConsentDescription(schemaID string) string {
+	return fmt.Sprintf("Changes to %s are captured by a trigger which writes each changed row to a change log table. "+
+		"The plugin will create a sequence, a change log table and a trigger in the table's schema, and upgrade them when the table's columns change. "+
+		"The trigger adds work to every insert, update and delete on the table. "+
+		"Only one real time read should use the change log, because entries are purged once they have been published.", schemaID)
+}
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// pollWatermark publishes the rows whose watermark column is at or after the
+// last checkpointed watermark, less the overlap window. Rows in the overlap
+// window are re-read on every poll so that rows from transactions which
+// committed late are not missed; the rows which were already published are
+// remembered in the state and skipped unless they have changed.
+func (s *Server) pollWatermark(ctx context.Context, req *pub.ReadRequest, settings RealTimeSettings, state RealTimeState, waiter pollWaiter, stream pub.Publisher_ReadStreamServer) error {
+	log := s.log.With("schema", req.Schema.Id)
+
+	for {
+		poll, err := newWatermarkPoll(req.Schema, settings, state)
+		if err != nil {
+			return err
+		}
+
+		var scope readScope
+		scope.condition, err = poll.condition()
+		if err != nil {
+			return err
+		}
+
+		count, err := s.sendRecords(ctx, req, scope, stream, poll.accept)
+		if err != nil {
+			return err
+		}
+
+		// a cancelled read may be incomplete, so it must not be checkpointed
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		state, err = poll.state()
+		if err != nil {
+			return err
+		}
+		if err = commitRealTimeState(stream, state); err != nil {
+			return err
+		}
+
+		log.Debug("Polled for changes.", "watermark", state.Watermark, "changes", count, "boundary", len(state.Boundary))
+
+		if !waiter.wait(ctx) {
+			return nil
+		}
+	}
+}
+
+// watermarkPoll tracks the records read in one poll of a watermark column.
+type watermarkPoll struct {
+	property *pub.Property
+	keys     []*pub.Property
+	overlap  float64
+	previous RealTimeState
+	high     *watermarkValue
+	seen     []seenRow
+}
+
+// seenRow is a row read in the current poll.
+type seenRow struct {
+	key       string
+	hash      string
+	watermark watermarkValue
+}
+
+func newWatermarkPoll(schema *pub.Schema, settings RealTimeSettings, previous RealTimeState) (*watermarkPoll, error) {
+	poll := &watermarkPoll{
+		overlap:  settings.OverlapWindow,
+		previous: previous,
+	}
+
+	for _, p := range schema.Properties {
+		if p.Id == settings.WatermarkColumn {
+			poll.property = p
+		}
+		if p.IsKey {
+			poll.keys = append(poll.keys, p)
+		}
+	}
+	if poll.property == nil {
+		return nil, errors.Errorf("watermark column %s is not a property of %s", settings.WatermarkColumn, schema.Id)
+	}
+
+	if previous.Watermark != "" {
+		high, err := parseWatermark(poll.property, previous.Watermark)
+		if err != nil {
+			return nil, errors.Errorf("invalid watermark in real time state: %s", err)
+		}
+		poll.high = &high
+	}
+
+	return poll, nil
+}
+
+// condition returns the condition selecting the rows to read,
+// or an empty string if all rows should be read.
+func (w *watermarkPoll) condition() (string, error) {
+	if w.high == nil {
+		return "", nil
+	}
+
+	lower := w.high.minus(w.overlap)
+	literal, err := lower.literal(w.property)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s >= %s", w.property.Id, literal), nil
+}
+
+// accept records that the record was read, and returns false if
+// it was already published by the previous poll and has not changed.
+func (w *watermarkPoll) accept(record *pub.Record) (bool, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(record.DataJson)))
+	decoder.UseNumber()
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	raw := data[w.property.Id]
+	if raw == nil {
+		// a row without a watermark cannot be tracked, and will only be published by the initial load
+		return w.high == nil, nil
+	}
+
+	value, err := parseWatermark(w.property, fmt.Sprint(raw))
+	if err != nil {
+		return false, err
+	}
+
+	sum := sha1.Sum([]byte(record.DataJson))
+	row := seenRow{
+		hash:      base64.RawStdEncoding.EncodeToString(sum[:]),
+		watermark: value,
+	}
+	row.key = row.hash
+	if len(w.keys) > 0 {
+		keys := make([]interface{}, len(w.keys))
+		for i, k := range w.keys {
+			keys[i] = data[k.Id]
+		}
+		b, _ := json.Marshal(keys)
+		row.key = string(b)
+	}
+	w.seen = append(w.seen, row)
+
+	record.Cause = fmt.Sprintf("%s changed to %s", w.property.Name, value)
+
+	return w.previous.Boundary[row.key] != row.hash, nil
+}
+
+// state returns the checkpoint after all the records in the poll have been read.
+// The boundary holds the rows which will be read again by the next poll.
+func (w *watermarkPoll) state() (RealTimeState, error) {
+	var high *watermarkValue
+	if w.previous.Watermark != "" {
+		v, err := parseWatermark(w.property, w.previous.Watermark)
+		if err != nil {
+			return RealTimeState{}, err
+		}
+		high = &v
+	}
+	for i := range w.seen {
+		if high == nil || w.seen[i].watermark.compare(*high) > 0 {
+			high = &w.seen[i].watermark
+		}
+	}
+
+	if high == nil {
+		return w.previous, nil
+	}
+
+	lower := high.minus(w.overlap)
+	state := RealTimeState{
+		Watermark: high.String(),
+		Boundary:  make(map[string]string),
+	}
+	for _, row := range w.seen {
+		if row.watermark.compare(lower) >= 0 {
+			state.Boundary[row.key] = row.hash
+		}
+	}
+
+	return state, nil
+}
+
+// watermarkValue is a value of a numeric or date/time watermark column.
+type watermarkValue struct {
+	number *big.Rat
+	time   time.Time
+}
+
+func parseWatermark(property *pub.Property, raw string) (watermarkValue, error) {
+	switch property.Type {
+	case pub.PropertyType_DATE, pub.PropertyType_DATETIME:
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return watermarkValue{}, errors.Errorf("watermark %q of %s is not a date and time: %s", raw, property.Id, err)
+		}
+		return watermarkValue{time: t}, nil
+	case pub.PropertyType_INTEGER, pub.PropertyType_DECIMAL, pub.PropertyType_FLOAT:
+		n, ok := new(big.Rat).SetString(raw)
+		if !ok {
+			return watermarkValue{}, errors.Errorf("watermark %q of %s is not a number", raw, property.Id)
+		}
+		return watermarkValue{number: n}, nil
+	default:
+		return watermarkValue{}, errors.Errorf("%s cannot be used as a watermark because it is a %s", property.Id, property.Type)
+	}
+}
+
+func (v watermarkValue) compare(other watermarkValue) int {
+	if v.number != nil {
+		return v.number.Cmp(other.number)
+	}
+	switch {
+	case v.time.Before(other.time):
+		return -1
+	case v.time.After(other.time):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// minus returns the value less the overlap, which is
+// in seconds for date/time values.
+func (v watermarkValue) minus(overlap float64) watermarkValue {
+	if v.number != nil {
+		o := new(big.Rat)
+		o.SetFloat64(overlap)
+		return watermarkValue{number: new(big.Rat).Sub(v.number, o)}
+	}
+	return watermarkValue{time: v.time.Add(-time.Duration(overlap * float64(time.Second)))}
+}
+
+// literal returns the value as a SQL literal which can be compared with the property.
+func (v watermarkValue) literal(property *pub.Property) (string, error) {
+	if v.number != nil {
+		return v.String(), nil
+	}
+
+	switch property.TypeAtSource {
+	case "DATE", "TIMESTAMP":
+		// values without a time zone are published as if they were UTC
+		return fmt.Sprintf(`TO_TIMESTAMP('%s', 'YYYY-MM-DD"T"HH24:MI:SS.FF9')`, v.time.UTC().Format("2006-01-02T15:04:05.000000000")), nil
+	default:
+		return fmt.Sprintf(`TO_TIMESTAMP_TZ('%s', 'YYYY-MM-DD"T"HH24:MI:SS.FF9TZH:TZM')`, v.time.Format("2006-01-02T15:04:05.000000000-07:00")), nil
+	}
+}
+
+func (v watermarkValue) String() string {
+	if v.number != nil {
+		if v.number.IsInt() {
+			return v.number.Num().String()
+		}
+		return strings.TrimRight(v.number.FloatString(18), "0")
+	}
+	return v.time.Format(time.RFC3339Nano)
+}
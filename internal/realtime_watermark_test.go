@@ -0,0 +1,94 @@
+package internal_test
+
+import (
+	"fmt"
+
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watermark polling", func() {
+
+	var schema *pub.Schema
+
+	BeforeEach(func() {
+		schema = &pub.Schema{
+			Id: `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{
+				{Id: `"AGENT_CODE"`, Name: "AGENT_CODE", Type: pub.PropertyType_STRING, IsKey: true},
+				{Id: `"VERSION"`, Name: "VERSION", Type: pub.PropertyType_INTEGER},
+				{Id: `"UPDATED_AT"`, Name: "UPDATED_AT", Type: pub.PropertyType_DATETIME, TypeAtSource: "TIMESTAMP(6) WITH TIME ZONE"},
+			},
+		}
+	})
+
+	accept := func(poll *WatermarkPoll, code string, version int) bool {
+		ok, err := poll.Accept(&pub.Record{DataJson: fmt.Sprintf(`{"\"AGENT_CODE\"":%q,"\"VERSION\"":%d}`, code, version)})
+		Expect(err).ToNot(HaveOccurred())
+		return ok
+	}
+
+	It("should read everything without a watermark", func() {
+		poll, err := NewWatermarkPoll(schema, RealTimeSettings{WatermarkColumn: `"VERSION"`}, RealTimeState{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(poll.Condition()).To(BeEmpty())
+	})
+
+	It("should read from the watermark less the overlap window", func() {
+		poll, err := NewWatermarkPoll(schema, RealTimeSettings{WatermarkColumn: `"VERSION"`, OverlapWindow: 5}, RealTimeState{Watermark: "100"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(poll.Condition()).To(Equal(`"VERSION" >= 95`))
+	})
+
+	It("should format date watermarks as timestamps", func() {
+		poll, err := NewWatermarkPoll(schema, RealTimeSettings{WatermarkColumn: `"UPDATED_AT"`, OverlapWindow: 60}, RealTimeState{Watermark: "2019-01-02T03:04:05Z"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(poll.Condition()).To(Equal(`"UPDATED_AT" >= TO_TIMESTAMP_TZ('2019-01-02T03:03:05.000000000+00:00', 'YYYY-MM-DD"T"HH24:MI:SS.FF9TZH:TZM')`))
+	})
+
+	It("should skip unchanged rows in the overlap window", func() {
+		settings := RealTimeSettings{WatermarkColumn: `"VERSION"`, OverlapWindow: 2}
+
+		poll, err := NewWatermarkPoll(schema, settings, RealTimeState{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(accept(poll, "A001", 7)).To(BeTrue())
+		Expect(accept(poll, "A002", 9)).To(BeTrue())
+		Expect(accept(poll, "A003", 10)).To(BeTrue())
+
+		state, err := poll.State()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.Watermark).To(Equal("10"))
+		Expect(state.Boundary).To(HaveLen(2))
+
+		poll, err = NewWatermarkPoll(schema, settings, state)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(poll.Condition()).To(Equal(`"VERSION" >= 8`))
+		Expect(accept(poll, "A002", 9)).To(BeFalse())
+		Expect(accept(poll, "A004", 9)).To(BeTrue(), "late commit in the overlap window")
+		Expect(accept(poll, "A003", 10)).To(BeFalse())
+		Expect(accept(poll, "A001", 11)).To(BeTrue())
+
+		state, err = poll.State()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.Watermark).To(Equal("11"))
+		Expect(state.Boundary).To(HaveLen(4))
+	})
+
+	It("should keep the watermark when nothing was read", func() {
+		previous := RealTimeState{Watermark: "10", Boundary: map[string]string{"x": "y"}}
+		poll, err := NewWatermarkPoll(schema, RealTimeSettings{WatermarkColumn: `"VERSION"`}, previous)
+		Expect(err).ToNot(HaveOccurred())
+
+		state, err := poll.State()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.Watermark).To(Equal("10"))
+		Expect(state.Boundary).To(BeEmpty(), "rows in the overlap window which were not read again no longer match")
+	})
+
+	It("should error if the column is not a property", func() {
+		_, err := NewWatermarkPoll(schema, RealTimeSettings{WatermarkColumn: `"MISSING"`}, RealTimeState{})
+		Expect(err).To(HaveOccurred())
+	})
+})
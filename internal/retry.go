@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Defaults for retrying operations which fail with transient errors.
+const (
+	defaultRetryAttempts   = 5
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// retryPolicy is how many times, and how long apart, an operation which
+// fails with a transient error is attempted. The wait between attempts
+// doubles after each attempt, up to maxBackoff.
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// wait returns how long to wait after the numbered attempt fails, counting from 1.
+func (p retryPolicy) wait(attempt int) time.Duration {
+	wait := p.backoff
+	for i := 1; i < attempt && wait < p.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return wait
+}
+
+// retry calls op until it succeeds or fails with a permanent error, it has been attempted
+// as many times as the policy allows, or the next attempt would start after the deadline.
+// A zero deadline is ignored. It returns the error of the last attempt.
+func (p retryPolicy) retry(ctx context.Context, log hclog.Logger, deadline time.Time, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || classifyError(err) == errorPermanent || attempt >= p.attempts {
+			return err
+		}
+
+		wait := p.wait(attempt)
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			log.Warn("Not retrying transient error because the deadline would pass.", "error", err, "attempt", attempt)
+			return err
+		}
+
+		log.Warn("Retrying after transient error.", "error", err, "attempt", attempt, "wait", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// Extent is a contiguous range of blocks allocated to a table segment.
+type Extent struct {
+	RelativeFileNo int64
+	BlockID        int64
+	Blocks         int64
+}
+
+// RowIDRange is an inclusive range of extended ROWIDs.
+type RowIDRange struct {
+	Start string
+	End   string
+}
+
+// Predicate returns a condition restricting a query to the range.
+func (r RowIDRange) Predicate() string {
+	return fmt.Sprintf("ROWID BETWEEN CHARTOROWID('%s') AND CHARTOROWID('%s')", r.Start, r.End)
+}
+
+// minChunkBlocks is the smallest number of blocks worth reading as a
+// separate chunk; smaller tables are read with fewer chunks.
+const minChunkBlocks = 128
+
+// maxRowsPerBlock is the highest row number which can appear in a ROWID.
+const maxRowsPerBlock = 32767
+
+// PlanRowIDChunks splits the extents of a table segment into at most the
+// requested number of ROWID ranges covering roughly equal numbers of blocks,
+// in the same way that DBMS_PARALLEL_EXECUTE chunks by ROWID. The extents
+// must be ordered by file and block. Extents are split across chunks when
+// they are larger than a chunk.
+func PlanRowIDChunks(dataObjectID int64, extents []Extent, chunks int) []RowIDRange {
+	var totalBlocks int64
+	for _, e := range extents {
+		totalBlocks += e.Blocks
+	}
+
+	if n := int(totalBlocks / minChunkBlocks); n < chunks {
+		chunks = n
+	}
+	if chunks < 1 || totalBlocks == 0 {
+		return nil
+	}
+
+	// distribute the remainder over the first chunks so that sizes differ by at most one block
+	perChunk, remainder := totalBlocks/int64(chunks), totalBlocks%int64(chunks)
+	chunkSize := func(i int) int64 {
+		if int64(i) < remainder {
+			return perChunk + 1
+		}
+		return perChunk
+	}
+
+	var ranges []RowIDRange
+	var current *RowIDRange
+	var needed int64
+
+	for _, e := range extents {
+		block, remaining := e.BlockID, e.Blocks
+		for remaining > 0 {
+			if current == nil {
+				current = &RowIDRange{Start: EncodeRowID(dataObjectID, e.RelativeFileNo, block, 0)}
+				needed = chunkSize(len(ranges))
+			}
+
+			take := remaining
+			if take > needed {
+				take = needed
+			}
+			block += take
+			remaining -= take
+			needed -= take
+
+			if needed == 0 {
+				current.End = EncodeRowID(dataObjectID, e.RelativeFileNo, block-1, maxRowsPerBlock)
+				ranges = append(ranges, *current)
+				current = nil
+			}
+		}
+	}
+
+	return ranges
+}
+
+const rowIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// EncodeRowID returns the extended ROWID (OOOOOOFFFBBBBBBRRR) for a row.
+func EncodeRowID(dataObjectID, relativeFileNo, block, row int64) string {
+	b := make([]byte, 0, 18)
+	encode := func(value int64, digits int) {
+		for i := digits - 1; i >= 0; i-- {
+			b = append(b, rowIDAlphabet[(value>>(6*uint(i)))&63])
+		}
+	}
+	encode(dataObjectID, 6)
+	encode(relativeFileNo, 3)
+	encode(block, 6)
+	encode(row, 3)
+	return string(b)
+}
+
+// getExtents returns the data object ID and the extents of a table segment.
+func (s *Server) getExtents(owner, table string) (int64, []Extent, error) {
+	var dataObjectID int64
+	row := s.db.QueryRow(`
+SELECT DATA_OBJECT_ID
+FROM ALL_OBJECTS
+WHERE OWNER = :owner AND OBJECT_NAME = :name AND OBJECT_TYPE = 'TABLE' AND SUBOBJECT_NAME IS NULL`, sql.Named("owner", owner), sql.Named("name", table))
+	if err := row.Scan(&dataObjectID); err != nil {
+		return 0, nil, errors.Errorf("could not get data object ID: %s", err)
+	}
+
+	rows, err := s.executeQuery(`
+SELECT RELATIVE_FNO, BLOCK_ID, BLOCKS
+FROM DBA_EXTENTS
+WHERE OWNER = :owner AND SEGMENT_NAME = :name AND SEGMENT_TYPE = 'TABLE'
+ORDER BY RELATIVE_FNO, BLOCK_ID`, sql.Named("owner", owner), sql.Named("name", table))
+	if err != nil {
+		return 0, nil, errors.Errorf("could not read extents: %s", err)
+	}
+	defer rows.Close()
+
+	var extents []Extent
+	for rows.Next() {
+		var e Extent
+		if err = rows.Scan(&e.RelativeFileNo, &e.BlockID, &e.Blocks); err != nil {
+			return 0, nil, errors.WithStack(err)
+		}
+		extents = append(extents, e)
+	}
+
+	return dataObjectID, extents, rows.Err()
+}
+
+// planRowIDSlices splits a read of a non-partitioned table into ROWID range
+// slices within the scope. It returns no slices if the table cannot or
+// should not be chunked.
+func (s *Server) planRowIDSlices(req *pub.ReadRequest, scope readScope) ([]readSlice, error) {
+	owner, table := decomposeSafeName(req.Schema.Id)
+	if owner == "" || table == "" {
+		return nil, nil
+	}
+
+	dataObjectID, extents, err := s.getExtents(owner, table)
+	if err != nil {
+		// reading the extents requires access to DBA_EXTENTS, without which the table is read serially
+		s.log.Warn("Could not plan ROWID chunks, table will be read with a single query.", "schema", req.Schema.Id, "err", err)
+		return nil, nil
+	}
+
+	ranges := PlanRowIDChunks(dataObjectID, extents, s.settings.GetRowIDChunks())
+	if len(ranges) < 2 {
+		return nil, nil
+	}
+
+	var slices []readSlice
+	for i, r := range ranges {
+		chunkScope := scope
+		chunkScope.rowIDRange = &ranges[i]
+		query, err := buildScopedQuery(req, chunkScope)
+		if err != nil {
+			return nil, errors.Errorf("could not build query for chunk %s-%s: %v", r.Start, r.End, err)
+		}
+		slices = append(slices, readSlice{
+			name:  fmt.Sprintf("chunk %d (%s-%s)", i+1, r.Start, r.End),
+			query: query,
+		})
+	}
+
+	return slices, nil
+}
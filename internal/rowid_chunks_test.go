@@ -0,0 +1,77 @@
+package internal_test
+
+import (
+	. "github.com/naveego/plugin-oracle/internal"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ROWID chunks", func() {
+
+	Describe("EncodeRowID", func() {
+
+		It("should encode an extended ROWID", func() {
+			Expect(EncodeRowID(73196, 4, 151, 0)).To(Equal("AAAR3sAAEAAAACXAAA"))
+			Expect(EncodeRowID(73196, 4, 151, 32767)).To(Equal("AAAR3sAAEAAAACXH//"))
+		})
+	})
+
+	Describe("PlanRowIDChunks", func() {
+
+		It("should split a single extent into equal ranges", func() {
+			extents := []Extent{
+				{RelativeFileNo: 4, BlockID: 1024, Blocks: 1024},
+			}
+
+			Expect(PlanRowIDChunks(73196, extents, 4)).To(Equal([]RowIDRange{
+				{Start: EncodeRowID(73196, 4, 1024, 0), End: EncodeRowID(73196, 4, 1279, 32767)},
+				{Start: EncodeRowID(73196, 4, 1280, 0), End: EncodeRowID(73196, 4, 1535, 32767)},
+				{Start: EncodeRowID(73196, 4, 1536, 0), End: EncodeRowID(73196, 4, 1791, 32767)},
+				{Start: EncodeRowID(73196, 4, 1792, 0), End: EncodeRowID(73196, 4, 2047, 32767)},
+			}))
+		})
+
+		It("should combine small extents and span files", func() {
+			extents := []Extent{
+				{RelativeFileNo: 4, BlockID: 128, Blocks: 128},
+				{RelativeFileNo: 4, BlockID: 512, Blocks: 128},
+				{RelativeFileNo: 7, BlockID: 8, Blocks: 256},
+			}
+
+			Expect(PlanRowIDChunks(10, extents, 2)).To(Equal([]RowIDRange{
+				{Start: EncodeRowID(10, 4, 128, 0), End: EncodeRowID(10, 4, 639, 32767)},
+				{Start: EncodeRowID(10, 7, 8, 0), End: EncodeRowID(10, 7, 263, 32767)},
+			}))
+		})
+
+		It("should cover every block exactly once when sizes do not divide evenly", func() {
+			extents := []Extent{
+				{RelativeFileNo: 1, BlockID: 0, Blocks: 200},
+				{RelativeFileNo: 1, BlockID: 300, Blocks: 201},
+			}
+
+			Expect(PlanRowIDChunks(10, extents, 3)).To(Equal([]RowIDRange{
+				{Start: EncodeRowID(10, 1, 0, 0), End: EncodeRowID(10, 1, 133, 32767)},
+				{Start: EncodeRowID(10, 1, 134, 0), End: EncodeRowID(10, 1, 367, 32767)},
+				{Start: EncodeRowID(10, 1, 368, 0), End: EncodeRowID(10, 1, 500, 32767)},
+			}))
+		})
+
+		It("should use fewer chunks for small tables", func() {
+			extents := []Extent{
+				{RelativeFileNo: 4, BlockID: 128, Blocks: 300},
+			}
+
+			Expect(PlanRowIDChunks(10, extents, 16)).To(HaveLen(2))
+		})
+
+		It("should not chunk tables smaller than a single chunk", func() {
+			extents := []Extent{
+				{RelativeFileNo: 4, BlockID: 128, Blocks: 8},
+			}
+
+			Expect(PlanRowIDChunks(10, extents, 16)).To(BeEmpty())
+			Expect(PlanRowIDChunks(10, nil, 16)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"encoding/json"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// SchemaMeta is the plugin specific information about a schema which
+// is round-tripped through the host in pub.Schema.PublisherMetaJson.
+type SchemaMeta struct {
+	Partitioning *Partitioning `json:"partitioning,omitempty"`
+	// Queue is set if the schema is an Advanced Queuing queue rather than a table.
+	Queue *QueueMeta `json:"queue,omitempty"`
+	// Write is set if the schema writes to a target other than a stored procedure.
+	Write *WriteMeta `json:"write,omitempty"`
+}
+
+// getSchemaMeta parses the metadata attached to the schema.
+// A schema without metadata returns an empty SchemaMeta.
+func getSchemaMeta(schema *pub.Schema) (*SchemaMeta, error) {
+	meta := new(SchemaMeta)
+	if schema == nil || schema.PublisherMetaJson == "" {
+		return meta, nil
+	}
+
+	if err := json.Unmarshal([]byte(schema.PublisherMetaJson), meta); err != nil {
+		return nil, errors.Errorf("could not parse metadata for schema %q: %s", schema.Id, err)
+	}
+
+	return meta, nil
+}
+
+// setSchemaMeta attaches the metadata to the schema, clearing
+// PublisherMetaJson if the metadata is empty.
+func setSchemaMeta(schema *pub.Schema, meta *SchemaMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	schema.PublisherMetaJson = string(b)
+	if schema.PublisherMetaJson == "{}" {
+		schema.PublisherMetaJson = ""
+	}
+
+	return nil
+}
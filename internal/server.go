@@ -1,7 +1,6 @@
 package internal
 
 import (
-	"io"
 	"regexp"
 	"sync"
 	"sync/atomic"
@@ -31,13 +30,21 @@ type Server struct {
 
 	WriteSettings *WriteSettings
 	StoredProcedures []string
+	// procedures are the procedures which can be written to, by their choices in StoredProcedures.
+	procedures map[string]storedProcedure
+
+	snapshots *snapshots
+
+	// newChangeNotifier replaces Continuous Query Notification in tests.
+	newChangeNotifier changeNotifierFactory
 }
 
 // NewServer creates a new publisher Host.
 func NewServer(logger hclog.Logger) pub.PublisherServer {
 	return &Server{
-		mu:  &sync.Mutex{},
-		log: logger,
+		mu:        &sync.Mutex{},
+		log:       logger,
+		snapshots: &snapshots{},
 	}
 }
 
@@ -57,8 +64,50 @@ func (s *Server) ConfigureQuery(ctx context.Context, req *pub.ConfigureQueryRequ
 	return nil, errors.New("Not implemented.")
 }
 
+// ConfigureRealTime returns the form for choosing how changes to a schema are published in real time
 func (s *Server) ConfigureRealTime(ctx context.Context, req *pub.ConfigureRealTimeRequest) (*pub.ConfigureRealTimeResponse, error) {
-	return nil, errors.New("Not implemented.")
+	if !s.connected {
+		return nil, errNotConnected
+	}
+
+	schemaJSON := s.realTimeFormSchema(req.Schema)
+
+	// first request return ui json schema form
+	if req.Form == nil || req.Form.DataJson == "" {
+		data, _ := json.Marshal(RealTimeSettings{
+			Mode:                   RealTimeModeSCN,
+			PollingIntervalSeconds: defaultPollingIntervalSeconds,
+		})
+		return &pub.ConfigureRealTimeResponse{
+			Form: &pub.ConfigurationFormResponse{
+				DataJson:   string(data),
+				SchemaJson: schemaJSON,
+			},
+		}, nil
+	}
+
+	var errArray []string
+	var settings RealTimeSettings
+	if err := json.Unmarshal([]byte(req.Form.DataJson), &settings); err != nil {
+		errArray = append(errArray, fmt.Sprintf("error reading form data: %s", err))
+	} else {
+		errArray = s.validateRealTimeSettings(req.Schema, &settings)
+	}
+
+	if len(errArray) == 0 && settings.Mode == RealTimeModeTriggers {
+		if _, err := s.installChangeLog(ctx, req.Schema.Id, settings); err != nil {
+			errArray = append(errArray, err.Error())
+		}
+	}
+
+	return &pub.ConfigureRealTimeResponse{
+		Form: &pub.ConfigurationFormResponse{
+			DataJson:   req.Form.DataJson,
+			Errors:     errArray,
+			StateJson:  req.Form.StateJson,
+			SchemaJson: schemaJSON,
+		},
+	}, nil
 }
 
 func (s *Server) BeginOAuthFlow(ctx context.Context, req *pub.BeginOAuthFlowRequest) (*pub.BeginOAuthFlowResponse, error) {
@@ -101,27 +150,21 @@ func (s *Server) Connect(ctx context.Context, req *pub.ConnectRequest) (*pub.Con
 	s.settings = settings
 	s.StoredProcedures = nil
 	s.StoredProcedures = append(s.StoredProcedures, Custom)
+	s.procedures = nil
 
 	var connectionResponse = new(pub.ConnectResponse)
 
 	if s.settings.ShouldDiscoverWrite() {
-		// get stored procedures
-		rows, err := s.db.Query("SELECT owner, object_name FROM dba_objects WHERE object_type = 'PROCEDURE' AND oracle_maintained != 'Y' AND status = 'VALID'")
+		// get stored procedures, including the members of packages
+		procedures, err := s.getStoredProcedures()
 		if err != nil {
-			connectionResponse.ConnectionError = fmt.Sprintf("could not read stored procedures from database: %s",err)
+			connectionResponse.ConnectionError = err.Error()
 			return connectionResponse, nil
 		}
 
-		for rows.Next() {
-			var schema, name string
-			var safeName string
-			err = rows.Scan(&schema, &name)
-			if err != nil {
-				connectionResponse.ConnectionError = fmt.Sprintf("could not read stored procedure schema: %s",err)
-				return connectionResponse, nil
-			}
-			safeName = fmt.Sprintf(`"%s"."%s"`, schema, name)
-			s.StoredProcedures = append(s.StoredProcedures, safeName)
+		s.procedures = procedures
+		for choice := range procedures {
+			s.StoredProcedures = append(s.StoredProcedures, choice)
 		}
 		sort.Strings(s.StoredProcedures)
 	}
@@ -161,6 +204,16 @@ func (s *Server) DiscoverSchemas(ctx context.Context, req *pub.DiscoverSchemasRe
 
 	resp := &pub.DiscoverSchemasResponse{}
 
+	// discovery is not part of a job, so the counts are made as of a snapshot of their own
+	var countScope readScope
+	snap, err := s.getSnapshot(ctx, &pub.ReadRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if snap != nil {
+		countScope.asOfSCN = snap.scn
+	}
+
 	wait := new(sync.WaitGroup)
 
 	for i := range shapes {
@@ -171,7 +224,14 @@ func (s *Server) DiscoverSchemas(ctx context.Context, req *pub.DiscoverSchemasRe
 		// concurrently get details for shape
 		go func() {
 			s.log.Debug("Getting details for discovered schema...", "id", shape.Id)
-			err := s.populateShapeColumns(shape)
+			meta, err := getSchemaMeta(shape)
+			if err == nil {
+				if meta.Queue != nil {
+					err = s.populateQueueProperties(shape, meta)
+				} else {
+					err = s.populateShapeColumns(shape)
+				}
+			}
 			if err != nil {
 				s.log.With("shape", shape.Id).With("err", err).Error("Error discovering columns.")
 				shape.Errors = append(shape.Errors, fmt.Sprintf("Could not discover columns: %s", err))
@@ -179,8 +239,16 @@ func (s *Server) DiscoverSchemas(ctx context.Context, req *pub.DiscoverSchemasRe
 			}
 			s.log.Debug("Got details for discovered schema.", "id", shape.Id)
 
+			if shape.Query == "" && meta.Queue == nil {
+				s.log.Debug("Getting partitions for discovered schema...", "id", shape.Id)
+				if err := s.populateShapePartitions(shape); err != nil {
+					// partitioning is only used to speed up reads, so the shape is still usable
+					s.log.With("shape", shape.Id).With("err", err).Warn("Error discovering partitions.")
+				}
+			}
+
 			s.log.Debug("Getting count for discovered schema...", "id", shape.Id)
-			shape.Count, err = s.getCount(shape)
+			shape.Count, err = s.getCount(shape, countScope)
 			if err != nil {
 				s.log.With("shape", shape.Id).With("err", err).Error("Error getting row count.")
 				shape.Errors = append(shape.Errors, fmt.Sprintf("Could not get row count for shape: %s", err))
@@ -197,7 +265,7 @@ func (s *Server) DiscoverSchemas(ctx context.Context, req *pub.DiscoverSchemasRe
 				records := make(chan *pub.Record)
 
 				go func() {
-					err = s.readRecords(ctx, publishReq, records)
+					err = s.readRecords(ctx, publishReq, readScope{}, records)
 				}()
 
 				for record := range records {
@@ -234,12 +302,16 @@ func (s *Server) DiscoverShapes(ctx context.Context, req *pub.DiscoverSchemasReq
 
 var queryID int32 = 0
 
-func (s *Server) executeQuery(query string) (*sql.Rows, error) {
+func (s *Server) executeQuery(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.executeQueryContext(context.Background(), s.db, query, args...)
+}
+
+func (s *Server) executeQueryContext(ctx context.Context, q queryer, query string, args ...interface{}) (*sql.Rows, error) {
 	t := time.Now()
 	id := atomic.AddInt32(&queryID, 1)
 	log := s.log.With("id", id)
 	log.With("query", query).Debug("Executing query...")
-	r, err := s.db.Query(query)
+	r, err := q.QueryContext(ctx, query, args...)
 	e := time.Since(t)
 	log.With("elapsed", e.Seconds()).Debug("Query complete.")
 	return r, err
@@ -279,6 +351,13 @@ WHERE TABLESPACE_NAME NOT IN ('SYSTEM', 'SYSAUX', 'TEMP', 'UNDOTBS1')
 		shapes = append(shapes, shape)
 	}
 
+	queues, err := s.getAllQueues()
+	if err != nil {
+		// queues are only discovered if the user can see the AQ views
+		s.log.Warn("Could not discover queues.", "err", err)
+	}
+	shapes = append(shapes, queues...)
+
 	return shapes, nil
 }
 
@@ -301,6 +380,22 @@ func (c columnInfo) IsKey() bool {
 	return c.ConstraintType == "P"
 }
 
+// TypeAtSource returns the data type with the length, precision and scale which are part of its identity.
+func (c columnInfo) TypeAtSource() string {
+	dtn := c.DataType
+	switch dtn {
+	case "CHAR", "VARCHAR2", "NCHAR", "NVARCHAR2":
+		if c.DataLength != nil {
+			dtn = fmt.Sprintf("%s(%d)", dtn, *c.DataLength)
+		}
+	case "NUMBER":
+		if c.DataPrecision != nil && c.DataScale != nil {
+			dtn = fmt.Sprintf("%s(%d,%d)", dtn, *c.DataPrecision, *c.DataScale)
+		}
+	}
+	return dtn
+}
+
 var deparameterizer = regexp.MustCompile(`\(\d+\)`)
 
 func (s *Server) populateShapeColumns(shape *pub.Schema) (error) {
@@ -421,19 +516,7 @@ ORDER BY rownum`, strings.Trim(query, ";"))
 			shape.Properties = append(shape.Properties, property)
 		}
 
-		dtn := m.DataType
-		switch dtn {
-		case "CHAR", "VARCHAR2", "NCHAR", "NVARCHAR2":
-			if m.DataLength != nil {
-				dtn = fmt.Sprintf("%s(%d)", dtn, *m.DataLength)
-			}
-		case "NUMBER":
-			if m.DataPrecision != nil && m.DataScale != nil {
-				dtn = fmt.Sprintf("%s(%d,%d)", dtn, *m.DataPrecision, *m.DataScale)
-			}
-		}
-
-		property.TypeAtSource = dtn
+		property.TypeAtSource = m.TypeAtSource()
 
 		property.Type = convertSQLType(m)
 
@@ -454,25 +537,81 @@ func (s *Server) ReadStream(req *pub.ReadRequest, stream pub.Publisher_ReadStrea
 		return errNotConnected
 	}
 
-	var err error
-	records := make(chan *pub.Record)
+	if req.RealTimeSettingsJson != "" {
+		return s.readRealTime(req, stream)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	snap, err := s.getSnapshot(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	var scope readScope
+	var prepare func(*pub.Record) (bool, error)
+	if snap != nil {
+		scope.asOfSCN = snap.scn
+		stateJSON := snap.stateJSON()
+		prepare = func(record *pub.Record) (bool, error) {
+			record.RealTimeStateJson = stateJSON
+			return true, nil
+		}
+	}
+
+	_, err = s.sendRecords(ctx, req, scope, stream, prepare)
+
+	return err
+}
+
+// sendRecords reads the records in scope and sends them to the stream.
+// If prepare is set it is called with each record before it is sent,
+// and can modify the record or return false to skip it.
+// It returns the number of records sent.
+func (s *Server) sendRecords(ctx context.Context, req *pub.ReadRequest, scope readScope, stream pub.Publisher_ReadStreamServer, prepare func(*pub.Record) (bool, error)) (int, error) {
+	var err, readErr error
+	var count int
+	records := make(chan *pub.Record)
+	done := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	go func() {
-		err = s.readRecords(ctx, req, records)
+		defer close(done)
+		readErr = s.readRecords(ctx, req, scope, records)
 	}()
 
 	for record := range records {
+		if prepare != nil {
+			send, prepareErr := prepare(record)
+			if prepareErr != nil {
+				cancel()
+				err = prepareErr
+				break
+			}
+			if !send {
+				continue
+			}
+		}
 		sendErr := stream.Send(record)
 		if sendErr != nil {
 			cancel()
 			err = sendErr
 			break
 		}
+		count++
 	}
 
-	return err
+	// wait for the reader so that its error is not lost
+	<-done
+
+	if err == nil {
+		err = readErr
+	}
+
+	return count, err
 }
 
 func (s *Server) PublishStream(req *pub.ReadRequest, stream pub.Publisher_PublishStreamServer) error {
@@ -483,68 +622,13 @@ func (s *Server) PublishStream(req *pub.ReadRequest, stream pub.Publisher_Publis
 func (s *Server) ConfigureWrite(ctx context.Context, req *pub.ConfigureWriteRequest) (*pub.ConfigureWriteResponse, error) {
 	var errArray []string
 
-	storedProcedures, _ := json.Marshal(s.StoredProcedures)
-	schemaJSON := fmt.Sprintf(`{
-  "type": "object",
-  "properties": {
-    "storedProcedure": {
-      "type": "string",
-      "title": "Stored Procedure Name",
-      "description": "The name of the stored procedure",
-      "enum": %s
-    }
-  },
-  "required": [
-    "storedProcedure"
-  ],
-  "dependencies": {
-    "storedProcedure": {
-      "oneOf": [
-        {
-          "properties": {
-            "storedProcedure": {
-              "enum": [
-                "%s"
-              ]
-            },
-			"customName":{
-			  "type": "string",
-			  "title": "Custom Stored Procedure Name"
-			},
-			"customFullName":{
-			  "type": "string",
-			  "title": "Fully Qualified Custom Stored Procedure Name"
-			},
-            "customParameters": {
-              "type": "array",
-              "title": "Parameters",
-              "description": "Parameters for a custom defined stored procedure",
-              "items": {
-                "type": "object",
-                "properties": {
-                  "paramName": {
-                    "type": "string",
-                    "title": "Parameter Name"
-                  },
-                  "paramType": {
-                    "type": "string",
-                    "title": "Parameter Type"
-                  }
-                }
-              }
-            }
-          }
-        }
-      ]
-    }
-  }
-}`, storedProcedures, Custom)
+	schemaJSON := s.writeFormSchema()
 
 	// first request return ui json schema form
 	if req.Form == nil || req.Form.DataJson == "" {
 		return &pub.ConfigureWriteResponse{
 			Form: &pub.ConfigurationFormResponse{
-				DataJson:       `{"storedProcedure":""}`,
+				DataJson:       fmt.Sprintf(`{"target":%q,"storedProcedure":""}`, WriteTargetStoredProcedure),
 				DataErrorsJson: "",
 				Errors:         nil,
 				SchemaJson: schemaJSON ,
@@ -556,11 +640,11 @@ func (s *Server) ConfigureWrite(ctx context.Context, req *pub.ConfigureWriteRequ
 	}
 
 	// build schema
-	var query string
 	var properties []*pub.Property
-	var stmt *sql.Stmt
-	var rows *sql.Rows
-	var sprocSchema, sprocName, sprocPkg string
+	var arguments []procedureArgument
+	var outputs []string
+	var procedure storedProcedure
+	var named ProcedureCall
 	var err error
 	found := false
 	var schemaId string
@@ -576,17 +660,36 @@ func (s *Server) ConfigureWrite(ctx context.Context, req *pub.ConfigureWriteRequ
 		goto Done
 	}
 
+	if formData.Target == WriteTargetQueue || formData.Target == WriteTargetTable {
+		var schema *pub.Schema
+		var errs []string
+		if formData.Target == WriteTargetQueue {
+			schema, errs = s.configureQueueWrite(formData)
+		} else {
+			schema, errs = s.configureTableWrite(formData)
+		}
+		if len(errs) == 0 {
+			errs = s.configureWriteOptions(formData, schema)
+		}
+		return &pub.ConfigureWriteResponse{
+			Form: &pub.ConfigurationFormResponse{
+				DataJson:   req.Form.DataJson,
+				Errors:     errs,
+				StateJson:  req.Form.StateJson,
+				SchemaJson: schemaJSON,
+			},
+			Schema: schema,
+		}, nil
+	}
+
 	if formData.StoredProcedure == "" {
 		errArray = append(errArray, "stored procedure does not exist")
 		goto Done
 	}
 
-	for _, safeProc := range s.StoredProcedures {
-		if safeProc == formData.StoredProcedure {
-			found = true
-			s.log.Info("found stored procedure", "stored procedure", safeProc)
-			continue
-		}
+	procedure, found = s.procedures[formData.StoredProcedure]
+	if found {
+		s.log.Info("found stored procedure", "stored procedure", formData.StoredProcedure)
 	}
 
 	if !found && formData.StoredProcedure != Custom {
@@ -594,95 +697,62 @@ func (s *Server) ConfigureWrite(ctx context.Context, req *pub.ConfigureWriteRequ
 		goto Done
 	}
 
-	schemaId = formData.StoredProcedure
+	schemaId = procedure.qualifiedName()
 	if formData.StoredProcedure == Custom {
 		schemaId = formData.CustomName
 		s.log.Info("found custom stored procedure", "stored procedure", schemaId)
-	}
-
-	sprocSchema, sprocName = decomposeSafeName(schemaId)
+		procedure.owner, procedure.name = decomposeSafeName(schemaId)
 
-	if formData.CustomFullName != "" {
-		schemaId = formData.CustomFullName
+		if formData.CustomFullName != "" {
+			schemaId = formData.CustomFullName
+			procedure.owner, procedure.pkg, procedure.name = decomposeSafePackageName(formData.CustomFullName)
+		}
 	}
 	schemaProc.WriteString(fmt.Sprintf("%s(", schemaId))
 
-	s.log.Info("got decomposed schema name", "owner", sprocSchema, "object name", sprocName)
+	s.log.Info("got decomposed schema name", "owner", procedure.owner, "package", procedure.pkg, "object name", procedure.name)
 
 	// get params for stored procedure
-	if formData.CustomFullName != "" {
-		sprocSchema, sprocPkg, sprocName = decomposeSafePackageName(formData.CustomFullName)
-
-		query = `SELECT ARGUMENT_NAME, DATA_TYPE, DATA_LENGTH, SEQUENCE FROM ALL_ARGUMENTS WHERE OWNER = :owner and OBJECT_NAME = :name and PACKAGE_NAME = :pkg order by SEQUENCE ASC`
-		stmt, err = s.db.Prepare(query)
-		if err != nil {
-			s.log.Error(fmt.Sprintf("error preparing to get parameters for stored procedure: %s", err))
-			errArray = append(errArray, fmt.Sprintf("error preparing to get parameters for stored procedure: %s", err))
-			goto CustomProperties
-		}
-
-		s.log.Info("prepared query", "query", query)
-
-		rows, err = stmt.Query(sql.Named("owner", sprocSchema), sql.Named("name", sprocName), sql.Named("pkg", sprocPkg))
-		if err != nil {
-			s.log.Error(fmt.Sprintf("error preparing to get parameters for stored procedure: %s", err))
-			errArray = append(errArray, fmt.Sprintf("error getting parameters for stored procedure: %s", err))
-			goto CustomProperties
-		}
-
-		s.log.Info("got rows for query", "query", query)
+	arguments, err = s.getProcedureArguments(procedure.owner, procedure.pkg, procedure.name, procedure.overload)
+	if err != nil {
+		s.log.Error(err.Error())
+		errArray = append(errArray, err.Error())
+		goto CustomProperties
+	}
 
-	} else {
-		query = `SELECT ARGUMENT_NAME, DATA_TYPE, DATA_LENGTH, SEQUENCE FROM ALL_ARGUMENTS WHERE OWNER = :owner and OBJECT_NAME = :name order by SEQUENCE ASC`
-		stmt, err = s.db.Prepare(query)
+	// overloads and procedures with optional or composite arguments are called by name
+	if procedure.byName(arguments) {
+		named, err = namedProcedureCall(schemaId, arguments)
 		if err != nil {
-			s.log.Error(fmt.Sprintf("error preparing to get parameters for stored procedure: %s", err))
-			errArray = append(errArray, fmt.Sprintf("error preparing to get parameters for stored procedure: %s", err))
-			goto CustomProperties
+			errArray = append(errArray, err.Error())
+			goto Done
 		}
-
-		s.log.Info("prepared query", "query", query)
-
-		rows, err = stmt.Query(sql.Named("owner", sprocSchema), sql.Named("name", sprocName))
-		if err != nil {
-			s.log.Error(fmt.Sprintf("error preparing to get parameters for stored procedure: %s", err))
-			errArray = append(errArray, fmt.Sprintf("error getting parameters for stored procedure: %s", err))
-			goto CustomProperties
+		outputs = named.Outputs
+		for _, arg := range arguments {
+			if arg.isInput() {
+				properties = append(properties, arg.property())
+			}
 		}
-
-		s.log.Info("got rows for query", "query", query)
+		goto Done
 	}
 
-
-	// add all params to properties of schema
-	for rows.Next() {
-		var colName, colType string
-		var length interface{}
-		var sequence interface{}
-
-		err := rows.Scan(&colName, &colType, &length, &sequence)
-		if err != nil {
-			s.log.Error(fmt.Sprintf("error preparing to get parameters for stored procedure: %s", err))
-			errArray = append(errArray, fmt.Sprintf("error getting parameters for stored procedure: %s", err))
-			goto Done
+	// add all params to properties of schema, and capture the outputs of
+	// OUT params and functions
+	for _, arg := range arguments {
+		schemaParams.WriteString(arg.declaration())
+		if arg.isReturnValue() {
+			schemaProc.Reset()
+			schemaProc.WriteString(fmt.Sprintf(":%s := %s(", returnValueParameter, schemaId))
+			outputs = append(outputs, returnValueParameter)
+			continue
 		}
-
-		properties = append(properties, &pub.Property{
-			Id: colName,
-			Name: colName,
-			TypeAtSource: colType,
-			Type: convertFromSQLType(colType, 0),
-		})
-
-		schemaParams.WriteString(fmt.Sprintf("%s %s", colName, colType))
-		if length != nil {
-			schemaParams.WriteString(fmt.Sprintf("(%s)", length))
+		if arg.isInput() {
+			properties = append(properties, arg.property())
 		}
-		if length == nil && strings.Contains(colType, "VARCHAR2") {
-			schemaParams.WriteString("(32767)")
+		if arg.isOutput() {
+			outputs = append(outputs, arg.name)
 		}
-		schemaParams.WriteString(";")
-		schemaProc.WriteString(fmt.Sprintf(":%s,", colName))
+		schemaProc.WriteString(fmt.Sprintf(":%s,", arg.name))
 	}
 
 	CustomProperties:
@@ -700,7 +770,7 @@ func (s *Server) ConfigureWrite(ctx context.Context, req *pub.ConfigureWriteRequ
 
 				schemaParams.WriteString(fmt.Sprintf("%s %s", param.ParamName, param.ParamType))
 				schemaParams.WriteString(";")
-				schemaProc.WriteString(fmt.Sprintf("%s=>:%s,", param.ParamName, param.ParamName))
+				schemaProc.WriteString(fmt.Sprintf("%s=>%s,", param.ParamName, bindExpression(param.ParamType, ":"+param.ParamName)))
 			}
 		}
 	}
@@ -709,6 +779,40 @@ func (s *Server) ConfigureWrite(ctx context.Context, req *pub.ConfigureWriteRequ
 	schemaParamsOut = schemaParams.String()
 	schemaProcOut = fmt.Sprintf("%s);", strings.TrimSuffix(schemaProc.String(), ","))
 
+	if named.Query == "" {
+		named.Query = fmt.Sprintf("DECLARE %s BEGIN %s END;", schemaParamsOut, schemaProcOut)
+	}
+
+	schema := &pub.Schema{
+		Id:         schemaId,
+		Query:      named.Query,
+		DataFlowDirection: pub.Schema_WRITE,
+		Properties: properties,
+	}
+
+	target := StoredProcedureWriteMeta{BatchSize: formData.BatchSize, Outputs: outputs, OutputMode: formData.OutputMode, Arguments: named.Arguments}
+	if target.BatchSize == 0 {
+		target.BatchSize = defaultWriteBatchSize
+	}
+	if target.BatchSize < 0 {
+		errArray = append(errArray, "the batch size must not be negative")
+	}
+	if target.OutputMode == "" {
+		target.OutputMode = OutputModeLog
+	}
+	if target.OutputMode != OutputModeLog && target.OutputMode != OutputModeAck {
+		errArray = append(errArray, fmt.Sprintf("unrecognized outputMode %q", target.OutputMode))
+	}
+	if len(errArray) == 0 {
+		errArray = append(errArray, s.configureProcedureActions(formData, schema, &target)...)
+	}
+	if err := setSchemaMeta(schema, &SchemaMeta{Write: &WriteMeta{Target: WriteTargetStoredProcedure, StoredProcedure: &target}}); err != nil {
+		errArray = append(errArray, err.Error())
+	}
+	if len(errArray) == 0 {
+		errArray = append(errArray, s.configureWriteOptions(formData, schema)...)
+	}
+
 	// return write back schema
 	return &pub.ConfigureWriteResponse{
 		Form: &pub.ConfigurationFormResponse{
@@ -717,20 +821,263 @@ func (s *Server) ConfigureWrite(ctx context.Context, req *pub.ConfigureWriteRequ
 			StateJson: req.Form.StateJson,
 			SchemaJson:schemaJSON,
 		},
-		Schema: &pub.Schema{
-			Id:         schemaId,
-			Query:      fmt.Sprintf("DECLARE %s BEGIN %s END;", schemaParamsOut, schemaProcOut),
-			DataFlowDirection: pub.Schema_WRITE,
-			Properties: properties,
-		},
+		Schema: schema,
 	}, nil
 }
 
+// writeFormSchema returns the JSON schema for the ConfigureWrite form, offering the tables
+// and queues as targets as well as the stored procedures if there are any.
+func (s *Server) writeFormSchema() string {
+	storedProcedures, _ := json.Marshal(s.StoredProcedures)
+	procedures := []string{}
+	for _, p := range s.StoredProcedures {
+		if p != Custom {
+			procedures = append(procedures, p)
+		}
+	}
+	actionProcedures, _ := json.Marshal(procedures)
+	targets := []string{fmt.Sprintf("%q", WriteTargetStoredProcedure)}
+	branches := []string{fmt.Sprintf(`{
+  "properties": {
+    "target": {
+      "enum": [%q]
+    },
+    "storedProcedure": {
+      "type": "string",
+      "title": "Stored Procedure Name",
+      "description": "The name of the stored procedure or function",
+      "enum": %s
+    },
+    "batchSize": {
+      "type": "integer",
+      "title": "Batch Size",
+      "description": "The most records written in one transaction. Records are acknowledged once their transaction has been committed.",
+      "default": %d,
+      "minimum": 1
+    },
+    "actionParameter": {
+      "type": "string",
+      "title": "Action Parameter",
+      "description": "The parameter of the stored procedure which is passed the action of each record: UPSERT, INSERT, UPDATE or DELETE. It is not a property of the schema."
+    },
+    "outputMode": {
+      "type": "string",
+      "title": "Output Values",
+      "description": "How the values of OUT parameters and the results of functions are returned to the host: logged with the correlation ID of each record, or in the acknowledgement of each record as a JSON object such as {\"outputs\":{\"O_STATUS\":\"OK\"}}.",
+      "enum": [%[6]q, %[7]q],
+      "default": %[6]q
+    },
+    "insertProcedure": {
+      "type": "string",
+      "title": "Insert Stored Procedure",
+      "description": "The stored procedure which writes inserted records, instead of the stored procedure above.",
+      "enum": %[5]s
+    },
+    "updateProcedure": {
+      "type": "string",
+      "title": "Update Stored Procedure",
+      "description": "The stored procedure which writes updated records, instead of the stored procedure above.",
+      "enum": %[5]s
+    },
+    "deleteProcedure": {
+      "type": "string",
+      "title": "Delete Stored Procedure",
+      "description": "The stored procedure which writes deleted records, instead of the stored procedure above.",
+      "enum": %[5]s
+    }
+  },
+  "required": [
+    "storedProcedure"
+  ],
+  "dependencies": {
+    "storedProcedure": {
+      "oneOf": [
+        {
+          "properties": {
+            "storedProcedure": {
+              "enum": [
+                "%[4]s"
+              ]
+            },
+			"customName":{
+			  "type": "string",
+			  "title": "Custom Stored Procedure Name"
+			},
+			"customFullName":{
+			  "type": "string",
+			  "title": "Fully Qualified Custom Stored Procedure Name"
+			},
+            "customParameters": {
+              "type": "array",
+              "title": "Parameters",
+              "description": "Parameters for a custom defined stored procedure",
+              "items": {
+                "type": "object",
+                "properties": {
+                  "paramName": {
+                    "type": "string",
+                    "title": "Parameter Name"
+                  },
+                  "paramType": {
+                    "type": "string",
+                    "title": "Parameter Type"
+                  }
+                }
+              }
+            }
+          }
+        }
+      ]
+    }
+  }
+}`, WriteTargetStoredProcedure, storedProcedures, defaultWriteBatchSize, Custom, actionProcedures, OutputModeLog, OutputModeAck)}
+
+	if s.connected {
+		tables, err := s.getAllTables()
+		if err != nil {
+			s.log.Warn("Could not list tables to write to.", "err", err)
+		} else if len(tables) > 0 {
+			targets = append(targets, fmt.Sprintf("%q", WriteTargetTable))
+			branches = append(branches, tableWriteFormBranch(tables))
+		}
+
+		queues, err := s.getAllQueues()
+		if err != nil {
+			s.log.Warn("Could not list queues to write to.", "err", err)
+		} else if len(queues) > 0 {
+			targets = append(targets, fmt.Sprintf("%q", WriteTargetQueue))
+			branches = append(branches, queueWriteFormBranch(queues))
+		}
+	}
+
+	return fmt.Sprintf(`{
+  "type": "object",
+  "properties": {
+    "target": {
+      "type": "string",
+      "title": "Write To",
+      "description": "The kind of object records are written to.",
+      "enum": [%s],
+      "default": %q
+    },
+    "deadLetterTable": {
+      "type": "string",
+      "title": "Dead-Letter Table",
+      "description": "A table, as \"OWNER\".\"TABLE\", which records that are rejected are inserted into with their errors. A dead letter whose STATUS is set to REPLAY is written again by the replay-dead-letters debug command. Leave it empty to only report rejected records in their acknowledgements."
+    },
+    "createDeadLetterTable": {
+      "type": "boolean",
+      "title": "Create Dead-Letter Table",
+      "description": "Creates the dead-letter table if it does not exist.",
+      "default": false
+    },
+    "idempotencyTable": {
+      "type": "string",
+      "title": "Idempotency Table",
+      "description": "A table, as \"OWNER\".\"TABLE\", which tracks the correlation ID of each record written in the same transaction as the record. A record which is sent again with the same correlation ID is acknowledged without being written again. Leave it empty to write every record which is sent."
+    },
+    "createIdempotencyTable": {
+      "type": "boolean",
+      "title": "Create Idempotency Table",
+      "description": "Creates the idempotency table if it does not exist.",
+      "default": false
+    },
+    "idempotencyRetentionDays": {
+      "type": "integer",
+      "title": "Idempotency Retention (days)",
+      "description": "How long the correlation ID of a record is tracked for. Older correlation IDs are purged when the schema is written back.",
+      "default": %d,
+      "minimum": 1
+    },
+    "dryRun": {
+      "type": "boolean",
+      "title": "Dry Run",
+      "description": "Writes each batch of records in a transaction which is always rolled back, so that nothing the plugin writes is kept. A stored procedure which commits, uses an autonomous transaction or sends data out of the database, such as by mail, pipes or database links, still does so, as that cannot be rolled back. Each record is acknowledged with the error it would have been rejected with, if any, and a summary of the failures is logged. Dead letters are not inserted.",
+      "default": false
+    },
+    "writeConcurrency": {
+      "type": "integer",
+      "title": "Write Concurrency",
+      "description": "How many batches of records are written at the same time, each in its own session. Records are partitioned by their key properties, so the records for the same row are always written in the order they were sent. Writing more than one batch at a time needs key properties.",
+      "default": 1,
+      "minimum": 1
+    },
+    "keyProperties": {
+      "type": "array",
+      "title": "Key Properties",
+      "description": "The properties, such as the arguments of a stored procedure or the fields of a message, which identify the row a record writes to, so that records can be written concurrently. The key properties of a table are its key columns.",
+      "items": {
+        "type": "string"
+      },
+      "uniqueItems": true
+    }
+  },
+  "required": [
+    "target"
+  ],
+  "dependencies": {
+    "target": {
+      "oneOf": [%s]
+    }
+  }
+}`, strings.Join(targets, ","), WriteTargetStoredProcedure, defaultIdempotencyRetentionDays, strings.Join(branches, ","))
+}
+
+// configureWriteOptions applies the options of the form which apply to every target to the write schema.
+func (s *Server) configureWriteOptions(formData ConfigureWriteFormData, schema *pub.Schema) []string {
+	errs := s.configureDeadLetter(formData, schema)
+	errs = append(errs, s.configureIdempotency(formData, schema)...)
+	if formData.DryRun {
+		if err := updateWriteMeta(schema, func(write *WriteMeta) { write.DryRun = true }); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	errs = append(errs, configureConcurrency(formData, schema)...)
+	return errs
+}
+
 type ConfigureWriteFormData struct {
+	// Target is the kind of object to write to, which is a stored procedure if it is not set.
+	Target WriteTarget `json:"target,omitempty"`
 	StoredProcedure string `json:"storedProcedure,omitempty"`
 	CustomName string `json:"customName,omitempty"`
 	CustomFullName string `json:"customFullName,omitempty"`
 	CustomParameters []Parameter `json:"customParameters,omitempty"`
+	ActionParameter string `json:"actionParameter,omitempty"`
+	InsertProcedure string `json:"insertProcedure,omitempty"`
+	UpdateProcedure string `json:"updateProcedure,omitempty"`
+	DeleteProcedure string `json:"deleteProcedure,omitempty"`
+	OutputMode OutputMode `json:"outputMode,omitempty"`
+
+	Queue string `json:"queue,omitempty"`
+	JSONFields []QueueField `json:"jsonFields,omitempty"`
+	// SetCorrelationID is nil if the form did not set it, in which case it defaults to true.
+	SetCorrelationID *bool `json:"setCorrelationId,omitempty"`
+	DelaySeconds int `json:"delaySeconds,omitempty"`
+	ExpirationSeconds int `json:"expirationSeconds,omitempty"`
+	BatchSize int `json:"batchSize,omitempty"`
+
+	Table string `json:"table,omitempty"`
+	Columns []TableColumn `json:"columns,omitempty"`
+	KeyColumns []string `json:"keyColumns,omitempty"`
+	NewTable string `json:"newTable,omitempty"`
+	Tablespace string `json:"tablespace,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	AddColumns bool `json:"addColumns,omitempty"`
+	StagingTable string `json:"stagingTable,omitempty"`
+	CreateStagingTable bool `json:"createStagingTable,omitempty"`
+	DirectPath bool `json:"directPath,omitempty"`
+	PostLoadProcedure string `json:"postLoadProcedure,omitempty"`
+	MergeSize int `json:"mergeSize,omitempty"`
+
+	DeadLetterTable string `json:"deadLetterTable,omitempty"`
+	CreateDeadLetterTable bool `json:"createDeadLetterTable,omitempty"`
+	IdempotencyTable string `json:"idempotencyTable,omitempty"`
+	CreateIdempotencyTable bool `json:"createIdempotencyTable,omitempty"`
+	IdempotencyRetentionDays int `json:"idempotencyRetentionDays,omitempty"`
+	DryRun bool `json:"dryRun,omitempty"`
+	WriteConcurrency int `json:"writeConcurrency,omitempty"`
+	KeyProperties []string `json:"keyProperties,omitempty"`
 }
 
 type Parameter struct {
@@ -745,89 +1092,42 @@ func (s *Server) PrepareWrite(ctx context.Context, req *pub.PrepareWriteRequest)
 		CommitSLA: req.CommitSlaSeconds,
 	}
 
+	// an invalid meta is reported by WriteStream
+	if meta, err := getSchemaMeta(req.Schema); err == nil && meta.Write != nil {
+		s.WriteSettings.DeadLetter = meta.Write.DeadLetter
+		s.WriteSettings.Idempotency = meta.Write.Idempotency
+		s.WriteSettings.DryRun = meta.Write.DryRun
+		s.WriteSettings.Concurrency = meta.Write.Concurrency
+	}
+
 	return &pub.PrepareWriteResponse{}, nil
 }
 
-// WriteStream writes a stream of records back to the source system
+// WriteStream writes a stream of records back to the source system. Records are written
+// in batches, each in a transaction, and are acknowledged once the transaction has been committed.
 func (s *Server) WriteStream(stream pub.Publisher_WriteStreamServer) error {
-	// get and process each record
-	for {
-		// return if not configured
-		if s.WriteSettings == nil {
-			return nil
-		}
-
-		// get record and exit if no more records or error
-		record, err := stream.Recv()
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-
-		var recordData map[string]interface{}
-		if err := json.Unmarshal([]byte(record.DataJson), &recordData); err != nil {
-			return errors.WithStack(err)
-		}
-
-		// process record and send ack
-		ackMsgCh := make(chan string, 1)
-		go func() {
-			defer close(ackMsgCh)
-
-			schema := s.WriteSettings.Schema
-
-			// build params for stored procedure
-			var args []interface{}
-			for _, prop := range schema.Properties {
-
-				rawValue := recordData[prop.Id]
-				var value interface{}
-				switch			 prop.Type {
-				case pub.PropertyType_DATE, pub.PropertyType_DATETIME:
-					stringValue, ok := rawValue.(string)
-					if !ok {
-						ackMsgCh <-fmt.Sprintf("cannot convert value %v to %s (was %T)", rawValue, prop.Type, rawValue)
-						return
-					}
-					value, err = time.Parse(time.RFC3339, stringValue)
-				default:
-					value = rawValue
-				}
-
-				args = append(args, sql.Named(prop.Id, value))
-			}
+	// return if not configured
+	if s.WriteSettings == nil {
+		return nil
+	}
 
-			// call stored procedure and capture any error
-			_, err := s.db.ExecContext(context.Background(),schema.Query, args...)
-			if err != nil {
-				ackMsgCh <- fmt.Sprintf("could not write back: %s", err)
-			}
-		}()
+	schema := s.WriteSettings.Schema
+	meta, err := getSchemaMeta(schema)
+	if err != nil {
+		return err
+	}
+	if meta.Write != nil && meta.Write.Target == WriteTargetQueue {
+		return s.writeQueue(stream, schema, *meta.Write.Queue)
+	}
+	if meta.Write != nil && meta.Write.Target == WriteTargetTable {
+		return s.writeTable(stream, schema, *meta.Write.Table)
+	}
 
-		// send ack when done writing or on timeout
-		select {
-		// done writing
-		case sendErr := <-ackMsgCh:
-			err := stream.Send(&pub.RecordAck{
-				CorrelationId: record.CorrelationId,
-				Error:         sendErr,
-			})
-			if err != nil {
-				return err
-			}
-		// timeout
-		case <-time.After(time.Duration(s.WriteSettings.CommitSLA) * time.Second):
-			err := stream.Send(&pub.RecordAck{
-				CorrelationId: record.CorrelationId,
-				Error:         "timed out",
-			})
-			if err != nil {
-				return err
-			}
-		}
+	target := StoredProcedureWriteMeta{BatchSize: defaultWriteBatchSize}
+	if meta.Write != nil && meta.Write.StoredProcedure != nil {
+		target = *meta.Write.StoredProcedure
 	}
+	return s.writeStoredProcedure(stream, schema, target)
 }
 
 func (s *Server) Disconnect(context.Context, *pub.DisconnectRequest) (*pub.DisconnectResponse, error) {
@@ -842,7 +1142,8 @@ func (s *Server) Disconnect(context.Context, *pub.DisconnectRequest) (*pub.Disco
 	return new(pub.DisconnectResponse), nil
 }
 
-func (s *Server) getCount(shape *pub.Schema) (*pub.Count, error) {
+// getCount counts the records of the schema within the scope.
+func (s *Server) getCount(shape *pub.Schema, scope readScope) (*pub.Count, error) {
 
 	cErr := make(chan error)
 	cCount := make(chan int)
@@ -851,9 +1152,9 @@ func (s *Server) getCount(shape *pub.Schema) (*pub.Count, error) {
 		defer close(cErr)
 		defer close(cCount)
 
-		query, err := buildQuery(&pub.ReadRequest{
+		query, err := buildScopedQuery(&pub.ReadRequest{
 			Schema: shape,
-		})
+		}, scope)
 		if err != nil {
 			cErr <- err
 			return
@@ -887,23 +1188,33 @@ func (s *Server) getCount(shape *pub.Schema) (*pub.Count, error) {
 	}
 }
 
-func (s *Server) readRecords(ctx context.Context, req *pub.ReadRequest, out chan<- *pub.Record) error {
+// readRecords reads the records for the request within the scope.
+func (s *Server) readRecords(ctx context.Context, req *pub.ReadRequest, scope readScope, out chan<- *pub.Record) error {
 
 	defer close(out)
 
-	var err error
-	var query string
+	meta, err := getSchemaMeta(req.Schema)
+	if err != nil {
+		return err
+	}
+	if meta.Queue != nil {
+		return s.readQueueMessages(ctx, req, *meta.Queue, out)
+	}
 
-	query, err = buildQuery(req)
+	slices, err := s.planRead(req, scope)
 	if err != nil {
-		return errors.Errorf("could not build query: %v", err)
+		return err
 	}
 
-	if req.Limit > 0 {
-		query = fmt.Sprintf(`SELECT SRC.* FROM (
-%s
-) SRC 
-WHERE rownum <= %d `, query, req.Limit)
+	if len(slices) > 1 {
+		s.log.Info("Reading schema in slices.", "schema", req.Schema.Id, "slices", len(slices), "sessions", s.settings.GetParallelReadSessions())
+		return s.readSlices(ctx, slices, req.Schema.Properties, out)
+	}
+
+	query := slices[0].query
+
+	if scope.asOfSCN != 0 && req.Schema.Query != "" {
+		return s.readQueryAsOf(ctx, query, scope.asOfSCN, req.Schema.Properties, out)
 	}
 
 	rows, err := s.executeQuery(query)
@@ -911,13 +1222,25 @@ WHERE rownum <= %d `, query, req.Limit)
 		return errors.Errorf("error executing query %q: %v", query, err)
 	}
 
-	properties := req.Schema.Properties
+	_, err = s.scanRecords(ctx, rows, req.Schema.Properties, out, nil)
+
+	return err
+}
+
+// scanRecords converts each row into a record and sends it to out,
+// calling progress (if set) with the running count after each record.
+// It returns the number of records sent.
+func (s *Server) scanRecords(ctx context.Context, rows *sql.Rows, properties []*pub.Property, out chan<- *pub.Record, progress func(count int)) (int, error) {
+	defer rows.Close()
+
+	var err error
+	var count int
 	valueBuffer := make([]interface{}, len(properties))
 	mapBuffer := make(map[string]interface{}, len(properties))
 
 	for rows.Next() {
 		if ctx.Err() != nil || !s.connected {
-			return nil
+			return count, nil
 		}
 
 		for i := range properties {
@@ -925,43 +1248,83 @@ WHERE rownum <= %d `, query, req.Limit)
 		}
 		err = rows.Scan(valueBuffer...)
 		if err != nil {
-			return errors.WithStack(err)
+			return count, errors.WithStack(err)
 		}
 
 		for i, p := range properties {
-			value := valueBuffer[i]
-			switch p.TypeAtSource {
-			case "DATE", "TIMESTAMP":
-				if t, ok := value.(time.Time); ok {
-					// strip time zone error from pure date and
-					// from timestamp without timezone
-					value = t.Format("2006-01-02T15:04:05.999999999Z")
-				}
-			}
-
-			mapBuffer[p.Id] = value
+			mapBuffer[p.Id] = publishedValue(p, valueBuffer[i])
 		}
 
 		var record *pub.Record
 		record, err = pub.NewRecord(pub.Record_UPSERT, mapBuffer)
 		if err != nil {
-			return errors.WithStack(err)
+			return count, errors.WithStack(err)
+		}
+
+		select {
+		case out <- record:
+		case <-ctx.Done():
+			return count, nil
+		}
+
+		count++
+		if progress != nil {
+			progress(count)
 		}
-		out <- record
 	}
 
-	if err == nil && rows.Err() != nil {
+	if rows.Err() != nil {
 		err = errors.WithMessage(rows.Err(), "error while scanning data")
 	}
 
-	return err
+	return count, err
+}
+
+// publishedValue returns the value scanned from a column as it is published in a record.
+func publishedValue(p *pub.Property, value interface{}) interface{} {
+	switch p.TypeAtSource {
+	case "DATE", "TIMESTAMP":
+		if t, ok := value.(time.Time); ok {
+			// strip time zone error from pure date and
+			// from timestamp without timezone
+			value = t.Format("2006-01-02T15:04:05.999999999Z")
+		}
+	}
+
+	return value
+}
+
+// readScope restricts a query built from a table-based schema.
+type readScope struct {
+	// partition is a partition extension clause, such as PARTITION ("P1").
+	partition string
+	// rowIDRange limits the query to the rows stored in a range of blocks.
+	rowIDRange *RowIDRange
+	// asOfSCN makes the query a flashback query as of the SCN.
+	asOfSCN uint64
+	// changedAfterSCN limits the query to rows with an ORA_ROWSCN after the SCN.
+	changedAfterSCN uint64
+	// condition is an additional condition on the properties of the schema,
+	// which also applies to query-based schemas.
+	condition string
 }
 
 func buildQuery(req *pub.ReadRequest) (string, error) {
+	return buildScopedQuery(req, readScope{})
+}
+
+func buildScopedQuery(req *pub.ReadRequest, scope readScope) (string, error) {
 
 	q := req.Schema.Query
 
-	if q == "" {
+	meta, err := getSchemaMeta(req.Schema)
+	if err != nil {
+		return "", err
+	}
+
+	if meta.Queue != nil {
+		q = buildQueueQuery(req.Schema, *meta.Queue)
+	} else if q == "" {
 		w := new(strings.Builder)
 		w.WriteString("SELECT ")
 
@@ -982,17 +1345,35 @@ func buildQuery(req *pub.ReadRequest) (string, error) {
 		}
 		columns := strings.Join(selectors, ", ")
 		fmt.Fprintln(w, columns)
-		fmt.Fprintln(w, "FROM ", req.Schema.Id)
+		source := req.Schema.Id
+		if scope.partition != "" {
+			source = fmt.Sprintf("%s %s", source, scope.partition)
+		}
+		if scope.asOfSCN != 0 {
+			source = fmt.Sprintf("%s AS OF SCN %d", source, scope.asOfSCN)
+		}
+		fmt.Fprintln(w, "FROM ", source)
 
-		if len(req.Filters) > 0 {
-			fmt.Fprintln(w, "WHERE")
+		var filters []string
 
+		if scope.rowIDRange != nil {
+			filters = append(filters, "  "+scope.rowIDRange.Predicate()+" ")
+		}
+
+		if scope.changedAfterSCN != 0 {
+			filters = append(filters, fmt.Sprintf("  ORA_ROWSCN > %d ", scope.changedAfterSCN))
+		}
+
+		if scope.condition != "" {
+			filters = append(filters, fmt.Sprintf("  %s ", scope.condition))
+		}
+
+		if len(req.Filters) > 0 {
 			properties := make(map[string]*pub.Property, len(req.Schema.Properties))
 			for _, p := range req.Schema.Properties {
 				properties[p.Id] = p
 			}
 
-			var filters []string
 			for _, f := range req.Filters {
 				property, ok := properties[f.PropertyId]
 				if !ok {
@@ -1024,12 +1405,19 @@ func buildQuery(req *pub.ReadRequest) (string, error) {
 
 				filters = append(filters, wf.String())
 			}
+		}
 
+		if len(filters) > 0 {
+			fmt.Fprintln(w, "WHERE")
 			fmt.Fprintln(w, strings.Join(filters, "AND\n  "))
-
 		}
 
 		q = w.String()
+	} else if scope.condition != "" {
+		q = fmt.Sprintf(`SELECT SRC.* FROM (
+%s
+) SRC
+WHERE %s`, strings.Trim(q, ";"), scope.condition)
 	}
 
 	return q, nil
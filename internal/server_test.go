@@ -16,6 +16,7 @@ import (
 	"google.golang.org/grpc/metadata"
 	"io"
 	"os"
+	"time"
 )
 
 var _ = Describe("Host", func() {
@@ -506,6 +507,283 @@ var _ = Describe("Host", func() {
 		})
 	})
 
+	Describe("Consistent snapshot reads", func() {
+
+		var req *pub.ReadRequest
+
+		BeforeEach(func() {
+			settings.Form.ConsistentSnapshotReads = true
+			Expect(sut.Connect(context.Background(), pub.NewConnectRequest(settings))).ToNot(BeNil())
+
+			response, err := sut.DiscoverShapes(context.Background(), &pub.DiscoverSchemasRequest{
+				Mode: pub.DiscoverSchemasRequest_REFRESH,
+				ToRefresh: []*pub.Schema{
+					{
+						Id:   `"C##NAVEEGO"."AGENTS"`,
+						Name: "Agents",
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			req = &pub.ReadRequest{
+				Schema: response.Schemas[0],
+				JobId:  "snapshot-job",
+			}
+		})
+
+		AfterEach(func() {
+			Expect(db.Exec(`DELETE FROM C##NAVEEGO.AGENTS WHERE AGENT_CODE = 'A999'`)).ToNot(BeNil())
+		})
+
+		It("should read every schema in a job as of the same SCN", func() {
+			first := new(publisherStream)
+			Expect(sut.PublishStream(req, first)).To(Succeed())
+			Expect(first.records).To(HaveLen(12))
+
+			var state RealTimeState
+			Expect(json.Unmarshal([]byte(first.records[0].RealTimeStateJson), &state)).To(Succeed())
+			Expect(state.SCN).To(BeNumerically(">", 0))
+
+			_, err := db.Exec(`INSERT INTO C##NAVEEGO.AGENTS (AGENT_CODE, AGENT_NAME) VALUES ('A999', 'Late')`)
+			Expect(err).ToNot(HaveOccurred())
+
+			second := new(publisherStream)
+			Expect(sut.PublishStream(req, second)).To(Succeed())
+			Expect(second.records).To(HaveLen(12))
+			Expect(second.records[0].RealTimeStateJson).To(Equal(first.records[0].RealTimeStateJson))
+
+			req.JobId = "next-job"
+			third := new(publisherStream)
+			Expect(sut.PublishStream(req, third)).To(Succeed())
+			Expect(third.records).To(HaveLen(13))
+		})
+	})
+
+	Describe("Real time", func() {
+
+		var agents *pub.Schema
+
+		BeforeEach(func() {
+			Expect(sut.Connect(context.Background(), pub.NewConnectRequest(settings))).ToNot(BeNil())
+
+			response, err := sut.DiscoverShapes(context.Background(), &pub.DiscoverSchemasRequest{
+				Mode: pub.DiscoverSchemasRequest_REFRESH,
+				ToRefresh: []*pub.Schema{
+					{
+						Id:   `"C##NAVEEGO"."AGENTS"`,
+						Name: "Agents",
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			agents = response.Schemas[0]
+		})
+
+		Describe("ConfigureRealTime", func() {
+
+			It("should return a json form schema on the first call", func() {
+				response, err := sut.ConfigureRealTime(context.Background(), &pub.ConfigureRealTimeRequest{
+					Schema: agents,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.SchemaJson).To(ContainSubstring("SCN Polling"))
+				Expect(response.Form.SchemaJson).To(ContainSubstring("ROWDEPENDENCIES"))
+				Expect(response.Form.DataJson).To(ContainSubstring("SCN Polling"))
+			})
+
+			It("should only warn about the modes a problem with the table affects", func() {
+				response, err := sut.ConfigureRealTime(context.Background(), &pub.ConfigureRealTimeRequest{
+					Schema: agents,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				var form struct {
+					Properties struct {
+						Mode struct {
+							Description string
+						}
+					}
+					Dependencies struct {
+						Mode struct {
+							OneOf []struct {
+								Properties struct {
+									Mode struct {
+										Enum        []RealTimeMode
+										Description string
+									}
+								}
+							}
+						}
+					}
+				}
+				Expect(json.Unmarshal([]byte(response.Form.SchemaJson), &form)).To(Succeed())
+				Expect(form.Properties.Mode.Description).ToNot(ContainSubstring("Warning"))
+
+				descriptions := map[RealTimeMode]string{}
+				for _, branch := range form.Dependencies.Mode.OneOf {
+					descriptions[branch.Properties.Mode.Enum[0]] = branch.Properties.Mode.Description
+				}
+				Expect(descriptions[RealTimeModeSCN]).To(ContainSubstring("ROWDEPENDENCIES"))
+				Expect(descriptions[RealTimeModeSCN]).ToNot(ContainSubstring("supplemental logging"))
+				Expect(descriptions[RealTimeModeLogMiner]).To(ContainSubstring("supplemental logging"))
+				Expect(descriptions[RealTimeModeLogMiner]).ToNot(ContainSubstring("ROWDEPENDENCIES"))
+			})
+
+			It("should reject SCN polling for a query", func() {
+				response, err := sut.ConfigureRealTime(context.Background(), &pub.ConfigureRealTimeRequest{
+					Schema: &pub.Schema{Id: "agent_names", Query: "SELECT AGENT_CODE FROM Agents"},
+					Form: &pub.ConfigurationFormRequest{
+						DataJson: `{"mode":"SCN Polling","pollingIntervalSeconds":1}`,
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.Errors).To(ContainElement(ContainSubstring("can only be used with a table")))
+			})
+		})
+
+		Describe("SCN polling", func() {
+
+			AfterEach(func() {
+				Expect(db.Exec(`DELETE FROM C##NAVEEGO.AGENTS WHERE AGENT_CODE = 'A999'`)).ToNot(BeNil())
+			})
+
+			It("should publish only rows committed after the checkpoint", func() {
+				req := &pub.ReadRequest{
+					Schema:               agents,
+					RealTimeSettingsJson: `{"mode":"SCN Polling","pollingIntervalSeconds":1}`,
+				}
+
+				stream := newRealTimeStream(1)
+				Expect(sut.ReadStream(req, stream)).To(Succeed())
+				Expect(stream.records).To(HaveLen(12))
+				Expect(stream.commits).To(HaveLen(1))
+
+				_, err := db.Exec(`INSERT INTO C##NAVEEGO.AGENTS (AGENT_CODE, AGENT_NAME) VALUES ('A999', 'Late')`)
+				Expect(err).ToNot(HaveOccurred())
+
+				req.RealTimeStateJson = stream.commits[0].RealTimeStateJson
+				stream = newRealTimeStream(1)
+				Expect(sut.ReadStream(req, stream)).To(Succeed())
+				Expect(stream.records).ToNot(BeEmpty())
+				Expect(stream.records).To(ContainElement(
+					WithTransform(func(r *pub.Record) string { return r.DataJson }, ContainSubstring("A999"))))
+			})
+		})
+
+		Describe("Change notifications", func() {
+
+			It("should poll as soon as the table changes", func() {
+				notifier := NewFakeChangeNotifier()
+				UseChangeNotifier(sut, notifier)
+
+				req := &pub.ReadRequest{
+					Schema:               agents,
+					RealTimeSettingsJson: `{"mode":"SCN Polling","pollingIntervalSeconds":3600,"changeNotifications":true}`,
+				}
+
+				notifier.Events <- struct{}{}
+				stream := newRealTimeStream(2)
+				done := make(chan error)
+				go func() { done <- sut.ReadStream(req, stream) }()
+
+				Eventually(done, 30*time.Second).Should(Receive(BeNil()))
+				Expect(stream.commits).To(HaveLen(2))
+				Expect(notifier.Closed).To(BeTrue())
+			})
+		})
+
+		Describe("Change log triggers", func() {
+
+			AfterEach(func() {
+				Expect(db.Exec(`DELETE FROM C##NAVEEGO.AGENTS WHERE AGENT_CODE = 'A999'`)).ToNot(BeNil())
+				Expect(sut.(*Server).UninstallChangeLog(context.Background(), agents.Id)).To(Succeed())
+			})
+
+			It("should require consent to install the change log", func() {
+				response, err := sut.ConfigureRealTime(context.Background(), &pub.ConfigureRealTimeRequest{
+					Schema: agents,
+					Form: &pub.ConfigurationFormRequest{
+						DataJson: `{"mode":"Change Log Triggers","pollingIntervalSeconds":1}`,
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.Errors).To(ContainElement(ContainSubstring("allow them to be installed")))
+
+				// nothing was installed, so there is nothing to uninstall
+				Expect(sut.(*Server).UninstallChangeLog(context.Background(), agents.Id)).ToNot(Succeed())
+				response, err = sut.ConfigureRealTime(context.Background(), &pub.ConfigureRealTimeRequest{
+					Schema: agents,
+					Form: &pub.ConfigurationFormRequest{
+						DataJson: `{"mode":"Change Log Triggers","installChangeLog":true,"pollingIntervalSeconds":1}`,
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.Errors).To(BeEmpty())
+			})
+
+			It("should publish inserts and deletes from the change log", func() {
+				req := &pub.ReadRequest{
+					Schema:               agents,
+					RealTimeSettingsJson: `{"mode":"Change Log Triggers","installChangeLog":true,"pollingIntervalSeconds":1}`,
+				}
+
+				stream := newRealTimeStream(1)
+				Expect(sut.ReadStream(req, stream)).To(Succeed())
+				Expect(stream.records).To(HaveLen(12))
+
+				_, err := db.Exec(`INSERT INTO C##NAVEEGO.AGENTS (AGENT_CODE, AGENT_NAME) VALUES ('A999', 'Late')`)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = db.Exec(`DELETE FROM C##NAVEEGO.AGENTS WHERE AGENT_CODE = 'A999'`)
+				Expect(err).ToNot(HaveOccurred())
+
+				req.RealTimeStateJson = stream.commits[0].RealTimeStateJson
+				stream = newRealTimeStream(1)
+				Expect(sut.ReadStream(req, stream)).To(Succeed())
+				Expect(stream.records).To(HaveLen(2))
+				Expect(stream.records[0].Action).To(Equal(pub.Record_INSERT))
+				Expect(stream.records[1].Action).To(Equal(pub.Record_DELETE))
+				Expect(stream.records[1].DataJson).To(ContainSubstring("A999"))
+			})
+		})
+
+		Describe("Watermark polling", func() {
+
+			AfterEach(func() {
+				Expect(db.Exec(`DELETE FROM C##NAVEEGO.AGENTS WHERE AGENT_CODE = 'A999'`)).ToNot(BeNil())
+			})
+
+			It("should offer the date and number columns", func() {
+				response, err := sut.ConfigureRealTime(context.Background(), &pub.ConfigureRealTimeRequest{
+					Schema: agents,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.SchemaJson).To(ContainSubstring("Watermark Column"))
+				Expect(response.Form.SchemaJson).To(ContainSubstring(`UPDATED_AT`))
+			})
+
+			It("should publish only rows after the watermark", func() {
+				req := &pub.ReadRequest{
+					Schema:               agents,
+					RealTimeSettingsJson: `{"mode":"Watermark Column","watermarkColumn":"\"UPDATED_AT\"","overlapWindow":60,"pollingIntervalSeconds":1}`,
+				}
+
+				stream := newRealTimeStream(1)
+				Expect(sut.ReadStream(req, stream)).To(Succeed())
+				Expect(stream.records).To(HaveLen(12))
+				Expect(stream.commits).To(HaveLen(1))
+
+				_, err := db.Exec(`INSERT INTO C##NAVEEGO.AGENTS (AGENT_CODE, AGENT_NAME, UPDATED_AT) VALUES ('A999', 'Late', TIMESTAMP '1972-01-02 00:00:00 +00:00')`)
+				Expect(err).ToNot(HaveOccurred())
+
+				req.RealTimeStateJson = stream.commits[0].RealTimeStateJson
+				stream = newRealTimeStream(1)
+				Expect(sut.ReadStream(req, stream)).To(Succeed())
+				Expect(stream.records).To(HaveLen(1))
+				Expect(stream.records[0].DataJson).To(ContainSubstring("A999"))
+			})
+		})
+	})
+
 	Describe("Write Backs", func() {
 
 		BeforeEach(func() {
@@ -562,6 +840,49 @@ var _ = Describe("Host", func() {
 				Expect(response.Form.Errors).To(HaveLen(1))
 				Expect(response.Form.Errors[0]).To(ContainSubstring("stored procedure does not exist"))
 			})
+
+			It("should pass the action of each record to the action parameter", func() {
+				req.Form = &pub.ConfigurationFormRequest{
+					DataJson: `{"storedProcedure":"\"C##NAVEEGO\".\"TEST\"","actionParameter":"I_NAME"}`,
+				}
+
+				response, err := sut.ConfigureWrite(context.Background(), req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.Errors).To(BeEmpty())
+
+				Expect(response.Schema.Query).To(Equal(`DECLARE I_AGENTID CHAR(4);I_NAME VARCHAR2(40);I_COMMISSION BINARY_FLOAT; BEGIN "C##NAVEEGO"."TEST"(:I_AGENTID,:I_NAME,:I_COMMISSION); END;`))
+				Expect(response.Schema.Properties).To(HaveLen(2))
+				Expect(response.Schema.Properties[0].Id).To(Equal("I_AGENTID"))
+				Expect(response.Schema.Properties[1].Id).To(Equal("I_COMMISSION"))
+			})
+
+			It("should return an error when the action parameter is not a parameter", func() {
+				req.Form = &pub.ConfigurationFormRequest{
+					DataJson: `{"storedProcedure":"\"C##NAVEEGO\".\"TEST\"","actionParameter":"I_ACTION"}`,
+				}
+
+				response, err := sut.ConfigureWrite(context.Background(), req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.Errors).To(ConsistOf(ContainSubstring(`action parameter "I_ACTION" is not a parameter`)))
+			})
+
+			It("should return a schema which merges into a table", func() {
+				req.Form = &pub.ConfigurationFormRequest{
+					DataJson: `{"target":"Table","table":"\"C##NAVEEGO\".\"AGENTS\"","columns":[{"column":"AGENT_CODE","property":"code"},{"column":"AGENT_NAME"}]}`,
+				}
+
+				response, err := sut.ConfigureWrite(context.Background(), req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.Form.Errors).To(BeEmpty())
+
+				Expect(response.Schema.Id).To(Equal(`"C##NAVEEGO"."AGENTS"`))
+				Expect(response.Schema.Query).To(HavePrefix(`MERGE INTO "C##NAVEEGO"."AGENTS" t`))
+				Expect(response.Schema.Properties).To(HaveLen(2))
+				Expect(response.Schema.Properties[0].Id).To(Equal("code"))
+				Expect(response.Schema.Properties[0].IsKey).To(BeTrue())
+				Expect(response.Schema.Properties[1].Id).To(Equal("AGENT_NAME"))
+				Expect(response.Schema.Properties[1].IsKey).To(BeFalse())
+			})
 		})
 
 		Describe("PrepareWrite", func() {
@@ -675,7 +996,7 @@ func (writeStream) SetTrailer(metadata.MD) {
 }
 
 func (writeStream) Context() context.Context {
-	panic("implement me")
+	return context.Background()
 }
 
 func (writeStream) SendMsg(m interface{}) error {
@@ -686,6 +1007,41 @@ func (writeStream) RecvMsg(m interface{}) error {
 	panic("implement me")
 }
 
+// realTimeStream collects the records of a real time read, and
+// ends the read after the expected number of state commits.
+type realTimeStream struct {
+	publisherStream
+	commits []*pub.Record
+	ctx     context.Context
+	cancel  func()
+	stopAt  int
+}
+
+func newRealTimeStream(stopAt int) *realTimeStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &realTimeStream{
+		ctx:    ctx,
+		cancel: cancel,
+		stopAt: stopAt,
+	}
+}
+
+func (p *realTimeStream) Send(record *pub.Record) error {
+	if record.Action != pub.Record_REAL_TIME_STATE_COMMIT {
+		return p.publisherStream.Send(record)
+	}
+
+	p.commits = append(p.commits, record)
+	if len(p.commits) >= p.stopAt {
+		p.cancel()
+	}
+	return nil
+}
+
+func (p *realTimeStream) Context() context.Context {
+	return p.ctx
+}
+
 type publisherStream struct {
 	records []*pub.Record
 	err     error
@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"gopkg.in/goracle.v2"
 	"strings"
+	"time"
 )
 
 type Settings struct {
@@ -26,6 +27,12 @@ type SettingsForm struct {
 	Password                  string `json:"password"`
 	WriteDiscovery            bool   `json:"writeDiscovery"`
 	DisableDiscoverAllSchemas bool   `json:"disableDiscoverAllSchemas"`
+	ParallelReadSessions      int    `json:"parallelReadSessions"`
+	RowIDChunks               int    `json:"rowIdChunks"`
+	ConsistentSnapshotReads   bool   `json:"consistentSnapshotReads"`
+	WriteRetryAttempts        int    `json:"writeRetryAttempts"`
+	WriteRetryBackoffMs       int    `json:"writeRetryBackoffMs"`
+	WriteRetryMaxBackoffMs    int    `json:"writeRetryMaxBackoffMs"`
 }
 
 type SettingsStringWithPassword struct {
@@ -33,6 +40,12 @@ type SettingsStringWithPassword struct {
 	Password                  string `json:"password"`
 	WriteDiscovery            bool   `json:"writeDiscovery"`
 	DisableDiscoverAllSchemas bool   `json:"disableDiscoverAllSchemas"`
+	ParallelReadSessions      int    `json:"parallelReadSessions"`
+	RowIDChunks               int    `json:"rowIdChunks"`
+	ConsistentSnapshotReads   bool   `json:"consistentSnapshotReads"`
+	WriteRetryAttempts        int    `json:"writeRetryAttempts"`
+	WriteRetryBackoffMs       int    `json:"writeRetryBackoffMs"`
+	WriteRetryMaxBackoffMs    int    `json:"writeRetryMaxBackoffMs"`
 }
 
 // Validate returns an error if the Settings are not valid.
@@ -112,6 +125,12 @@ func (s *Settings) GetConnectionString() (string, error) {
 
 		f := s.Form
 
+		// leave room for a session which is not part of a parallel read
+		maxSessions := 10
+		if p := s.GetParallelReadSessions(); p >= maxSessions {
+			maxSessions = p + 1
+		}
+
 		sid := fmt.Sprintf("%s:%d/%s", f.Hostname, f.Port, f.ServiceName)
 
 		cp := goracle.ConnectionParams{
@@ -119,7 +138,7 @@ func (s *Settings) GetConnectionString() (string, error) {
 			Username:    f.Username,
 			Password:    f.Password,
 			MinSessions: 1,
-			MaxSessions: 10,
+			MaxSessions: maxSessions,
 			ConnClass:   "POOLED",
 			IsSysOper:   true,
 		}
@@ -158,3 +177,85 @@ func (s *Settings) ShouldDiscoverWrite() bool {
 		return true
 	}
 }
+
+// GetParallelReadSessions returns the number of sessions which may be used
+// to read a single schema concurrently. Values less than 1 are treated as 1.
+func (s *Settings) GetParallelReadSessions() int {
+	var sessions int
+	switch s.Strategy {
+	case StrategyForm:
+		sessions = s.Form.ParallelReadSessions
+	case StrategyStringWithPassword:
+		sessions = s.StringWithPassword.ParallelReadSessions
+	}
+
+	if sessions < 1 {
+		return 1
+	}
+	return sessions
+}
+
+// GetRowIDChunks returns the number of ROWID ranges a non-partitioned table
+// is split into when it is read using parallel sessions. If it is not set,
+// each session is given four chunks to balance uneven reads.
+func (s *Settings) GetRowIDChunks() int {
+	var chunks int
+	switch s.Strategy {
+	case StrategyForm:
+		chunks = s.Form.RowIDChunks
+	case StrategyStringWithPassword:
+		chunks = s.StringWithPassword.RowIDChunks
+	}
+
+	if chunks < 1 {
+		return s.GetParallelReadSessions() * 4
+	}
+	return chunks
+}
+
+// ShouldReadConsistentSnapshot returns true if all the reads for a job
+// should be made as of the SCN captured at the start of the job.
+func (s *Settings) ShouldReadConsistentSnapshot() bool {
+	switch s.Strategy {
+	case StrategyForm:
+		return s.Form.ConsistentSnapshotReads
+	case StrategyStringWithPassword:
+		return s.StringWithPassword.ConsistentSnapshotReads
+
+	default:
+		return false
+	}
+}
+
+// writeRetryPolicy returns how write-back retries a batch which fails with a transient
+// error. Settings which are not set, or settings which have not been provided, use defaults.
+func (s *Settings) writeRetryPolicy() retryPolicy {
+	var attempts, backoffMs, maxBackoffMs int
+	if s != nil {
+		switch s.Strategy {
+		case StrategyForm:
+			attempts, backoffMs, maxBackoffMs = s.Form.WriteRetryAttempts, s.Form.WriteRetryBackoffMs, s.Form.WriteRetryMaxBackoffMs
+		case StrategyStringWithPassword:
+			attempts, backoffMs, maxBackoffMs = s.StringWithPassword.WriteRetryAttempts, s.StringWithPassword.WriteRetryBackoffMs, s.StringWithPassword.WriteRetryMaxBackoffMs
+		}
+	}
+
+	policy := retryPolicy{
+		attempts:   defaultRetryAttempts,
+		backoff:    defaultRetryBackoff,
+		maxBackoff: defaultRetryMaxBackoff,
+	}
+	if attempts > 0 {
+		policy.attempts = attempts
+	}
+	if backoffMs > 0 {
+		policy.backoff = time.Duration(backoffMs) * time.Millisecond
+	}
+	if maxBackoffMs > 0 {
+		policy.maxBackoff = time.Duration(maxBackoffMs) * time.Millisecond
+	}
+	if policy.maxBackoff < policy.backoff {
+		policy.maxBackoff = policy.backoff
+	}
+	return policy
+}
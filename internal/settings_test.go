@@ -1,6 +1,8 @@
 package internal_test
 
 import (
+	"time"
+
 	. "github.com/naveego/plugin-oracle/internal"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -56,4 +58,43 @@ var _ = Describe("Settings", func() {
 			Expect(settings.Validate()).To(Succeed())
 		})
 	})
+
+	Describe("Parallel reads", func() {
+
+		It("Should read serially by default", func() {
+			Expect(settings.GetParallelReadSessions()).To(Equal(1))
+		})
+
+		It("Should default to four ROWID chunks per session", func() {
+			settings.Form.ParallelReadSessions = 8
+			Expect(settings.GetRowIDChunks()).To(Equal(32))
+
+			settings.Form.RowIDChunks = 10
+			Expect(settings.GetRowIDChunks()).To(Equal(10))
+		})
+	})
+
+	Describe("Write retries", func() {
+
+		It("Should double the wait between attempts up to the maximum", func() {
+			settings.Form.WriteRetryAttempts = 6
+			settings.Form.WriteRetryBackoffMs = 500
+			settings.Form.WriteRetryMaxBackoffMs = 3000
+			Expect(WriteRetryWaits(settings)).To(Equal([]time.Duration{
+				500 * time.Millisecond, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second,
+			}))
+		})
+
+		It("Should use defaults if the settings are not set", func() {
+			Expect(WriteRetryWaits(settings)).To(Equal([]time.Duration{
+				100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond,
+			}))
+			Expect(WriteRetryWaits(nil)).To(HaveLen(4))
+		})
+
+		It("Should not retry if one attempt is allowed", func() {
+			settings.Form.WriteRetryAttempts = 1
+			Expect(WriteRetryWaits(settings)).To(BeEmpty())
+		})
+	})
 })
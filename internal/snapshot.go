@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// snapshot is a point in time which all reads for a job are made as of.
+type snapshot struct {
+	scn      uint64
+	captured time.Time
+}
+
+// jobSnapshotTTL is how long the snapshot for a job is kept after it
+// was captured, so that reads of other schemas in the job can share it.
+const jobSnapshotTTL = 24 * time.Hour
+
+// snapshots holds the snapshot of each job which is using consistent reads.
+type snapshots struct {
+	mu   sync.Mutex
+	jobs map[string]snapshot
+}
+
+// getSnapshot returns the snapshot the request should be read as of,
+// or nil if consistent reads are not enabled. Requests sharing a job ID
+// share the snapshot captured by the first request of the job.
+func (s *Server) getSnapshot(ctx context.Context, req *pub.ReadRequest) (*snapshot, error) {
+	if s.settings == nil || !s.settings.ShouldReadConsistentSnapshot() {
+		return nil, nil
+	}
+
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	now := time.Now()
+	for jobID, snap := range s.snapshots.jobs {
+		if now.Sub(snap.captured) > jobSnapshotTTL {
+			delete(s.snapshots.jobs, jobID)
+		}
+	}
+
+	if snap, ok := s.snapshots.jobs[req.JobId]; ok && req.JobId != "" {
+		return &snap, nil
+	}
+
+	scn, err := s.getCurrentSCN(ctx)
+	if err != nil {
+		return nil, errors.Errorf("could not capture snapshot: %s", err)
+	}
+
+	snap := snapshot{scn: scn, captured: now}
+	if req.JobId != "" {
+		if s.snapshots.jobs == nil {
+			s.snapshots.jobs = make(map[string]snapshot)
+		}
+		s.snapshots.jobs[req.JobId] = snap
+	}
+
+	s.log.Info("Captured snapshot for read.", "jobId", req.JobId, "scn", scn)
+
+	return &snap, nil
+}
+
+// getCurrentSCN returns the current system change number of the database.
+func (s *Server) getCurrentSCN(ctx context.Context) (uint64, error) {
+	var scn uint64
+	err := s.db.QueryRowContext(ctx, `SELECT CURRENT_SCN FROM V$DATABASE`).Scan(&scn)
+	if err == nil {
+		return scn, nil
+	}
+
+	// V$DATABASE requires SELECT_CATALOG_ROLE, so fall back to the flashback package
+	s.log.Debug("Could not read SCN from V$DATABASE, using DBMS_FLASHBACK.", "err", err)
+	err = s.db.QueryRowContext(ctx, `SELECT DBMS_FLASHBACK.GET_SYSTEM_CHANGE_NUMBER FROM DUAL`).Scan(&scn)
+
+	return scn, errors.WithStack(err)
+}
+
+// readQueryAsOf runs a query-based read as of the SCN. Flashback
+// clauses cannot be added to an arbitrary query, so the whole session is
+// put into flashback mode for the duration of the read.
+func (s *Server) readQueryAsOf(ctx context.Context, query string, scn uint64, properties []*pub.Property, out chan<- *pub.Record) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return errors.Errorf("could not open session: %s", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `BEGIN DBMS_FLASHBACK.ENABLE_AT_SYSTEM_CHANGE_NUMBER(:scn); END;`, sql.Named("scn", int64(scn)))
+	if err != nil {
+		return errors.Errorf("could not enable flashback at SCN %d: %s", scn, err)
+	}
+	defer func() {
+		// the session is returned to the pool, so it must not stay in flashback mode
+		if _, err := conn.ExecContext(context.Background(), `BEGIN DBMS_FLASHBACK.DISABLE; END;`); err != nil {
+			s.log.Error("Could not disable flashback.", "err", err)
+		}
+	}()
+
+	rows, err := s.executeQueryContext(ctx, conn, query)
+	if err != nil {
+		return errors.Errorf("error executing query %q: %v", query, err)
+	}
+
+	_, err = s.scanRecords(ctx, rows, properties, out, nil)
+
+	return err
+}
+
+// stateJSON returns the real time state identifying the snapshot.
+func (snap *snapshot) stateJSON() string {
+	b, _ := json.Marshal(RealTimeState{SCN: snap.scn})
+	return string(b)
+}
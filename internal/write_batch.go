@@ -0,0 +1,587 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+	"gopkg.in/goracle.v2"
+)
+
+// defaultWriteBatchSize is the batch size used when it is not set.
+const defaultWriteBatchSize = 100
+
+// receivedRecord is a record received from a write stream, or the error which ended the stream.
+type receivedRecord struct {
+	record *pub.Record
+	// at is when the record was received, from which its commit SLA is measured.
+	at  time.Time
+	err error
+}
+
+// writeStreamReceiver is the receiving half of a write stream.
+type writeStreamReceiver interface {
+	Recv() (*pub.Record, error)
+}
+
+// receiveRecords receives records from the stream until it ends, so that they can be
+// batched. The error which ended the stream, io.EOF if it ended normally, is sent last.
+func receiveRecords(ctx context.Context, stream writeStreamReceiver) <-chan receivedRecord {
+	received := make(chan receivedRecord)
+
+	go func() {
+		defer close(received)
+		for {
+			record, err := stream.Recv()
+			select {
+			case received <- receivedRecord{record: record, at: time.Now(), err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return received
+}
+
+// nextBatch waits for a record, then collects records until the batch is full or the window
+// has elapsed since the first record was received, returning them with when the first was
+// received. It returns the records collected, which may be none, once due fires, if it is
+// set. If the stream has ended it returns the error which ended it, with the records
+// received before the error.
+func nextBatch(ctx context.Context, received <-chan receivedRecord, size int, window time.Duration, due <-chan time.Time) ([]*pub.Record, time.Time, error) {
+	var batch []*pub.Record
+	var first time.Time
+	var deadline <-chan time.Time
+
+	for len(batch) < size {
+		select {
+		case r, ok := <-received:
+			if !ok {
+				return batch, first, ctx.Err()
+			}
+			if r.err != nil {
+				return batch, first, r.err
+			}
+			batch = append(batch, r.record)
+			if deadline == nil {
+				// a record may have waited in a partition's buffer
+				first = r.at
+				deadline = time.After(time.Until(first.Add(window)))
+			}
+		case <-deadline:
+			return batch, first, nil
+		case <-due:
+			return batch, first, nil
+		case <-ctx.Done():
+			return batch, first, ctx.Err()
+		}
+	}
+
+	return batch, first, nil
+}
+
+// batchWindow returns how long a batch collects records for, which is half
+// of the time the host allows before a record must be acknowledged.
+func batchWindow(commitSLA int32) time.Duration {
+	if commitSLA <= 0 {
+		return time.Second
+	}
+	return time.Duration(commitSLA) * time.Second / 2
+}
+
+// recordBinder converts a record to the statement which writes it and the statement's named binds.
+type recordBinder func(record *pub.Record) (query string, args []interface{}, err error)
+
+// batchWriter writes the records of a batch.
+type batchWriter struct {
+	bind recordBinder
+	// failure describes a record which could not be written in its ack, as in "could not write back".
+	failure string
+	// outputs, if it is set, returns the values captured by the OUT binds of a record
+	// to the host once the record has been committed.
+	outputs func(ack *pub.RecordAck, values map[string]interface{})
+}
+
+// boundRecord is a record in a batch converted by a recordBinder.
+type boundRecord struct {
+	ack   *pub.RecordAck
+	query string
+	args  []interface{}
+	// err is the error the record could not be written with, if any.
+	err error
+	// skipped is set if the record was not written because it had already been written.
+	skipped bool
+}
+
+// batchSavepoint is the savepoint a batch rolls back to when an array of records fails.
+const batchSavepoint = "NAVEEGO_BATCH"
+
+// writeBatches writes the records received from the stream in batches of up to size records,
+// each in its own transaction, and acknowledges the records once the transaction has been committed.
+func (s *Server) writeBatches(stream pub.Publisher_WriteStreamServer, schema *pub.Schema, size int, w batchWriter) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	// a dry run only writes in transactions which are rolled back
+	var summary *dryRunSummary
+	deadLetter := s.WriteSettings.DeadLetter
+	if s.WriteSettings.DryRun {
+		s.log.Info("Writing back as a dry run, every transaction will be rolled back.", "schema", schema.Id)
+		summary = newDryRunSummary()
+		defer summary.log(s.log, schema)
+		deadLetter = nil
+	}
+
+	if idempotency := s.WriteSettings.Idempotency; idempotency != nil && summary == nil {
+		if err := s.purgeCorrelations(ctx, schema, *idempotency); err != nil {
+			s.log.Warn("Could not purge correlation IDs.", "schema", schema.Id, "error", err)
+		}
+	}
+
+	if size < 1 {
+		size = defaultWriteBatchSize
+	}
+	received := receiveRecords(ctx, stream)
+	window := batchWindow(s.WriteSettings.CommitSLA)
+
+	// the acks of concurrent partitions are sent as their batches are committed
+	var sendMu sync.Mutex
+	write := func(batch []*pub.Record, received time.Time) error {
+		acks, rejected := s.writeBatch(ctx, schema, batch, w, received)
+		if deadLetter != nil {
+			s.deadLetter(ctx, schema, *deadLetter, batch, acks, rejected)
+		}
+		if summary != nil {
+			summary.add(acks, rejected)
+		}
+
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		for _, ack := range acks {
+			if err := stream.Send(ack); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	loop := func(ctx context.Context, received <-chan receivedRecord) error {
+		return writeLoop(ctx, received, size, window, write)
+	}
+
+	concurrency := s.WriteSettings.Concurrency
+	if concurrency > 1 && len(keyProperties(schema)) == 0 {
+		s.log.Warn("Writing back serially because the schema has no key properties to partition records by.", "schema", schema.Id)
+		concurrency = 1
+	}
+	if concurrency <= 1 {
+		return loop(ctx, received)
+	}
+
+	s.log.Debug("Writing back concurrently.", "schema", schema.Id, "partitions", concurrency)
+	return writePartitions(ctx, received, concurrency, size, keyPartitioner(schema, concurrency), loop)
+}
+
+// writeLoop writes the records received in batches until the stream ends.
+func writeLoop(ctx context.Context, received <-chan receivedRecord, size int, window time.Duration, write func(batch []*pub.Record, received time.Time) error) error {
+	for {
+		batch, first, err := nextBatch(ctx, received, size, window, nil)
+
+		if len(batch) > 0 {
+			if writeErr := write(batch, first); writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeBatch writes the records in a transaction, returning their acks. Consecutive records
+// written by the same statement are executed together using array binds. A record which
+// cannot be written does not prevent the rest of the batch from being committed. A batch which
+// fails with a transient error, such as a deadlock, is written again in a new transaction.
+// It also reports which records were rejected, because they could not be converted or
+// written, as opposed to failing with the rest of the batch. Received is when the first
+// record of the batch was received, from which the commit SLA is measured.
+func (s *Server) writeBatch(ctx context.Context, schema *pub.Schema, batch []*pub.Record, w batchWriter, received time.Time) ([]*pub.RecordAck, []bool) {
+	acks := make([]*pub.RecordAck, len(batch))
+	rejected := make([]bool, len(batch))
+	for i, record := range batch {
+		acks[i] = &pub.RecordAck{CorrelationId: record.CorrelationId}
+	}
+
+	failAll := func(message string) ([]*pub.RecordAck, []bool) {
+		for _, ack := range acks {
+			if ack.Error == "" {
+				ack.Error = message
+			}
+		}
+		return acks, rejected
+	}
+
+	// the batch must be committed within the SLA of its first record, whatever was
+	// spent collecting it, after which it is cancelled and rolled back
+	var deadline time.Time
+	if sla := s.WriteSettings.CommitSLA; sla > 0 {
+		deadline = received.Add(time.Duration(sla) * time.Second)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	var tracker *correlationTracker
+	if s.WriteSettings.Idempotency != nil {
+		tracker = newCorrelationTracker(schema, *s.WriteSettings.Idempotency)
+	}
+
+	var bound []boundRecord
+	err := s.settings.writeRetryPolicy().retry(ctx, s.log, deadline, func() error {
+		bound = bindBatch(batch, acks, w.bind)
+		return writeAttempt(ctx, s.db, bound, w.failure, tracker, s.WriteSettings.DryRun)
+	})
+	for i, ack := range acks {
+		rejected[i] = ack.Error != ""
+	}
+	if err != nil {
+		return failAll(err.Error())
+	}
+
+	if w.outputs != nil {
+		for _, r := range bound {
+			if values := outputValues(r.args); values != nil && r.ack.Error == "" && !r.skipped {
+				w.outputs(r.ack, values)
+			}
+		}
+	}
+
+	s.log.Debug("Wrote batch.", "schema", schema.Id, "records", len(batch))
+
+	return acks, rejected
+}
+
+// bindBatch converts the records of a batch, setting the ack errors of those which cannot be
+// converted. A batch is bound again for each attempt to write it, as a LOB is bound as a
+// reader which the first execution of its statement consumes.
+func bindBatch(batch []*pub.Record, acks []*pub.RecordAck, bind recordBinder) []boundRecord {
+	var bound []boundRecord
+	for i, record := range batch {
+		query, args, err := bind(record)
+		if err != nil {
+			acks[i].Error = fmt.Sprintf("%s: %s", conversionFailure, err)
+			continue
+		}
+		bound = append(bound, boundRecord{ack: acks[i], query: query, args: args})
+	}
+	return bound
+}
+
+// txBeginner begins transactions, on any session of a pool or on one session.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// batchOutcomeError is an error which ended a batch, with what happened to its transaction.
+// It is not retried, either because the batch ran out of time or because it may have been committed.
+type batchOutcomeError struct {
+	outcome string
+	err     error
+}
+
+func (e batchOutcomeError) Error() string { return fmt.Sprintf("%s: %s", e.outcome, e.err) }
+
+func (e batchOutcomeError) errorClass() errorClass { return errorPermanent }
+
+// rolledBack returns the error for a batch which was rolled back because its context ended.
+func rolledBack(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return batchOutcomeError{outcome: "rolled back because it was not written within the commit SLA", err: err}
+	}
+	return batchOutcomeError{outcome: "rolled back because the write was cancelled", err: err}
+}
+
+// writeAttempt writes the records in a transaction. Records which cannot be written have
+// their ack errors set, and the rest are committed. If a transient error occurs the
+// transaction is rolled back and the error is returned, so that the batch can be retried.
+// If the tracker is set, records which have already been written are skipped. If dryRun
+// is set the transaction is rolled back instead of being committed.
+//
+// If the context ends while the batch is being written, the statement being executed is
+// cancelled and the transaction is rolled back. A commit cannot be cancelled once it has
+// been sent, so the batch is committed if the commit succeeds, even after the deadline.
+// If the connection is lost while committing, whether the batch was committed is unknown.
+func writeAttempt(ctx context.Context, db txBeginner, bound []boundRecord, failure string, tracker *correlationTracker, dryRun bool) error {
+	for i := range bound {
+		bound[i].ack.Error = ""
+		bound[i].err = nil
+		bound[i].skipped = false
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return rolledBack(ctx, err)
+		}
+		return errors.WithMessage(err, "could not begin transaction")
+	}
+
+	abort := func(err error, message string) error {
+		tx.Rollback()
+		if ctx.Err() != nil {
+			return rolledBack(ctx, err)
+		}
+		return errors.WithMessage(err, message)
+	}
+
+	write := bound
+	if tracker != nil {
+		if write, err = tracker.track(ctx, tx, bound); err != nil {
+			return abort(err, "could not track correlation IDs")
+		}
+	}
+
+	for _, run := range statementRuns(write) {
+		if err = execRun(ctx, tx, run, failure); err != nil {
+			return abort(err, failure)
+		}
+	}
+
+	if tracker != nil {
+		if err = tracker.untrack(ctx, tx, write); err != nil {
+			return abort(err, "could not track correlation IDs")
+		}
+	}
+
+	if dryRun {
+		return errors.WithMessage(tx.Rollback(), "could not roll back")
+	}
+
+	err = tx.Commit()
+	switch {
+	case err == nil:
+		return nil
+	case err == ctx.Err() || err == sql.ErrTxDone:
+		// the commit was not sent
+		return rolledBack(ctx, err)
+	case classifyError(err) == errorTransient:
+		return batchOutcomeError{outcome: "the outcome of the batch is unknown", err: errors.WithMessage(err, "could not commit")}
+	default:
+		// a transaction which cannot be committed is rolled back
+		return errors.WithMessage(err, "could not commit")
+	}
+}
+
+// statementRuns splits the records into runs of consecutive records which
+// are written by the same statement, so that each run can be array bound.
+func statementRuns(bound []boundRecord) [][]boundRecord {
+	var runs [][]boundRecord
+	start := 0
+	for i := 1; i <= len(bound); i++ {
+		if i < len(bound) && sameStatement(bound[start], bound[i]) {
+			continue
+		}
+		if i > start {
+			runs = append(runs, bound[start:i])
+		}
+		start = i
+	}
+	return runs
+}
+
+func sameStatement(a, b boundRecord) bool {
+	if a.query != b.query || len(a.args) != len(b.args) {
+		return false
+	}
+	for i := range a.args {
+		an, ok := a.args[i].(sql.NamedArg)
+		bn, ok2 := b.args[i].(sql.NamedArg)
+		if !ok || !ok2 || an.Name != bn.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// execRun writes a run of records with one execution of their statement, setting the ack
+// error of each record which cannot be written. The driver does not support batch error mode,
+// so when an array fails it is rolled back and written in halves until the failed records are found.
+// If a transient error occurs, or the context ends, it stops and returns the error.
+func execRun(ctx context.Context, tx *sql.Tx, run []boundRecord, failure string) error {
+	failRun := func(err error) error {
+		if classifyError(err) == errorTransient || ctx.Err() != nil {
+			return err
+		}
+		for i := range run {
+			run[i].err = err
+			run[i].ack.Error = fmt.Sprintf("%s: %s", failure, err)
+		}
+		return nil
+	}
+
+	if len(run) == 1 {
+		if _, err := tx.ExecContext(ctx, run[0].query, run[0].args...); err != nil {
+			return failRun(err)
+		}
+		return nil
+	}
+
+	args, ok := arrayArgs(run)
+	if !ok {
+		for i := range run {
+			if err := execRun(ctx, tx, run[i:i+1], failure); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+batchSavepoint); err != nil {
+		return failRun(err)
+	}
+	_, err := tx.ExecContext(ctx, run[0].query, args...)
+	if err == nil {
+		return nil
+	}
+	if classifyError(err) == errorTransient || ctx.Err() != nil {
+		return err
+	}
+	// the records before the failed record have been written
+	if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+batchSavepoint); err != nil {
+		return failRun(err)
+	}
+
+	half := len(run) / 2
+	if err := execRun(ctx, tx, run[:half], failure); err != nil {
+		return err
+	}
+	return execRun(ctx, tx, run[half:], failure)
+}
+
+// arrayArgs returns the binds of a run of records as arrays, with the value of each bind for
+// each record. It returns false if the values of a bind do not have a type which can be an array.
+func arrayArgs(run []boundRecord) ([]interface{}, bool) {
+	var args []interface{}
+	values := make([]interface{}, len(run))
+	for i := range run[0].args {
+		for j, r := range run {
+			values[j] = r.args[i].(sql.NamedArg).Value
+		}
+		array, ok := arrayValue(values)
+		if !ok {
+			return nil, false
+		}
+		args = append(args, sql.Named(run[0].args[i].(sql.NamedArg).Name, array))
+	}
+	return args, true
+}
+
+// arrayValue converts the values to a slice which the driver can bind as an array.
+// Nil values are bound as nulls, using the zero value of strings, numbers and times,
+// which Oracle and the driver treat as null. Times are only bound to DATEs, as timestamps
+// are bound as text.
+func arrayValue(values []interface{}) (interface{}, bool) {
+	var first interface{}
+	for _, v := range values {
+		if v != nil {
+			first = v
+			break
+		}
+	}
+
+	switch first.(type) {
+	case nil, string:
+		array := make([]string, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			s, ok := v.(string)
+			if !ok {
+				return nil, false
+			}
+			array[i] = s
+		}
+		return array, true
+	case float64:
+		array := make([]sql.NullFloat64, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			f, ok := v.(float64)
+			if !ok {
+				return nil, false
+			}
+			array[i] = sql.NullFloat64{Float64: f, Valid: true}
+		}
+		return array, true
+	case int, int64:
+		array := make([]sql.NullInt64, len(values))
+		for i, v := range values {
+			switch n := v.(type) {
+			case nil:
+			case int:
+				array[i] = sql.NullInt64{Int64: int64(n), Valid: true}
+			case int64:
+				array[i] = sql.NullInt64{Int64: n, Valid: true}
+			default:
+				return nil, false
+			}
+		}
+		return array, true
+	case goracle.Number:
+		array := make([]goracle.Number, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			n, ok := v.(goracle.Number)
+			if !ok {
+				return nil, false
+			}
+			array[i] = n
+		}
+		return array, true
+	case time.Time:
+		array := make([]time.Time, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			t, ok := v.(time.Time)
+			if !ok {
+				return nil, false
+			}
+			array[i] = t
+		}
+		return array, true
+	case []byte:
+		array := make([][]byte, len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			b, ok := v.([]byte)
+			if !ok {
+				return nil, false
+			}
+			array[i] = b
+		}
+		return array, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,238 @@
+package internal_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batched write back", func() {
+
+	var (
+		sut    pub.PublisherServer
+		db     *fakeDB
+		schema *pub.Schema
+	)
+
+	BeforeEach(func() {
+		sut, db = newWriteServer(hclog.NewNullLogger())
+
+		schema = &pub.Schema{
+			Id: `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{
+				{Id: "code", Type: pub.PropertyType_STRING},
+				{Id: "name", Type: pub.PropertyType_STRING},
+				{Id: "commission", Type: pub.PropertyType_FLOAT},
+			},
+			PublisherMetaJson: `{"write":{"target":"Table","table":{
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":["AGENT_CODE","AGENT_NAME","COMMISSION"],
+				"keyColumns":["AGENT_CODE"],
+				"batchSize":10}}}`,
+		}
+	})
+
+	It("should write consecutive records with the same statement as one array", func() {
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_UPSERT, "1", `{"code":"A001","name":"One","commission":0.1}`),
+			writeRecord(pub.Record_UPSERT, "2", `{"code":"A002","name":"Two"}`),
+			writeRecord(pub.Record_DELETE, "3", `{"code":"A003"}`),
+			writeRecord(pub.Record_UPSERT, "4", `{"code":"A004","name":"Four","commission":0.4}`),
+		)
+
+		Expect(acks).To(HaveLen(4))
+		for i, ack := range acks {
+			Expect(ack.CorrelationId).To(Equal([]string{"1", "2", "3", "4"}[i]))
+			Expect(ack.Error).To(BeEmpty())
+		}
+
+		executions := db.Executions()
+		Expect(executions).To(HaveLen(3))
+		Expect(executions[0].query).To(HavePrefix("MERGE"))
+		Expect(executions[0].rows).To(Equal(2))
+		Expect(executions[1].query).To(HavePrefix("DELETE"))
+		Expect(executions[2].query).To(HavePrefix("MERGE"))
+
+		committed := db.Committed()
+		Expect(committed).To(HaveLen(4))
+		Expect(committed[1].values).To(Equal(map[string]interface{}{"p1": "A002", "p2": "Two", "p3": nil}))
+	})
+
+	It("should only fail the records which could not be written", func() {
+		db.fail = func(query string, row map[string]interface{}) error {
+			if row["p1"] == "BAD1" || row["p1"] == "BAD2" {
+				return errors.New("ORA-00001: unique constraint violated")
+			}
+			return nil
+		}
+
+		var records []*pub.Record
+		for _, code := range []string{"A001", "BAD1", "A003", "A004", "A005", "BAD2", "A007"} {
+			records = append(records, writeRecord(pub.Record_INSERT, code, `{"code":"`+code+`"}`))
+		}
+		acks := writeBack(sut, schema, records...)
+
+		Expect(acks).To(HaveLen(7))
+		for _, ack := range acks {
+			if strings.HasPrefix(ack.CorrelationId, "BAD") {
+				Expect(ack.Error).To(Equal("could not write back: ORA-00001: unique constraint violated"))
+			} else {
+				Expect(ack.Error).To(BeEmpty())
+			}
+		}
+
+		var codes []interface{}
+		for _, row := range db.Committed() {
+			codes = append(codes, row.values["p1"])
+		}
+		Expect(codes).To(Equal([]interface{}{"A001", "A003", "A004", "A005", "A007"}))
+	})
+
+	It("should retry a batch which fails with a transient error", func() {
+		UseSettings(sut, &Settings{Form: &SettingsForm{WriteRetryBackoffMs: 1}, Strategy: StrategyForm})
+		deadlocks := 1
+		db.fail = func(query string, row map[string]interface{}) error {
+			if row["p1"] == "A002" && deadlocks > 0 {
+				deadlocks--
+				return errors.New("ORA-00060: deadlock detected while waiting for resource")
+			}
+			return nil
+		}
+
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"A002"}`),
+		)
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(BeEmpty())
+		Expect(db.Executions()).To(HaveLen(2))
+		Expect(db.Committed()).To(HaveLen(2))
+	})
+
+	It("should bind a LOB again when a batch is retried", func() {
+		UseSettings(sut, &Settings{Form: &SettingsForm{WriteRetryBackoffMs: 1}, Strategy: StrategyForm})
+		schema.Properties[1].TypeAtSource = "CLOB"
+		deadlocks := 1
+		db.fail = func(query string, row map[string]interface{}) error {
+			if row["p1"] == "A002" && deadlocks > 0 {
+				deadlocks--
+				return errors.New("ORA-00060: deadlock detected while waiting for resource")
+			}
+			return nil
+		}
+
+		name := strings.Repeat("x", 5000)
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", fmt.Sprintf(`{"code":"A001","name":%q}`, name)),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"A002"}`),
+		)
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(BeEmpty())
+		rows := db.Committed()
+		Expect(rows).To(HaveLen(2))
+		Expect(rows[0].values["p2"]).To(Equal(name))
+	})
+
+	It("should fail the batch when a transient error persists", func() {
+		UseSettings(sut, &Settings{Form: &SettingsForm{WriteRetryAttempts: 3, WriteRetryBackoffMs: 1}, Strategy: StrategyForm})
+		db.fail = func(query string, row map[string]interface{}) error {
+			if row["p1"] == "A002" {
+				return errors.New("ORA-08177: can't serialize access for this transaction")
+			}
+			return nil
+		}
+
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"A002"}`),
+			writeRecord(pub.Record_INSERT, "3", `not json`),
+		)
+
+		Expect(acks[0].Error).To(Equal("could not write back: ORA-08177: can't serialize access for this transaction"))
+		Expect(acks[1].Error).To(Equal(acks[0].Error))
+		Expect(acks[2].Error).To(HavePrefix("could not convert record"))
+		Expect(db.Executions()).To(HaveLen(3))
+		Expect(db.Committed()).To(BeEmpty())
+	})
+
+	It("should not retry a batch which fails with a permanent error", func() {
+		db.fail = func(query string, row map[string]interface{}) error {
+			return errors.New("ORA-01722: invalid number")
+		}
+
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`))
+
+		Expect(acks[0].Error).To(Equal("could not write back: ORA-01722: invalid number"))
+		Expect(db.Executions()).To(HaveLen(1))
+	})
+
+	It("should acknowledge a record which cannot be converted without writing it", func() {
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`),
+			writeRecord(pub.Record_INSERT, "2", `not json`),
+		)
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(HavePrefix("could not convert record"))
+		Expect(db.Committed()).To(HaveLen(1))
+	})
+
+	It("should convert values to the types of their properties to write them as one array", func() {
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001","commission":0.1}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"A002","commission":"0.2"}`),
+		)
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(BeEmpty())
+		executions := db.Executions()
+		Expect(executions).To(HaveLen(1))
+		Expect(executions[0].rows).To(Equal(2))
+		Expect(db.Committed()[1].values).To(HaveKeyWithValue("p3", 0.2))
+	})
+
+	It("should write records whose values cannot be bound as one array one at a time", func() {
+		schema.Properties[1].TypeAtSource = "CLOB"
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001","name":"short"}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"A002","name":"`+strings.Repeat("x", 5000)+`"}`),
+		)
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(BeEmpty())
+		executions := db.Executions()
+		Expect(executions).To(HaveLen(2))
+		Expect(executions[0].rows).To(Equal(1))
+	})
+
+	It("should acknowledge a record with a value which cannot be converted", func() {
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001","commission":"high"}`))
+
+		Expect(acks[0].Error).To(Equal(`could not convert record: cannot convert commission value "high" to FLOAT: it is not a number`))
+		Expect(db.Committed()).To(BeEmpty())
+	})
+
+	It("should batch the calls of a stored procedure", func() {
+		schema = &pub.Schema{
+			Id:         `"C##NAVEEGO"."TEST"`,
+			Query:      `DECLARE I_AGENTID CHAR(4); BEGIN "C##NAVEEGO"."TEST"(:I_AGENTID); END;`,
+			Properties: []*pub.Property{{Id: "I_AGENTID"}},
+		}
+
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_UPSERT, "1", `{"I_AGENTID":"A001"}`),
+			writeRecord(pub.Record_UPSERT, "2", `{"I_AGENTID":"A002"}`),
+		)
+
+		Expect(acks).To(HaveLen(2))
+		Expect(db.Executions()).To(Equal([]fakeExecution{{query: schema.Query, rows: 2}}))
+	})
+})
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// The variables which the call of a procedure with composite arguments uses to convert them
+// from and to JSON. They are prefixed so they do not hide the arguments' variables.
+const (
+	compositeObject = "NAVEEGO_O"
+	compositeArray  = "NAVEEGO_A"
+	compositeIndex  = "NAVEEGO_I"
+)
+
+// compositeDeclarations declares the variables which convert composite arguments.
+var compositeDeclarations = fmt.Sprintf("%s JSON_OBJECT_T;%s JSON_ARRAY_T;%s PLS_INTEGER;", compositeObject, compositeArray, compositeIndex)
+
+// compositeKind returns the kind of composite with the data type from ALL_ARGUMENTS,
+// or "" if values of the data type are scalars.
+func compositeKind(dataType string) CompositeKind {
+	switch dataType {
+	case "PL/SQL RECORD":
+		return CompositeRecord
+	case "OBJECT":
+		return CompositeObject
+	case "TABLE", "VARRAY":
+		return CompositeCollection
+	case "PL/SQL TABLE":
+		return CompositeIndexTable
+	}
+	return ""
+}
+
+// compositeType returns the type of a composite argument from its attributes, or its element.
+// An argument is passed as JSON if its attributes are scalars, or if it is a collection of
+// scalars or of records and objects whose attributes are scalars.
+func (a procedureArgument) compositeType() (*CompositeType, error) {
+	kind := compositeKind(a.dataType)
+	if kind == "" {
+		return nil, nil
+	}
+	if a.typeName == "" {
+		return nil, errors.Errorf("the type of argument %s is not known", a.bindName())
+	}
+
+	composite := &CompositeType{Kind: kind}
+	switch kind {
+	case CompositeRecord, CompositeObject:
+		if kind == CompositeObject {
+			composite.Constructor = a.typeName
+		}
+		for _, attribute := range a.attributes {
+			if compositeKind(attribute.dataType) != "" {
+				return nil, errors.Errorf("attribute %s of argument %s is a %s, which cannot be passed as JSON", attribute.name, a.bindName(), attribute.dataType)
+			}
+			composite.Attributes = append(composite.Attributes, CompositeAttribute{Name: attribute.name, DataType: attribute.dataType})
+		}
+	default:
+		if kind == CompositeCollection {
+			composite.Constructor = a.typeName
+		}
+		if len(a.attributes) != 1 {
+			return nil, errors.Errorf("the elements of argument %s are not known", a.bindName())
+		}
+		element := a.attributes[0]
+		switch compositeKind(element.dataType) {
+		case "":
+			composite.ElementType = element.dataType
+		case CompositeRecord, CompositeObject:
+			element.name = a.bindName()
+			var err error
+			if composite.Element, err = element.compositeType(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Errorf("argument %s is a collection of collections, which cannot be passed as JSON", a.bindName())
+		}
+	}
+	return composite, nil
+}
+
+// jsonGetter returns the method of JSON_OBJECT_T and JSON_ARRAY_T which gets a value of the data type.
+func jsonGetter(dataType string) string {
+	switch {
+	case dataType == "PL/SQL BOOLEAN":
+		return "get_boolean"
+	case dataType == "DATE":
+		return "get_date"
+	case strings.HasPrefix(dataType, "TIMESTAMP"):
+		return "get_timestamp"
+	case dataType == "CLOB" || dataType == "NCLOB":
+		return "get_clob"
+	case dataType == "PLS_INTEGER" || dataType == "BINARY_INTEGER":
+		return "get_number"
+	}
+	switch convertFromSQLType(dataType, 0) {
+	case pub.PropertyType_INTEGER, pub.PropertyType_DECIMAL, pub.PropertyType_FLOAT:
+		return "get_number"
+	}
+	return "get_string"
+}
+
+// fromJSON returns the statements which build the variable from the JSON in the bind,
+// leaving it null if the bind is null.
+func (c *CompositeType) fromJSON(variable, bind string) string {
+	var statements []string
+	switch c.Kind {
+	case CompositeRecord, CompositeObject:
+		statements = append(statements, fmt.Sprintf("%s := JSON_OBJECT_T.parse(%s);", compositeObject, bind))
+		statements = append(statements, c.attributesFromJSON(variable)...)
+	default:
+		statements = append(statements, fmt.Sprintf("%s := JSON_ARRAY_T.parse(%s);", compositeArray, bind))
+		if c.Constructor != "" {
+			statements = append(statements, fmt.Sprintf("%s := %s();", variable, c.Constructor))
+		}
+
+		element := fmt.Sprintf("%s(%s + 1)", variable, compositeIndex)
+		var loop []string
+		if c.Constructor != "" {
+			loop = append(loop, fmt.Sprintf("%s.EXTEND;", variable))
+		}
+		if c.Element == nil {
+			loop = append(loop, fmt.Sprintf("%s := %s.%s(%s);", element, compositeArray, jsonGetter(c.ElementType), compositeIndex))
+		} else {
+			loop = append(loop, fmt.Sprintf("%s := TREAT(%s.get(%s) AS JSON_OBJECT_T);", compositeObject, compositeArray, compositeIndex))
+			loop = append(loop, c.Element.attributesFromJSON(element)...)
+		}
+		statements = append(statements, fmt.Sprintf("FOR %s IN 0 .. %s.get_size - 1 LOOP %s END LOOP;", compositeIndex, compositeArray, strings.Join(loop, " ")))
+	}
+	return fmt.Sprintf("IF %s IS NOT NULL THEN %s END IF;", bind, strings.Join(statements, " "))
+}
+
+// attributesFromJSON returns the statements which set the attributes of the record or
+// object from the fields of the JSON object in compositeObject. An object is constructed
+// with null attributes first.
+func (c *CompositeType) attributesFromJSON(variable string) []string {
+	var statements []string
+	if c.Constructor != "" {
+		nulls := make([]string, len(c.Attributes))
+		for i := range nulls {
+			nulls[i] = "NULL"
+		}
+		statements = append(statements, fmt.Sprintf("%s := %s(%s);", variable, c.Constructor, strings.Join(nulls, ", ")))
+	}
+	for _, a := range c.Attributes {
+		statements = append(statements, fmt.Sprintf("%s.%s := %s.%s('%s');", variable, a.Name, compositeObject, jsonGetter(a.DataType), a.Name))
+	}
+	return statements
+}
+
+// toJSON returns the statements which assign the variable to the bind as JSON.
+func (c *CompositeType) toJSON(variable, bind string) string {
+	var statements []string
+	switch c.Kind {
+	case CompositeRecord, CompositeObject:
+		statements = append(statements, c.attributesToJSON(variable)...)
+		statements = append(statements, fmt.Sprintf("%s := %s.to_string;", bind, compositeObject))
+	default:
+		element := fmt.Sprintf("%s(%s)", variable, compositeIndex)
+		var loop []string
+		if c.Element == nil {
+			loop = append(loop, fmt.Sprintf("%s.append(%s);", compositeArray, element))
+		} else {
+			loop = append(loop, c.Element.attributesToJSON(element)...)
+			loop = append(loop, fmt.Sprintf("%s.append(%s);", compositeArray, compositeObject))
+		}
+		loop = append(loop, fmt.Sprintf("%s := %s.NEXT(%s);", compositeIndex, variable, compositeIndex))
+
+		each := fmt.Sprintf("%s := %s.FIRST; WHILE %s IS NOT NULL LOOP %s END LOOP;", compositeIndex, variable, compositeIndex, strings.Join(loop, " "))
+		// a collection which has not been constructed has no elements to read
+		if c.Constructor != "" {
+			each = fmt.Sprintf("IF %s IS NOT NULL THEN %s END IF;", variable, each)
+		}
+		statements = append(statements, fmt.Sprintf("%s := JSON_ARRAY_T();", compositeArray), each)
+		statements = append(statements, fmt.Sprintf("%s := %s.to_string;", bind, compositeArray))
+	}
+	return strings.Join(statements, " ")
+}
+
+// attributesToJSON returns the statements which put the attributes of the
+// record or object into a new JSON object in compositeObject.
+func (c *CompositeType) attributesToJSON(variable string) []string {
+	statements := []string{fmt.Sprintf("%s := JSON_OBJECT_T();", compositeObject)}
+	for _, a := range c.Attributes {
+		statements = append(statements, fmt.Sprintf("%s.put('%s', %s.%s);", compositeObject, a.Name, variable, a.Name))
+	}
+	return statements
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"sync"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"gopkg.in/goracle.v2"
+)
+
+// keyProperties returns the key properties of the schema, which identify the row a record writes to.
+func keyProperties(schema *pub.Schema) []*pub.Property {
+	var keys []*pub.Property
+	for _, p := range schema.Properties {
+		if p.IsKey {
+			keys = append(keys, p)
+		}
+	}
+	return keys
+}
+
+// configureConcurrency sets the write concurrency and the key properties chosen in the form on
+// the write schema. Records can only be written concurrently if the schema has key properties,
+// which are the key columns of a table, so that the records for a row are written in order.
+func configureConcurrency(formData ConfigureWriteFormData, schema *pub.Schema) []string {
+	if len(formData.KeyProperties) > 0 {
+		if formData.Target == WriteTargetTable {
+			return []string{"the key properties of a table are its key columns, which must be chosen instead"}
+		}
+		var errs []string
+		for _, id := range formData.KeyProperties {
+			p := findProperty(schema, id)
+			if p == nil {
+				errs = append(errs, fmt.Sprintf("key property %q is not a property of %s", id, schema.Id))
+				continue
+			}
+			p.IsKey = true
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+
+	switch {
+	case formData.WriteConcurrency < 0:
+		return []string{"the write concurrency must not be negative"}
+	case formData.WriteConcurrency > 1:
+		if len(keyProperties(schema)) == 0 {
+			return []string{fmt.Sprintf("%s can only be written concurrently if its key properties are chosen", schema.Id)}
+		}
+		if err := updateWriteMeta(schema, func(write *WriteMeta) { write.Concurrency = formData.WriteConcurrency }); err != nil {
+			return []string{err.Error()}
+		}
+	}
+	return nil
+}
+
+// keyPartitioner returns the function which assigns a record to one of the partitions by the
+// values of its key properties, as they are bound, so that the records which write to the
+// same row are always written by the same partition. A record whose keys cannot be converted
+// is assigned to the first partition, and is rejected when it is bound.
+func keyPartitioner(schema *pub.Schema, partitions int) func(record *pub.Record) int {
+	keys := keyProperties(schema)
+
+	return func(record *pub.Record) int {
+		data, err := decodeRecordData(record)
+		if err != nil {
+			return 0
+		}
+
+		h := fnv.New32a()
+		for _, p := range keys {
+			value, err := writeValue(p, data[p.Id])
+			if err != nil {
+				return 0
+			}
+			// decimals keep the text they were sent as, such as 7.0 for 7
+			if n, ok := value.(goracle.Number); ok {
+				if r, ok := new(big.Rat).SetString(string(n)); ok {
+					value = r.RatString()
+				}
+			}
+			fmt.Fprintf(h, "%v\x00", value)
+		}
+		return int(h.Sum32() % uint32(partitions))
+	}
+}
+
+// writePartitions writes the records received concurrently in partitions, each of which writes
+// the records assigned to it in order with loop. The error which ended the stream is sent to
+// every partition. If a partition fails the others are cancelled, and the first error is returned.
+func writePartitions(ctx context.Context, received <-chan receivedRecord, partitions, size int, partition func(record *pub.Record) int, loop func(ctx context.Context, received <-chan receivedRecord) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// each partition buffers a batch, so that a partition which is writing
+	// does not hold up the records of the others
+	inputs := make([]chan receivedRecord, partitions)
+	wait := new(sync.WaitGroup)
+
+	// the partitions which are cancelled because another failed fail after it
+	var errMu sync.Mutex
+	var err error
+	for i := range inputs {
+		inputs[i] = make(chan receivedRecord, size)
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			if loopErr := loop(ctx, inputs[i]); loopErr != nil {
+				errMu.Lock()
+				if err == nil {
+					err = loopErr
+				}
+				errMu.Unlock()
+				cancel()
+			}
+		}(i)
+	}
+
+Receive:
+	for r := range received {
+		targets := inputs
+		if r.err == nil {
+			targets = inputs[partition(r.record):][:1]
+		}
+		for _, input := range targets {
+			select {
+			case input <- r:
+			case <-ctx.Done():
+				break Receive
+			}
+		}
+	}
+
+	wait.Wait()
+
+	return err
+}
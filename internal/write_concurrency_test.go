@@ -0,0 +1,221 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Concurrent write back", func() {
+
+	var (
+		sut    pub.PublisherServer
+		db     *fakeDB
+		schema *pub.Schema
+	)
+
+	record := func(id string, code string, commission int) *pub.Record {
+		return writeRecord(pub.Record_INSERT, id, fmt.Sprintf(`{"code":%q,"commission":%d}`, code, commission))
+	}
+
+	// useSchema writes to a table with the batch size and concurrency.
+	useSchema := func(batchSize, concurrency int) {
+		schema.PublisherMetaJson = fmt.Sprintf(`{"write":{"target":"Table","table":{
+			"table":"\"C##NAVEEGO\".\"AGENTS\"",
+			"columns":["AGENT_CODE","COMMISSION"],
+			"keyColumns":["AGENT_CODE"],
+			"batchSize":%d},
+			"concurrency":%d}}`, batchSize, concurrency)
+	}
+
+	BeforeEach(func() {
+		sut, db = newWriteServer(hclog.NewNullLogger())
+
+		schema = &pub.Schema{
+			Id: `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{
+				{Id: "code", Type: pub.PropertyType_STRING, IsKey: true},
+				{Id: "commission", Type: pub.PropertyType_INTEGER},
+			},
+		}
+	})
+
+	It("should assign the records for the same row to the same partition", func() {
+		Expect(KeyPartition(schema, 4, record("1", "A001", 1))).To(Equal(KeyPartition(schema, 4, record("2", "A001", 2))))
+
+		partitions := make(map[int]bool)
+		for i := 0; i < 20; i++ {
+			partition := KeyPartition(schema, 4, record("1", fmt.Sprintf("A%03d", i), 1))
+			Expect(partition).To(BeNumerically(">=", 0))
+			Expect(partition).To(BeNumerically("<", 4))
+			partitions[partition] = true
+		}
+		Expect(partitions).To(HaveLen(4), "the rows should be spread over every partition")
+	})
+
+	It("should assign keys to partitions by the values they are bound as", func() {
+		keys := &pub.Schema{Properties: []*pub.Property{
+			{Id: "id", Type: pub.PropertyType_INTEGER, IsKey: true},
+			{Id: "amount", Type: pub.PropertyType_DECIMAL, IsKey: true},
+		}}
+		partition := func(data string) int {
+			return KeyPartition(keys, 16, &pub.Record{Action: pub.Record_UPSERT, DataJson: data})
+		}
+
+		same := partition(`{"id":7,"amount":1.5}`)
+		Expect(partition(`{"id":7.0,"amount":1.50}`)).To(Equal(same))
+		Expect(partition(`{"id":"7","amount":"1.500"}`)).To(Equal(same))
+
+		var others []int
+		for i := 8; i < 24; i++ {
+			others = append(others, partition(fmt.Sprintf(`{"id":%d,"amount":1.5}`, i)))
+		}
+		Expect(others).To(ContainElement(Not(Equal(same))))
+	})
+
+	It("should write the records for the same row in the order they were sent", func() {
+		useSchema(3, 4)
+
+		var records []*pub.Record
+		for i := 0; i < 60; i++ {
+			records = append(records, record(fmt.Sprint(i), fmt.Sprintf("A%03d", i%6), i))
+		}
+
+		stream := &writeStream{records: records}
+		Expect(writeStreamBack(sut, schema, 60, stream)).To(Succeed())
+
+		Expect(stream.recordAcks).To(HaveLen(60))
+		acked := make(map[string]bool)
+		for _, ack := range stream.recordAcks {
+			Expect(ack.Error).To(BeEmpty())
+			acked[ack.CorrelationId] = true
+		}
+		Expect(acked).To(HaveLen(60))
+
+		last := make(map[interface{}]int)
+		rows := db.Committed()
+		Expect(rows).To(HaveLen(60))
+		for _, row := range rows {
+			// a single row is bound as int64, and an array as int
+			commission, err := strconv.Atoi(fmt.Sprint(row.values["p2"]))
+			Expect(err).ToNot(HaveOccurred())
+			if previous, ok := last[row.values["p1"]]; ok {
+				Expect(commission).To(BeNumerically(">", previous), "row %v was written out of order", row.values["p1"])
+			}
+			last[row.values["p1"]] = commission
+		}
+	})
+
+	It("should write the records for different rows at the same time", func() {
+		useSchema(1, 4)
+		db.delay = func(query string) time.Duration {
+			if strings.HasPrefix(query, "INSERT") {
+				return 300 * time.Millisecond
+			}
+			return 0
+		}
+
+		// one record for each partition
+		var records []*pub.Record
+		seen := make(map[int]bool)
+		for i := 0; len(records) < 4; i++ {
+			r := record(fmt.Sprint(i), fmt.Sprintf("A%03d", i), i)
+			if partition := KeyPartition(schema, 4, r); !seen[partition] {
+				seen[partition] = true
+				records = append(records, r)
+			}
+		}
+
+		start := time.Now()
+		stream := &writeStream{records: records}
+		Expect(writeStreamBack(sut, schema, 60, stream)).To(Succeed())
+
+		Expect(time.Since(start)).To(BeNumerically("<", 900*time.Millisecond))
+		Expect(stream.recordAcks).To(HaveLen(4))
+		Expect(db.Committed()).To(HaveLen(4))
+	})
+
+	It("should write a schema without key properties one batch at a time", func() {
+		useSchema(2, 4)
+		schema.Properties[0].IsKey = false
+
+		stream := &writeStream{records: []*pub.Record{
+			record("1", "A001", 1),
+			record("2", "A002", 2),
+			record("3", "A003", 3),
+		}}
+		Expect(writeStreamBack(sut, schema, 60, stream)).To(Succeed())
+
+		Expect(stream.recordAcks).To(HaveLen(3))
+		var ids []string
+		for _, ack := range stream.recordAcks {
+			ids = append(ids, ack.CorrelationId)
+		}
+		Expect(ids).To(Equal([]string{"1", "2", "3"}))
+	})
+
+	It("should stop every partition when an ack cannot be sent", func() {
+		useSchema(1, 4)
+
+		var records []*pub.Record
+		for i := 0; i < 20; i++ {
+			records = append(records, record(fmt.Sprint(i), fmt.Sprintf("A%03d", i), i))
+		}
+
+		stream := &unsendableStream{writeStream: &writeStream{records: records}}
+		Expect(writeStreamBack(sut, schema, 60, stream)).To(MatchError("stream closed"))
+		Expect(len(db.Committed())).To(BeNumerically("<", 20))
+	})
+
+	Describe("configuring a stored procedure", func() {
+
+		configure := func(options string) *pub.ConfigureWriteResponse {
+			response, err := sut.ConfigureWrite(context.Background(), &pub.ConfigureWriteRequest{
+				Form: &pub.ConfigurationFormRequest{DataJson: `{
+					"target":"Stored Procedure",
+					"storedProcedure":"Custom",
+					"customName":"\"C##NAVEEGO\".\"UPDATE_ORDER_STATUS\"",
+					"customParameters":[
+						{"paramName":"ORDER_ID","paramType":"NUMBER"},
+						{"paramName":"STATUS","paramType":"VARCHAR2"}
+					],` + options + `}`},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return response
+		}
+
+		It("should write concurrently by the key properties chosen", func() {
+			response := configure(`"writeConcurrency":4,"keyProperties":["ORDER_ID"]`)
+			Expect(response.Form.Errors).To(BeEmpty())
+			Expect(response.Schema.Properties[0].IsKey).To(BeTrue())
+			Expect(response.Schema.Properties[1].IsKey).To(BeFalse())
+			Expect(response.Schema.PublisherMetaJson).To(ContainSubstring(`"concurrency":4`))
+		})
+
+		It("should reject concurrency without key properties", func() {
+			response := configure(`"writeConcurrency":4`)
+			Expect(response.Form.Errors).To(ConsistOf(`"C##NAVEEGO"."UPDATE_ORDER_STATUS" can only be written concurrently if its key properties are chosen`))
+		})
+
+		It("should reject key properties which are not properties", func() {
+			response := configure(`"writeConcurrency":4,"keyProperties":["ORDER_NO"]`)
+			Expect(response.Form.Errors).To(ConsistOf(`key property "ORDER_NO" is not a property of "C##NAVEEGO"."UPDATE_ORDER_STATUS"`))
+		})
+	})
+})
+
+// unsendableStream is a write stream which fails to send acks.
+type unsendableStream struct {
+	*writeStream
+}
+
+func (unsendableStream) Send(*pub.RecordAck) error { return errors.New("stream closed") }
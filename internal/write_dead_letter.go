@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// The statuses of a dead letter. Operators set the status of a dead letter
+// to REPLAY, after fixing its payload if needed, to have it written again
+// when the dead letters of its schema are replayed.
+const (
+	deadLetterFailed   = "FAILED"
+	deadLetterReplay   = "REPLAY"
+	deadLetterReplayed = "REPLAYED"
+)
+
+// maxDeadLetterMessage is the size of the ERROR_MESSAGE column of a dead-letter table.
+const maxDeadLetterMessage = 4000
+
+// deadLetterDDL returns the statements which create a dead-letter table.
+func deadLetterDDL(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE %s
+(
+  "DEAD_LETTER_ID" NUMBER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "SCHEMA_ID" VARCHAR2(4000) NOT NULL,
+  "CORRELATION_ID" VARCHAR2(4000),
+  "ACTION" VARCHAR2(10) NOT NULL,
+  "DATA_JSON" CLOB,
+  "ERROR_CODE" NUMBER(5),
+  "ERROR_MESSAGE" VARCHAR2(%d),
+  "FAILED_AT" TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL,
+  "STATUS" VARCHAR2(10) DEFAULT '%s' NOT NULL CHECK ("STATUS" IN ('%[3]s', '%s', '%s')),
+  "REPLAYED_AT" TIMESTAMP WITH TIME ZONE
+)`, table, maxDeadLetterMessage, deadLetterFailed, deadLetterReplay, deadLetterReplayed),
+		fmt.Sprintf(`COMMENT ON TABLE %s IS 'Naveego write-back dead letters. Set STATUS to %s to write a record again.'`, table, deadLetterReplay),
+	}
+}
+
+// configureDeadLetter attaches the dead-letter table chosen in the form to the write schema,
+// creating the table if it does not exist and the form asks for it to be created.
+func (s *Server) configureDeadLetter(formData ConfigureWriteFormData, schema *pub.Schema) []string {
+	if formData.DeadLetterTable == "" {
+		return nil
+	}
+
+	table, err := s.ensureWriteTable(formData.DeadLetterTable, formData.CreateDeadLetterTable, deadLetterDDL)
+	if err != nil {
+		return []string{fmt.Sprintf("dead-letter table: %s", err)}
+	}
+
+	if err := updateWriteMeta(schema, func(write *WriteMeta) { write.DeadLetter = &DeadLetterMeta{Table: table} }); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// ensureWriteTable returns the qualified name of a table used by write-back, such as a
+// dead-letter table. If the table does not exist it is created with the DDL if create is set.
+func (s *Server) ensureWriteTable(safeName string, create bool, ddl func(table string) []string) (string, error) {
+	owner, name := decomposeSafeName(safeName)
+	if owner == "" || name == "" {
+		return "", errors.Errorf("%q is not a qualified table name", safeName)
+	}
+	table := fmt.Sprintf(`"%s"."%s"`, owner, name)
+
+	var count int
+	row := s.db.QueryRow(`SELECT COUNT(*) FROM ALL_TABLES WHERE OWNER = :1 AND TABLE_NAME = :2`, owner, name)
+	if err := row.Scan(&count); err != nil {
+		return "", errors.Errorf("could not check whether %s exists: %s", table, err)
+	}
+	if count > 0 {
+		return table, nil
+	}
+
+	if !create {
+		return "", errors.Errorf("%s does not exist", table)
+	}
+	for _, statement := range ddl(table) {
+		if _, err := s.db.Exec(statement); err != nil {
+			return "", errors.Errorf("could not create %s: %s", table, err)
+		}
+	}
+	s.log.Info("Created table for write-back.", "table", table)
+
+	return table, nil
+}
+
+// updateWriteMeta changes the write metadata of the schema.
+func updateWriteMeta(schema *pub.Schema, update func(write *WriteMeta)) error {
+	meta, err := getSchemaMeta(schema)
+	if err != nil {
+		return err
+	}
+	if meta.Write == nil {
+		meta.Write = &WriteMeta{Target: WriteTargetStoredProcedure}
+	}
+	update(meta.Write)
+	return setSchemaMeta(schema, meta)
+}
+
+// deadLetter inserts the rejected records into the dead-letter table, with the errors in their acks.
+// The records stay rejected if they cannot be inserted, so the failure is only logged.
+func (s *Server) deadLetter(ctx context.Context, schema *pub.Schema, target DeadLetterMeta, batch []*pub.Record, acks []*pub.RecordAck, rejected []bool) {
+	query := fmt.Sprintf(`INSERT INTO %s ("SCHEMA_ID", "CORRELATION_ID", "ACTION", "DATA_JSON", "ERROR_CODE", "ERROR_MESSAGE") VALUES (:schema_id, :correlation_id, :action, :data_json, :error_code, :error_message)`, target.Table)
+
+	err := func() error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer tx.Rollback()
+
+		for i, record := range batch {
+			if !rejected[i] {
+				continue
+			}
+			data, _ := clobValue(record.DataJson, true)
+			_, err = tx.ExecContext(ctx, query,
+				sql.Named("schema_id", schema.Id),
+				sql.Named("correlation_id", record.CorrelationId),
+				sql.Named("action", record.Action.String()),
+				sql.Named("data_json", data),
+				sql.Named("error_code", deadLetterCode(acks[i].Error)),
+				sql.Named("error_message", truncateMessage(acks[i].Error)))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return errors.WithStack(tx.Commit())
+	}()
+
+	if err != nil {
+		s.log.Error("Could not insert rejected records into the dead-letter table.", "table", target.Table, "error", err)
+	}
+}
+
+// deadLetterRecord is a dead letter which is being replayed.
+type deadLetterRecord struct {
+	id     int64
+	record *pub.Record
+}
+
+// ReplayDeadLetters writes the dead letters of the write schema which are marked for replay,
+// as its write stream would, but outside of one, so that they are not mixed with live records.
+func (s *Server) ReplayDeadLetters(ctx context.Context, schema *pub.Schema) error {
+	if !s.connected {
+		return errors.New("not connected")
+	}
+
+	meta, err := getSchemaMeta(schema)
+	if err != nil {
+		return err
+	}
+	write := meta.Write
+	if write == nil || write.DeadLetter == nil {
+		return errors.Errorf("%s does not have a dead-letter table", schema.Id)
+	}
+	if write.DryRun {
+		return errors.Errorf("%s is written back as a dry run, which does not replay dead letters", schema.Id)
+	}
+
+	// the writers read the settings of the write
+	previous := s.WriteSettings
+	s.WriteSettings = &WriteSettings{Schema: schema, Idempotency: write.Idempotency}
+	defer func() { s.WriteSettings = previous }()
+
+	var size int
+	var w batchWriter
+	switch write.Target {
+	case WriteTargetQueue:
+		size, w = write.Queue.BatchSize, queueWriter(schema, *write.Queue)
+	case WriteTargetTable:
+		// a bulk load replays its dead letters to the table, without staging them
+		target := *write.Table
+		size = target.BatchSize
+		if w, err = s.tableWriter(schema, &target); err != nil {
+			return err
+		}
+	default:
+		target := StoredProcedureWriteMeta{BatchSize: defaultWriteBatchSize}
+		if write.StoredProcedure != nil {
+			target = *write.StoredProcedure
+		}
+		size, w = target.BatchSize, s.procedureWriter(schema, target)
+	}
+
+	return s.replayDeadLetters(ctx, schema, size, w, *write.DeadLetter)
+}
+
+// replayDeadLetters writes the dead letters of the schema which are marked for replay, in
+// batches of up to size records, and records the outcome of each in the dead-letter table.
+// A dead letter which is rejected again is marked as failed with its new error. One which
+// fails with a transient error stays marked for replay.
+func (s *Server) replayDeadLetters(ctx context.Context, schema *pub.Schema, size int, w batchWriter, target DeadLetterMeta) error {
+	letters, err := s.getReplayDeadLetters(ctx, schema, target)
+	if err != nil {
+		return err
+	}
+	if len(letters) == 0 {
+		return nil
+	}
+
+	s.log.Info("Replaying dead letters.", "schema", schema.Id, "table", target.Table, "records", len(letters))
+
+	// the host is not waiting for the acks of replayed records, so their outputs are logged
+	if w.outputs != nil {
+		w.outputs = func(ack *pub.RecordAck, values map[string]interface{}) {
+			s.log.Info("Replayed dead letter.", "correlationId", ack.CorrelationId, "outputs", values)
+		}
+	}
+
+	if size < 1 {
+		size = defaultWriteBatchSize
+	}
+	for start := 0; start < len(letters); start += size {
+		end := start + size
+		if end > len(letters) {
+			end = len(letters)
+		}
+
+		batch := make([]*pub.Record, 0, end-start)
+		for _, l := range letters[start:end] {
+			batch = append(batch, l.record)
+		}
+
+		acks, rejected := s.writeBatch(ctx, schema, batch, w, time.Now())
+		if err := s.markReplayed(ctx, target, letters[start:end], acks, rejected); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getReplayDeadLetters returns the dead letters of the schema which are marked for replay, oldest first.
+func (s *Server) getReplayDeadLetters(ctx context.Context, schema *pub.Schema, target DeadLetterMeta) ([]deadLetterRecord, error) {
+	query := fmt.Sprintf(`SELECT "DEAD_LETTER_ID", "CORRELATION_ID", "ACTION", "DATA_JSON" FROM %s WHERE "SCHEMA_ID" = :schema_id AND "STATUS" = '%s' ORDER BY "DEAD_LETTER_ID"`, target.Table, deadLetterReplay)
+
+	rows, err := s.db.QueryContext(ctx, query, sql.Named("schema_id", schema.Id))
+	if err != nil {
+		return nil, errors.Errorf("could not read dead letters from %s: %s", target.Table, err)
+	}
+	defer rows.Close()
+
+	var letters []deadLetterRecord
+	for rows.Next() {
+		var (
+			id                          int64
+			correlationID, action, data sql.NullString
+		)
+		if err := rows.Scan(&id, &correlationID, &action, &data); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		letters = append(letters, deadLetterRecord{
+			id: id,
+			record: &pub.Record{
+				Action:        pub.Record_Action(pub.Record_Action_value[action.String]),
+				CorrelationId: correlationID.String,
+				DataJson:      data.String,
+			},
+		})
+	}
+
+	return letters, errors.WithStack(rows.Err())
+}
+
+// markReplayed records the outcome of writing the dead letters again.
+func (s *Server) markReplayed(ctx context.Context, target DeadLetterMeta, letters []deadLetterRecord, acks []*pub.RecordAck, rejected []bool) error {
+	replayed := fmt.Sprintf(`UPDATE %s SET "STATUS" = '%s', "REPLAYED_AT" = SYSTIMESTAMP WHERE "DEAD_LETTER_ID" = :id`, target.Table, deadLetterReplayed)
+	failed := fmt.Sprintf(`UPDATE %s SET "STATUS" = '%s', "ERROR_CODE" = :error_code, "ERROR_MESSAGE" = :error_message, "FAILED_AT" = SYSTIMESTAMP WHERE "DEAD_LETTER_ID" = :id`, target.Table, deadLetterFailed)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback()
+
+	for i, l := range letters {
+		var err error
+		switch {
+		case rejected[i]:
+			s.log.Warn("Dead letter was rejected again.", "correlationId", l.record.CorrelationId, "error", acks[i].Error)
+			_, err = tx.ExecContext(ctx, failed,
+				sql.Named("error_code", deadLetterCode(acks[i].Error)),
+				sql.Named("error_message", truncateMessage(acks[i].Error)),
+				sql.Named("id", l.id))
+		case acks[i].Error != "":
+			s.log.Warn("Dead letter could not be replayed, it will be replayed again.", "correlationId", l.record.CorrelationId, "error", acks[i].Error)
+		default:
+			_, err = tx.ExecContext(ctx, replayed, sql.Named("id", l.id))
+		}
+		if err != nil {
+			return errors.Errorf("could not record the outcome of replaying dead letter %d: %s", l.id, err)
+		}
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+// deadLetterCode returns the ORA code in the error message of an ack, or nil if there is none.
+func deadLetterCode(message string) interface{} {
+	if code := oraErrorCode(errors.New(message)); code != 0 {
+		return int64(code)
+	}
+	return nil
+}
+
+// truncateMessage truncates an error message to fit the ERROR_MESSAGE column of a dead-letter table.
+func truncateMessage(message string) string {
+	if len(message) <= maxDeadLetterMessage {
+		return message
+	}
+	message = message[:maxDeadLetterMessage]
+	for !utf8.ValidString(message) {
+		message = message[:len(message)-1]
+	}
+	return message
+}
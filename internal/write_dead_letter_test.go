@@ -0,0 +1,149 @@
+package internal_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dead letters", func() {
+
+	const deadLetters = `"C##NAVEEGO"."DEAD_LETTERS"`
+
+	var (
+		sut    pub.PublisherServer
+		db     *fakeDB
+		schema *pub.Schema
+	)
+
+	// rowsFor returns the values of the committed rows written by statements with the prefix.
+	rowsFor := func(prefix string) []map[string]interface{} {
+		var rows []map[string]interface{}
+		for _, row := range db.CommittedBy(prefix) {
+			rows = append(rows, row.values)
+		}
+		return rows
+	}
+
+	BeforeEach(func() {
+		sut, db = newWriteServer(hclog.NewNullLogger())
+
+		schema = &pub.Schema{
+			Id: `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{
+				{Id: "code", Type: pub.PropertyType_STRING},
+				{Id: "name", Type: pub.PropertyType_STRING},
+			},
+			PublisherMetaJson: `{"write":{"target":"Table","table":{
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":["AGENT_CODE","AGENT_NAME"],
+				"keyColumns":["AGENT_CODE"],
+				"batchSize":10},
+				"deadLetter":{"table":"\"C##NAVEEGO\".\"DEAD_LETTERS\""}}}`,
+		}
+		db.fail = func(query string, row map[string]interface{}) error {
+			if row["p1"] == "BAD" {
+				return errors.New("ORA-00001: unique constraint violated")
+			}
+			return nil
+		}
+	})
+
+	It("should generate the DDL of a dead-letter table", func() {
+		expectGolden("deadletter/create.sql", DeadLetterDDL(deadLetters))
+	})
+
+	It("should insert rejected records into the dead-letter table and still reject them", func() {
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"BAD","name":"Bad"}`),
+			writeRecord(pub.Record_UPDATE, "3", `not json`),
+		)
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(Equal("could not write back: ORA-00001: unique constraint violated"))
+		Expect(acks[2].Error).To(HavePrefix("could not convert record"))
+
+		letters := rowsFor("INSERT INTO " + deadLetters)
+		Expect(letters).To(HaveLen(2))
+		Expect(letters[0]).To(Equal(map[string]interface{}{
+			"schema_id":      schema.Id,
+			"correlation_id": "2",
+			"action":         "INSERT",
+			"data_json":      `{"code":"BAD","name":"Bad"}`,
+			"error_code":     int64(1),
+			"error_message":  acks[1].Error,
+		}))
+		Expect(letters[1]).To(HaveKeyWithValue("action", "UPDATE"))
+		Expect(letters[1]).To(HaveKeyWithValue("data_json", "not json"))
+		Expect(letters[1]["error_code"]).To(BeNil())
+	})
+
+	It("should not dead-letter records which failed with a transient error", func() {
+		UseSettings(sut, &Settings{Form: &SettingsForm{WriteRetryAttempts: 1}, Strategy: StrategyForm})
+		db.fail = func(query string, row map[string]interface{}) error {
+			return errors.New("ORA-00060: deadlock detected while waiting for resource")
+		}
+
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`))
+
+		Expect(acks[0].Error).To(HaveSuffix("ORA-00060: deadlock detected while waiting for resource"))
+		Expect(rowsFor("INSERT INTO " + deadLetters)).To(BeEmpty())
+	})
+
+	It("should replay the dead letters marked for replay", func() {
+		var replayQuery string
+		db.query = func(query string, args map[string]interface{}) ([]string, [][]driver.Value) {
+			replayQuery = query
+			Expect(args).To(HaveKeyWithValue("schema_id", schema.Id))
+			return []string{"DEAD_LETTER_ID", "CORRELATION_ID", "ACTION", "DATA_JSON"}, [][]driver.Value{
+				{int64(7), "2", "INSERT", `{"code":"A002"}`},
+				{int64(8), "3", "INSERT", `{"code":"BAD"}`},
+			}
+		}
+
+		Expect(sut.(*Server).ReplayDeadLetters(context.Background(), schema)).To(Succeed())
+
+		Expect(replayQuery).To(ContainSubstring(`FROM ` + deadLetters + ` WHERE "SCHEMA_ID" = :schema_id AND "STATUS" = 'REPLAY'`))
+
+		var codes []interface{}
+		for _, row := range rowsFor("INSERT INTO " + schema.Id) {
+			codes = append(codes, row["p1"])
+		}
+		Expect(codes).To(Equal([]interface{}{"A002"}))
+
+		updates := rowsFor("UPDATE " + deadLetters)
+		Expect(updates).To(Equal([]map[string]interface{}{
+			{"id": int64(7)},
+			{"error_code": int64(1), "error_message": "could not write back: ORA-00001: unique constraint violated", "id": int64(8)},
+		}))
+		Expect(rowsFor("INSERT INTO " + deadLetters)).To(BeEmpty())
+	})
+
+	It("should not replay dead letters while writing the stream", func() {
+		var queried bool
+		db.query = func(query string, args map[string]interface{}) ([]string, [][]driver.Value) {
+			queried = true
+			return nil, nil
+		}
+
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "4", `{"code":"A004"}`))
+
+		Expect(acks).To(HaveLen(1))
+		Expect(queried).To(BeFalse())
+	})
+
+	It("should not replay the dead letters of a schema without a dead-letter table", func() {
+		schema.PublisherMetaJson = strings.Replace(schema.PublisherMetaJson, `,
+				"deadLetter":{"table":"\"C##NAVEEGO\".\"DEAD_LETTERS\""}`, "", 1)
+
+		Expect(sut.(*Server).ReplayDeadLetters(context.Background(), schema)).To(MatchError(`"C##NAVEEGO"."AGENTS" does not have a dead-letter table`))
+	})
+})
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// conversionFailure prefixes the ack error of a record which could not be converted to binds.
+const conversionFailure = "could not convert record"
+
+// dryRunProcedureWarning is logged when a dry run calls a stored procedure.
+const dryRunProcedureWarning = "A dry run cannot roll back what a stored procedure commits itself, writes in an autonomous transaction or sends out of the database."
+
+// dryRunSummary counts the outcomes of the records written by a dry run, so that
+// the reasons records would be rejected can be reviewed without reading every ack.
+type dryRunSummary struct {
+	// mu guards the counts, which are added to by concurrent partitions.
+	mu        sync.Mutex
+	records   int
+	succeeded int
+	// rejected counts the rejected records by kind: conversion for values which could not be
+	// converted to the types of their properties, or the kind of the Oracle error.
+	rejected map[string]int
+	// codes counts the rejected records by the ORA code of their errors.
+	codes map[int]int
+	// failed counts the records which failed with the rest of their batch.
+	failed int
+}
+
+func newDryRunSummary() *dryRunSummary {
+	return &dryRunSummary{rejected: make(map[string]int), codes: make(map[int]int)}
+}
+
+// add counts the outcomes of a batch.
+func (d *dryRunSummary) add(acks []*pub.RecordAck, rejected []bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, ack := range acks {
+		d.records++
+		switch {
+		case rejected[i] && strings.HasPrefix(ack.Error, conversionFailure):
+			d.rejected["conversion"]++
+		case rejected[i]:
+			err := errors.New(ack.Error)
+			d.rejected[oraFailureKind(err)]++
+			if code := oraErrorCode(err); code != 0 {
+				d.codes[code]++
+			}
+		case ack.Error != "" && !isOutputEnvelope(ack.Error):
+			d.failed++
+		default:
+			d.succeeded++
+		}
+	}
+}
+
+// log logs the summary.
+func (d *dryRunSummary) log(log hclog.Logger, schema *pub.Schema) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	log.Info("Dry run of write back finished, every transaction was rolled back.",
+		"schema", schema.Id,
+		"records", d.records,
+		"succeeded", d.succeeded,
+		"conversionFailures", d.rejected["conversion"],
+		"constraintFailures", d.rejected[failureConstraint],
+		"valueFailures", d.rejected[failureValue],
+		"otherFailures", d.rejected[failureOther],
+		"batchFailures", d.failed,
+		"failuresByCode", d.codes)
+}
@@ -0,0 +1,90 @@
+package internal_test
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dry run write back", func() {
+
+	var (
+		sut    pub.PublisherServer
+		db     *fakeDB
+		logs   *bytes.Buffer
+		schema *pub.Schema
+	)
+
+	BeforeEach(func() {
+		logs = new(bytes.Buffer)
+		sut, db = newWriteServer(hclog.New(&hclog.LoggerOptions{Output: logs, Level: hclog.Info}))
+
+		schema = &pub.Schema{
+			Id: `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{
+				{Id: "code", Type: pub.PropertyType_STRING},
+				{Id: "commission", Type: pub.PropertyType_FLOAT},
+			},
+			PublisherMetaJson: `{"write":{"target":"Table","table":{
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":["AGENT_CODE","COMMISSION"],
+				"keyColumns":["AGENT_CODE"],
+				"batchSize":2},
+				"deadLetter":{"table":"\"C##NAVEEGO\".\"DEAD_LETTERS\""},
+				"dryRun":true}}`,
+		}
+		db.fail = func(query string, row map[string]interface{}) error {
+			switch row["p1"] {
+			case "NULL":
+				return errors.New("ORA-01400: cannot insert NULL into (\"C##NAVEEGO\".\"AGENTS\".\"AGENT_NAME\")")
+			case "BIG":
+				return errors.New("ORA-01438: value larger than specified precision allowed for this column")
+			}
+			return nil
+		}
+	})
+
+	It("should acknowledge what would have been written without committing anything", func() {
+		acks := writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001","commission":0.1}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"NULL"}`),
+			writeRecord(pub.Record_INSERT, "3", `{"code":"A003","commission":"high"}`),
+		)
+
+		Expect(acks).To(HaveLen(3))
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(HavePrefix("could not write back: ORA-01400"))
+		Expect(acks[2].Error).To(HavePrefix("could not convert record"))
+		Expect(db.Executions()).ToNot(BeEmpty())
+		Expect(db.Committed()).To(BeEmpty())
+	})
+
+	It("should log a summary of the failures", func() {
+		writeBack(sut, schema,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"NULL"}`),
+			writeRecord(pub.Record_INSERT, "3", `{"code":"BIG","commission":1000}`),
+			writeRecord(pub.Record_INSERT, "4", `{"code":"A004","commission":"high"}`),
+			writeRecord(pub.Record_INSERT, "5", `{"code":"NULL"}`),
+		)
+
+		Expect(logs.String()).To(ContainSubstring("Dry run of write back finished"))
+		Expect(logs.String()).To(ContainSubstring("records=5 succeeded=1 conversionFailures=1 constraintFailures=2 valueFailures=1 otherFailures=0 batchFailures=0 failuresByCode=\"map[1400:2 1438:1]\""))
+	})
+	It("should warn that what a stored procedure does outside the transaction is not rolled back", func() {
+		schema.Id = `"C##NAVEEGO"."UPDATE_AGENT"`
+		schema.Query = `BEGIN "C##NAVEEGO"."UPDATE_AGENT"(:code, :commission); END;`
+		schema.PublisherMetaJson = `{"write":{"target":"Stored Procedure","storedProcedure":{"batchSize":2},"dryRun":true}}`
+
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001","commission":0.1}`))
+
+		Expect(acks).To(HaveLen(1))
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(db.Committed()).To(BeEmpty())
+		Expect(logs.String()).To(ContainSubstring(`A dry run cannot roll back what a stored procedure commits itself, writes in an autonomous transaction or sends out of the database.: procedure="C##NAVEEGO"."UPDATE_AGENT"`))
+	})
+})
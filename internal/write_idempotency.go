@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// defaultIdempotencyRetentionDays is how long correlation IDs are tracked for when it is not set.
+const defaultIdempotencyRetentionDays = 30
+
+// oraUniqueConstraint is the error raised when a row would duplicate a unique key.
+const oraUniqueConstraint = 1
+
+// idempotencyDDL returns the statements which create a table for tracking the correlation IDs
+// of the records which have been written. It is index organized by its key, which is all it is
+// queried by.
+func idempotencyDDL(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE %s
+(
+  "SCHEMA_ID" VARCHAR2(1000) NOT NULL,
+  "CORRELATION_ID" VARCHAR2(1000) NOT NULL,
+  "PROCESSED_AT" TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL,
+  PRIMARY KEY ("SCHEMA_ID", "CORRELATION_ID")
+) ORGANIZATION INDEX`, table),
+		fmt.Sprintf(`COMMENT ON TABLE %s IS 'Naveego write-back correlation IDs. A record with a correlation ID in this table is not written again.'`, table),
+	}
+}
+
+// configureIdempotency attaches the tracking table chosen in the form to the write schema,
+// creating the table if it does not exist and the form asks for it to be created.
+func (s *Server) configureIdempotency(formData ConfigureWriteFormData, schema *pub.Schema) []string {
+	if formData.IdempotencyTable == "" {
+		return nil
+	}
+
+	target := IdempotencyMeta{RetentionDays: formData.IdempotencyRetentionDays}
+	if target.RetentionDays == 0 {
+		target.RetentionDays = defaultIdempotencyRetentionDays
+	}
+	if target.RetentionDays < 0 {
+		return []string{"the idempotency retention must not be negative"}
+	}
+
+	var err error
+	target.Table, err = s.ensureWriteTable(formData.IdempotencyTable, formData.CreateIdempotencyTable, idempotencyDDL)
+	if err != nil {
+		return []string{fmt.Sprintf("idempotency table: %s", err)}
+	}
+
+	if err := updateWriteMeta(schema, func(write *WriteMeta) { write.Idempotency = &target }); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// correlationTracker tracks the correlation IDs of the records written to a schema, so that
+// records which are sent again by the host are not written twice.
+type correlationTracker struct {
+	schemaID string
+	insert   string
+	delete   string
+}
+
+func newCorrelationTracker(schema *pub.Schema, target IdempotencyMeta) *correlationTracker {
+	return &correlationTracker{
+		schemaID: schema.Id,
+		insert:   fmt.Sprintf(`INSERT INTO %s ("SCHEMA_ID", "CORRELATION_ID") VALUES (:schema_id, :correlation_id)`, target.Table),
+		delete:   fmt.Sprintf(`DELETE FROM %s WHERE "SCHEMA_ID" = :schema_id AND "CORRELATION_ID" = :correlation_id`, target.Table),
+	}
+}
+
+// bind returns the statements which track or untrack the correlation IDs of the records, and the
+// indexes of the records they are for. Records without correlation IDs are not tracked.
+func (t *correlationTracker) bind(query string, records []boundRecord) ([]boundRecord, []int) {
+	var tracking []boundRecord
+	var indexes []int
+	for i, r := range records {
+		if r.ack.CorrelationId == "" {
+			continue
+		}
+		tracking = append(tracking, boundRecord{
+			ack:   &pub.RecordAck{CorrelationId: r.ack.CorrelationId},
+			query: query,
+			args:  []interface{}{sql.Named("schema_id", t.schemaID), sql.Named("correlation_id", r.ack.CorrelationId)},
+		})
+		indexes = append(indexes, i)
+	}
+	return tracking, indexes
+}
+
+// track inserts the correlation IDs of the records in the transaction, and returns the records
+// which should be written. A record whose correlation ID has already been inserted, whether by
+// an earlier transaction or by an earlier record in the batch, is skipped. Because the rows are
+// inserted before the records are written, a concurrent write of the same record waits for
+// this transaction, and is then skipped if it commits.
+func (t *correlationTracker) track(ctx context.Context, tx *sql.Tx, bound []boundRecord) ([]boundRecord, error) {
+	tracking, indexes := t.bind(t.insert, bound)
+	if len(tracking) > 0 {
+		if err := execRun(ctx, tx, tracking, "could not track correlation ID"); err != nil {
+			return nil, err
+		}
+	}
+
+	for j, i := range indexes {
+		switch err := tracking[j].err; {
+		case err == nil:
+		case oraErrorCode(err) == oraUniqueConstraint:
+			bound[i].skipped = true
+		default:
+			bound[i].ack.Error = tracking[j].ack.Error
+		}
+	}
+
+	var write []boundRecord
+	for _, r := range bound {
+		if !r.skipped && r.ack.Error == "" {
+			write = append(write, r)
+		}
+	}
+	return write, nil
+}
+
+// untrack deletes the correlation IDs of the records which could not be written, so that they
+// are written if the host sends them again.
+func (t *correlationTracker) untrack(ctx context.Context, tx *sql.Tx, written []boundRecord) error {
+	var failed []boundRecord
+	for _, r := range written {
+		if r.ack.Error != "" {
+			failed = append(failed, r)
+		}
+	}
+
+	tracking, _ := t.bind(t.delete, failed)
+	if len(tracking) == 0 {
+		return nil
+	}
+	if err := execRun(ctx, tx, tracking, "could not untrack correlation ID"); err != nil {
+		return err
+	}
+	for _, r := range tracking {
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// purgeCorrelations deletes the correlation IDs of the schema which are older than the retention.
+func (s *Server) purgeCorrelations(ctx context.Context, schema *pub.Schema, target IdempotencyMeta) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE "SCHEMA_ID" = :schema_id AND "PROCESSED_AT" < SYSTIMESTAMP - NUMTODSINTERVAL(:days, 'DAY')`, target.Table)
+
+	result, err := s.db.ExecContext(ctx, query, sql.Named("schema_id", schema.Id), sql.Named("days", target.RetentionDays))
+	if err != nil {
+		return errors.Errorf("could not purge correlation IDs from %s: %s", target.Table, err)
+	}
+	if purged, err := result.RowsAffected(); err == nil && purged > 0 {
+		s.log.Info("Purged correlation IDs.", "schema", schema.Id, "table", target.Table, "rows", purged)
+	}
+	return nil
+}
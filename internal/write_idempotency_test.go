@@ -0,0 +1,106 @@
+package internal_test
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Idempotent write back", func() {
+
+	const tracking = `"C##NAVEEGO"."WRITTEN"`
+
+	var (
+		sut    pub.PublisherServer
+		db     *fakeDB
+		schema *pub.Schema
+	)
+
+	// committedValues returns the values of a bind in the committed rows written by statements with the prefix.
+	committedValues := func(prefix, name string) []interface{} {
+		var values []interface{}
+		for _, row := range db.CommittedBy(prefix) {
+			values = append(values, row.values[name])
+		}
+		return values
+	}
+
+	BeforeEach(func() {
+		sut, db = newWriteServer(hclog.NewNullLogger())
+
+		schema = &pub.Schema{
+			Id:         `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{{Id: "code", Type: pub.PropertyType_STRING}},
+			PublisherMetaJson: `{"write":{"target":"Table","table":{
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":["AGENT_CODE"],
+				"keyColumns":["AGENT_CODE"],
+				"batchSize":10},
+				"idempotency":{"table":"\"C##NAVEEGO\".\"WRITTEN\"","retentionDays":30}}}`,
+		}
+
+		// the primary key of the tracking table, which is checked while the database is locked
+		db.fail = func(query string, row map[string]interface{}) error {
+			if !strings.HasPrefix(query, "INSERT INTO "+tracking) {
+				if row["p1"] == "BAD" {
+					return errors.New("ORA-02290: check constraint violated")
+				}
+				return nil
+			}
+			for _, r := range append(db.committed, db.pending...) {
+				if r.query == query && r.values["correlation_id"] == row["correlation_id"] {
+					return errors.New("ORA-00001: unique constraint violated")
+				}
+			}
+			return nil
+		}
+	})
+
+	It("should generate the DDL of a tracking table", func() {
+		expectGolden("idempotency/create.sql", IdempotencyDDL(tracking))
+	})
+
+	It("should track the correlation ID of each record in the transaction which writes it", func() {
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`), writeRecord(pub.Record_INSERT, "2", `{"code":"A002"}`))
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(BeEmpty())
+		Expect(committedValues("INSERT INTO "+tracking, "correlation_id")).To(Equal([]interface{}{"1", "2"}))
+		Expect(committedValues("INSERT INTO "+tracking, "schema_id")).To(Equal([]interface{}{schema.Id, schema.Id}))
+		Expect(committedValues("INSERT INTO "+schema.Id, "p1")).To(Equal([]interface{}{"A001", "A002"}))
+	})
+
+	It("should acknowledge records which have already been written without writing them again", func() {
+		writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`))
+
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`), writeRecord(pub.Record_INSERT, "2", `{"code":"A002"}`), writeRecord(pub.Record_INSERT, "2", `{"code":"A002"}`))
+
+		Expect(acks).To(HaveLen(3))
+		for _, ack := range acks {
+			Expect(ack.Error).To(BeEmpty())
+		}
+		Expect(committedValues("INSERT INTO "+schema.Id, "p1")).To(Equal([]interface{}{"A001", "A002"}))
+	})
+
+	It("should stop tracking records which could not be written", func() {
+		acks := writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`), writeRecord(pub.Record_INSERT, "2", `{"code":"BAD"}`))
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(Equal("could not write back: ORA-02290: check constraint violated"))
+		Expect(committedValues("DELETE FROM "+tracking+` WHERE "SCHEMA_ID" = :schema_id AND "CORRELATION_ID"`, "correlation_id")).To(Equal([]interface{}{"2"}))
+	})
+
+	It("should purge correlation IDs older than the retention before writing", func() {
+		writeBack(sut, schema, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`))
+
+		executions := db.Executions()
+		Expect(executions[0].query).To(HavePrefix("DELETE FROM " + tracking))
+		Expect(executions[0].query).To(ContainSubstring(`"PROCESSED_AT" < SYSTIMESTAMP - NUMTODSINTERVAL(:days, 'DAY')`))
+		Expect(db.Committed()[0].values).To(HaveKeyWithValue("days", 30))
+	})
+})
@@ -0,0 +1,688 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// returnValueParameter is the bind which captures the result of a function.
+const returnValueParameter = "RETURN_VALUE"
+
+// storedProcedure is a standalone or packaged procedure or function which can be written to.
+type storedProcedure struct {
+	owner, pkg, name string
+	// overload is the overload of a packaged procedure, which is empty if it is not overloaded.
+	overload string
+}
+
+// qualifiedName returns the quoted name the procedure is called by.
+func (p storedProcedure) qualifiedName() string {
+	if p.pkg == "" {
+		return fmt.Sprintf(`"%s"."%s"`, p.owner, p.name)
+	}
+	return fmt.Sprintf(`"%s"."%s"."%s"`, p.owner, p.pkg, p.name)
+}
+
+// byName returns true if the procedure must be called by name, because the types of its
+// arguments choose between its overloads, because some of its arguments have defaults,
+// because some of its arguments are composites which are built in the call, or because
+// some of its arguments are timestamps, which are bound as text and converted in the call.
+func (p storedProcedure) byName(arguments []procedureArgument) bool {
+	if p.overload != "" {
+		return true
+	}
+	for _, a := range arguments {
+		if a.defaulted || compositeKind(a.dataType) != "" || isTimestamp(a.dataType) {
+			return true
+		}
+	}
+	return false
+}
+
+// call returns the call of the procedure with its arguments.
+func (p storedProcedure) call(arguments []procedureArgument) (ProcedureCall, error) {
+	if p.byName(arguments) {
+		return namedProcedureCall(p.qualifiedName(), arguments)
+	}
+	return procedureCall(p.qualifiedName(), arguments), nil
+}
+
+// getStoredProcedures returns the procedures and functions which can be written to, by the
+// choices offered for them in the ConfigureWrite form. The choice of a standalone or packaged
+// procedure is its qualified name, followed by its signature if it is one of several overloads.
+func (s *Server) getStoredProcedures() (map[string]storedProcedure, error) {
+	procedures := make(map[string]storedProcedure)
+
+	rows, err := s.db.Query("SELECT owner, object_name FROM dba_objects WHERE object_type IN ('PROCEDURE', 'FUNCTION') AND oracle_maintained != 'Y' AND status = 'VALID'")
+	if err != nil {
+		return nil, errors.Errorf("could not read stored procedures from database: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p storedProcedure
+		if err := rows.Scan(&p.owner, &p.name); err != nil {
+			return nil, errors.Errorf("could not read stored procedure schema: %s", err)
+		}
+		procedures[p.qualifiedName()] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Errorf("could not read stored procedures from database: %s", err)
+	}
+
+	members, err := s.db.Query(`SELECT p.OWNER, p.OBJECT_NAME, p.PROCEDURE_NAME, p.OVERLOAD
+FROM ALL_PROCEDURES p
+JOIN dba_objects o ON o.owner = p.OWNER AND o.object_name = p.OBJECT_NAME AND o.object_type = 'PACKAGE'
+WHERE p.OBJECT_TYPE = 'PACKAGE' AND p.PROCEDURE_NAME IS NOT NULL AND o.oracle_maintained != 'Y' AND o.status = 'VALID'`)
+	if err != nil {
+		return nil, errors.Errorf("could not read packaged procedures from database: %s", err)
+	}
+	defer members.Close()
+
+	var overloads []storedProcedure
+	for members.Next() {
+		var p storedProcedure
+		var overload sql.NullString
+		if err := members.Scan(&p.owner, &p.pkg, &p.name, &overload); err != nil {
+			return nil, errors.Errorf("could not read packaged procedure: %s", err)
+		}
+		if overload.Valid {
+			p.overload = overload.String
+			overloads = append(overloads, p)
+			continue
+		}
+		procedures[p.qualifiedName()] = p
+	}
+	if err := members.Err(); err != nil {
+		return nil, errors.Errorf("could not read packaged procedures from database: %s", err)
+	}
+	if len(overloads) == 0 {
+		return procedures, nil
+	}
+
+	signatures, err := s.getOverloadSignatures()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range overloads {
+		choice := p.qualifiedName() + signatures[p]
+		// overloads whose arguments only differ in subtypes have the same signature
+		if _, ok := procedures[choice]; ok {
+			choice += fmt.Sprintf(" [%s]", p.overload)
+		}
+		procedures[choice] = p
+	}
+	return procedures, nil
+}
+
+// getOverloadSignatures returns the signature of each overload of the packaged procedures.
+func (s *Server) getOverloadSignatures() (map[storedProcedure]string, error) {
+	rows, err := s.db.Query(`SELECT a.OWNER, a.PACKAGE_NAME, a.OBJECT_NAME, a.OVERLOAD, a.ARGUMENT_NAME, a.DATA_TYPE, a.IN_OUT, a.POSITION
+FROM ALL_ARGUMENTS a
+JOIN dba_objects o ON o.owner = a.OWNER AND o.object_name = a.PACKAGE_NAME AND o.object_type = 'PACKAGE'
+WHERE a.OVERLOAD IS NOT NULL AND a.DATA_LEVEL = 0 AND o.oracle_maintained != 'Y' AND o.status = 'VALID'
+ORDER BY a.OWNER, a.PACKAGE_NAME, a.OBJECT_NAME, a.OVERLOAD, a.SEQUENCE`)
+	if err != nil {
+		return nil, errors.Errorf("could not read overloaded procedures from database: %s", err)
+	}
+	defer rows.Close()
+
+	arguments := make(map[storedProcedure][]procedureArgument)
+	for rows.Next() {
+		var p storedProcedure
+		var arg procedureArgument
+		var argName, dataType sql.NullString
+		if err := rows.Scan(&p.owner, &p.pkg, &p.name, &p.overload, &argName, &dataType, &arg.inOut, &arg.position); err != nil {
+			return nil, errors.Errorf("could not read overloaded procedure: %s", err)
+		}
+		if _, ok := arguments[p]; !ok {
+			arguments[p] = nil
+		}
+		// a procedure without arguments has a row without a data type
+		if !dataType.Valid {
+			continue
+		}
+		arg.name, arg.dataType = argName.String, dataType.String
+		arguments[p] = append(arguments[p], arg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Errorf("could not read overloaded procedures from database: %s", err)
+	}
+
+	signatures := make(map[storedProcedure]string, len(arguments))
+	for p, as := range arguments {
+		signatures[p] = procedureSignature(as)
+	}
+	return signatures, nil
+}
+
+// procedureSignature returns the signature shown for an overload, such as
+// (P_ID NUMBER, P_STATUS OUT VARCHAR2) RETURN NUMBER.
+func procedureSignature(arguments []procedureArgument) string {
+	var params []string
+	result := ""
+	for _, a := range arguments {
+		if a.isReturnValue() {
+			result = " RETURN " + a.dataType
+			continue
+		}
+		mode := ""
+		switch a.inOut {
+		case "OUT":
+			mode = "OUT "
+		case "IN/OUT":
+			mode = "IN OUT "
+		}
+		params = append(params, fmt.Sprintf("%s %s%s", a.name, mode, a.dataType))
+	}
+	return fmt.Sprintf("(%s)%s", strings.Join(params, ", "), result)
+}
+
+// procedureArgument is an argument of a stored procedure, from ALL_ARGUMENTS.
+type procedureArgument struct {
+	name     string
+	dataType string
+	length   sql.NullString
+	// inOut is the direction of the argument: IN, OUT or IN/OUT.
+	inOut string
+	// position is the position of the argument, which is 0 for the result of a function.
+	position int
+	// defaulted is set if the argument has a default.
+	defaulted bool
+	// level is the depth of an attribute of a composite argument, which is 0 for an argument.
+	level int
+	// typeName is the qualified name of the type of a composite argument.
+	typeName string
+	// attributes are the attributes of a record or object, or the element of a collection.
+	attributes []procedureArgument
+}
+
+// getProcedureArguments returns the arguments of a standalone procedure, or of a packaged
+// procedure if the package is set, in the order they are declared. Only the arguments of
+// the overload are returned if it is set. The result of a function is its first argument.
+// The attributes of composite arguments are nested in them.
+func (s *Server) getProcedureArguments(owner, pkg, name, overload string) ([]procedureArgument, error) {
+	query := `SELECT ARGUMENT_NAME, DATA_TYPE, DATA_LENGTH, SEQUENCE, IN_OUT, POSITION, DEFAULTED, DATA_LEVEL, TYPE_OWNER, TYPE_NAME, TYPE_SUBNAME, TYPE_OBJECT_TYPE FROM ALL_ARGUMENTS WHERE OWNER = :owner and OBJECT_NAME = :name`
+	args := []interface{}{sql.Named("owner", owner), sql.Named("name", name)}
+	if pkg != "" {
+		query += ` and PACKAGE_NAME = :pkg`
+		args = append(args, sql.Named("pkg", pkg))
+	} else {
+		query += ` and PACKAGE_NAME IS NULL`
+	}
+	if overload != "" {
+		query += ` and OVERLOAD = :overload`
+		args = append(args, sql.Named("overload", overload))
+	}
+	query += ` order by SEQUENCE ASC`
+
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, errors.Errorf("error preparing to get parameters for stored procedure: %s", err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, errors.Errorf("error getting parameters for stored procedure: %s", err)
+	}
+	defer rows.Close()
+
+	var arguments []procedureArgument
+	for rows.Next() {
+		var arg procedureArgument
+		var argName, dataType, defaulted sql.NullString
+		var typeOwner, typeName, typeSubname, typeObjectType sql.NullString
+		var sequence interface{}
+		if err := rows.Scan(&argName, &dataType, &arg.length, &sequence, &arg.inOut, &arg.position, &defaulted,
+			&arg.level, &typeOwner, &typeName, &typeSubname, &typeObjectType); err != nil {
+			return nil, errors.Errorf("error getting parameters for stored procedure: %s", err)
+		}
+		// a procedure without arguments has a row without a data type
+		if !dataType.Valid {
+			continue
+		}
+		arg.name, arg.dataType = argName.String, dataType.String
+		arg.defaulted = defaulted.String == "Y"
+		if typeName.Valid {
+			switch {
+			case typeSubname.Valid:
+				arg.typeName = fmt.Sprintf(`"%s"."%s"."%s"`, typeOwner.String, typeName.String, typeSubname.String)
+			case typeObjectType.String == "TABLE" || typeObjectType.String == "VIEW":
+				arg.typeName = fmt.Sprintf(`"%s"."%s"%%ROWTYPE`, typeOwner.String, typeName.String)
+			default:
+				arg.typeName = fmt.Sprintf(`"%s"."%s"`, typeOwner.String, typeName.String)
+			}
+		}
+		arguments = append(arguments, arg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Errorf("error getting parameters for stored procedure: %s", err)
+	}
+
+	return nestArguments(arguments), nil
+}
+
+// nestArguments nests the rows of ALL_ARGUMENTS which follow a composite
+// argument, at deeper levels, in its attributes.
+func nestArguments(rows []procedureArgument) []procedureArgument {
+	var arguments []procedureArgument
+	for i := 0; i < len(rows); {
+		arg := rows[i]
+		j := i + 1
+		for j < len(rows) && rows[j].level > arg.level {
+			j++
+		}
+		arg.attributes = nestArguments(rows[i+1 : j])
+		arguments = append(arguments, arg)
+		i = j
+	}
+	return arguments
+}
+
+func (a procedureArgument) isReturnValue() bool {
+	return a.position == 0
+}
+
+// isInput returns true if the argument is passed a property.
+func (a procedureArgument) isInput() bool {
+	return !a.isReturnValue() && a.inOut != "OUT"
+}
+
+// isOutput returns true if the value of the argument is captured after the call.
+func (a procedureArgument) isOutput() bool {
+	return !a.isReturnValue() && strings.HasSuffix(a.inOut, "OUT")
+}
+
+// bindName returns the name of the argument's bind in the call.
+func (a procedureArgument) bindName() string {
+	if a.isReturnValue() {
+		return returnValueParameter
+	}
+	return a.name
+}
+
+// property returns the property whose value is passed to the argument.
+func (a procedureArgument) property() *pub.Property {
+	p := &pub.Property{
+		Id:           a.name,
+		Name:         a.name,
+		TypeAtSource: a.dataType,
+		Type:         convertFromSQLType(a.dataType, 0),
+	}
+	if a.defaulted {
+		p.Description = "Optional. The default of the argument is passed for records without this property."
+		p.IsNullable = true
+	}
+	if compositeKind(a.dataType) != "" {
+		p.Type = pub.PropertyType_JSON
+		p.TypeAtSource = a.typeName
+	}
+	return p
+}
+
+// variableType returns the type of the argument's variable. Arguments do not have
+// lengths, so strings and raws are declared with the most a variable can hold.
+func (a procedureArgument) variableType() string {
+	if compositeKind(a.dataType) != "" {
+		return a.typeName
+	}
+	switch a.dataType {
+	case "CHAR", "NCHAR", "VARCHAR2", "NVARCHAR2", "RAW":
+		if a.length.Valid {
+			return fmt.Sprintf("%s(%s)", a.dataType, a.length.String)
+		}
+		return a.dataType + "(32767)"
+	}
+	return a.dataType
+}
+
+// declaration returns the declaration of the argument's variable in the DECLARE section of the call.
+func (a procedureArgument) declaration() string {
+	return fmt.Sprintf("%s %s;", a.bindName(), a.variableType())
+}
+
+// procedureCall returns the call of a procedure or function which binds its arguments by position.
+func procedureCall(procedure string, arguments []procedureArgument) ProcedureCall {
+	call := ProcedureCall{Procedure: procedure}
+	var declarations strings.Builder
+	var binds []string
+	result := ""
+	for _, a := range arguments {
+		declarations.WriteString(a.declaration())
+		if a.isReturnValue() {
+			result = fmt.Sprintf(":%s := ", returnValueParameter)
+			call.Outputs = append(call.Outputs, returnValueParameter)
+			continue
+		}
+		binds = append(binds, ":"+a.name)
+		if a.isInput() {
+			call.Parameters = append(call.Parameters, a.name)
+		}
+		if a.isOutput() {
+			call.Outputs = append(call.Outputs, a.name)
+		}
+	}
+	call.Query = fmt.Sprintf("DECLARE %s BEGIN %s%s(%s); END;", declarations.String(), result, procedure, strings.Join(binds, ","))
+	return call
+}
+
+// namedProcedureCall returns the call of a procedure or function which passes its arguments
+// by name. Its query passes every argument, and the query for a record leaves out the
+// optional arguments whose properties the record does not have. Composite arguments are
+// built from JSON, and their outputs are returned as JSON.
+func namedProcedureCall(procedure string, arguments []procedureArgument) (ProcedureCall, error) {
+	call := ProcedureCall{Procedure: procedure}
+	for _, a := range arguments {
+		composite, err := a.compositeType()
+		if err != nil {
+			return call, errors.WithMessage(err, procedure)
+		}
+		call.Arguments = append(call.Arguments, CallArgument{
+			Name:      a.bindName(),
+			Type:      a.variableType(),
+			In:        a.isInput(),
+			Out:       a.isReturnValue() || a.isOutput(),
+			Result:    a.isReturnValue(),
+			Optional:  a.defaulted,
+			Composite: composite,
+		})
+		if a.isInput() {
+			call.Parameters = append(call.Parameters, a.name)
+		}
+		if a.isReturnValue() || a.isOutput() {
+			call.Outputs = append(call.Outputs, a.bindName())
+		}
+	}
+	call.Query = call.namedQuery(nil)
+	return call, nil
+}
+
+// namedQuery returns the block which assigns the binds of the arguments to their variables,
+// calls the procedure with the variables by name and assigns the outputs to their binds.
+// The omitted arguments are left out, so that the procedure uses their defaults.
+func (c ProcedureCall) namedQuery(omitted map[string]bool) string {
+	var declarations strings.Builder
+	var before, passed, after []string
+	result := ""
+	composites := false
+	for _, a := range c.Arguments {
+		if omitted[a.Name] {
+			continue
+		}
+		declarations.WriteString(fmt.Sprintf("%s %s;", a.Name, a.Type))
+		if a.Result {
+			result = a.Name + " := "
+		} else {
+			passed = append(passed, fmt.Sprintf("%s => %s", a.Name, a.Name))
+		}
+
+		if a.Composite != nil {
+			composites = true
+			if a.In {
+				before = append(before, a.Composite.fromJSON(a.Name, ":"+a.Name))
+			}
+			if a.Out {
+				after = append(after, a.Composite.toJSON(a.Name, ":"+a.Name))
+			}
+			continue
+		}
+		if a.In {
+			before = append(before, fmt.Sprintf("%s := %s;", a.Name, bindExpression(a.Type, ":"+a.Name)))
+		}
+		if a.Out {
+			after = append(after, fmt.Sprintf(":%s := %s;", a.Name, a.Name))
+		}
+	}
+	if composites {
+		declarations.WriteString(compositeDeclarations)
+	}
+
+	statements := append(before, fmt.Sprintf("%s%s(%s);", result, c.Procedure, strings.Join(passed, ", ")))
+	statements = append(statements, after...)
+	return fmt.Sprintf("DECLARE %s BEGIN %s END;", declarations.String(), strings.Join(statements, " "))
+}
+
+// configureProcedureActions sets the action parameter and the procedures for actions chosen
+// in the form. The action parameter is removed from the properties, and each argument of the
+// procedures for actions which is not a property is added to them.
+func (s *Server) configureProcedureActions(formData ConfigureWriteFormData, schema *pub.Schema, target *StoredProcedureWriteMeta) []string {
+	var errs []string
+
+	for _, p := range schema.Properties {
+		target.Parameters = append(target.Parameters, p.Id)
+	}
+
+	if formData.ActionParameter != "" {
+		target.ActionParameter = formData.ActionParameter
+		found := false
+		for i, p := range schema.Properties {
+			if p.Id == formData.ActionParameter {
+				schema.Properties = append(schema.Properties[:i], schema.Properties[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("action parameter %q is not a parameter of %s", formData.ActionParameter, schema.Id))
+		}
+	}
+
+	for _, a := range []struct {
+		action    pub.Record_Action
+		procedure string
+	}{
+		{pub.Record_INSERT, formData.InsertProcedure},
+		{pub.Record_UPDATE, formData.UpdateProcedure},
+		{pub.Record_DELETE, formData.DeleteProcedure},
+	} {
+		if a.procedure == "" {
+			continue
+		}
+		procedure, ok := s.procedures[a.procedure]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("stored procedure %s for %s records does not exist", a.procedure, a.action))
+			continue
+		}
+
+		arguments, err := s.getProcedureArguments(procedure.owner, procedure.pkg, procedure.name, procedure.overload)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		call, err := procedure.call(arguments)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, arg := range arguments {
+			if arg.isInput() && arg.name != target.ActionParameter && findProperty(schema, arg.name) == nil {
+				schema.Properties = append(schema.Properties, arg.property())
+			}
+		}
+
+		if target.Procedures == nil {
+			target.Procedures = make(map[string]ProcedureCall)
+		}
+		target.Procedures[a.action.String()] = call
+	}
+
+	return errs
+}
+
+// writeStoredProcedure calls the stored procedure for each record, or the
+// procedure chosen for the record's action.
+func (s *Server) writeStoredProcedure(stream pub.Publisher_WriteStreamServer, schema *pub.Schema, target StoredProcedureWriteMeta) error {
+	if s.WriteSettings.DryRun {
+		s.log.Warn(dryRunProcedureWarning, "procedure", schema.Id)
+	}
+
+	return s.writeBatches(stream, schema, target.BatchSize, s.procedureWriter(schema, target))
+}
+
+// procedureWriter returns the writer which calls the stored procedure for each record, or
+// the procedure chosen for the record's action.
+func (s *Server) procedureWriter(schema *pub.Schema, target StoredProcedureWriteMeta) batchWriter {
+	call := ProcedureCall{Procedure: schema.Id, Query: schema.Query, Parameters: target.Parameters, Outputs: target.Outputs, Arguments: target.Arguments}
+	if call.Parameters == nil {
+		for _, p := range schema.Properties {
+			call.Parameters = append(call.Parameters, p.Id)
+		}
+	}
+
+	return batchWriter{
+		bind: func(record *pub.Record) (string, []interface{}, error) {
+			c := call
+			if actionCall, ok := target.Procedures[record.Action.String()]; ok {
+				c = actionCall
+			}
+			return procedureArgs(schema, target, c, record)
+		},
+		failure: "could not write back",
+		outputs: s.procedureOutputs(schema, target.OutputMode),
+	}
+}
+
+// procedureArgs returns the query of the call for the record and its binds. An argument
+// which is not a property is passed null, unless it is the action parameter, and an
+// optional argument is left out of the call if the record does not have its property.
+// The outputs are bound to variables which capture their values.
+func procedureArgs(schema *pub.Schema, target StoredProcedureWriteMeta, call ProcedureCall, record *pub.Record) (string, []interface{}, error) {
+	recordData, err := decodeRecordData(record)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := call.Query
+	omitted := make(map[string]bool)
+	if len(call.Arguments) > 0 {
+		for _, a := range call.Arguments {
+			if _, ok := recordData[a.Name]; a.Optional && !ok && a.Name != target.ActionParameter {
+				omitted[a.Name] = true
+			}
+		}
+		query = call.namedQuery(omitted)
+	}
+
+	// build params for stored procedure
+	var args []interface{}
+	for _, parameter := range call.Parameters {
+		if omitted[parameter] {
+			continue
+		}
+		var value interface{}
+		if parameter == target.ActionParameter {
+			value = record.Action.String()
+		} else if prop := findProperty(schema, parameter); prop != nil {
+			var err error
+			if value, err = writeValue(prop, recordData[parameter]); err != nil {
+				return "", nil, err
+			}
+		}
+
+		if containsString(call.Outputs, parameter) {
+			args = append(args, sql.Named(parameter, inOutBind(value)))
+		} else {
+			args = append(args, sql.Named(parameter, value))
+		}
+	}
+	for _, output := range call.Outputs {
+		if !containsString(call.Parameters, output) {
+			args = append(args, sql.Named(output, sql.Out{Dest: new(string)}))
+		}
+	}
+
+	return query, args, nil
+}
+
+// inOutBind returns the bind of an IN OUT argument, which passes the value to
+// the argument and captures the argument's value after the call.
+func inOutBind(value interface{}) sql.Out {
+	switch v := value.(type) {
+	case nil:
+		return sql.Out{Dest: new(string), In: true}
+	case time.Time:
+		return sql.Out{Dest: &v, In: true}
+	default:
+		s := fmt.Sprint(v)
+		return sql.Out{Dest: &s, In: true}
+	}
+}
+
+// outputValues returns the values captured by the OUT binds, by the names of the
+// binds, or nil if there are no OUT binds.
+func outputValues(args []interface{}) map[string]interface{} {
+	var values map[string]interface{}
+	for _, arg := range args {
+		named, ok := arg.(sql.NamedArg)
+		if !ok {
+			continue
+		}
+		out, ok := named.Value.(sql.Out)
+		if !ok {
+			continue
+		}
+
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		// Oracle does not distinguish empty strings from nulls
+		values[named.Name] = nil
+		switch dest := out.Dest.(type) {
+		case *string:
+			if *dest != "" {
+				values[named.Name] = *dest
+			}
+		case *time.Time:
+			if !dest.IsZero() {
+				values[named.Name] = dest.Format(time.RFC3339Nano)
+			}
+		}
+	}
+	return values
+}
+
+// outputEnvelope is the JSON returned in the error of an ack by OutputModeAck.
+type outputEnvelope struct {
+	Outputs map[string]interface{} `json:"outputs"`
+}
+
+// isOutputEnvelope returns true if the error of an ack is an outputEnvelope rather than an error.
+func isOutputEnvelope(message string) bool {
+	var envelope outputEnvelope
+	return json.Unmarshal([]byte(message), &envelope) == nil && envelope.Outputs != nil
+}
+
+// procedureOutputs returns the function which returns the outputs of a
+// record's call to the host once the call has been committed.
+func (s *Server) procedureOutputs(schema *pub.Schema, mode OutputMode) func(ack *pub.RecordAck, values map[string]interface{}) {
+	return func(ack *pub.RecordAck, values map[string]interface{}) {
+		if mode == OutputModeAck {
+			b, err := json.Marshal(outputEnvelope{Outputs: values})
+			if err != nil {
+				ack.Error = fmt.Sprintf("could not encode outputs: %s", err)
+				return
+			}
+			ack.Error = string(b)
+			return
+		}
+		s.log.Info("Stored procedure returned outputs.", "schema", schema.Id, "correlationId", ack.CorrelationId, "outputs", values)
+	}
+}
+
+// findProperty returns the property of the schema with the ID, or nil if there is none.
+func findProperty(schema *pub.Schema, id string) *pub.Property {
+	for _, p := range schema.Properties {
+		if p.Id == id {
+			return p
+		}
+	}
+	return nil
+}
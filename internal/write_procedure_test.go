@@ -0,0 +1,318 @@
+package internal_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stored procedure write back", func() {
+
+	const (
+		upsertQuery = `DECLARE I_AGENTID CHAR(4);I_NAME VARCHAR2(32767);I_ACTION VARCHAR2(32767); BEGIN "C##NAVEEGO"."SAVE_AGENT"(:I_AGENTID,:I_NAME,:I_ACTION); END;`
+		deleteQuery = `DECLARE I_AGENTID CHAR(4);I_REASON VARCHAR2(32767); BEGIN "C##NAVEEGO"."DELETE_AGENT"(:I_AGENTID,:I_REASON); END;`
+	)
+
+	var (
+		sut    pub.PublisherServer
+		db     *fakeDB
+		schema *pub.Schema
+	)
+
+	BeforeEach(func() {
+		sut, db = newWriteServer(hclog.NewNullLogger())
+
+		schema = &pub.Schema{
+			Id:    `"C##NAVEEGO"."SAVE_AGENT"`,
+			Query: upsertQuery,
+			Properties: []*pub.Property{
+				{Id: "I_AGENTID", Type: pub.PropertyType_STRING},
+				{Id: "I_NAME", Type: pub.PropertyType_STRING},
+				{Id: "I_REASON", Type: pub.PropertyType_STRING},
+			},
+		}
+
+		meta, err := json.Marshal(SchemaMeta{Write: &WriteMeta{
+			Target: WriteTargetStoredProcedure,
+			StoredProcedure: &StoredProcedureWriteMeta{
+				BatchSize:       10,
+				Parameters:      []string{"I_AGENTID", "I_NAME", "I_ACTION"},
+				ActionParameter: "I_ACTION",
+				Procedures: map[string]ProcedureCall{
+					"DELETE": {
+						Procedure:  `"C##NAVEEGO"."DELETE_AGENT"`,
+						Query:      deleteQuery,
+						Parameters: []string{"I_AGENTID", "I_REASON"},
+					},
+				},
+			},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+		schema.PublisherMetaJson = string(meta)
+	})
+
+	It("should pass the action to the action parameter and call the procedure for deletes", func() {
+		_, err := sut.PrepareWrite(context.Background(), &pub.PrepareWriteRequest{Schema: schema, CommitSlaSeconds: 60})
+		Expect(err).ToNot(HaveOccurred())
+
+		stream := &writeStream{records: []*pub.Record{
+			{Action: pub.Record_UPSERT, CorrelationId: "1", DataJson: `{"I_AGENTID":"A001","I_NAME":"One"}`},
+			{Action: pub.Record_INSERT, CorrelationId: "2", DataJson: `{"I_AGENTID":"A002","I_NAME":"Two"}`},
+			{Action: pub.Record_DELETE, CorrelationId: "3", DataJson: `{"I_AGENTID":"A003","I_REASON":"Closed"}`},
+		}}
+		Expect(sut.WriteStream(stream)).To(Succeed())
+
+		Expect(stream.recordAcks).To(HaveLen(3))
+		for _, ack := range stream.recordAcks {
+			Expect(ack.Error).To(BeEmpty())
+		}
+
+		Expect(db.Executions()).To(Equal([]fakeExecution{
+			{query: upsertQuery, rows: 2},
+			{query: deleteQuery, rows: 1},
+		}))
+
+		committed := db.Committed()
+		Expect(committed).To(HaveLen(3))
+		Expect(committed[0].values).To(Equal(map[string]interface{}{"I_AGENTID": "A001", "I_NAME": "One", "I_ACTION": "UPSERT"}))
+		Expect(committed[1].values).To(HaveKeyWithValue("I_ACTION", "INSERT"))
+		Expect(committed[2].values).To(Equal(map[string]interface{}{"I_AGENTID": "A003", "I_REASON": "Closed"}))
+	})
+
+	Describe("outputs", func() {
+
+		const saveQuery = `DECLARE RETURN_VALUE NUMBER;I_AGENTID CHAR(4);IO_NAME VARCHAR2(32767);O_STATUS VARCHAR2(32767); BEGIN :RETURN_VALUE := "C##NAVEEGO"."SAVE_AGENT"(:I_AGENTID,:IO_NAME,:O_STATUS); END;`
+
+		It("should call a function and bind its OUT arguments", func() {
+			call := ProcedureCallFor(`"C##NAVEEGO"."SAVE_AGENT"`, []ProcedureArgument{
+				{DataType: "NUMBER", InOut: "OUT", Position: 0},
+				{Name: "I_AGENTID", DataType: "CHAR", Length: "4", InOut: "IN", Position: 1},
+				{Name: "IO_NAME", DataType: "VARCHAR2", InOut: "IN/OUT", Position: 2},
+				{Name: "O_STATUS", DataType: "VARCHAR2", InOut: "OUT", Position: 3},
+			})
+
+			Expect(call.Query).To(Equal(saveQuery))
+			Expect(call.Parameters).To(Equal([]string{"I_AGENTID", "IO_NAME"}))
+			Expect(call.Outputs).To(Equal([]string{"RETURN_VALUE", "IO_NAME", "O_STATUS"}))
+		})
+
+		write := func(mode OutputMode) *writeStream {
+			schema.Query = saveQuery
+			schema.Properties = []*pub.Property{
+				{Id: "I_AGENTID", Type: pub.PropertyType_STRING},
+				{Id: "IO_NAME", Type: pub.PropertyType_STRING},
+			}
+			meta, err := json.Marshal(SchemaMeta{Write: &WriteMeta{
+				Target: WriteTargetStoredProcedure,
+				StoredProcedure: &StoredProcedureWriteMeta{
+					BatchSize:  10,
+					Outputs:    []string{"RETURN_VALUE", "IO_NAME", "O_STATUS"},
+					OutputMode: mode,
+				},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			schema.PublisherMetaJson = string(meta)
+
+			db.out = func(name string, row map[string]interface{}) string {
+				switch name {
+				case "RETURN_VALUE":
+					return "42"
+				case "IO_NAME":
+					return strings.ToUpper(row["IO_NAME"].(string))
+				}
+				return ""
+			}
+
+			_, err = sut.PrepareWrite(context.Background(), &pub.PrepareWriteRequest{Schema: schema, CommitSlaSeconds: 60})
+			Expect(err).ToNot(HaveOccurred())
+			stream := &writeStream{records: []*pub.Record{
+				{Action: pub.Record_UPSERT, CorrelationId: "1", DataJson: `{"I_AGENTID":"A001","IO_NAME":"one"}`},
+				{Action: pub.Record_UPSERT, CorrelationId: "2", DataJson: `{"I_AGENTID":"A002","IO_NAME":"two"}`},
+			}}
+			Expect(sut.WriteStream(stream)).To(Succeed())
+			return stream
+		}
+
+		It("should return the outputs of each record in its ack", func() {
+			stream := write(OutputModeAck)
+
+			Expect(stream.recordAcks).To(HaveLen(2))
+			Expect(stream.recordAcks[0].Error).To(MatchJSON(`{"outputs":{"RETURN_VALUE":"42","IO_NAME":"ONE","O_STATUS":null}}`))
+			Expect(stream.recordAcks[1].Error).To(MatchJSON(`{"outputs":{"RETURN_VALUE":"42","IO_NAME":"TWO","O_STATUS":null}}`))
+
+			// each record is called on its own to capture its outputs
+			Expect(db.Executions()).To(HaveLen(2))
+			Expect(db.Committed()[0].values).To(Equal(map[string]interface{}{
+				"I_AGENTID": "A001", "IO_NAME": "one", "RETURN_VALUE": nil, "O_STATUS": nil,
+			}))
+		})
+
+		It("should log the outputs by default", func() {
+			stream := write(OutputModeLog)
+
+			Expect(stream.recordAcks).To(HaveLen(2))
+			Expect(stream.recordAcks[0].Error).To(BeEmpty())
+			Expect(stream.recordAcks[1].Error).To(BeEmpty())
+		})
+	})
+
+	Describe("packaged procedures", func() {
+
+		const (
+			overloadQuery = `DECLARE RETURN_VALUE NUMBER;P_ID NUMBER;P_NAME VARCHAR2(32767);P_STATUS VARCHAR2(32767); BEGIN P_ID := :P_ID; P_NAME := :P_NAME; RETURN_VALUE := "C##NAVEEGO"."AGENTS_API"."SAVE"(P_ID => P_ID, P_NAME => P_NAME, P_STATUS => P_STATUS); :RETURN_VALUE := RETURN_VALUE; :P_STATUS := P_STATUS; END;`
+			defaultQuery  = `DECLARE P_ID NUMBER; BEGIN P_ID := :P_ID; "C##NAVEEGO"."AGENTS_API"."SAVE"(P_ID => P_ID); END;`
+		)
+
+		arguments := []ProcedureArgument{
+			{DataType: "NUMBER", InOut: "OUT", Position: 0},
+			{Name: "P_ID", DataType: "NUMBER", InOut: "IN", Position: 1},
+			{Name: "P_NAME", DataType: "VARCHAR2", InOut: "IN", Position: 2, Defaulted: true},
+			{Name: "P_STATUS", DataType: "VARCHAR2", InOut: "OUT", Position: 3},
+		}
+
+		It("should show the signature of each overload", func() {
+			Expect(ProcedureSignature(arguments)).To(Equal("(P_ID NUMBER, P_NAME VARCHAR2, P_STATUS OUT VARCHAR2) RETURN NUMBER"))
+			Expect(ProcedureSignature(nil)).To(Equal("()"))
+		})
+
+		It("should call an overload by name through variables of its types", func() {
+			call, err := PackagedProcedureCallFor("C##NAVEEGO", "AGENTS_API", "SAVE", "2", arguments)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(call.Procedure).To(Equal(`"C##NAVEEGO"."AGENTS_API"."SAVE"`))
+			Expect(call.Query).To(Equal(overloadQuery))
+			Expect(call.Parameters).To(Equal([]string{"P_ID", "P_NAME"}))
+			Expect(call.Outputs).To(Equal([]string{"RETURN_VALUE", "P_STATUS"}))
+		})
+
+		It("should call a procedure which is not overloaded by position unless it has defaults", func() {
+			call, err := PackagedProcedureCallFor("C##NAVEEGO", "AGENTS_API", "SAVE", "", arguments[1:2])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(call.Query).To(Equal(`DECLARE P_ID NUMBER; BEGIN "C##NAVEEGO"."AGENTS_API"."SAVE"(:P_ID); END;`))
+			Expect(call.Arguments).To(BeEmpty())
+
+			call, err = PackagedProcedureCallFor("C##NAVEEGO", "AGENTS_API", "SAVE", "", arguments[1:3])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(call.Arguments).To(HaveLen(2))
+		})
+
+		It("should leave optional arguments out of the calls of records without their properties", func() {
+			call, err := PackagedProcedureCallFor("C##NAVEEGO", "AGENTS_API", "SAVE", "", arguments[1:3])
+			Expect(err).ToNot(HaveOccurred())
+			schema.Id = call.Procedure
+			schema.Query = call.Query
+			schema.Properties = []*pub.Property{
+				{Id: "P_ID", Type: pub.PropertyType_FLOAT},
+				{Id: "P_NAME", Type: pub.PropertyType_STRING},
+			}
+			meta, err := json.Marshal(SchemaMeta{Write: &WriteMeta{
+				Target: WriteTargetStoredProcedure,
+				StoredProcedure: &StoredProcedureWriteMeta{
+					BatchSize:  10,
+					Parameters: call.Parameters,
+					Arguments:  call.Arguments,
+				},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			schema.PublisherMetaJson = string(meta)
+
+			_, err = sut.PrepareWrite(context.Background(), &pub.PrepareWriteRequest{Schema: schema, CommitSlaSeconds: 60})
+			Expect(err).ToNot(HaveOccurred())
+			stream := &writeStream{records: []*pub.Record{
+				{Action: pub.Record_UPSERT, CorrelationId: "1", DataJson: `{"P_ID":1,"P_NAME":"One"}`},
+				{Action: pub.Record_UPSERT, CorrelationId: "2", DataJson: `{"P_ID":2}`},
+				{Action: pub.Record_UPSERT, CorrelationId: "3", DataJson: `{"P_ID":3,"P_NAME":null}`},
+			}}
+			Expect(sut.WriteStream(stream)).To(Succeed())
+
+			for _, ack := range stream.recordAcks {
+				Expect(ack.Error).To(BeEmpty())
+			}
+			Expect(db.Executions()).To(Equal([]fakeExecution{
+				{query: call.Query, rows: 1},
+				{query: defaultQuery, rows: 1},
+				{query: call.Query, rows: 1},
+			}))
+			committed := db.Committed()
+			Expect(committed[1].values).To(Equal(map[string]interface{}{"P_ID": float64(2)}))
+			Expect(committed[2].values).To(Equal(map[string]interface{}{"P_ID": float64(3), "P_NAME": nil}))
+		})
+	})
+
+	Describe("composite arguments", func() {
+
+		arguments := []ProcedureArgument{
+			{Name: "P_ORDER", DataType: "PL/SQL RECORD", InOut: "IN", Position: 1, TypeName: `"C##NAVEEGO"."ORDERS"%ROWTYPE`},
+			{Name: "ORDER_ID", DataType: "NUMBER", InOut: "IN", Position: 1, Level: 1},
+			{Name: "CUSTOMER", DataType: "VARCHAR2", InOut: "IN", Position: 2, Level: 1},
+			{Name: "ORDERED_AT", DataType: "DATE", InOut: "IN", Position: 3, Level: 1},
+			{Name: "P_LINES", DataType: "TABLE", InOut: "IN", Position: 2, TypeName: `"C##NAVEEGO"."LINE_TAB"`},
+			{DataType: "OBJECT", InOut: "IN", Position: 1, Level: 1, TypeName: `"C##NAVEEGO"."LINE_OBJ"`},
+			{Name: "PRODUCT", DataType: "VARCHAR2", InOut: "IN", Position: 1, Level: 2},
+			{Name: "QTY", DataType: "NUMBER", InOut: "IN", Position: 2, Level: 2},
+			{Name: "P_TAGS", DataType: "PL/SQL TABLE", InOut: "IN", Position: 3, TypeName: `"C##NAVEEGO"."ORDERS_API"."TAG_LIST"`},
+			{DataType: "VARCHAR2", InOut: "IN", Position: 1, Level: 1},
+			{Name: "P_RESULT", DataType: "PL/SQL RECORD", InOut: "OUT", Position: 4, TypeName: `"C##NAVEEGO"."ORDERS_API"."RESULT_REC"`},
+			{Name: "STATUS", DataType: "VARCHAR2", InOut: "OUT", Position: 1, Level: 1},
+			{Name: "SHIPPED_AT", DataType: "DATE", InOut: "OUT", Position: 2, Level: 1},
+		}
+
+		It("should build records, objects and collections from JSON in the call", func() {
+			call, err := PackagedProcedureCallFor("C##NAVEEGO", "ORDERS_API", "SAVE_ORDER", "", arguments)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(call.Parameters).To(Equal([]string{"P_ORDER", "P_LINES", "P_TAGS"}))
+			Expect(call.Outputs).To(Equal([]string{"P_RESULT"}))
+			expectGolden("procedure/composite.sql", []string{call.Query})
+		})
+
+		It("should reject composites nested too deeply to be passed as JSON", func() {
+			_, err := PackagedProcedureCallFor("C##NAVEEGO", "ORDERS_API", "SAVE_ORDER", "", []ProcedureArgument{
+				{Name: "P_ORDER", DataType: "OBJECT", InOut: "IN", Position: 1, TypeName: `"C##NAVEEGO"."ORDER_OBJ"`},
+				{Name: "LINES", DataType: "TABLE", InOut: "IN", Position: 1, Level: 1, TypeName: `"C##NAVEEGO"."LINE_TAB"`},
+			})
+			Expect(err).To(MatchError(ContainSubstring("attribute LINES of argument P_ORDER is a TABLE, which cannot be passed as JSON")))
+		})
+
+		It("should bind composite properties as JSON", func() {
+			call, err := PackagedProcedureCallFor("C##NAVEEGO", "ORDERS_API", "SAVE_ORDER", "", arguments[:8])
+			Expect(err).ToNot(HaveOccurred())
+			schema.Id = call.Procedure
+			schema.Query = call.Query
+			schema.Properties = []*pub.Property{
+				{Id: "P_ORDER", Type: pub.PropertyType_JSON},
+				{Id: "P_LINES", Type: pub.PropertyType_JSON},
+			}
+			meta, err := json.Marshal(SchemaMeta{Write: &WriteMeta{
+				Target: WriteTargetStoredProcedure,
+				StoredProcedure: &StoredProcedureWriteMeta{
+					BatchSize:  10,
+					Parameters: call.Parameters,
+					Arguments:  call.Arguments,
+				},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			schema.PublisherMetaJson = string(meta)
+
+			_, err = sut.PrepareWrite(context.Background(), &pub.PrepareWriteRequest{Schema: schema, CommitSlaSeconds: 60})
+			Expect(err).ToNot(HaveOccurred())
+			stream := &writeStream{records: []*pub.Record{{
+				Action:        pub.Record_UPSERT,
+				CorrelationId: "1",
+				DataJson:      `{"P_ORDER":{"ORDER_ID":7,"CUSTOMER":"Acme"},"P_LINES":[{"PRODUCT":"Widget","QTY":2}]}`,
+			}}}
+			Expect(sut.WriteStream(stream)).To(Succeed())
+
+			Expect(stream.recordAcks[0].Error).To(BeEmpty())
+			values := db.Committed()[0].values
+			Expect(values["P_ORDER"]).To(MatchJSON(`{"ORDER_ID":7,"CUSTOMER":"Acme"}`))
+			Expect(values["P_LINES"]).To(MatchJSON(`[{"PRODUCT":"Widget","QTY":2}]`))
+		})
+	})
+})
@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// QueueField is a field of the JSON object written to the RAW payload of a queue.
+type QueueField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// maxEnqueuePayloadBytes is the largest RAW payload which can be bound to the enqueue block.
+const maxEnqueuePayloadBytes = 32767
+
+// jsonFieldTypes are the types which can be chosen for a QueueField.
+var jsonFieldTypes = map[string]pub.PropertyType{
+	"string":  pub.PropertyType_STRING,
+	"integer": pub.PropertyType_INTEGER,
+	"number":  pub.PropertyType_FLOAT,
+	"boolean": pub.PropertyType_BOOL,
+	"json":    pub.PropertyType_JSON,
+}
+
+// queueWriteFormBranch returns the part of the ConfigureWrite form for writing to one of the queues.
+func queueWriteFormBranch(queues []*pub.Schema) string {
+	var ids []string
+	for _, q := range queues {
+		ids = append(ids, q.Id)
+	}
+	enum, _ := json.Marshal(ids)
+
+	return fmt.Sprintf(`{
+  "properties": {
+    "target": {
+      "enum": [%q]
+    },
+    "queue": {
+      "type": "string",
+      "title": "Queue",
+      "description": "The queue each record is enqueued to as a message. The properties are the attributes of the queue's payload type, or the fields of a JSON object for a RAW queue.",
+      "enum": %s
+    },
+    "jsonFields": {
+      "type": "array",
+      "title": "JSON Fields",
+      "description": "The fields of the JSON object written to the payload of a RAW queue. If there are none, they are found in the messages already in the queue.",
+      "items": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string",
+            "title": "Name"
+          },
+          "type": {
+            "type": "string",
+            "title": "Type",
+            "enum": ["string", "integer", "number", "boolean", "json"],
+            "default": "string"
+          }
+        }
+      }
+    },
+    "setCorrelationId": {
+      "type": "boolean",
+      "title": "Set Correlation ID",
+      "description": "Set the correlation of each message to the correlation ID of its record.",
+      "default": true
+    },
+    "delaySeconds": {
+      "type": "integer",
+      "title": "Delay (Seconds)",
+      "description": "How long each message waits before it can be dequeued.",
+      "default": 0,
+      "minimum": 0
+    },
+    "expirationSeconds": {
+      "type": "integer",
+      "title": "Expiration (Seconds)",
+      "description": "How long each message can be dequeued for before it is moved to the exception queue. Messages never expire if this is 0.",
+      "default": 0,
+      "minimum": 0
+    },
+    "batchSize": {
+      "type": "integer",
+      "title": "Batch Size",
+      "description": "The most messages enqueued in one transaction. Records are acknowledged once their transaction has been committed.",
+      "default": %d,
+      "minimum": 1
+    }
+  },
+  "required": [
+    "queue"
+  ]
+}`, WriteTargetQueue, enum, defaultWriteBatchSize)
+}
+
+// configureQueueWrite returns the schema for writing to the queue chosen in the form.
+func (s *Server) configureQueueWrite(formData ConfigureWriteFormData) (*pub.Schema, []string) {
+	schema := &pub.Schema{
+		Id:                formData.Queue,
+		DataFlowDirection: pub.Schema_WRITE,
+	}
+
+	target := QueueWriteMeta{
+		SetCorrelationID:  formData.SetCorrelationID == nil || *formData.SetCorrelationID,
+		DelaySeconds:      formData.DelaySeconds,
+		ExpirationSeconds: formData.ExpirationSeconds,
+		BatchSize:         formData.BatchSize,
+	}
+	if target.BatchSize == 0 {
+		target.BatchSize = defaultWriteBatchSize
+	}
+	if target.BatchSize < 0 || target.DelaySeconds < 0 || target.ExpirationSeconds < 0 {
+		return schema, []string{"the batch size, delay and expiration must not be negative"}
+	}
+
+	queues, err := s.getAllQueues()
+	if err != nil {
+		return schema, []string{err.Error()}
+	}
+	var queue *pub.Schema
+	for _, q := range queues {
+		if q.Id == formData.Queue {
+			queue = q
+		}
+	}
+	if queue == nil {
+		return schema, []string{fmt.Sprintf("queue %s does not exist", formData.Queue)}
+	}
+	meta, err := getSchemaMeta(queue)
+	if err != nil {
+		return schema, []string{err.Error()}
+	}
+
+	var attributes []payloadAttribute
+	var fields []jsonPayloadField
+	if meta.Queue.PayloadType != rawPayloadType {
+		if attributes, err = s.getPayloadAttributes(meta.Queue.PayloadType); err != nil {
+			return schema, []string{err.Error()}
+		}
+	} else if len(formData.JSONFields) > 0 {
+		for _, f := range formData.JSONFields {
+			t, ok := jsonFieldTypes[f.Type]
+			if !ok {
+				return schema, []string{fmt.Sprintf("JSON field %q has unrecognized type %q", f.Name, f.Type)}
+			}
+			fields = append(fields, jsonPayloadField{name: f.Name, propertyType: t})
+		}
+	} else {
+		payloads, err := s.sampleQueuePayloads(queue, *meta.Queue)
+		if err != nil {
+			return schema, []string{fmt.Sprintf("could not sample messages: %s", err)}
+		}
+		var ok bool
+		if fields, ok = jsonPayloadFields(payloads); !ok {
+			return schema, []string{fmt.Sprintf("the fields of the JSON payload could not be found in the messages in %s, so they must be listed", queue.Id)}
+		}
+	}
+
+	schema, err = queueWriteSchema(queue.Id, *meta.Queue, attributes, fields, target)
+	if err != nil {
+		return schema, []string{err.Error()}
+	}
+	return schema, nil
+}
+
+// queueWriteSchema returns the schema for writing to a queue, whose query is the enqueue block.
+// The properties are the attributes of an object payload, apart from objects and collections
+// which are enqueued as nulls, or the fields of a JSON payload.
+func queueWriteSchema(queueID string, queue QueueMeta, attributes []payloadAttribute, fields []jsonPayloadField, target QueueWriteMeta) (*pub.Schema, error) {
+	schema := &pub.Schema{
+		Id:                queueID,
+		DataFlowDirection: pub.Schema_WRITE,
+	}
+
+	payload := ":payload"
+	if queue.PayloadType != rawPayloadType {
+		var args []string
+		for _, a := range attributes {
+			if a.composite {
+				args = append(args, "NULL")
+				continue
+			}
+			schema.Properties = append(schema.Properties, &pub.Property{
+				Id:           a.ColumnName,
+				Name:         a.ColumnName,
+				TypeAtSource: a.TypeAtSource(),
+				Type:         convertSQLType(a.columnInfo),
+				IsNullable:   true,
+			})
+			args = append(args, bindExpression(a.TypeAtSource(), fmt.Sprintf(":p%d", len(schema.Properties))))
+		}
+		payload = fmt.Sprintf("%s(%s)", queue.PayloadType, strings.Join(args, ", "))
+	} else {
+		target.JSONPayload = true
+		for _, f := range fields {
+			schema.Properties = append(schema.Properties, &pub.Property{
+				Id:           f.name,
+				Name:         f.name,
+				TypeAtSource: "JSON",
+				Type:         f.propertyType,
+				IsNullable:   true,
+			})
+		}
+	}
+
+	schema.Query = enqueueBlock(removeSafeName(queueID), payload)
+
+	return schema, setSchemaMeta(schema, &SchemaMeta{Write: &WriteMeta{Target: WriteTargetQueue, Queue: &target}})
+}
+
+// enqueueBlock returns the PL/SQL block which enqueues a message with the payload expression.
+// The message properties are set from the binds :correlation, :delay and :expiration.
+func enqueueBlock(queueName, payload string) string {
+	return fmt.Sprintf(`DECLARE
+  enqueue_options    DBMS_AQ.ENQUEUE_OPTIONS_T;
+  message_properties DBMS_AQ.MESSAGE_PROPERTIES_T;
+  msgid              RAW(16);
+BEGIN
+  message_properties.correlation := :correlation;
+  message_properties.delay := :delay;
+  message_properties.expiration := :expiration;
+  DBMS_AQ.ENQUEUE(
+    queue_name         => '%s',
+    enqueue_options    => enqueue_options,
+    message_properties => message_properties,
+    payload            => %s,
+    msgid              => msgid);
+END;`, strings.Replace(queueName, "'", "''", -1), payload)
+}
+
+// enqueueArgs returns the binds of the enqueue block for the record.
+func enqueueArgs(schema *pub.Schema, target QueueWriteMeta, record *pub.Record) ([]interface{}, error) {
+	data, err := decodeRecordData(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var correlation string
+	if target.SetCorrelationID {
+		correlation = record.CorrelationId
+	}
+	expiration := target.ExpirationSeconds
+	if expiration == 0 {
+		// DBMS_AQ.NEVER
+		expiration = -1
+	}
+
+	args := []interface{}{
+		sql.Named("correlation", correlation),
+		sql.Named("delay", target.DelaySeconds),
+		sql.Named("expiration", expiration),
+	}
+
+	if target.JSONPayload {
+		payload := make(map[string]interface{}, len(schema.Properties))
+		for _, p := range schema.Properties {
+			payload[p.Name] = data[p.Id]
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(b) > maxEnqueuePayloadBytes {
+			return nil, errors.Errorf("the JSON payload is %d bytes, which is larger than %d bytes", len(b), maxEnqueuePayloadBytes)
+		}
+		return append(args, sql.Named("payload", b)), nil
+	}
+
+	for i, p := range schema.Properties {
+		value, err := writeValue(p, data[p.Id])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, sql.Named(fmt.Sprintf("p%d", i+1), value))
+	}
+
+	return args, nil
+}
+
+// writeQueue enqueues each record as a message. The records are enqueued in batches,
+// each in its own transaction, and are acknowledged once the transaction has been committed.
+func (s *Server) writeQueue(stream pub.Publisher_WriteStreamServer, schema *pub.Schema, target QueueWriteMeta) error {
+	return s.writeBatches(stream, schema, target.BatchSize, queueWriter(schema, target))
+}
+
+// queueWriter returns the writer which enqueues each record as a message.
+func queueWriter(schema *pub.Schema, target QueueWriteMeta) batchWriter {
+	return batchWriter{
+		bind: func(record *pub.Record) (string, []interface{}, error) {
+			args, err := enqueueArgs(schema, target, record)
+			return schema.Query, args, err
+		},
+		failure: "could not enqueue",
+	}
+}
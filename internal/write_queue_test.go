@@ -0,0 +1,144 @@
+package internal_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/goracle.v2"
+)
+
+var _ = Describe("Queue write back", func() {
+
+	Describe("schema", func() {
+
+		It("should construct an object payload from the properties", func() {
+			schema, err := QueueWriteSchema(`"C##NAVEEGO"."ORDER_EVENTS"`,
+				QueueMeta{QueueTable: "ORDER_EVENTS_QT", PayloadType: `"C##NAVEEGO"."ORDER_EVENT_T"`},
+				[]PayloadAttribute{
+					{Name: "ORDER_ID", DataType: "NUMBER"},
+					{Name: "LINES", DataType: "ORDER_LINES_T", Composite: true},
+					{Name: "PLACED_AT", DataType: "DATE"},
+				}, nil,
+				QueueWriteMeta{BatchSize: 10})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(schema.Id).To(Equal(`"C##NAVEEGO"."ORDER_EVENTS"`))
+			Expect(schema.DataFlowDirection).To(Equal(pub.Schema_WRITE))
+			Expect(schema.Properties).To(HaveLen(2))
+			Expect(schema.Properties[0].Id).To(Equal("ORDER_ID"))
+			Expect(schema.Properties[1].Id).To(Equal("PLACED_AT"))
+			Expect(schema.Properties[1].Type).To(Equal(pub.PropertyType_DATETIME))
+			Expect(schema.PublisherMetaJson).To(MatchJSON(`{"write":{"target":"Queue","queue":{"batchSize":10}}}`))
+			expectGolden("queue/object_enqueue.sql", []string{schema.Query})
+		})
+
+		It("should write a JSON payload to a RAW queue", func() {
+			schema, err := QueueWriteSchema(`"C##NAVEEGO"."EVENTS"`,
+				QueueMeta{QueueTable: "EVENTS_QT", PayloadType: "RAW"},
+				nil, []QueueField{{Name: "id", Type: "integer"}, {Name: "name", Type: "string"}},
+				QueueWriteMeta{SetCorrelationID: true, DelaySeconds: 5, BatchSize: 10})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(schema.Properties).To(HaveLen(2))
+			Expect(schema.Properties[0].Type).To(Equal(pub.PropertyType_INTEGER))
+			expectGolden("queue/raw_enqueue.sql", []string{schema.Query})
+
+			args, err := EnqueueArgs(schema, QueueWriteMeta{JSONPayload: true, SetCorrelationID: true, DelaySeconds: 5}, &pub.Record{
+				CorrelationId: "record-1",
+				DataJson:      `{"id": 7, "name": "seven", "ignored": true}`,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(ConsistOf(
+				sql.Named("correlation", "record-1"),
+				sql.Named("delay", 5),
+				sql.Named("expiration", -1),
+				sql.Named("payload", []byte(`{"id":7,"name":"seven"}`)),
+			))
+		})
+
+		It("should bind the attributes of an object payload in order", func() {
+			schema, err := QueueWriteSchema(`"C##NAVEEGO"."ORDER_EVENTS"`,
+				QueueMeta{QueueTable: "ORDER_EVENTS_QT", PayloadType: `"C##NAVEEGO"."ORDER_EVENT_T"`},
+				[]PayloadAttribute{{Name: "ORDER_ID", DataType: "NUMBER"}, {Name: "PLACED_AT", DataType: "DATE"}}, nil,
+				QueueWriteMeta{})
+			Expect(err).ToNot(HaveOccurred())
+
+			args, err := EnqueueArgs(schema, QueueWriteMeta{ExpirationSeconds: 60}, &pub.Record{
+				CorrelationId: "record-1",
+				DataJson:      `{"ORDER_ID": 42, "PLACED_AT": "2019-03-01T12:00:00Z"}`,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(Equal([]interface{}{
+				sql.Named("correlation", ""),
+				sql.Named("delay", 0),
+				sql.Named("expiration", 60),
+				sql.Named("p1", goracle.Number("42")),
+				sql.Named("p2", time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)),
+			}))
+		})
+	})
+
+	Describe("batching", func() {
+
+		records := func(n int) []*pub.Record {
+			var rs []*pub.Record
+			for i := 0; i < n; i++ {
+				rs = append(rs, &pub.Record{DataJson: "{}", CorrelationId: string('a' + rune(i))})
+			}
+			return rs
+		}
+
+		It("should fill batches up to their size and return the end of the stream", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			received := ReceiveRecords(ctx, &writeStream{records: records(5)})
+
+			batch, err := NextBatch(ctx, received, 2, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batch).To(HaveLen(2))
+
+			batch, err = NextBatch(ctx, received, 2, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batch).To(HaveLen(2))
+
+			batch, err = NextBatch(ctx, received, 2, time.Minute)
+			Expect(err).To(Equal(io.EOF))
+			Expect(batch).To(HaveLen(1))
+			Expect(batch[0].CorrelationId).To(Equal("e"))
+		})
+
+		It("should end a batch when the window elapses", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream := &slowWriteStream{records: make(chan *pub.Record, 1)}
+			received := ReceiveRecords(ctx, stream)
+
+			stream.records <- records(1)[0]
+			start := time.Now()
+			batch, err := NextBatch(ctx, received, 10, 50*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batch).To(HaveLen(1))
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+	})
+})
+
+// slowWriteStream receives the records sent to it by the test.
+type slowWriteStream struct {
+	writeStream
+	records chan *pub.Record
+}
+
+func (s *slowWriteStream) Recv() (*pub.Record, error) {
+	record, ok := <-s.records
+	if !ok {
+		return nil, io.EOF
+	}
+	return record, nil
+}
@@ -10,4 +10,194 @@ import (
 type WriteSettings struct {
 	Schema		*pub.Schema   `json:"schema"`
 	CommitSLA	int32		  `json:"commitSla"`
+	// DeadLetter is the dead-letter table of the schema, if it has one.
+	DeadLetter	*DeadLetterMeta	  `json:"deadLetter,omitempty"`
+	// Idempotency is the table which tracks the records written to the schema, if it has one.
+	Idempotency	*IdempotencyMeta  `json:"idempotency,omitempty"`
+	// DryRun is set if every transaction is rolled back rather than committed.
+	DryRun		bool		  `json:"dryRun,omitempty"`
+	// Concurrency is how many partitions of the records are written at the same time.
+	Concurrency	int		  `json:"concurrency,omitempty"`
+}
+
+// WriteTarget is the kind of object records are written to.
+type WriteTarget string
+
+const WriteTargetStoredProcedure = WriteTarget("Stored Procedure")
+const WriteTargetQueue = WriteTarget("Queue")
+const WriteTargetTable = WriteTarget("Table")
+
+// WriteMeta is the configuration of a write-back target, which is round-tripped
+// through the host in the schema's metadata. Schemas without it call a stored procedure.
+type WriteMeta struct {
+	Target          WriteTarget               `json:"target"`
+	StoredProcedure *StoredProcedureWriteMeta `json:"storedProcedure,omitempty"`
+	Queue           *QueueWriteMeta           `json:"queue,omitempty"`
+	Table           *TableWriteMeta           `json:"table,omitempty"`
+	// DeadLetter is set if records which are rejected are inserted into a dead-letter table.
+	DeadLetter *DeadLetterMeta `json:"deadLetter,omitempty"`
+	// Idempotency is set if records whose correlation IDs have already been written are skipped.
+	Idempotency *IdempotencyMeta `json:"idempotency,omitempty"`
+	// DryRun is set if records are written to validate them, and then rolled back.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Concurrency is how many partitions of the records, by their key properties, are written
+	// at the same time. Records are written one batch at a time if it is not set.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// StoredProcedureWriteMeta configures how records are written by a stored procedure.
+type StoredProcedureWriteMeta struct {
+	// BatchSize is the most records written in a transaction.
+	BatchSize int `json:"batchSize,omitempty"`
+	// Parameters are the names of the arguments bound to the schema's query,
+	// which are the IDs of the schema's properties if they are not set.
+	Parameters []string `json:"parameters,omitempty"`
+	// Outputs are the names of the OUT arguments of the schema's query, and of
+	// returnValueParameter if it calls a function.
+	Outputs []string `json:"outputs,omitempty"`
+	// Arguments are the arguments of the procedure if it is called by name, in which
+	// case the call of each record is built from them instead of the schema's query.
+	Arguments []CallArgument `json:"arguments,omitempty"`
+	// OutputMode is how the values of the outputs are returned to the host.
+	OutputMode OutputMode `json:"outputMode,omitempty"`
+	// ActionParameter is the argument which is passed the action of each record, if any.
+	ActionParameter string `json:"actionParameter,omitempty"`
+	// Procedures are the calls of the procedures which write the records with an action,
+	// by the name of the action, instead of the schema's stored procedure.
+	Procedures map[string]ProcedureCall `json:"procedures,omitempty"`
+}
+
+// ProcedureCall is the block which calls a stored procedure.
+type ProcedureCall struct {
+	Procedure string `json:"procedure"`
+	Query     string `json:"query"`
+	// Parameters are the names of the arguments bound to the call, which are
+	// passed the properties with the same IDs.
+	Parameters []string `json:"parameters"`
+	// Outputs are the names of the OUT arguments, and of returnValueParameter for a function.
+	Outputs []string `json:"outputs,omitempty"`
+	// Arguments are the arguments of the procedure if it is called by name.
+	Arguments []CallArgument `json:"arguments,omitempty"`
+}
+
+// CallArgument is an argument of a procedure which is called by name. Its value is
+// passed through a variable of its type, so that the types of the arguments choose
+// between the overloads of a packaged procedure.
+type CallArgument struct {
+	Name string `json:"name"`
+	// Type is the type of the variable, such as VARCHAR2(32767).
+	Type string `json:"type"`
+	In   bool   `json:"in,omitempty"`
+	Out  bool   `json:"out,omitempty"`
+	// Result is set for the result of a function.
+	Result bool `json:"result,omitempty"`
+	// Optional is set for an argument with a default, which is left
+	// out of the call of a record which does not have its property.
+	Optional bool `json:"optional,omitempty"`
+	// Composite is the type of a record, object or collection argument, whose value is passed as JSON.
+	Composite *CompositeType `json:"composite,omitempty"`
+}
+
+// CompositeKind is the kind of a composite argument.
+type CompositeKind string
+
+const (
+	CompositeRecord CompositeKind = "Record"
+	CompositeObject CompositeKind = "Object"
+	// CompositeCollection is a nested table or varray, which is constructed.
+	CompositeCollection CompositeKind = "Collection"
+	// CompositeIndexTable is an associative array indexed by integers.
+	CompositeIndexTable CompositeKind = "Index Table"
+)
+
+// CompositeType is the type of a composite argument, which is built from a JSON object
+// with a field for each attribute, or from a JSON array with an item for each element.
+type CompositeType struct {
+	Kind CompositeKind `json:"kind"`
+	// Constructor is the type of an object or collection, which constructs it.
+	Constructor string `json:"constructor,omitempty"`
+	// Attributes are the attributes of a record or object.
+	Attributes []CompositeAttribute `json:"attributes,omitempty"`
+	// ElementType is the data type of the elements of a collection of scalars.
+	ElementType string `json:"elementType,omitempty"`
+	// Element is the type of the elements of a collection of records or objects.
+	Element *CompositeType `json:"element,omitempty"`
+}
+
+// CompositeAttribute is a scalar attribute of a record or object.
+type CompositeAttribute struct {
+	Name     string `json:"name"`
+	DataType string `json:"dataType"`
+}
+
+// OutputMode is how the values of OUT arguments and function results are returned to the host.
+type OutputMode string
+
+const (
+	// OutputModeLog logs the values with the correlation ID of the record.
+	OutputModeLog = OutputMode("Log")
+	// OutputModeAck returns the values in the error of the record's ack, as a JSON outputEnvelope.
+	OutputModeAck = OutputMode("Acknowledgement")
+)
+
+// QueueWriteMeta configures how records are enqueued to an Advanced Queuing queue.
+type QueueWriteMeta struct {
+	// JSONPayload is set if the properties are written as a JSON object to a RAW payload,
+	// rather than to the attributes of an object payload.
+	JSONPayload bool `json:"jsonPayload,omitempty"`
+	// SetCorrelationID sets the correlation of each message to the record's CorrelationId.
+	SetCorrelationID bool `json:"setCorrelationId,omitempty"`
+	// DelaySeconds is how long a message waits before it can be dequeued.
+	DelaySeconds int `json:"delaySeconds,omitempty"`
+	// ExpirationSeconds is how long a message can be dequeued for, or 0 if it never expires.
+	ExpirationSeconds int `json:"expirationSeconds,omitempty"`
+	// BatchSize is the most messages enqueued in a transaction.
+	BatchSize int `json:"batchSize,omitempty"`
+}
+
+// TableWriteMeta configures how records are written to a table.
+type TableWriteMeta struct {
+	// Table is the table written to, as "OWNER"."TABLE".
+	Table string `json:"table"`
+	// Columns are the columns written to, in the order of the schema's properties.
+	Columns []string `json:"columns"`
+	// KeyColumns are the columns which identify the row an update, upsert or delete writes to.
+	KeyColumns []string `json:"keyColumns"`
+	// BatchSize is the most records written in a transaction.
+	BatchSize int `json:"batchSize,omitempty"`
+	// AddColumns is set if properties added to the schema later are written to new columns,
+	// which are added to the table when it is written to.
+	AddColumns bool `json:"addColumns,omitempty"`
+	// Staging is set if the records are bulk loaded into a staging table and merged into the table.
+	Staging *StagingWriteMeta `json:"staging,omitempty"`
+}
+
+// StagingWriteMeta configures a bulk load, which inserts the records into a staging table
+// and merges them into the table written to once the stream ends.
+type StagingWriteMeta struct {
+	// Table is the staging table, as "OWNER"."TABLE".
+	Table string `json:"table"`
+	// DirectPath is set if the records are inserted into the staging table by direct-path inserts.
+	DirectPath bool `json:"directPath,omitempty"`
+	// PostLoadProcedure is the procedure called after the merge, in the same transaction, if any.
+	PostLoadProcedure string `json:"postLoadProcedure,omitempty"`
+	// MergeSize is the number of staged records which are merged without waiting for more,
+	// defaultStagingMergeSize if it is not set.
+	MergeSize int `json:"mergeSize,omitempty"`
+}
+
+// DeadLetterMeta configures the table which records rejected by write-back are inserted into,
+// so that they can be fixed and replayed.
+type DeadLetterMeta struct {
+	// Table is the dead-letter table, as "OWNER"."TABLE".
+	Table string `json:"table"`
+}
+
+// IdempotencyMeta configures the table which tracks the correlation IDs of the records written,
+// so that a record which the host sends again is acknowledged without being written twice.
+type IdempotencyMeta struct {
+	// Table is the tracking table, as "OWNER"."TABLE".
+	Table string `json:"table"`
+	// RetentionDays is how long a correlation ID is tracked for.
+	RetentionDays int `json:"retentionDays"`
 }
@@ -0,0 +1,473 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// The columns a staging table has in addition to the columns of the table it is merged into:
+// the position of each record in the load, its action and its correlation ID.
+const (
+	stagingSequence      = "NAVEEGO_SEQ"
+	stagingAction        = "NAVEEGO_ACTION"
+	stagingCorrelationID = "NAVEEGO_CORRELATION_ID"
+)
+
+// defaultStagingMergeSize is the number of staged records which are merged without waiting
+// for more if the bulk load does not set a merge size.
+const defaultStagingMergeSize = 100000
+
+// stagingDDL returns the function which returns the statements which create a staging table for
+// the table, as a global temporary table whose rows are only seen by the session which loads
+// them. It has the columns written to, which are all nullable, other than the keys, so that
+// the records of deletes can be staged without their values.
+func stagingDDL(target TableWriteMeta, notNull []string) func(table string) []string {
+	return func(table string) []string {
+		var columns []string
+		for _, c := range target.Columns {
+			columns = append(columns, fmt.Sprintf(`t."%s"`, c))
+		}
+
+		statements := []string{fmt.Sprintf(`CREATE GLOBAL TEMPORARY TABLE %s
+ON COMMIT PRESERVE ROWS
+AS SELECT %s,
+  CAST(NULL AS NUMBER) AS "%s",
+  CAST(NULL AS VARCHAR2(10)) AS "%s",
+  CAST(NULL AS VARCHAR2(4000)) AS "%s"
+FROM %s t
+WHERE 1 = 0`, table, strings.Join(columns, ", "), stagingSequence, stagingAction, stagingCorrelationID, target.Table)}
+
+		if len(notNull) > 0 {
+			var nullable []string
+			for _, c := range notNull {
+				nullable = append(nullable, fmt.Sprintf(`"%s" NULL`, c))
+			}
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s MODIFY (%s)", table, strings.Join(nullable, ", ")))
+		}
+
+		return append(statements, fmt.Sprintf(`COMMENT ON TABLE %s IS 'Naveego write-back staging table for %s.'`, table, strings.Replace(target.Table, "'", "''", -1)))
+	}
+}
+
+// configureStaging sets the staging table chosen in the form on the table write, creating
+// the table if it does not exist and the form asks for it to be created.
+func (s *Server) configureStaging(formData ConfigureWriteFormData, target *TableWriteMeta, shape *pub.Schema) []string {
+	if formData.StagingTable == "" {
+		return nil
+	}
+	// a bulk load is merged in one session, and its correlation IDs are not tracked
+	var errs []string
+	if formData.IdempotencyTable != "" {
+		errs = append(errs, "records loaded through a staging table cannot be tracked in an idempotency table")
+	}
+	if formData.WriteConcurrency > 1 {
+		errs = append(errs, "records loaded through a staging table cannot be written concurrently")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if formData.MergeSize < 0 {
+		return []string{"the merge size must not be negative"}
+	}
+	staging := StagingWriteMeta{DirectPath: formData.DirectPath, MergeSize: formData.MergeSize}
+
+	if formData.PostLoadProcedure != "" {
+		procedure, ok := s.procedures[formData.PostLoadProcedure]
+		if !ok {
+			return []string{fmt.Sprintf("post-load procedure %s does not exist", formData.PostLoadProcedure)}
+		}
+		arguments, err := s.getProcedureArguments(procedure.owner, procedure.pkg, procedure.name, procedure.overload)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		for _, a := range arguments {
+			if a.isReturnValue() || !a.defaulted {
+				return []string{fmt.Sprintf("post-load procedure %s must be a procedure which can be called without arguments", formData.PostLoadProcedure)}
+			}
+		}
+		staging.PostLoadProcedure = procedure.qualifiedName()
+	}
+
+	var notNull []string
+	for _, p := range shape.Properties {
+		if !p.IsNullable && containsString(target.Columns, p.Name) && !containsString(target.KeyColumns, p.Name) {
+			notNull = append(notNull, p.Name)
+		}
+	}
+
+	var err error
+	staging.Table, err = s.ensureWriteTable(formData.StagingTable, formData.CreateStagingTable, stagingDDL(*target, notNull))
+	if err != nil {
+		return []string{fmt.Sprintf("staging table: %s", err)}
+	}
+	if staging.Table == target.Table {
+		return []string{"the staging table must not be the table written to"}
+	}
+
+	target.Staging = &staging
+	return nil
+}
+
+// stagingInsert returns the statement which inserts a record into the staging table, with
+// the value of each column bound to :p<n> as in the statements of the table.
+func stagingInsert(target TableWriteMeta, properties []*pub.Property, directPath bool) string {
+	var names, binds []string
+	for i, c := range target.Columns {
+		names = append(names, fmt.Sprintf(`"%s"`, c))
+		binds = append(binds, tableBind(properties, i))
+	}
+	names = append(names, fmt.Sprintf(`"%s"`, stagingSequence), fmt.Sprintf(`"%s"`, stagingAction), fmt.Sprintf(`"%s"`, stagingCorrelationID))
+	binds = append(binds, ":seq", ":action", ":correlation_id")
+
+	hint := ""
+	if directPath {
+		hint = "/*+ APPEND_VALUES */ "
+	}
+	return fmt.Sprintf("INSERT %sINTO %s (%s)\nVALUES (%s)", hint, target.Staging.Table, strings.Join(names, ", "), strings.Join(binds, ", "))
+}
+
+// stagingMerge returns the statements which merge the staging table into the table. Only the
+// last record staged for each row is merged: the row is deleted if it is a delete, otherwise
+// it is merged as an upsert, so inserts of rows which exist update them and updates of rows
+// which do not exist insert them.
+func stagingMerge(target TableWriteMeta) []string {
+	quoted := func(columns []string, prefix string) string {
+		var q []string
+		for _, c := range columns {
+			q = append(q, fmt.Sprintf(`%s"%s"`, prefix, c))
+		}
+		return strings.Join(q, ", ")
+	}
+
+	var on, set []string
+	for _, c := range target.Columns {
+		if !containsString(target.KeyColumns, c) {
+			set = append(set, fmt.Sprintf(`t."%s" = s."%s"`, c, c))
+		}
+	}
+	for _, c := range target.KeyColumns {
+		on = append(on, fmt.Sprintf(`t."%s" = s."%s"`, c, c))
+	}
+
+	last := fmt.Sprintf(`SELECT %s, "%s" FROM (
+  SELECT r.*, ROW_NUMBER() OVER (PARTITION BY %s ORDER BY "%s" DESC) AS "NAVEEGO_RANK"
+  FROM %s r
+) WHERE "NAVEEGO_RANK" = 1`, quoted(target.Columns, ""), stagingAction, quoted(target.KeyColumns, "r."), stagingSequence, target.Staging.Table)
+
+	remove := fmt.Sprintf(`DELETE FROM %s
+WHERE (%s) IN (SELECT %s FROM (%s) WHERE "%s" = '%s')`,
+		target.Table, quoted(target.KeyColumns, ""), quoted(target.KeyColumns, ""), last, stagingAction, pub.Record_DELETE)
+
+	merge := fmt.Sprintf(`MERGE INTO %s t
+USING (SELECT * FROM (%s) WHERE "%s" <> '%s') s
+ON (%s)`, target.Table, last, stagingAction, pub.Record_DELETE, strings.Join(on, " AND "))
+	if len(set) > 0 {
+		merge += fmt.Sprintf("\nWHEN MATCHED THEN UPDATE SET %s", strings.Join(set, ", "))
+	}
+	merge += fmt.Sprintf("\nWHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)", quoted(target.Columns, ""), quoted(target.Columns, "s."))
+
+	return []string{remove, merge}
+}
+
+// writeStaged bulk loads the records into the staging table and merges them into the table.
+// The records are inserted in batches of array binds on one session, which is the only session
+// which sees the rows of a temporary staging table. A record which cannot be staged is
+// acknowledged with its error at once. The staged records are merged into the table, and the
+// post-load procedure is called, in one transaction once the batch window has elapsed since
+// the first of them was received, once there are the merge size of them, or once the stream
+// ends, after which they are acknowledged and cleared from the staging table. If the merge
+// fails they are acknowledged with its error. If the session is lost the rows it staged are
+// lost, so the load fails without acknowledging them.
+func (s *Server) writeStaged(stream pub.Publisher_WriteStreamServer, schema *pub.Schema, target TableWriteMeta) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	staging := *target.Staging
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return errors.Errorf("could not open a session to load %s: %s", staging.Table, err)
+	}
+	defer conn.Close()
+
+	// rows left by a load which failed are discarded
+	discard := fmt.Sprintf("DELETE FROM %s", staging.Table)
+	if _, err := conn.ExecContext(ctx, discard); err != nil {
+		return errors.Errorf("could not clear %s: %s", staging.Table, err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), discard); err != nil {
+			s.log.Warn("Could not clear staging table.", "table", staging.Table, "error", err)
+		}
+	}()
+
+	statement := tableStatement{query: stagingInsert(target, schema.Properties, false)}
+	for i := range target.Columns {
+		statement.binds = append(statement.binds, i)
+	}
+	statements := map[pub.Record_Action]tableStatement{
+		pub.Record_UPSERT: statement,
+		pub.Record_INSERT: statement,
+		pub.Record_UPDATE: statement,
+		pub.Record_DELETE: statement,
+	}
+	var sequence int64
+	bind := func(record *pub.Record) (string, []interface{}, error) {
+		query, args, err := tableArgs(schema, statements, record)
+		if err != nil {
+			return "", nil, err
+		}
+		sequence++
+		return query, append(args,
+			sql.Named("seq", sequence),
+			sql.Named("action", record.Action.String()),
+			sql.Named("correlation_id", record.CorrelationId)), nil
+	}
+
+	deadLetter := s.WriteSettings.DeadLetter
+	if s.WriteSettings.DryRun {
+		s.log.Info("Loading as a dry run, the merge will be rolled back.", "schema", schema.Id)
+		if staging.PostLoadProcedure != "" {
+			s.log.Warn(dryRunProcedureWarning, "procedure", staging.PostLoadProcedure)
+		}
+		deadLetter = nil
+	}
+
+	direct := ""
+	if staging.DirectPath {
+		direct = stagingInsert(target, schema.Properties, true)
+	}
+
+	received := receiveRecords(ctx, stream)
+	size := target.BatchSize
+	if size < 1 {
+		size = defaultWriteBatchSize
+	}
+	mergeSize := staging.MergeSize
+	if mergeSize < 1 {
+		mergeSize = defaultStagingMergeSize
+	}
+	window := batchWindow(s.WriteSettings.CommitSLA)
+
+	// staged is the number of records staged since the last merge, which is due when the
+	// window has elapsed since the first of them was received
+	staged := 0
+	var start time.Time
+	var due *time.Timer
+	defer func() {
+		if due != nil {
+			due.Stop()
+		}
+	}()
+
+	// merge merges the staged records and acknowledges them, then clears them from the
+	// staging table unless the load is finished, when they are cleared as it returns.
+	merge := func(finished bool) error {
+		if due != nil {
+			due.Stop()
+			due = nil
+		}
+		s.log.Info("Staged records.", "schema", schema.Id, "table", staging.Table, "records", staged, "elapsed", time.Since(start))
+		staged = 0
+
+		var failure string
+		err := s.settings.writeRetryPolicy().retry(ctx, s.log, time.Time{}, func() error {
+			return s.mergeStaged(ctx, conn, target)
+		})
+		if err != nil {
+			s.log.Error("Could not merge staged records.", "schema", schema.Id, "table", target.Table, "error", err)
+			failure = fmt.Sprintf("could not merge staged records: %s", err)
+		}
+
+		if err := s.ackStaged(ctx, conn, stream, staging, failure); err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+		if _, err := conn.ExecContext(ctx, discard); err != nil {
+			return errors.Errorf("could not clear %s: %s", staging.Table, err)
+		}
+		return nil
+	}
+
+	for {
+		var dueC <-chan time.Time
+		if due != nil {
+			dueC = due.C
+		}
+		batch, first, err := nextBatch(ctx, received, size, window, dueC)
+
+		if len(batch) > 0 {
+			acks, rejected, stageErr := s.stageBatch(ctx, conn, batch, bind, target, direct)
+			if stageErr != nil {
+				return stageErr
+			}
+			if deadLetter != nil {
+				s.deadLetter(ctx, schema, *deadLetter, batch, acks, rejected)
+			}
+			for i, ack := range acks {
+				if !rejected[i] {
+					if staged == 0 {
+						start = first
+						due = time.NewTimer(time.Until(start.Add(window)))
+					}
+					staged++
+					continue
+				}
+				if sendErr := stream.Send(ack); sendErr != nil {
+					return sendErr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if staged > 0 && (staged >= mergeSize || time.Since(start) >= window) {
+			if err := merge(false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if staged == 0 {
+		return nil
+	}
+	return merge(true)
+}
+
+// stageBatch inserts the records of a batch into the staging table in a transaction, returning
+// their acks and which of them were rejected. A direct-path insert of the batch which fails is
+// rolled back, and the batch is inserted again without it, to find the records which cannot be
+// staged. Direct is the direct-path insert, if the staging table is loaded by direct path. It
+// returns an error if the batch cannot be staged.
+func (s *Server) stageBatch(ctx context.Context, conn *sql.Conn, batch []*pub.Record, bind recordBinder, target TableWriteMeta, direct string) ([]*pub.RecordAck, []bool, error) {
+	acks := make([]*pub.RecordAck, len(batch))
+	rejected := make([]bool, len(batch))
+	for i, record := range batch {
+		acks[i] = &pub.RecordAck{CorrelationId: record.CorrelationId}
+	}
+	bound := bindBatch(batch, acks, bind)
+	if len(bound) == 0 {
+		for i, ack := range acks {
+			rejected[i] = ack.Error != ""
+		}
+		return acks, rejected, nil
+	}
+
+	if direct != "" {
+		err := stageDirect(ctx, conn, direct, bound)
+		if err == nil {
+			return acks, rejected, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, errors.WithMessage(err, "could not stage records")
+		}
+		s.log.Debug("Direct-path insert into staging table failed, inserting the batch conventionally.", "table", target.Staging.Table, "error", err)
+	}
+
+	err := s.settings.writeRetryPolicy().retry(ctx, s.log, time.Time{}, func() error {
+		bound = bindBatch(batch, acks, bind)
+		return writeAttempt(ctx, conn, bound, "could not stage record", nil, false)
+	})
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "could not stage records")
+	}
+
+	for i, ack := range acks {
+		rejected[i] = ack.Error != ""
+	}
+	return acks, rejected, nil
+}
+
+// stageDirect inserts the records into the staging table with one direct-path insert of
+// their arrays, in a transaction which is rolled back if any of them cannot be inserted.
+func stageDirect(ctx context.Context, conn *sql.Conn, query string, bound []boundRecord) error {
+	args, ok := arrayArgs(bound)
+	if !ok {
+		return errors.New("the values of the records cannot be bound as arrays")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+// mergeStaged merges the staging table into the table, and calls the post-load procedure,
+// in one transaction, which is rolled back instead of being committed by a dry run.
+func (s *Server) mergeStaged(ctx context.Context, conn *sql.Conn, target TableWriteMeta) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithMessage(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	for _, statement := range stagingMerge(target) {
+		result, err := tx.ExecContext(ctx, statement)
+		if err != nil {
+			return errors.WithMessage(err, "could not merge")
+		}
+		if rows, err := result.RowsAffected(); err == nil {
+			s.log.Debug("Merged staged records.", "table", target.Table, "rows", rows)
+		}
+	}
+
+	if procedure := target.Staging.PostLoadProcedure; procedure != "" {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("BEGIN %s; END;", procedure)); err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("could not call post-load procedure %s", procedure))
+		}
+	}
+
+	if s.WriteSettings.DryRun {
+		s.log.Info("Dry run of bulk load finished, the merge was rolled back.", "table", target.Table, "elapsed", time.Since(start))
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.WithMessage(err, "could not commit")
+	}
+	s.log.Info("Merged staged records.", "table", target.Table, "elapsed", time.Since(start))
+	return nil
+}
+
+// ackStaged acknowledges the records in the staging table in the order they were staged,
+// with the error of the merge if it failed.
+func (s *Server) ackStaged(ctx context.Context, conn *sql.Conn, stream pub.Publisher_WriteStreamServer, staging StagingWriteMeta, failure string) error {
+	query := fmt.Sprintf(`SELECT "%s" FROM %s ORDER BY "%s"`, stagingCorrelationID, staging.Table, stagingSequence)
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return errors.Errorf("could not read the staged records from %s: %s", staging.Table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var correlationID sql.NullString
+		if err := rows.Scan(&correlationID); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := stream.Send(&pub.RecordAck{CorrelationId: correlationID.String, Error: failure}); err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(rows.Err())
+}
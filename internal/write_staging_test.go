@@ -0,0 +1,371 @@
+package internal_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Staged bulk load", func() {
+
+	var (
+		sut     pub.PublisherServer
+		db      *fakeDB
+		schema  *pub.Schema
+		staging StagingWriteMeta
+	)
+
+	load := func(stream pub.Publisher_WriteStreamServer, commitSLA int32) {
+		meta, err := json.Marshal(staging)
+		Expect(err).ToNot(HaveOccurred())
+		schema.PublisherMetaJson = fmt.Sprintf(`{"write":{"target":"Table","table":{
+			"table":"\"C##NAVEEGO\".\"AGENTS\"",
+			"columns":["AGENT_CODE","COMMISSION"],
+			"keyColumns":["AGENT_CODE"],
+			"batchSize":2,
+			"staging":%s}}}`, meta)
+
+		Expect(writeStreamBack(sut, schema, commitSLA, stream)).To(Succeed())
+	}
+
+	write := func(records ...*pub.Record) []*pub.RecordAck {
+		stream := &writeStream{records: records}
+		load(stream, 60)
+		return stream.recordAcks
+	}
+
+	// executed returns the statements executed, other than inserts, by the first word of each.
+	executed := func() []string {
+		var statements []string
+		for _, e := range db.Executions() {
+			if !strings.HasPrefix(e.query, "INSERT") {
+				statements = append(statements, strings.Join(strings.Fields(e.query)[:3], " "))
+			}
+		}
+		return statements
+	}
+
+	// stagedRows returns the values of the AGENT_CODE of the rows committed to the staging table.
+	stagedRows := func() []interface{} {
+		var codes []interface{}
+		for _, row := range db.Committed() {
+			if strings.Contains(row.query, "INTO "+staging.Table) {
+				codes = append(codes, row.values["p1"])
+			}
+		}
+		return codes
+	}
+
+	BeforeEach(func() {
+		sut, db = newWriteServer(hclog.NewNullLogger())
+
+		schema = &pub.Schema{
+			Id: `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{
+				{Id: "code", Type: pub.PropertyType_STRING, IsKey: true},
+				{Id: "commission", Type: pub.PropertyType_FLOAT},
+			},
+		}
+		staging = StagingWriteMeta{Table: `"C##NAVEEGO"."AGENTS_STAGE"`, PostLoadProcedure: `"C##NAVEEGO"."REFRESH_AGENTS"`}
+
+		// the staged records are read back in the order they were staged, without the
+		// records read back before, which were cleared once they were acknowledged
+		var cleared int
+		db.query = func(query string, args map[string]interface{}) ([]string, [][]driver.Value) {
+			if !strings.Contains(query, `"NAVEEGO_CORRELATION_ID" FROM `+staging.Table) {
+				return nil, nil
+			}
+			seq := func(row fakeRow) int {
+				n, _ := strconv.Atoi(fmt.Sprint(row.values["seq"]))
+				return n
+			}
+			var rows []fakeRow
+			for _, row := range db.Committed() {
+				if strings.HasPrefix(row.query, "INSERT") && strings.Contains(row.query, staging.Table) && seq(row) > cleared {
+					rows = append(rows, row)
+				}
+			}
+			sort.Slice(rows, func(i, j int) bool { return seq(rows[i]) < seq(rows[j]) })
+
+			var values [][]driver.Value
+			for _, row := range rows {
+				values = append(values, []driver.Value{row.values["correlation_id"]})
+				cleared = seq(row)
+			}
+			return []string{"NAVEEGO_CORRELATION_ID"}, values
+		}
+	})
+
+	It("should generate the statements which create, load and merge the staging table", func() {
+		target := TableWriteMeta{
+			Table:      `"C##NAVEEGO"."ORDER_LINES"`,
+			Columns:    []string{"ORDER_ID", "LINE_NO", "Product Name", "QTY$"},
+			KeyColumns: []string{"ORDER_ID", "LINE_NO"},
+			Staging:    &StagingWriteMeta{Table: `"C##NAVEEGO"."ORDER_LINES_STAGE"`},
+		}
+		expectGolden("staging/create.sql", StagingDDL(target, []string{"Product Name"}))
+		expectGolden("staging/statements.sql", StagingStatements(target))
+	})
+
+	It("should create the staging table when the write is configured", func() {
+		db.query = func(query string, args map[string]interface{}) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "ALL_TAB_COLUMNS"):
+				return []string{"COLUMN_NAME", "DATA_TYPE", "DATA_LENGTH", "DATA_PRECISION", "DATA_SCALE", "NULLABLE", "CONSTRAINT_TYPE"}, [][]driver.Value{
+					{"AGENT_CODE", "VARCHAR2", int64(4), nil, nil, "N", "P"},
+					{"AGENT_NAME", "VARCHAR2", int64(40), nil, nil, "N", ""},
+				}
+			case strings.Contains(query, "COUNT(*) FROM ALL_TABLES"):
+				return []string{"COUNT(*)"}, [][]driver.Value{{int64(0)}}
+			}
+			return nil, nil
+		}
+
+		response, err := sut.ConfigureWrite(context.Background(), &pub.ConfigureWriteRequest{
+			Form: &pub.ConfigurationFormRequest{DataJson: `{
+				"target":"Table",
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":[{"column":"AGENT_CODE","property":"code"},{"column":"AGENT_NAME","property":"name"}],
+				"keyColumns":["AGENT_CODE"],
+				"stagingTable":"\"C##NAVEEGO\".\"AGENTS_STAGE\"",
+				"createStagingTable":true,
+				"directPath":true}`},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Form.Errors).To(BeEmpty())
+
+		Expect(executed()).To(Equal([]string{
+			"CREATE GLOBAL TEMPORARY",
+			`ALTER TABLE "C##NAVEEGO"."AGENTS_STAGE"`,
+			"COMMENT ON TABLE",
+		}))
+		Expect(response.Schema.PublisherMetaJson).To(ContainSubstring(`"staging":{"table":"\"C##NAVEEGO\".\"AGENTS_STAGE\"","directPath":true}`))
+	})
+
+	It("should reject write options which a bulk load cannot honour", func() {
+		db.query = func(query string, args map[string]interface{}) ([]string, [][]driver.Value) {
+			if strings.Contains(query, "ALL_TAB_COLUMNS") {
+				return []string{"COLUMN_NAME", "DATA_TYPE", "DATA_LENGTH", "DATA_PRECISION", "DATA_SCALE", "NULLABLE", "CONSTRAINT_TYPE"}, [][]driver.Value{
+					{"AGENT_CODE", "VARCHAR2", int64(4), nil, nil, "N", "P"},
+				}
+			}
+			return nil, nil
+		}
+
+		response, err := sut.ConfigureWrite(context.Background(), &pub.ConfigureWriteRequest{
+			Form: &pub.ConfigurationFormRequest{DataJson: `{
+				"target":"Table",
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":[{"column":"AGENT_CODE","property":"code"}],
+				"keyColumns":["AGENT_CODE"],
+				"stagingTable":"\"C##NAVEEGO\".\"AGENTS_STAGE\"",
+				"idempotencyTable":"\"C##NAVEEGO\".\"AGENTS_SEEN\"",
+				"writeConcurrency":4}`},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Form.Errors).To(ConsistOf(
+			"records loaded through a staging table cannot be tracked in an idempotency table",
+			"records loaded through a staging table cannot be written concurrently",
+		))
+	})
+
+	It("should merge the staged records once the stream ends and then acknowledge them", func() {
+		acks := write(
+			writeRecord(pub.Record_UPSERT, "1", `{"code":"A001","commission":0.1}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"A002","commission":0.2}`),
+			writeRecord(pub.Record_DELETE, "3", `{"code":"A003"}`),
+			writeRecord(pub.Record_INSERT, "4", `{"code":"A004","commission":"high"}`),
+			writeRecord(pub.Record_UPSERT, "5", `{"code":"A001","commission":0.15}`),
+		)
+
+		Expect(acks).To(HaveLen(5))
+		Expect(acks[0].CorrelationId).To(Equal("4"))
+		Expect(acks[0].Error).To(HavePrefix("could not convert record"))
+		var ids []string
+		for _, ack := range acks[1:] {
+			Expect(ack.Error).To(BeEmpty())
+			ids = append(ids, ack.CorrelationId)
+		}
+		Expect(ids).To(Equal([]string{"1", "2", "3", "5"}))
+
+		Expect(stagedRows()).To(Equal([]interface{}{"A001", "A002", "A003", "A001"}))
+		Expect(executed()).To(Equal([]string{
+			"DELETE FROM " + staging.Table,
+			`DELETE FROM "C##NAVEEGO"."AGENTS"`,
+			`MERGE INTO "C##NAVEEGO"."AGENTS"`,
+			`BEGIN "C##NAVEEGO"."REFRESH_AGENTS"; END;`,
+			"DELETE FROM " + staging.Table,
+		}))
+
+		var merged bool
+		for _, row := range db.Committed() {
+			merged = merged || strings.HasPrefix(row.query, "MERGE")
+		}
+		Expect(merged).To(BeTrue(), "the merge should have been committed")
+	})
+
+	It("should merge and acknowledge the staged records each time there are the merge size of them", func() {
+		staging.MergeSize = 2
+		var records []*pub.Record
+		for i := 1; i <= 5; i++ {
+			records = append(records, writeRecord(pub.Record_UPSERT, fmt.Sprint(i), fmt.Sprintf(`{"code":"A%03d","commission":0.1}`, i)))
+		}
+
+		stream := newOpenStream(records, 4)
+		load(stream, 60)
+
+		Expect(stream.ackedBeforeEnd).To(Equal(4), "the records merged before the stream ended should have been acknowledged")
+		var ids []string
+		for _, ack := range stream.recordAcks {
+			Expect(ack.Error).To(BeEmpty())
+			ids = append(ids, ack.CorrelationId)
+		}
+		Expect(ids).To(Equal([]string{"1", "2", "3", "4", "5"}))
+
+		var merges []string
+		for _, row := range db.Committed() {
+			if strings.HasPrefix(row.query, "MERGE") {
+				merges = append(merges, row.query)
+			}
+		}
+		Expect(merges).To(HaveLen(3))
+		Expect(stagedRows()).To(Equal([]interface{}{"A001", "A002", "A003", "A004", "A005"}))
+	})
+
+	It("should merge and acknowledge the staged records once the batch window has elapsed", func() {
+		stream := newOpenStream([]*pub.Record{writeRecord(pub.Record_UPSERT, "1", `{"code":"A001","commission":0.1}`)}, 1)
+		load(stream, 1)
+
+		Expect(stream.ackedBeforeEnd).To(Equal(1), "the record should have been acknowledged before the stream ended")
+		Expect(stream.recordAcks).To(HaveLen(1))
+		Expect(stream.recordAcks[0].Error).To(BeEmpty())
+	})
+
+	It("should acknowledge the staged records with the error of a merge which fails", func() {
+		db.fail = func(query string, row map[string]interface{}) error {
+			if strings.HasPrefix(query, "MERGE") {
+				return errors.New("ORA-01400: cannot insert NULL into (\"C##NAVEEGO\".\"AGENTS\".\"AGENT_NAME\")")
+			}
+			return nil
+		}
+
+		acks := write(
+			writeRecord(pub.Record_UPSERT, "1", `{"code":"A001","commission":0.1}`),
+			writeRecord(pub.Record_UPSERT, "2", `{"code":"A002","commission":0.2}`),
+		)
+
+		Expect(acks).To(HaveLen(2))
+		for _, ack := range acks {
+			Expect(ack.Error).To(Equal("could not merge staged records: could not merge: ORA-01400: cannot insert NULL into (\"C##NAVEEGO\".\"AGENTS\".\"AGENT_NAME\")"))
+		}
+		Expect(executed()).ToNot(ContainElement(HavePrefix("BEGIN")))
+	})
+
+	It("should stage a batch which fails a direct-path insert conventionally", func() {
+		staging.DirectPath = true
+		db.fail = func(query string, row map[string]interface{}) error {
+			if strings.HasPrefix(query, "INSERT") && row["p1"] == "A002-TOO-LONG" {
+				return errors.New("ORA-12899: value too large for column \"C##NAVEEGO\".\"AGENTS_STAGE\".\"AGENT_CODE\" (actual: 13, maximum: 4)")
+			}
+			return nil
+		}
+
+		acks := write(
+			writeRecord(pub.Record_UPSERT, "1", `{"code":"A001","commission":0.1}`),
+			writeRecord(pub.Record_UPSERT, "2", `{"code":"A002-TOO-LONG","commission":0.2}`),
+			writeRecord(pub.Record_UPSERT, "3", `{"code":"A003","commission":0.3}`),
+		)
+
+		Expect(acks).To(HaveLen(3))
+		Expect(acks[0].CorrelationId).To(Equal("2"))
+		Expect(acks[0].Error).To(HavePrefix("could not stage record: ORA-12899"))
+		Expect(acks[1].Error).To(BeEmpty())
+		Expect(acks[2].Error).To(BeEmpty())
+
+		var direct int
+		for _, e := range db.Executions() {
+			if strings.HasPrefix(e.query, "INSERT /*+ APPEND_VALUES */") {
+				direct++
+			}
+		}
+		Expect(direct).To(Equal(2))
+		Expect(stagedRows()).To(Equal([]interface{}{"A001", "A003"}))
+	})
+
+	It("should roll back the merge of a dry run", func() {
+		write := func(records ...*pub.Record) []*pub.RecordAck {
+			meta, _ := json.Marshal(staging)
+			schema.PublisherMetaJson = fmt.Sprintf(`{"write":{"target":"Table","table":{
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":["AGENT_CODE","COMMISSION"],
+				"keyColumns":["AGENT_CODE"],
+				"staging":%s},
+				"dryRun":true}}`, meta)
+			return writeBack(sut, schema, records...)
+		}
+
+		acks := write(writeRecord(pub.Record_UPSERT, "1", `{"code":"A001","commission":0.1}`))
+
+		Expect(acks).To(HaveLen(1))
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(executed()).To(ContainElement(`MERGE INTO "C##NAVEEGO"."AGENTS"`))
+		for _, row := range db.Committed() {
+			Expect(row.query).ToNot(HavePrefix("MERGE"))
+		}
+	})
+})
+
+// openStream is a write stream which stays open after sending its records until it has
+// received the acks it waits for, or a few seconds have passed.
+type openStream struct {
+	*writeStream
+	mu    sync.Mutex
+	wait  int
+	acked chan struct{}
+	// ackedBeforeEnd is the number of acks received before the stream ended.
+	ackedBeforeEnd int
+}
+
+func newOpenStream(records []*pub.Record, wait int) *openStream {
+	return &openStream{writeStream: &writeStream{records: records}, wait: wait, acked: make(chan struct{})}
+}
+
+func (o *openStream) Send(ack *pub.RecordAck) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.writeStream.Send(ack); err != nil {
+		return err
+	}
+	if len(o.recordAcks) == o.wait {
+		close(o.acked)
+	}
+	return nil
+}
+
+func (o *openStream) Recv() (*pub.Record, error) {
+	if o.index < len(o.records) {
+		return o.writeStream.Recv()
+	}
+	select {
+	case <-o.acked:
+	case <-time.After(5 * time.Second):
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ackedBeforeEnd = len(o.recordAcks)
+	return nil, io.EOF
+}
@@ -0,0 +1,483 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// TableColumn maps a column of the table written to to the property written to it.
+type TableColumn struct {
+	Column string `json:"column"`
+	// Property is the name of the property, which is the column name if it is not set.
+	Property string `json:"property,omitempty"`
+	// Type is the type of the property, such as STRING, which chooses the type of the
+	// column if the table is created. It is STRING if it is not set.
+	Type string `json:"type,omitempty"`
+	// Length is the length of a string column if the table is created.
+	Length int `json:"length,omitempty"`
+	// Required makes the column NOT NULL if the table is created.
+	Required bool `json:"required,omitempty"`
+}
+
+// tableWriteFormBranch returns the part of the ConfigureWrite form for writing to one of the tables.
+func tableWriteFormBranch(tables []*pub.Schema) string {
+	var ids []string
+	for _, t := range tables {
+		ids = append(ids, t.Id)
+	}
+	enum, _ := json.Marshal(ids)
+
+	var names []string
+	for t := pub.PropertyType_STRING; t <= pub.PropertyType_XML; t++ {
+		names = append(names, t.String())
+	}
+	types, _ := json.Marshal(names)
+
+	return fmt.Sprintf(`{
+  "properties": {
+    "target": {
+      "enum": [%q]
+    },
+    "table": {
+      "type": "string",
+      "title": "Table",
+      "description": "The table each record is written to. Upserts are merged into the table, inserts, updates and deletes are written as they are.",
+      "enum": %s
+    },
+    "newTable": {
+      "type": "string",
+      "title": "New Table",
+      "description": "A table, as \"OWNER\".\"TABLE\", to write to instead of the table above. It is created from the columns if it does not exist, with a column of the type of each property and a primary key of the key columns."
+    },
+    "tablespace": {
+      "type": "string",
+      "title": "Tablespace",
+      "description": "The tablespace the new table is created in, which is the owner's default tablespace if it is not set."
+    },
+    "compression": {
+      "type": "string",
+      "title": "Compression",
+      "description": "How the rows of the new table are compressed. Advanced compression requires the Advanced Compression option.",
+      "enum": ["None", "Basic", "Advanced"],
+      "default": "None"
+    },
+    "addColumns": {
+      "type": "boolean",
+      "title": "Add Columns",
+      "description": "Adds a column to the table for each property which is added to the schema later, when the schema is written back.",
+      "default": false
+    },
+    "stagingTable": {
+      "type": "string",
+      "title": "Staging Table",
+      "description": "A table, as \"OWNER\".\"TABLE\", to bulk load the records into before they are merged into the table. The records are inserted with array binds on one session, and once half the commit SLA has passed since the oldest of them arrived, once enough of them have been staged, or once every record has been sent, the last record staged for each row is merged into the table in one transaction, after which the records are acknowledged. Inserts and updates are merged as upserts. Leave it empty to write each batch of records to the table as it arrives."
+    },
+    "createStagingTable": {
+      "type": "boolean",
+      "title": "Create Staging Table",
+      "description": "Creates the staging table, as a global temporary table with the columns written to, if it does not exist. A staging table which is not temporary must not be loaded by two schemas at the same time.",
+      "default": false
+    },
+    "directPath": {
+      "type": "boolean",
+      "title": "Direct-Path Load",
+      "description": "Inserts the records into the staging table with direct-path inserts, which are faster for large batches.",
+      "default": false
+    },
+    "postLoadProcedure": {
+      "type": "string",
+      "title": "Post-Load Procedure",
+      "description": "A stored procedure without arguments to call after the staged records have been merged, in the same transaction."
+    },
+    "mergeSize": {
+      "type": "integer",
+      "title": "Merge Size",
+      "description": "The number of staged records which are merged without waiting for more.",
+      "default": 100000,
+      "minimum": 1
+    },
+    "columns": {
+      "type": "array",
+      "title": "Columns",
+      "description": "The columns written to, and the property written to each. If there are none, every column is written to from the property with its name.",
+      "items": {
+        "type": "object",
+        "properties": {
+          "column": {
+            "type": "string",
+            "title": "Column"
+          },
+          "property": {
+            "type": "string",
+            "title": "Property",
+            "description": "The name of the property, which is the name of the column if it is not set."
+          },
+          "type": {
+            "type": "string",
+            "title": "Type",
+            "description": "The type of the property, which chooses the type of the column of a new table.",
+            "enum": %s,
+            "default": "STRING"
+          },
+          "length": {
+            "type": "integer",
+            "title": "Length",
+            "description": "The length of a string column of a new table. Longer strings must be TEXT, which is created as a CLOB.",
+            "default": %d,
+            "minimum": 1,
+            "maximum": %d
+          },
+          "required": {
+            "type": "boolean",
+            "title": "Required",
+            "description": "Makes the column of a new table NOT NULL.",
+            "default": false
+          }
+        }
+      }
+    },
+    "keyColumns": {
+      "type": "array",
+      "title": "Key Columns",
+      "description": "The columns which identify the row a record is written to. If there are none, the primary key is used.",
+      "items": {
+        "type": "string"
+      }
+    },
+    "batchSize": {
+      "type": "integer",
+      "title": "Batch Size",
+      "description": "The most records written in one transaction. Records are acknowledged once their transaction has been committed.",
+      "default": %d,
+      "minimum": 1
+    }
+  }
+}`, WriteTargetTable, enum, types, defaultStringLength, maxStringLength, defaultWriteBatchSize)
+}
+
+// getAllTables returns the tables which can be written to.
+func (s *Server) getAllTables() ([]*pub.Schema, error) {
+	shapes, err := s.getAllShapesFromSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []*pub.Schema
+	for _, shape := range shapes {
+		if schemaQueue(shape) == nil {
+			tables = append(tables, shape)
+		}
+	}
+	return tables, nil
+}
+
+// configureTableWrite returns the schema for writing to the table chosen in the form,
+// creating the table if the form names a new table which does not exist.
+func (s *Server) configureTableWrite(formData ConfigureWriteFormData) (*pub.Schema, []string) {
+	name := formData.Table
+	if formData.NewTable != "" {
+		name = formData.NewTable
+	}
+	schema := &pub.Schema{
+		Id:                name,
+		DataFlowDirection: pub.Schema_WRITE,
+	}
+
+	target := TableWriteMeta{
+		KeyColumns: formData.KeyColumns,
+		BatchSize:  formData.BatchSize,
+		AddColumns: formData.AddColumns,
+	}
+	if target.BatchSize == 0 {
+		target.BatchSize = defaultWriteBatchSize
+	}
+	if target.BatchSize < 0 {
+		return schema, []string{"the batch size must not be negative"}
+	}
+
+	owner, table := decomposeSafeName(name)
+	if owner == "" || table == "" {
+		return schema, []string{fmt.Sprintf("table %q is not a qualified table name", name)}
+	}
+	target.Table = fmt.Sprintf(`"%s"."%s"`, owner, table)
+
+	shape := &pub.Schema{Id: target.Table}
+	if err := s.populateShapeColumns(shape); err != nil {
+		return schema, []string{fmt.Sprintf("could not read the columns of %s: %s", target.Table, err)}
+	}
+	if len(shape.Properties) == 0 && formData.NewTable != "" {
+		if err := s.createWriteTable(target.Table, formData); err != nil {
+			return schema, []string{err.Error()}
+		}
+		if err := s.populateShapeColumns(shape); err != nil {
+			return schema, []string{fmt.Sprintf("could not read the columns of %s: %s", target.Table, err)}
+		}
+	}
+	if len(shape.Properties) == 0 {
+		return schema, []string{fmt.Sprintf("table %s does not exist", target.Table)}
+	}
+
+	columns := formData.Columns
+	if len(columns) == 0 {
+		for _, p := range shape.Properties {
+			columns = append(columns, TableColumn{Column: p.Name})
+		}
+	}
+
+	var errs []string
+	for _, c := range columns {
+		var column *pub.Property
+		for _, p := range shape.Properties {
+			if p.Name == c.Column {
+				column = p
+			}
+		}
+		if column == nil {
+			errs = append(errs, fmt.Sprintf("column %q is not in %s", c.Column, target.Table))
+			continue
+		}
+
+		name := c.Property
+		if name == "" {
+			name = c.Column
+		}
+		target.Columns = append(target.Columns, column.Name)
+		schema.Properties = append(schema.Properties, &pub.Property{
+			Id:           name,
+			Name:         name,
+			TypeAtSource: column.TypeAtSource,
+			Type:         column.Type,
+			IsNullable:   column.IsNullable,
+		})
+	}
+	if len(errs) > 0 {
+		return schema, errs
+	}
+
+	if len(target.KeyColumns) == 0 {
+		for _, p := range shape.Properties {
+			if p.IsKey {
+				target.KeyColumns = append(target.KeyColumns, p.Name)
+			}
+		}
+	}
+
+	if _, err := tableStatements(target, schema.Properties); err != nil {
+		return schema, []string{err.Error()}
+	}
+	for i, c := range target.Columns {
+		schema.Properties[i].IsKey = containsString(target.KeyColumns, c)
+	}
+
+	schema.Query = tableStatementList(target, schema.Properties)[0]
+
+	if errs = s.configureStaging(formData, &target, shape); len(errs) > 0 {
+		return schema, errs
+	}
+
+	if err := setSchemaMeta(schema, &SchemaMeta{Write: &WriteMeta{Target: WriteTargetTable, Table: &target}}); err != nil {
+		return schema, []string{err.Error()}
+	}
+	return schema, nil
+}
+
+// tableStatement is a statement which writes a record to a table, with the indexes
+// of the columns whose values are bound to it. The value of each column is bound to
+// the variable :p<n>, where n is the position of the column, in every statement, and
+// converted by bindExpression for the type of the property written to the column.
+type tableStatement struct {
+	query string
+	binds []int
+}
+
+// tableStatements returns the statement which writes each action to the table: a MERGE
+// for an upsert, an INSERT, an UPDATE of the columns which are not keys, and a DELETE.
+func tableStatements(target TableWriteMeta, properties []*pub.Property) (map[pub.Record_Action]tableStatement, error) {
+	if len(target.Columns) == 0 {
+		return nil, errors.Errorf("no columns of %s are written to", target.Table)
+	}
+	if len(target.KeyColumns) == 0 {
+		return nil, errors.Errorf("%s does not have a primary key, so the key columns must be chosen", target.Table)
+	}
+
+	column := make(map[string]int, len(target.Columns))
+	for i, c := range target.Columns {
+		if strings.Contains(c, `"`) {
+			return nil, errors.Errorf("column %s cannot be quoted", c)
+		}
+		if _, ok := column[c]; ok {
+			return nil, errors.Errorf("column %q is written to more than once", c)
+		}
+		column[c] = i
+	}
+
+	var keys, values []int
+	for _, k := range target.KeyColumns {
+		i, ok := column[k]
+		if !ok {
+			return nil, errors.Errorf("key column %q must be written to", k)
+		}
+		keys = append(keys, i)
+	}
+	for i, c := range target.Columns {
+		if !containsString(target.KeyColumns, c) {
+			values = append(values, i)
+		}
+	}
+
+	quoted := func(i int) string { return fmt.Sprintf(`"%s"`, target.Columns[i]) }
+	bind := func(i int) string { return tableBind(properties, i) }
+	all := make([]int, len(target.Columns))
+	for i := range all {
+		all[i] = i
+	}
+
+	var selected, names, sourced, on, set, where, assign []string
+	for _, i := range all {
+		selected = append(selected, fmt.Sprintf("%s AS %s", bind(i), quoted(i)))
+		names = append(names, quoted(i))
+		sourced = append(sourced, fmt.Sprintf("s.%s", quoted(i)))
+	}
+	for _, i := range keys {
+		on = append(on, fmt.Sprintf("t.%s = s.%s", quoted(i), quoted(i)))
+		where = append(where, fmt.Sprintf("%s = %s", quoted(i), bind(i)))
+	}
+	for _, i := range values {
+		set = append(set, fmt.Sprintf("t.%s = s.%s", quoted(i), quoted(i)))
+		assign = append(assign, fmt.Sprintf("%s = %s", quoted(i), bind(i)))
+	}
+
+	var binds []string
+	for _, i := range all {
+		binds = append(binds, bind(i))
+	}
+
+	merge := fmt.Sprintf("MERGE INTO %s t\nUSING (SELECT %s FROM DUAL) s\nON (%s)",
+		target.Table, strings.Join(selected, ", "), strings.Join(on, " AND "))
+	if len(set) > 0 {
+		merge += fmt.Sprintf("\nWHEN MATCHED THEN UPDATE SET %s", strings.Join(set, ", "))
+	}
+	merge += fmt.Sprintf("\nWHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)", strings.Join(names, ", "), strings.Join(sourced, ", "))
+
+	statements := map[pub.Record_Action]tableStatement{
+		pub.Record_UPSERT: {query: merge, binds: all},
+		pub.Record_INSERT: {
+			query: fmt.Sprintf("INSERT INTO %s (%s)\nVALUES (%s)", target.Table, strings.Join(names, ", "), strings.Join(binds, ", ")),
+			binds: all,
+		},
+		pub.Record_DELETE: {
+			query: fmt.Sprintf("DELETE FROM %s\nWHERE %s", target.Table, strings.Join(where, " AND ")),
+			binds: keys,
+		},
+	}
+	// a table whose columns are all keys cannot be updated
+	if len(assign) > 0 {
+		statements[pub.Record_UPDATE] = tableStatement{
+			query: fmt.Sprintf("UPDATE %s\nSET %s\nWHERE %s", target.Table, strings.Join(assign, ", "), strings.Join(where, " AND ")),
+			binds: append(append([]int{}, values...), keys...),
+		}
+	}
+
+	return statements, nil
+}
+
+// tableBind returns the expression the value of the column at the index is bound as.
+func tableBind(properties []*pub.Property, i int) string {
+	bind := fmt.Sprintf(":p%d", i+1)
+	if i < len(properties) {
+		return bindExpression(properties[i].TypeAtSource, bind)
+	}
+	return bind
+}
+
+// tableStatementList returns the queries of the statements for the
+// table in the order of their actions, for display and testing.
+func tableStatementList(target TableWriteMeta, properties []*pub.Property) []string {
+	statements, _ := tableStatements(target, properties)
+	var queries []string
+	for _, action := range []pub.Record_Action{pub.Record_UPSERT, pub.Record_INSERT, pub.Record_UPDATE, pub.Record_DELETE} {
+		if statement, ok := statements[action]; ok {
+			queries = append(queries, statement.query)
+		}
+	}
+	return queries
+}
+
+// tableArgs returns the statement which writes the record to the table and its binds.
+func tableArgs(schema *pub.Schema, statements map[pub.Record_Action]tableStatement, record *pub.Record) (string, []interface{}, error) {
+	statement, ok := statements[record.Action]
+	if !ok {
+		return "", nil, errors.Errorf("cannot write a record with action %s to %s", record.Action, schema.Id)
+	}
+
+	data, err := decodeRecordData(record)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var args []interface{}
+	for _, i := range statement.binds {
+		p := schema.Properties[i]
+		value, err := writeValue(p, data[p.Id])
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, sql.Named(fmt.Sprintf("p%d", i+1), value))
+	}
+
+	return statement.query, args, nil
+}
+
+// writeTable writes each record to the table with the statement for its action.
+func (s *Server) writeTable(stream pub.Publisher_WriteStreamServer, schema *pub.Schema, target TableWriteMeta) error {
+	w, err := s.tableWriter(schema, &target)
+	if err != nil {
+		return err
+	}
+
+	if target.Staging != nil {
+		return s.writeStaged(stream, schema, target)
+	}
+
+	return s.writeBatches(stream, schema, target.BatchSize, w)
+}
+
+// tableWriter returns the writer which writes each record to the table with the statement
+// for its action, first adding the columns of new properties if the target adds them.
+func (s *Server) tableWriter(schema *pub.Schema, target *TableWriteMeta) (batchWriter, error) {
+	// the staging table of a bulk load has the columns the table had when it was created
+	if target.AddColumns && target.Staging == nil && len(schema.Properties) > len(target.Columns) {
+		if err := s.addTableColumns(schema, target); err != nil {
+			return batchWriter{}, err
+		}
+	}
+
+	statements, err := tableStatements(*target, schema.Properties)
+	if err != nil {
+		return batchWriter{}, err
+	}
+	if len(schema.Properties) != len(target.Columns) {
+		return batchWriter{}, errors.Errorf("schema %s has %d properties but writes to %d columns", schema.Id, len(schema.Properties), len(target.Columns))
+	}
+
+	return batchWriter{
+		bind: func(record *pub.Record) (string, []interface{}, error) {
+			return tableArgs(schema, statements, record)
+		},
+		failure: "could not write back",
+	}, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+)
+
+// defaultStringLength is the length of the column created for a string property without a length.
+const defaultStringLength = 255
+
+// maxStringLength is the longest VARCHAR2 column created for a string, as longer
+// columns are read back as text, which is created as a CLOB.
+const maxStringLength = 1023
+
+// timeColumnLength is the length of the column created for a time of day, which holds
+// a time with nanoseconds and a zone.
+const timeColumnLength = 32
+
+// tableCompressions are the compression clauses of a table created for write-back, by
+// the name of the compression chosen in the form.
+var tableCompressions = map[string]string{
+	"":         "",
+	"None":     "",
+	"Basic":    "ROW STORE COMPRESS BASIC",
+	"Advanced": "ROW STORE COMPRESS ADVANCED",
+}
+
+// tableStorage is where a table created for write-back is stored.
+type tableStorage struct {
+	// tablespace is the tablespace of the table, or the owner's default tablespace if it is not set.
+	tablespace string
+	// compression is the compression clause of the table, if any.
+	compression string
+}
+
+// oracleColumn returns the column created for a property. For most types it is the inverse of
+// convertSQLType, and the column is read back as a property of the same type. It is not for the
+// types Oracle has no columns for: booleans are created as NUMBER(1) columns, which are how they
+// are written and are read back as integers, dates as DATE columns, which are read back as
+// datetimes, times of day as strings and JSON as CLOBs, which are read back as text.
+// Integers are limited to 16 digits, which is the most an INTEGER column can have. The length
+// is the length of a string, which has a default length if it is not set.
+func oracleColumn(p *pub.Property, length int) (columnInfo, error) {
+	c := columnInfo{ColumnName: p.Name, NullableChar: "Y"}
+	if p.IsKey {
+		c.ConstraintType = "P"
+	}
+	if p.IsKey || !p.IsNullable {
+		c.NullableChar = "N"
+	}
+
+	if strings.Contains(c.ColumnName, `"`) {
+		return c, errors.Errorf("column %s cannot be quoted", c.ColumnName)
+	}
+
+	number := func(precision, scale int64) {
+		c.DataType = "NUMBER"
+		c.DataPrecision = &precision
+		c.DataScale = &scale
+	}
+	varchar := func(length int64) {
+		c.DataType = "VARCHAR2"
+		c.DataLength = &length
+	}
+
+	switch p.Type {
+	case pub.PropertyType_STRING:
+		if length == 0 {
+			length = defaultStringLength
+		}
+		if length < 1 || length > maxStringLength {
+			return c, errors.Errorf("the length of column %s must be between 1 and %d, longer strings are TEXT", c.ColumnName, maxStringLength)
+		}
+		varchar(int64(length))
+	case pub.PropertyType_TEXT, pub.PropertyType_JSON:
+		c.DataType = "CLOB"
+	case pub.PropertyType_INTEGER:
+		number(16, 0)
+	case pub.PropertyType_BOOL:
+		number(1, 0)
+	case pub.PropertyType_DECIMAL:
+		c.DataType = "NUMBER"
+	case pub.PropertyType_FLOAT:
+		c.DataType = "BINARY_DOUBLE"
+	case pub.PropertyType_DATE:
+		c.DataType = "DATE"
+	case pub.PropertyType_DATETIME:
+		c.DataType = "TIMESTAMP WITH TIME ZONE"
+	case pub.PropertyType_TIME:
+		varchar(timeColumnLength)
+	case pub.PropertyType_BLOB:
+		c.DataType = "BLOB"
+	case pub.PropertyType_XML:
+		c.DataType = "XMLTYPE"
+	default:
+		return c, errors.Errorf("column %s cannot be created for a property of type %s", c.ColumnName, p.Type)
+	}
+
+	return c, nil
+}
+
+// columnDefinition returns the definition of a column in a CREATE or ALTER TABLE statement.
+func columnDefinition(c columnInfo) string {
+	definition := fmt.Sprintf(`"%s" %s`, c.ColumnName, c.TypeAtSource())
+	if !c.Nullable() {
+		definition += " NOT NULL"
+	}
+	return definition
+}
+
+// createTableDDL returns the statement which creates a table with the columns, whose
+// primary key is the key columns.
+func createTableDDL(table string, columns []columnInfo, storage tableStorage) string {
+	var definitions, keys []string
+	for _, c := range columns {
+		definitions = append(definitions, "  "+columnDefinition(c))
+		if c.IsKey() {
+			keys = append(keys, fmt.Sprintf(`"%s"`, c.ColumnName))
+		}
+	}
+	if len(keys) > 0 {
+		definitions = append(definitions, fmt.Sprintf("  PRIMARY KEY (%s)", strings.Join(keys, ", ")))
+	}
+
+	ddl := fmt.Sprintf("CREATE TABLE %s\n(\n%s\n)", table, strings.Join(definitions, ",\n"))
+	if storage.tablespace != "" {
+		ddl += fmt.Sprintf("\nTABLESPACE \"%s\"", storage.tablespace)
+	}
+	if storage.compression != "" {
+		ddl += "\n" + storage.compression
+	}
+	return ddl
+}
+
+// addColumnsDDL returns the statement which adds the columns to a table.
+func addColumnsDDL(table string, columns []columnInfo) string {
+	var definitions []string
+	for _, c := range columns {
+		definitions = append(definitions, "  "+columnDefinition(c))
+	}
+	return fmt.Sprintf("ALTER TABLE %s ADD\n(\n%s\n)", table, strings.Join(definitions, ",\n"))
+}
+
+// createWriteTable creates the table from the columns of the form, with a
+// column of the type chosen for each and a primary key of the key columns.
+func (s *Server) createWriteTable(table string, formData ConfigureWriteFormData) error {
+	if len(formData.Columns) == 0 {
+		return errors.Errorf("the columns of %s must be chosen to create it", table)
+	}
+	if len(formData.KeyColumns) == 0 {
+		return errors.Errorf("the key columns of %s must be chosen to create it", table)
+	}
+
+	storage := tableStorage{tablespace: formData.Tablespace}
+	if strings.Contains(storage.tablespace, `"`) {
+		return errors.Errorf("tablespace %s cannot be quoted", storage.tablespace)
+	}
+	var ok bool
+	if storage.compression, ok = tableCompressions[formData.Compression]; !ok {
+		return errors.Errorf("unknown compression %q", formData.Compression)
+	}
+
+	var columns []columnInfo
+	for _, c := range formData.Columns {
+		t := pub.PropertyType_STRING
+		if c.Type != "" {
+			value, ok := pub.PropertyType_value[strings.ToUpper(c.Type)]
+			if !ok {
+				return errors.Errorf("column %s has unknown type %q", c.Column, c.Type)
+			}
+			t = pub.PropertyType(value)
+		}
+
+		column, err := oracleColumn(&pub.Property{
+			Name:       c.Column,
+			Type:       t,
+			IsKey:      containsString(formData.KeyColumns, c.Column),
+			IsNullable: !c.Required,
+		}, c.Length)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, column)
+	}
+
+	if _, err := s.db.Exec(createTableDDL(table, columns, storage)); err != nil {
+		return errors.Errorf("could not create %s: %s", table, err)
+	}
+	s.log.Info("Created table for write-back.", "table", table, "columns", len(columns))
+
+	return nil
+}
+
+// addTableColumns adds a column to the table for each property of the schema after the
+// columns written to, as when properties have been added to the schema since it was
+// configured, and writes those properties to the columns. A property is written to the
+// column with its name, which is created, as a nullable column, if it does not exist.
+func (s *Server) addTableColumns(schema *pub.Schema, target *TableWriteMeta) error {
+	shape := &pub.Schema{Id: target.Table}
+	if err := s.populateShapeColumns(shape); err != nil {
+		return errors.Errorf("could not read the columns of %s: %s", target.Table, err)
+	}
+
+	var added []columnInfo
+	var columns []string
+	for _, p := range schema.Properties[len(target.Columns):] {
+		name := p.Name
+		if name == "" {
+			name = strings.Trim(p.Id, `"`)
+		}
+		columns = append(columns, name)
+
+		exists := false
+		for _, existing := range shape.Properties {
+			exists = exists || existing.Name == name
+		}
+		if exists {
+			continue
+		}
+
+		column, err := oracleColumn(&pub.Property{Name: name, Type: p.Type, IsNullable: true}, 0)
+		if err != nil {
+			return err
+		}
+		added = append(added, column)
+	}
+
+	if len(added) > 0 {
+		if _, err := s.db.Exec(addColumnsDDL(target.Table, added)); err != nil {
+			return errors.Errorf("could not add columns to %s: %s", target.Table, err)
+		}
+		s.log.Info("Added columns for new properties to table.", "table", target.Table, "columns", len(added))
+	}
+
+	target.Columns = append(target.Columns, columns...)
+	return nil
+}
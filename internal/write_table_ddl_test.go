@@ -0,0 +1,230 @@
+package internal_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Table write back DDL", func() {
+
+	DescribeTable("the column created for each type of property",
+		func(t pub.PropertyType, length int, column string, readBack pub.PropertyType) {
+			p := &pub.Property{Name: "VALUE", Type: t}
+			Expect(OracleColumnType(p, length)).To(Equal(column))
+			Expect(ReadBackType(p)).To(Equal(readBack))
+		},
+		Entry("string", pub.PropertyType_STRING, 0, "VARCHAR2(255)", pub.PropertyType_STRING),
+		Entry("string with a length", pub.PropertyType_STRING, 40, "VARCHAR2(40)", pub.PropertyType_STRING),
+		Entry("string with the longest length", pub.PropertyType_STRING, 1023, "VARCHAR2(1023)", pub.PropertyType_STRING),
+		Entry("text", pub.PropertyType_TEXT, 0, "CLOB", pub.PropertyType_TEXT),
+		Entry("integer", pub.PropertyType_INTEGER, 0, "NUMBER(16,0)", pub.PropertyType_INTEGER),
+		Entry("decimal", pub.PropertyType_DECIMAL, 0, "NUMBER", pub.PropertyType_DECIMAL),
+		Entry("float", pub.PropertyType_FLOAT, 0, "BINARY_DOUBLE", pub.PropertyType_FLOAT),
+		Entry("datetime", pub.PropertyType_DATETIME, 0, "TIMESTAMP WITH TIME ZONE", pub.PropertyType_DATETIME),
+		Entry("blob", pub.PropertyType_BLOB, 0, "BLOB", pub.PropertyType_BLOB),
+		Entry("xml", pub.PropertyType_XML, 0, "XMLTYPE", pub.PropertyType_XML),
+		Entry("boolean, which is written as a number", pub.PropertyType_BOOL, 0, "NUMBER(1,0)", pub.PropertyType_INTEGER),
+		Entry("date, which is read as a datetime", pub.PropertyType_DATE, 0, "DATE", pub.PropertyType_DATETIME),
+		Entry("time, which is read as a string", pub.PropertyType_TIME, 0, "VARCHAR2(32)", pub.PropertyType_STRING),
+		Entry("JSON, which is read as text", pub.PropertyType_JSON, 0, "CLOB", pub.PropertyType_TEXT),
+	)
+
+	DescribeTable("the column created for the type read from each column",
+		func(dataType string, size ...int64) {
+			t := ConvertSQLType(dataType, size...)
+			Expect(ReadBackType(&pub.Property{Name: "VALUE", Type: t})).To(Equal(t))
+		},
+		Entry("VARCHAR2", "VARCHAR2", int64(100)),
+		Entry("long VARCHAR2", "VARCHAR2", int64(2000)),
+		Entry("NVARCHAR2", "NVARCHAR2", int64(20)),
+		Entry("CHAR", "CHAR", int64(4)),
+		Entry("CLOB", "CLOB"),
+		Entry("NCLOB", "NCLOB"),
+		Entry("NUMBER", "NUMBER"),
+		Entry("integer NUMBER", "NUMBER", int64(10), int64(0)),
+		Entry("long integer NUMBER", "NUMBER", int64(19), int64(0)),
+		Entry("decimal NUMBER", "NUMBER", int64(10), int64(2)),
+		Entry("FLOAT", "FLOAT"),
+		Entry("BINARY_FLOAT", "BINARY_FLOAT"),
+		Entry("BINARY_DOUBLE", "BINARY_DOUBLE"),
+		Entry("DATE", "DATE"),
+		Entry("TIMESTAMP", "TIMESTAMP"),
+		Entry("TIMESTAMP WITH TIME ZONE", "TIMESTAMP WITH TIME ZONE"),
+		Entry("TIMESTAMP WITH LOCAL TIME ZONE", "TIMESTAMP WITH LOCAL TIME ZONE"),
+		Entry("BLOB", "BLOB"),
+		Entry("XMLTYPE", "XMLTYPE"),
+		Entry("RAW", "RAW"),
+	)
+
+	It("should create a table with keys, nullability, lengths, a tablespace and compression", func() {
+		ddl, err := CreateTableDDL(`"C##NAVEEGO"."LANDING_ORDERS"`, []*pub.Property{
+			{Name: "ORDER_ID", Type: pub.PropertyType_INTEGER, IsKey: true},
+			{Name: "LINE_NO", Type: pub.PropertyType_INTEGER, IsKey: true},
+			{Name: "CUSTOMER", Type: pub.PropertyType_STRING, IsNullable: false},
+			{Name: "NOTES", Type: pub.PropertyType_TEXT, IsNullable: true},
+			{Name: "AMOUNT", Type: pub.PropertyType_DECIMAL, IsNullable: true},
+			{Name: "SHIPPED_AT", Type: pub.PropertyType_DATETIME, IsNullable: true},
+		}, []int{0, 0, 80, 0, 0, 0}, "LANDING", "Basic")
+		Expect(err).ToNot(HaveOccurred())
+		expectGolden("table/create.sql", []string{ddl})
+	})
+
+	It("should reject columns which cannot be created", func() {
+		_, err := OracleColumnType(&pub.Property{Name: "CODE", Type: pub.PropertyType_STRING}, 4001)
+		Expect(err).To(MatchError(ContainSubstring("must be between 1 and 1023")))
+
+		// a longer VARCHAR2 would be read back as text
+		_, err = OracleColumnType(&pub.Property{Name: "NOTES", Type: pub.PropertyType_STRING}, 2000)
+		Expect(err).To(MatchError(ContainSubstring("must be between 1 and 1023, longer strings are TEXT")))
+
+		_, err = OracleColumnType(&pub.Property{Name: `BAD"NAME`, Type: pub.PropertyType_STRING}, 0)
+		Expect(err).To(MatchError(ContainSubstring("cannot be quoted")))
+	})
+
+	Describe("write back", func() {
+
+		var (
+			sut     pub.PublisherServer
+			db      *fakeDB
+			columns [][]driver.Value
+		)
+
+		// executed returns the statements executed which start with the prefix.
+		executed := func(prefix string) []string {
+			var statements []string
+			for _, e := range db.Executions() {
+				if strings.HasPrefix(e.query, prefix) {
+					statements = append(statements, e.query)
+				}
+			}
+			return statements
+		}
+
+		BeforeEach(func() {
+			sut, db = newWriteServer(hclog.NewNullLogger())
+
+			columns = nil
+			db.query = func(query string, args map[string]interface{}) ([]string, [][]driver.Value) {
+				if !strings.Contains(query, "ALL_TAB_COLUMNS") {
+					return nil, nil
+				}
+				return []string{"COLUMN_NAME", "DATA_TYPE", "DATA_LENGTH", "DATA_PRECISION", "DATA_SCALE", "NULLABLE", "CONSTRAINT_TYPE"}, columns
+			}
+		})
+
+		It("should create a new table from the columns of the form", func() {
+			db.fail = func(query string, row map[string]interface{}) error {
+				if strings.HasPrefix(query, "CREATE TABLE") {
+					columns = [][]driver.Value{
+						{"AGENT_CODE", "VARCHAR2", int64(4), nil, nil, "N", "P"},
+						{"COMMISSION", "NUMBER", int64(22), nil, nil, "Y", ""},
+					}
+				}
+				return nil
+			}
+
+			response, err := sut.ConfigureWrite(context.Background(), &pub.ConfigureWriteRequest{
+				Form: &pub.ConfigurationFormRequest{DataJson: `{
+					"target":"Table",
+					"newTable":"\"C##NAVEEGO\".\"LANDING_AGENTS\"",
+					"columns":[
+						{"column":"AGENT_CODE","property":"code","length":4},
+						{"column":"COMMISSION","property":"commission","type":"DECIMAL"}
+					],
+					"keyColumns":["AGENT_CODE"],
+					"tablespace":"LANDING",
+					"compression":"Advanced"}`},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Form.Errors).To(BeEmpty())
+
+			Expect(executed("CREATE TABLE")).To(Equal([]string{`CREATE TABLE "C##NAVEEGO"."LANDING_AGENTS"
+(
+  "AGENT_CODE" VARCHAR2(4) NOT NULL,
+  "COMMISSION" NUMBER,
+  PRIMARY KEY ("AGENT_CODE")
+)
+TABLESPACE "LANDING"
+ROW STORE COMPRESS ADVANCED`}))
+
+			schema := response.Schema
+			Expect(schema.Id).To(Equal(`"C##NAVEEGO"."LANDING_AGENTS"`))
+			Expect(schema.Properties).To(HaveLen(2))
+			Expect(schema.Properties[0].Id).To(Equal("code"))
+			Expect(schema.Properties[0].IsKey).To(BeTrue())
+			Expect(schema.Properties[1].Type).To(Equal(pub.PropertyType_DECIMAL))
+		})
+
+		It("should not create a table which already exists", func() {
+			columns = [][]driver.Value{{"AGENT_CODE", "VARCHAR2", int64(4), nil, nil, "N", "P"}}
+
+			response, err := sut.ConfigureWrite(context.Background(), &pub.ConfigureWriteRequest{
+				Form: &pub.ConfigurationFormRequest{DataJson: `{
+					"target":"Table",
+					"newTable":"\"C##NAVEEGO\".\"LANDING_AGENTS\"",
+					"columns":[{"column":"AGENT_CODE","property":"code"}]}`},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Form.Errors).To(BeEmpty())
+			Expect(executed("CREATE TABLE")).To(BeEmpty())
+		})
+
+		It("should require the key columns of a new table", func() {
+			response, err := sut.ConfigureWrite(context.Background(), &pub.ConfigureWriteRequest{
+				Form: &pub.ConfigurationFormRequest{DataJson: `{
+					"target":"Table",
+					"newTable":"\"C##NAVEEGO\".\"LANDING_AGENTS\"",
+					"columns":[{"column":"AGENT_CODE","property":"code"}]}`},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Form.Errors).To(ConsistOf(`the key columns of "C##NAVEEGO"."LANDING_AGENTS" must be chosen to create it`))
+			Expect(executed("CREATE TABLE")).To(BeEmpty())
+		})
+
+		It("should add columns for properties added to the schema", func() {
+			columns = [][]driver.Value{
+				{"AGENT_CODE", "VARCHAR2", int64(4), nil, nil, "N", "P"},
+				{"AGENT_NAME", "VARCHAR2", int64(40), nil, nil, "Y", ""},
+			}
+			schema := &pub.Schema{
+				Id: `"C##NAVEEGO"."AGENTS"`,
+				Properties: []*pub.Property{
+					{Id: "code", Type: pub.PropertyType_STRING, IsKey: true},
+					{Id: "name", Name: "AGENT_NAME", Type: pub.PropertyType_STRING},
+					{Id: "commission", Name: "COMMISSION", Type: pub.PropertyType_FLOAT},
+				},
+				PublisherMetaJson: `{"write":{"target":"Table","table":{
+					"table":"\"C##NAVEEGO\".\"AGENTS\"",
+					"columns":["AGENT_CODE"],
+					"keyColumns":["AGENT_CODE"],
+					"addColumns":true}}}`,
+			}
+			_, err := sut.PrepareWrite(context.Background(), &pub.PrepareWriteRequest{Schema: schema, CommitSlaSeconds: 60})
+			Expect(err).ToNot(HaveOccurred())
+
+			stream := &writeStream{records: []*pub.Record{{
+				Action:        pub.Record_INSERT,
+				CorrelationId: "1",
+				DataJson:      `{"code":"A001","name":"Alex","commission":0.1}`,
+			}}}
+			Expect(sut.WriteStream(stream)).To(Succeed())
+			Expect(stream.recordAcks).To(HaveLen(1))
+			Expect(stream.recordAcks[0].Error).To(BeEmpty())
+
+			Expect(executed("ALTER TABLE")).To(Equal([]string{`ALTER TABLE "C##NAVEEGO"."AGENTS" ADD
+(
+  "COMMISSION" BINARY_DOUBLE
+)`}))
+			Expect(executed("INSERT")).To(Equal([]string{`INSERT INTO "C##NAVEEGO"."AGENTS" ("AGENT_CODE", "AGENT_NAME", "COMMISSION")
+VALUES (:p1, :p2, :p3)`}))
+		})
+	})
+})
@@ -0,0 +1,111 @@
+package internal_test
+
+import (
+	"database/sql"
+	"time"
+
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/goracle.v2"
+)
+
+var _ = Describe("Table write back", func() {
+
+	var target TableWriteMeta
+
+	BeforeEach(func() {
+		target = TableWriteMeta{
+			Table:      `"C##NAVEEGO"."ORDER_LINES"`,
+			Columns:    []string{"ORDER_ID", "LINE_NO", "Product Name", "QTY$", "SHIPPED_AT"},
+			KeyColumns: []string{"ORDER_ID", "LINE_NO"},
+		}
+	})
+
+	It("should generate a statement for each action", func() {
+		statements, err := TableStatements(target)
+		Expect(err).ToNot(HaveOccurred())
+		expectGolden("table/order_lines.sql", statements)
+	})
+
+	It("should not update a table whose columns are all keys", func() {
+		target.Columns = []string{"ORDER_ID", "LINE_NO"}
+		statements, err := TableStatements(target)
+		Expect(err).ToNot(HaveOccurred())
+		expectGolden("table/keys_only.sql", statements)
+	})
+
+	It("should reject invalid columns", func() {
+		target.KeyColumns = nil
+		_, err := TableStatements(target)
+		Expect(err).To(MatchError(ContainSubstring("the key columns must be chosen")))
+
+		target.KeyColumns = []string{"LINE_ID"}
+		_, err = TableStatements(target)
+		Expect(err).To(MatchError(ContainSubstring(`key column "LINE_ID" must be written to`)))
+
+		target.KeyColumns = []string{"ORDER_ID"}
+		target.Columns = []string{"ORDER_ID", `BAD"NAME`}
+		_, err = TableStatements(target)
+		Expect(err).To(MatchError(ContainSubstring("cannot be quoted")))
+	})
+
+	Describe("binds", func() {
+
+		var schema *pub.Schema
+
+		BeforeEach(func() {
+			schema = &pub.Schema{
+				Id: `"C##NAVEEGO"."ORDER_LINES"`,
+				Properties: []*pub.Property{
+					{Id: "orderId", Type: pub.PropertyType_INTEGER},
+					{Id: "lineNo", Type: pub.PropertyType_INTEGER},
+					{Id: "product", Type: pub.PropertyType_STRING},
+					{Id: "qty", Type: pub.PropertyType_DECIMAL},
+					{Id: "shippedAt", Type: pub.PropertyType_DATETIME},
+				},
+			}
+		})
+
+		It("should bind the values of the columns in the statement for the action", func() {
+			query, args, err := TableArgs(schema, target, &pub.Record{
+				Action:   pub.Record_UPDATE,
+				DataJson: `{"orderId": 7, "lineNo": 1, "product": "Widget", "qty": 2.5, "shippedAt": "2019-03-01T12:00:00Z"}`,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(query).To(HavePrefix(`UPDATE "C##NAVEEGO"."ORDER_LINES"`))
+			Expect(args).To(Equal([]interface{}{
+				sql.Named("p3", "Widget"),
+				sql.Named("p4", goracle.Number("2.5")),
+				sql.Named("p5", time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)),
+				sql.Named("p1", int64(7)),
+				sql.Named("p2", int64(1)),
+			}))
+		})
+
+		It("should only bind the keys of a delete", func() {
+			query, args, err := TableArgs(schema, target, &pub.Record{
+				Action:   pub.Record_DELETE,
+				DataJson: `{"orderId": 7, "lineNo": 1}`,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(query).To(HavePrefix(`DELETE FROM "C##NAVEEGO"."ORDER_LINES"`))
+			Expect(args).To(Equal([]interface{}{
+				sql.Named("p1", int64(7)),
+				sql.Named("p2", int64(1)),
+			}))
+		})
+
+		It("should bind missing properties as nulls", func() {
+			_, args, err := TableArgs(schema, target, &pub.Record{
+				Action:   pub.Record_UPSERT,
+				DataJson: `{"orderId": 7, "lineNo": 1}`,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(HaveLen(5))
+			Expect(args[2]).To(Equal(sql.Named("p3", nil)))
+			Expect(args[4]).To(Equal(sql.Named("p5", nil)))
+		})
+	})
+})
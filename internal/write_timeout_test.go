@@ -0,0 +1,113 @@
+package internal_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Write back within the commit SLA", func() {
+
+	var (
+		sut    pub.PublisherServer
+		db     *fakeDB
+		schema *pub.Schema
+	)
+
+	BeforeEach(func() {
+		sut, db = newWriteServer(hclog.NewNullLogger())
+
+		schema = &pub.Schema{
+			Id:         `"C##NAVEEGO"."AGENTS"`,
+			Properties: []*pub.Property{{Id: "code", Type: pub.PropertyType_STRING}},
+			PublisherMetaJson: `{"write":{"target":"Table","table":{
+				"table":"\"C##NAVEEGO\".\"AGENTS\"",
+				"columns":["AGENT_CODE"],
+				"keyColumns":["AGENT_CODE"],
+				"batchSize":10}}}`,
+		}
+	})
+
+	It("should cancel a statement which does not finish in time and roll back the batch", func() {
+		db.delay = func(query string) time.Duration {
+			if strings.HasPrefix(query, "DELETE") {
+				return time.Minute
+			}
+			return 0
+		}
+
+		start := time.Now()
+		acks := writeBackWithin(sut, schema, 1,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`),
+			writeRecord(pub.Record_DELETE, "2", `{"code":"A002"}`),
+		)
+
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+		for _, ack := range acks {
+			Expect(ack.Error).To(Equal("rolled back because it was not written within the commit SLA: ORA-01013: user requested cancel of current operation"))
+		}
+		Expect(db.Committed()).To(BeEmpty())
+	})
+
+	It("should acknowledge a batch whose commit finished after the deadline as committed", func() {
+		db.commitDelay = 800 * time.Millisecond
+
+		acks := writeBackWithin(sut, schema, 1, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`))
+
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(db.Committed()).To(HaveLen(1))
+	})
+
+	It("should report that the outcome is unknown if the connection is lost while committing", func() {
+		UseSettings(sut, &Settings{Form: &SettingsForm{WriteRetryBackoffMs: 1}, Strategy: StrategyForm})
+		db.commitErr = errors.New("ORA-03113: end-of-file on communication channel")
+
+		acks := writeBackWithin(sut, schema, 1, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`))
+
+		Expect(acks[0].Error).To(Equal("the outcome of the batch is unknown: could not commit: ORA-03113: end-of-file on communication channel"))
+		Expect(db.Executions()).To(HaveLen(1))
+	})
+
+	It("should not retry a transient error after the deadline", func() {
+		UseSettings(sut, &Settings{Form: &SettingsForm{WriteRetryBackoffMs: 900}, Strategy: StrategyForm})
+		db.delay = func(string) time.Duration { return 200 * time.Millisecond }
+		db.fail = func(string, map[string]interface{}) error {
+			return errors.New("ORA-00060: deadlock detected while waiting for resource")
+		}
+
+		acks := writeBackWithin(sut, schema, 1, writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`))
+
+		Expect(acks[0].Error).To(Equal("could not write back: ORA-00060: deadlock detected while waiting for resource"))
+		Expect(db.Executions()).To(HaveLen(1))
+	})
+
+	It("should measure the deadline of a batch from when its first record was received", func() {
+		schema.PublisherMetaJson = strings.Replace(schema.PublisherMetaJson, `"batchSize":10`, `"batchSize":1`, 1)
+		// the second record is received while the first is written, and waits for it
+		var mu sync.Mutex
+		delays := []time.Duration{1300 * time.Millisecond, 850 * time.Millisecond}
+		db.delay = func(string) time.Duration {
+			mu.Lock()
+			defer mu.Unlock()
+			delay := delays[0]
+			delays = delays[1:]
+			return delay
+		}
+
+		acks := writeBackWithin(sut, schema, 2,
+			writeRecord(pub.Record_INSERT, "1", `{"code":"A001"}`),
+			writeRecord(pub.Record_INSERT, "2", `{"code":"A002"}`),
+		)
+
+		Expect(acks).To(HaveLen(2))
+		Expect(acks[0].Error).To(BeEmpty())
+		Expect(acks[1].Error).To(Equal("rolled back because it was not written within the commit SLA: ORA-01013: user requested cancel of current operation"))
+	})
+})
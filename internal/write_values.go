@@ -0,0 +1,449 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naveego/plugin-oracle/internal/pub"
+	"github.com/pkg/errors"
+	"gopkg.in/goracle.v2"
+)
+
+const (
+	// maxStringBind is the longest string bound as a VARCHAR2. Longer strings
+	// written to CLOBs are bound as LOBs.
+	maxStringBind = 4000
+	// maxRawBind is the longest value bound as a RAW. Longer values written
+	// to BLOBs are bound as LOBs.
+	maxRawBind = 2000
+)
+
+var (
+	decimalPattern          = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+	dayToSecondPattern      = regexp.MustCompile(`^[+-]?\d+ \d{1,2}:\d{1,2}:\d{1,2}(\.\d{1,9})?$`)
+	yearToMonthPattern      = regexp.MustCompile(`^[+-]?\d+-\d{1,2}$`)
+	isoDayToSecondPattern   = regexp.MustCompile(`^([+-])?P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d{1,9})?)S)?)?$`)
+	isoYearToMonthPattern   = regexp.MustCompile(`^([+-])?P(?:(\d+)Y)?(?:(\d+)M)?$`)
+	timeLayouts             = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00"}
+	timeLayoutsWithoutZones = []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02"}
+)
+
+// The driver binds times as DATEs, without their fractional seconds and zones, so
+// timestamps are bound as text in these layouts and converted in the statement with
+// the matching formats.
+const (
+	timestampLayout   = "2006-01-02T15:04:05.000000000"
+	timestampTZLayout = timestampLayout + "-07:00"
+	timestampFormat   = `YYYY-MM-DD"T"HH24:MI:SS.FF9`
+	timestampTZFormat = timestampFormat + "TZH:TZM"
+)
+
+// decodeRecordData decodes the data of a record, keeping its numbers as
+// json.Number so they are converted to their properties' types without
+// losing precision.
+func decodeRecordData(record *pub.Record) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(record.DataJson))
+	d.UseNumber()
+	if err := d.Decode(&data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+// writeValue converts a value from a record's data into the value bound for the property,
+// from the type of the property and its type at the source. The error names the property.
+func writeValue(prop *pub.Property, rawValue interface{}) (interface{}, error) {
+	if rawValue == nil {
+		return nil, nil
+	}
+
+	value, err := convertWriteValue(prop, rawValue)
+	if err != nil {
+		target := prop.TypeAtSource
+		if target == "" {
+			target = prop.Type.String()
+		}
+		return nil, errors.Errorf("cannot convert %s value %s to %s: %s", prop.Id, describeValue(rawValue), target, err)
+	}
+	return value, nil
+}
+
+func convertWriteValue(prop *pub.Property, rawValue interface{}) (interface{}, error) {
+	source := strings.ToUpper(prop.TypeAtSource)
+	switch {
+	case strings.HasPrefix(source, "INTERVAL DAY"):
+		return dayToSecondValue(rawValue)
+	case strings.HasPrefix(source, "INTERVAL YEAR"):
+		return yearToMonthValue(rawValue)
+	case source == "CLOB" || source == "NCLOB" || source == "LONG":
+		return clobValue(rawValue, true)
+	}
+
+	switch prop.Type {
+	case pub.PropertyType_INTEGER:
+		return integerValue(rawValue)
+	case pub.PropertyType_DECIMAL:
+		return decimalValue(rawValue)
+	case pub.PropertyType_FLOAT:
+		return floatValue(rawValue)
+	case pub.PropertyType_BOOL:
+		return boolValue(rawValue)
+	case pub.PropertyType_DATE, pub.PropertyType_DATETIME:
+		return timeValue(rawValue, source)
+	case pub.PropertyType_BLOB:
+		return bytesValue(rawValue, source == "BLOB")
+	case pub.PropertyType_TEXT:
+		return clobValue(rawValue, false)
+	case pub.PropertyType_JSON:
+		return jsonValue(rawValue)
+	default:
+		return stringValue(rawValue)
+	}
+}
+
+// describeValue returns the value for an error message, quoting strings and truncating long values.
+func describeValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = strconv.Quote(v)
+	case json.Number:
+		s = v.String()
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		s = string(b)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if len(s) > 50 {
+		s = s[:47] + "..."
+	}
+	return s
+}
+
+// numberText returns the text of a number, or of a string or boolean which holds one.
+func numberText(value interface{}) (string, error) {
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = strings.TrimSpace(v)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		text = strconv.Itoa(v)
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case bool:
+		// Oracle has no booleans in SQL, so they are written as 1 and 0
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	default:
+		return "", errors.Errorf("expected a number but got %T", value)
+	}
+	if !decimalPattern.MatchString(text) {
+		return "", errors.New("it is not a number")
+	}
+	return text, nil
+}
+
+// integerValue returns an int64, or a goracle.Number for integers too large for an int64.
+func integerValue(value interface{}) (interface{}, error) {
+	text, err := numberText(value)
+	if err != nil {
+		return nil, err
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, errors.New("it is not a number")
+	}
+	if !r.IsInt() {
+		return nil, errors.New("it is not a whole number")
+	}
+	if r.Num().IsInt64() {
+		return r.Num().Int64(), nil
+	}
+	return goracle.Number(r.Num().String()), nil
+}
+
+// decimalValue returns a goracle.Number, which is written to NUMBER columns without losing precision.
+func decimalValue(value interface{}) (interface{}, error) {
+	text, err := numberText(value)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(text, "eE") {
+		f, _, err := big.ParseFloat(text, 10, 256, big.ToNearestEven)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		text = f.Text('f', -1)
+	}
+	return goracle.Number(text), nil
+}
+
+func floatValue(value interface{}) (interface{}, error) {
+	text, err := numberText(value)
+	if err != nil {
+		return nil, err
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, errors.New("it is out of range")
+	}
+	return f, nil
+}
+
+// boolValue returns 1 or 0, which is how booleans are stored in NUMBER(1) columns.
+func boolValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case json.Number:
+		return boolValue(v.String())
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "t", "yes", "y", "1":
+			return int64(1), nil
+		case "false", "f", "no", "n", "0":
+			return int64(0), nil
+		}
+		return nil, errors.New("it is not a boolean")
+	default:
+		return nil, errors.Errorf("expected a boolean but got %T", value)
+	}
+}
+
+// timeValue parses an RFC 3339 timestamp, with or without its zone, which is UTC if it
+// is not set, or a date. A time written to a TIMESTAMP is returned as the text which
+// bindExpression converts, with its zone if the TIMESTAMP keeps it, and any other time
+// is bound as a DATE.
+func timeValue(value interface{}, source string) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, errors.Errorf("expected a timestamp but got %T", value)
+	}
+	s = strings.TrimSpace(s)
+
+	var t time.Time
+	var err error
+	for _, layout := range timeLayouts {
+		if t, err = time.Parse(layout, s); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		for _, layout := range timeLayoutsWithoutZones {
+			if t, err = time.ParseInLocation(layout, s, time.UTC); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, errors.New("it is not an RFC 3339 timestamp or a date")
+	}
+
+	switch {
+	case !isTimestamp(source):
+		return t, nil
+	case strings.Contains(source, "TIME ZONE"):
+		return t.Format(timestampTZLayout), nil
+	default:
+		return t.Format(timestampLayout), nil
+	}
+}
+
+// isTimestamp returns true if the type is a TIMESTAMP, with or without a zone.
+func isTimestamp(typeAtSource string) bool {
+	return strings.HasPrefix(strings.ToUpper(typeAtSource), "TIMESTAMP")
+}
+
+// bindExpression returns the expression a bind is written as to a column or argument of
+// the type, which converts the text a timestamp is bound as back to a timestamp.
+func bindExpression(typeAtSource, bind string) string {
+	switch {
+	case !isTimestamp(typeAtSource):
+		return bind
+	case strings.Contains(strings.ToUpper(typeAtSource), "TIME ZONE"):
+		return fmt.Sprintf("TO_TIMESTAMP_TZ(%s, '%s')", bind, timestampTZFormat)
+	default:
+		return fmt.Sprintf("TO_TIMESTAMP(%s, '%s')", bind, timestampFormat)
+	}
+}
+
+// bytesValue decodes a base64 string. Values too long to be bound
+// as a RAW are bound as LOBs if they are written to a BLOB.
+func bytesValue(value interface{}, blob bool) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, errors.Errorf("expected base64 but got %T", value)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		if b, err = base64.URLEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("it is not base64")
+		}
+	}
+	if blob && len(b) > maxRawBind {
+		return goracle.Lob{Reader: bytes.NewReader(b)}, nil
+	}
+	return b, nil
+}
+
+// clobValue returns a string, which is bound as a LOB if it is written to a CLOB and is too long to be bound as a VARCHAR2.
+func clobValue(value interface{}, clob bool) (interface{}, error) {
+	v, err := stringValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if s := v.(string); clob && len(s) > maxStringBind {
+		return goracle.Lob{Reader: strings.NewReader(s), IsClob: true}, nil
+	}
+	return v, nil
+}
+
+func stringValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return jsonValue(value)
+	}
+}
+
+// jsonValue returns the JSON of objects, arrays and scalars other than strings,
+// to be parsed in the database. Strings are assumed to hold JSON already.
+func jsonValue(value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(b), nil
+}
+
+// dayToSecondValue returns the literal of an INTERVAL DAY TO SECOND, such as +1 02:03:04.500000000,
+// from an ISO 8601 duration such as P1DT2H3M4.5S, a Go duration such as 26h3m4.5s, a number
+// of seconds or a literal.
+func dayToSecondValue(value interface{}) (interface{}, error) {
+	var d time.Duration
+	switch v := value.(type) {
+	case json.Number, float64:
+		text, err := numberText(v)
+		if err != nil {
+			return nil, err
+		}
+		seconds, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, errors.New("it is out of range")
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	case string:
+		s := strings.TrimSpace(v)
+		if dayToSecondPattern.MatchString(s) {
+			return s, nil
+		}
+		if m := isoDayToSecondPattern.FindStringSubmatch(s); m != nil && s != "P" && !strings.HasSuffix(s, "T") {
+			var days, hours, minutes int64
+			var seconds float64
+			days, _ = strconv.ParseInt("0"+m[2], 10, 64)
+			hours, _ = strconv.ParseInt("0"+m[3], 10, 64)
+			minutes, _ = strconv.ParseInt("0"+m[4], 10, 64)
+			seconds, _ = strconv.ParseFloat("0"+m[5], 64)
+			d = time.Duration(days)*24*time.Hour + time.Duration(hours)*time.Hour +
+				time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+			if m[1] == "-" {
+				d = -d
+			}
+			break
+		}
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return nil, errors.New("it is not an ISO 8601 duration, a duration or an interval")
+		}
+	default:
+		return nil, errors.Errorf("expected a duration but got %T", value)
+	}
+
+	sign := "+"
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	seconds := d / time.Second
+	d -= seconds * time.Second
+	return fmt.Sprintf("%s%d %02d:%02d:%02d.%09d", sign, days, hours, minutes, seconds, d), nil
+}
+
+// yearToMonthValue returns the literal of an INTERVAL YEAR TO MONTH, such as +1-02,
+// from an ISO 8601 duration such as P1Y2M, a number of months or a literal.
+func yearToMonthValue(value interface{}) (interface{}, error) {
+	var months int64
+	switch v := value.(type) {
+	case json.Number, float64:
+		i, err := integerValue(v)
+		if err != nil {
+			return nil, err
+		}
+		n, ok := i.(int64)
+		if !ok {
+			return nil, errors.New("it is out of range")
+		}
+		months = n
+	case string:
+		s := strings.TrimSpace(v)
+		if yearToMonthPattern.MatchString(s) {
+			return s, nil
+		}
+		m := isoYearToMonthPattern.FindStringSubmatch(s)
+		if m == nil || s == "P" {
+			return nil, errors.New("it is not an ISO 8601 duration in years and months or an interval")
+		}
+		years, _ := strconv.ParseInt("0"+m[2], 10, 64)
+		months, _ = strconv.ParseInt("0"+m[3], 10, 64)
+		months += years * 12
+		if m[1] == "-" {
+			months = -months
+		}
+	default:
+		return nil, errors.Errorf("expected a duration but got %T", value)
+	}
+
+	sign := "+"
+	if months < 0 {
+		sign, months = "-", -months
+	}
+	return fmt.Sprintf("%s%d-%02d", sign, months/12, months%12), nil
+}
@@ -0,0 +1,143 @@
+package internal_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	. "github.com/naveego/plugin-oracle/internal"
+	"github.com/naveego/plugin-oracle/internal/pub"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"gopkg.in/goracle.v2"
+)
+
+var _ = Describe("Write back values", func() {
+
+	property := func(t pub.PropertyType, source string) *pub.Property {
+		return &pub.Property{Id: "amount", Type: t, TypeAtSource: source}
+	}
+
+	DescribeTable("should convert values to the types they are written to",
+		func(prop *pub.Property, data string, expected interface{}) {
+			value, err := WriteValue(prop, data)
+			Expect(err).ToNot(HaveOccurred())
+			if expected == nil {
+				Expect(value).To(BeNil())
+			} else {
+				Expect(value).To(Equal(expected))
+			}
+		},
+		Entry("null", property(pub.PropertyType_DECIMAL, "NUMBER"), `null`, nil),
+		Entry("decimal without losing precision", property(pub.PropertyType_DECIMAL, "NUMBER"), `12345678901234567890.123456789`, goracle.Number("12345678901234567890.123456789")),
+		Entry("decimal string", property(pub.PropertyType_DECIMAL, "NUMBER"), `" 0.10 "`, goracle.Number("0.10")),
+		Entry("decimal exponent", property(pub.PropertyType_DECIMAL, "NUMBER"), `1.5e3`, goracle.Number("1500")),
+		Entry("integer", property(pub.PropertyType_INTEGER, "NUMBER(10)"), `42`, int64(42)),
+		Entry("whole integer", property(pub.PropertyType_INTEGER, "NUMBER(10)"), `"42.0"`, int64(42)),
+		Entry("large integer", property(pub.PropertyType_INTEGER, "NUMBER(38)"), `123456789012345678901234567890`, goracle.Number("123456789012345678901234567890")),
+		Entry("float string", property(pub.PropertyType_FLOAT, "BINARY_DOUBLE"), `"0.25"`, 0.25),
+		Entry("boolean to a number", property(pub.PropertyType_DECIMAL, "NUMBER(1)"), `true`, goracle.Number("1")),
+		Entry("boolean", property(pub.PropertyType_BOOL, ""), `"N"`, int64(0)),
+		Entry("timestamp with a zone", property(pub.PropertyType_DATETIME, "TIMESTAMP(6)"), `"2019-03-01T12:00:00.5+02:00"`, "2019-03-01T12:00:00.500000000"),
+		Entry("timestamp to a column with a zone", property(pub.PropertyType_DATETIME, "TIMESTAMP(6) WITH TIME ZONE"), `"2019-03-01T12:00:00.5+02:00"`,
+			"2019-03-01T12:00:00.500000000+02:00"),
+		Entry("timestamp to a column with the local zone", property(pub.PropertyType_DATETIME, "TIMESTAMP(6) WITH LOCAL TIME ZONE"), `"2019-03-01T12:00:00Z"`,
+			"2019-03-01T12:00:00.000000000+00:00"),
+		Entry("timestamp without a zone", property(pub.PropertyType_DATETIME, "TIMESTAMP(6)"), `"2019-03-01 12:00:00"`, "2019-03-01T12:00:00.000000000"),
+		Entry("timestamp to a date", property(pub.PropertyType_DATETIME, "DATE"), `"2019-03-01T12:00:00+02:00"`,
+			time.Date(2019, 3, 1, 12, 0, 0, 0, time.FixedZone("", 2*60*60))),
+		Entry("date", property(pub.PropertyType_DATE, "DATE"), `"2019-03-01"`, time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("base64", property(pub.PropertyType_BLOB, "RAW(16)"), `"aGVsbG8="`, []byte("hello")),
+		Entry("JSON", property(pub.PropertyType_JSON, ""), `{"a":[1,2.50]}`, `{"a":[1,2.50]}`),
+		Entry("number to a string", property(pub.PropertyType_STRING, "VARCHAR2(10)"), `1.10`, "1.10"),
+		Entry("ISO 8601 day to second interval", property(pub.PropertyType_STRING, "INTERVAL DAY(2) TO SECOND(6)"), `"P1DT2H3M4.5S"`, "+1 02:03:04.500000000"),
+		Entry("duration", property(pub.PropertyType_STRING, "INTERVAL DAY(2) TO SECOND(6)"), `"-90m"`, "-0 01:30:00.000000000"),
+		Entry("seconds", property(pub.PropertyType_STRING, "INTERVAL DAY(2) TO SECOND(6)"), `90061`, "+1 01:01:01.000000000"),
+		Entry("day to second literal", property(pub.PropertyType_STRING, "INTERVAL DAY(2) TO SECOND(6)"), `"3 04:05:06"`, "3 04:05:06"),
+		Entry("ISO 8601 year to month interval", property(pub.PropertyType_STRING, "INTERVAL YEAR(2) TO MONTH"), `"P1Y14M"`, "+2-02"),
+		Entry("months", property(pub.PropertyType_STRING, "INTERVAL YEAR(2) TO MONTH"), `-13`, "-1-01"),
+	)
+
+	It("should bind long strings written to CLOBs as LOBs", func() {
+		long := strings.Repeat("x", 5000)
+		value, err := WriteValue(property(pub.PropertyType_BLOB, "CLOB"), `"`+long+`"`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(BeAssignableToTypeOf(goracle.Lob{}))
+		Expect(value.(goracle.Lob).IsClob).To(BeTrue())
+		b, err := ioutil.ReadAll(value.(goracle.Lob))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal(long))
+
+		value, err = WriteValue(property(pub.PropertyType_BLOB, "CLOB"), `"short"`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("short"))
+	})
+
+	DescribeTable("should name the property and the problem in conversion errors",
+		func(prop *pub.Property, data string, message string) {
+			_, err := WriteValue(prop, data)
+			Expect(err).To(MatchError(message))
+		},
+		Entry("not a number", property(pub.PropertyType_DECIMAL, "NUMBER"), `"12a"`, `cannot convert amount value "12a" to NUMBER: it is not a number`),
+		Entry("fraction", property(pub.PropertyType_INTEGER, ""), `1.5`, `cannot convert amount value 1.5 to INTEGER: it is not a whole number`),
+		Entry("not a timestamp", property(pub.PropertyType_DATETIME, "DATE"), `"yesterday"`, `cannot convert amount value "yesterday" to DATE: it is not an RFC 3339 timestamp or a date`),
+		Entry("not base64", property(pub.PropertyType_BLOB, "BLOB"), `"%%%"`, `cannot convert amount value "%%%" to BLOB: it is not base64`),
+		Entry("not a boolean", property(pub.PropertyType_BOOL, ""), `[true]`, `cannot convert amount value [true] to BOOL: expected a boolean but got []interface {}`),
+	)
+
+	Describe("timestamps", func() {
+
+		// the driver binds times as DATEs, which keep neither fractional seconds nor zones
+		It("should bind timestamps as text which the statements convert", func() {
+			sut, db := newWriteServer(hclog.NewNullLogger())
+
+			schema := &pub.Schema{
+				Id: `"C##NAVEEGO"."SHIPMENTS"`,
+				Properties: []*pub.Property{
+					{Id: "id", Type: pub.PropertyType_INTEGER, TypeAtSource: "NUMBER(16,0)", IsKey: true},
+					{Id: "shipped", Type: pub.PropertyType_DATETIME, TypeAtSource: "TIMESTAMP(6) WITH TIME ZONE"},
+					{Id: "due", Type: pub.PropertyType_DATETIME, TypeAtSource: "DATE"},
+				},
+				PublisherMetaJson: `{"write":{"target":"Table","table":{
+					"table":"\"C##NAVEEGO\".\"SHIPMENTS\"",
+					"columns":["ID","SHIPPED_AT","DUE"],
+					"keyColumns":["ID"],
+					"batchSize":10}}}`,
+			}
+			_, err := sut.PrepareWrite(context.Background(), &pub.PrepareWriteRequest{Schema: schema, CommitSlaSeconds: 60})
+			Expect(err).ToNot(HaveOccurred())
+
+			stream := &writeStream{records: []*pub.Record{
+				{Action: pub.Record_INSERT, CorrelationId: "1", DataJson: `{"id":1,"shipped":"2019-03-01T12:00:00.25+02:00","due":"2019-03-02"}`},
+				{Action: pub.Record_INSERT, CorrelationId: "2", DataJson: `{"id":2,"shipped":"2019-03-01T23:59:59.999999-05:00","due":null}`},
+			}}
+			Expect(sut.WriteStream(stream)).To(Succeed())
+			for _, ack := range stream.recordAcks {
+				Expect(ack.Error).To(BeEmpty())
+			}
+
+			rows := db.Committed()
+			Expect(rows).To(HaveLen(2))
+			Expect(rows[0].query).To(Equal(`INSERT INTO "C##NAVEEGO"."SHIPMENTS" ("ID", "SHIPPED_AT", "DUE")
+VALUES (:p1, TO_TIMESTAMP_TZ(:p2, 'YYYY-MM-DD"T"HH24:MI:SS.FF9TZH:TZM'), :p3)`))
+			Expect(rows[0].values["p2"]).To(Equal("2019-03-01T12:00:00.250000000+02:00"))
+			Expect(rows[1].values["p2"]).To(Equal("2019-03-01T23:59:59.999999000-05:00"))
+			Expect(rows[0].values["p3"]).To(BeTemporally("==", time.Date(2019, 3, 2, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should call procedures with timestamp arguments by name, converting their binds", func() {
+			call, err := PackagedProcedureCallFor("C##NAVEEGO", "", "SHIP_ORDER", "", []ProcedureArgument{
+				{Name: "ORDER_ID", DataType: "NUMBER", InOut: "IN", Position: 1},
+				{Name: "SHIPPED_AT", DataType: "TIMESTAMP WITH TIME ZONE", InOut: "IN", Position: 2},
+				{Name: "DUE", DataType: "TIMESTAMP", InOut: "IN", Position: 3},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(call.Query).To(ContainSubstring(`SHIPPED_AT := TO_TIMESTAMP_TZ(:SHIPPED_AT, 'YYYY-MM-DD"T"HH24:MI:SS.FF9TZH:TZM');`))
+			Expect(call.Query).To(ContainSubstring(`DUE := TO_TIMESTAMP(:DUE, 'YYYY-MM-DD"T"HH24:MI:SS.FF9');`))
+			Expect(call.Query).To(ContainSubstring(`ORDER_ID := :ORDER_ID;`))
+		})
+	})
+})
@@ -0,0 +1,31 @@
+CREATE SEQUENCE "C##NAVEEGO"."NG$CS_40DDC160" CACHE 1000
+/
+CREATE TABLE "C##NAVEEGO"."NG$CL_40DDC160"
+(
+  "NAVEEGO$CHANGE_ID" NUMBER NOT NULL PRIMARY KEY,
+  "NAVEEGO$OPERATION" CHAR(1) NOT NULL,
+  "NAVEEGO$CHANGED_AT" TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL,
+  "AGENT_CODE" CHAR(4 BYTE),
+  "AGENT_NAME" VARCHAR2(40 BYTE),
+  "COMMISSION" BINARY_FLOAT,
+  "UPDATED_AT" TIMESTAMP(6) WITH TIME ZONE
+) ROWDEPENDENCIES
+/
+COMMENT ON TABLE "C##NAVEEGO"."NG$CL_40DDC160" IS 'Naveego change log v1 for "C##NAVEEGO"."AGENTS" columns BB6E020C'
+/
+CREATE OR REPLACE TRIGGER "C##NAVEEGO"."NG$CT_40DDC160"
+AFTER INSERT OR UPDATE OR DELETE ON "C##NAVEEGO"."AGENTS"
+FOR EACH ROW
+BEGIN
+  IF INSERTING THEN
+    INSERT INTO "C##NAVEEGO"."NG$CL_40DDC160" ("NAVEEGO$CHANGE_ID", "NAVEEGO$OPERATION", "AGENT_CODE", "AGENT_NAME", "COMMISSION", "UPDATED_AT")
+    VALUES ("C##NAVEEGO"."NG$CS_40DDC160".NEXTVAL, 'I', :NEW."AGENT_CODE", :NEW."AGENT_NAME", :NEW."COMMISSION", :NEW."UPDATED_AT");
+  ELSIF UPDATING THEN
+    INSERT INTO "C##NAVEEGO"."NG$CL_40DDC160" ("NAVEEGO$CHANGE_ID", "NAVEEGO$OPERATION", "AGENT_CODE", "AGENT_NAME", "COMMISSION", "UPDATED_AT")
+    VALUES ("C##NAVEEGO"."NG$CS_40DDC160".NEXTVAL, 'U', :NEW."AGENT_CODE", :NEW."AGENT_NAME", :NEW."COMMISSION", :NEW."UPDATED_AT");
+  ELSE
+    INSERT INTO "C##NAVEEGO"."NG$CL_40DDC160" ("NAVEEGO$CHANGE_ID", "NAVEEGO$OPERATION", "AGENT_CODE", "AGENT_NAME", "COMMISSION", "UPDATED_AT")
+    VALUES ("C##NAVEEGO"."NG$CS_40DDC160".NEXTVAL, 'D', :OLD."AGENT_CODE", :OLD."AGENT_NAME", :OLD."COMMISSION", :OLD."UPDATED_AT");
+  END IF;
+END;
+/
@@ -0,0 +1,6 @@
+DROP TRIGGER "C##NAVEEGO"."NG$CT_40DDC160"
+/
+DROP TABLE "C##NAVEEGO"."NG$CL_40DDC160" PURGE
+/
+DROP SEQUENCE "C##NAVEEGO"."NG$CS_40DDC160"
+/
@@ -0,0 +1,23 @@
+ALTER TABLE "C##NAVEEGO"."NG$CL_40DDC160" ADD (
+  "COMMISSION" BINARY_FLOAT,
+  "UPDATED_AT" TIMESTAMP(6) WITH TIME ZONE
+)
+/
+COMMENT ON TABLE "C##NAVEEGO"."NG$CL_40DDC160" IS 'Naveego change log v1 for "C##NAVEEGO"."AGENTS" columns BB6E020C'
+/
+CREATE OR REPLACE TRIGGER "C##NAVEEGO"."NG$CT_40DDC160"
+AFTER INSERT OR UPDATE OR DELETE ON "C##NAVEEGO"."AGENTS"
+FOR EACH ROW
+BEGIN
+  IF INSERTING THEN
+    INSERT INTO "C##NAVEEGO"."NG$CL_40DDC160" ("NAVEEGO$CHANGE_ID", "NAVEEGO$OPERATION", "AGENT_CODE", "AGENT_NAME", "COMMISSION", "UPDATED_AT")
+    VALUES ("C##NAVEEGO"."NG$CS_40DDC160".NEXTVAL, 'I', :NEW."AGENT_CODE", :NEW."AGENT_NAME", :NEW."COMMISSION", :NEW."UPDATED_AT");
+  ELSIF UPDATING THEN
+    INSERT INTO "C##NAVEEGO"."NG$CL_40DDC160" ("NAVEEGO$CHANGE_ID", "NAVEEGO$OPERATION", "AGENT_CODE", "AGENT_NAME", "COMMISSION", "UPDATED_AT")
+    VALUES ("C##NAVEEGO"."NG$CS_40DDC160".NEXTVAL, 'U', :NEW."AGENT_CODE", :NEW."AGENT_NAME", :NEW."COMMISSION", :NEW."UPDATED_AT");
+  ELSE
+    INSERT INTO "C##NAVEEGO"."NG$CL_40DDC160" ("NAVEEGO$CHANGE_ID", "NAVEEGO$OPERATION", "AGENT_CODE", "AGENT_NAME", "COMMISSION", "UPDATED_AT")
+    VALUES ("C##NAVEEGO"."NG$CS_40DDC160".NEXTVAL, 'D', :OLD."AGENT_CODE", :OLD."AGENT_NAME", :OLD."COMMISSION", :OLD."UPDATED_AT");
+  END IF;
+END;
+/
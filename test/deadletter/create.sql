@@ -0,0 +1,16 @@
+CREATE TABLE "C##NAVEEGO"."DEAD_LETTERS"
+(
+  "DEAD_LETTER_ID" NUMBER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "SCHEMA_ID" VARCHAR2(4000) NOT NULL,
+  "CORRELATION_ID" VARCHAR2(4000),
+  "ACTION" VARCHAR2(10) NOT NULL,
+  "DATA_JSON" CLOB,
+  "ERROR_CODE" NUMBER(5),
+  "ERROR_MESSAGE" VARCHAR2(4000),
+  "FAILED_AT" TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL,
+  "STATUS" VARCHAR2(10) DEFAULT 'FAILED' NOT NULL CHECK ("STATUS" IN ('FAILED', 'REPLAY', 'REPLAYED')),
+  "REPLAYED_AT" TIMESTAMP WITH TIME ZONE
+)
+/
+COMMENT ON TABLE "C##NAVEEGO"."DEAD_LETTERS" IS 'Naveego write-back dead letters. Set STATUS to REPLAY to write a record again.'
+/
@@ -0,0 +1,10 @@
+CREATE TABLE "C##NAVEEGO"."WRITTEN"
+(
+  "SCHEMA_ID" VARCHAR2(1000) NOT NULL,
+  "CORRELATION_ID" VARCHAR2(1000) NOT NULL,
+  "PROCESSED_AT" TIMESTAMP WITH TIME ZONE DEFAULT SYSTIMESTAMP NOT NULL,
+  PRIMARY KEY ("SCHEMA_ID", "CORRELATION_ID")
+) ORGANIZATION INDEX
+/
+COMMENT ON TABLE "C##NAVEEGO"."WRITTEN" IS 'Naveego write-back correlation IDs. A record with a correlation ID in this table is not written again.'
+/
@@ -0,0 +1,2 @@
+DECLARE P_ORDER "C##NAVEEGO"."ORDERS"%ROWTYPE;P_LINES "C##NAVEEGO"."LINE_TAB";P_TAGS "C##NAVEEGO"."ORDERS_API"."TAG_LIST";P_RESULT "C##NAVEEGO"."ORDERS_API"."RESULT_REC";NAVEEGO_O JSON_OBJECT_T;NAVEEGO_A JSON_ARRAY_T;NAVEEGO_I PLS_INTEGER; BEGIN IF :P_ORDER IS NOT NULL THEN NAVEEGO_O := JSON_OBJECT_T.parse(:P_ORDER); P_ORDER.ORDER_ID := NAVEEGO_O.get_number('ORDER_ID'); P_ORDER.CUSTOMER := NAVEEGO_O.get_string('CUSTOMER'); P_ORDER.ORDERED_AT := NAVEEGO_O.get_date('ORDERED_AT'); END IF; IF :P_LINES IS NOT NULL THEN NAVEEGO_A := JSON_ARRAY_T.parse(:P_LINES); P_LINES := "C##NAVEEGO"."LINE_TAB"(); FOR NAVEEGO_I IN 0 .. NAVEEGO_A.get_size - 1 LOOP P_LINES.EXTEND; NAVEEGO_O := TREAT(NAVEEGO_A.get(NAVEEGO_I) AS JSON_OBJECT_T); P_LINES(NAVEEGO_I + 1) := "C##NAVEEGO"."LINE_OBJ"(NULL, NULL); P_LINES(NAVEEGO_I + 1).PRODUCT := NAVEEGO_O.get_string('PRODUCT'); P_LINES(NAVEEGO_I + 1).QTY := NAVEEGO_O.get_number('QTY'); END LOOP; END IF; IF :P_TAGS IS NOT NULL THEN NAVEEGO_A := JSON_ARRAY_T.parse(:P_TAGS); FOR NAVEEGO_I IN 0 .. NAVEEGO_A.get_size - 1 LOOP P_TAGS(NAVEEGO_I + 1) := NAVEEGO_A.get_string(NAVEEGO_I); END LOOP; END IF; "C##NAVEEGO"."ORDERS_API"."SAVE_ORDER"(P_ORDER => P_ORDER, P_LINES => P_LINES, P_TAGS => P_TAGS, P_RESULT => P_RESULT); NAVEEGO_O := JSON_OBJECT_T(); NAVEEGO_O.put('STATUS', P_RESULT.STATUS); NAVEEGO_O.put('SHIPPED_AT', P_RESULT.SHIPPED_AT); :P_RESULT := NAVEEGO_O.to_string; END;
+/
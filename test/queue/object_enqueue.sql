@@ -0,0 +1,16 @@
+DECLARE
+  enqueue_options    DBMS_AQ.ENQUEUE_OPTIONS_T;
+  message_properties DBMS_AQ.MESSAGE_PROPERTIES_T;
+  msgid              RAW(16);
+BEGIN
+  message_properties.correlation := :correlation;
+  message_properties.delay := :delay;
+  message_properties.expiration := :expiration;
+  DBMS_AQ.ENQUEUE(
+    queue_name         => 'C##NAVEEGO.ORDER_EVENTS',
+    enqueue_options    => enqueue_options,
+    message_properties => message_properties,
+    payload            => "C##NAVEEGO"."ORDER_EVENT_T"(:p1, NULL, :p2),
+    msgid              => msgid);
+END;
+/
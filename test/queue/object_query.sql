@@ -0,0 +1,5 @@
+SELECT RAWTOHEX(T.MSGID), T.CORRID, TO_CHAR(T.ENQ_TIME, 'YYYY-MM-DD HH24:MI:SS.FF9'), TO_CHAR(T.PRIORITY, 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,'''), TO_CHAR(T.USER_DATA."ORDER_ID", 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,'''), T.USER_DATA."STATUS", TO_CHAR(T.USER_DATA."AMOUNT", 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,'''), TO_CHAR(T.USER_DATA."PLACED_AT", 'YYYY-MM-DD HH24:MI:SS')
+FROM "C##NAVEEGO"."ORDER_EVENTS_QT" T
+WHERE T.Q_NAME = 'ORDER_EVENTS' AND T.STATE = 0
+ORDER BY T.ENQ_TIME
+/
@@ -0,0 +1,34 @@
+DECLARE
+  dequeue_options    DBMS_AQ.DEQUEUE_OPTIONS_T;
+  message_properties DBMS_AQ.MESSAGE_PROPERTIES_T;
+  msgid              RAW(16);
+  payload            RAW(32767);
+BEGIN
+  dequeue_options.consumer_name := :consumer_name;
+  dequeue_options.dequeue_mode := DBMS_AQ.BROWSE;
+  dequeue_options.visibility := DBMS_AQ.ON_COMMIT;
+  dequeue_options.wait := :wait;
+  dequeue_options.deq_condition := :condition;
+  IF :first_message = 1 THEN
+    dequeue_options.navigation := DBMS_AQ.FIRST_MESSAGE;
+  ELSE
+    dequeue_options.navigation := DBMS_AQ.NEXT_MESSAGE;
+  END IF;
+  DBMS_AQ.DEQUEUE(
+    queue_name         => :queue_name,
+    dequeue_options    => dequeue_options,
+    message_properties => message_properties,
+    payload            => payload,
+    msgid              => msgid);
+  :msgid := RAWTOHEX(msgid);
+  :enqueue_time := TO_CHAR(message_properties.enqueue_time, 'YYYY-MM-DD HH24:MI:SS');
+  :f1 := RAWTOHEX(msgid);
+  :f2 := message_properties.correlation;
+  :f3 := TO_CHAR(CAST(message_properties.enqueue_time AS TIMESTAMP), 'YYYY-MM-DD HH24:MI:SS.FF9');
+  :f4 := TO_CHAR(message_properties.priority, 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,''');
+  IF UTL_RAW.LENGTH(payload) > 16383 THEN
+    RAISE_APPLICATION_ERROR(-20000, 'The payload of message ' || RAWTOHEX(msgid) || ' is larger than 16383 bytes.');
+  END IF;
+  :payload := RAWTOHEX(payload);
+END;
+/
@@ -0,0 +1,13 @@
+CREATE GLOBAL TEMPORARY TABLE "C##NAVEEGO"."ORDER_LINES_STAGE"
+ON COMMIT PRESERVE ROWS
+AS SELECT t."ORDER_ID", t."LINE_NO", t."Product Name", t."QTY$",
+  CAST(NULL AS NUMBER) AS "NAVEEGO_SEQ",
+  CAST(NULL AS VARCHAR2(10)) AS "NAVEEGO_ACTION",
+  CAST(NULL AS VARCHAR2(4000)) AS "NAVEEGO_CORRELATION_ID"
+FROM "C##NAVEEGO"."ORDER_LINES" t
+WHERE 1 = 0
+/
+ALTER TABLE "C##NAVEEGO"."ORDER_LINES_STAGE" MODIFY ("Product Name" NULL)
+/
+COMMENT ON TABLE "C##NAVEEGO"."ORDER_LINES_STAGE" IS 'Naveego write-back staging table for "C##NAVEEGO"."ORDER_LINES".'
+/
@@ -0,0 +1,21 @@
+INSERT INTO "C##NAVEEGO"."ORDER_LINES_STAGE" ("ORDER_ID", "LINE_NO", "Product Name", "QTY$", "NAVEEGO_SEQ", "NAVEEGO_ACTION", "NAVEEGO_CORRELATION_ID")
+VALUES (:p1, :p2, :p3, :p4, :seq, :action, :correlation_id)
+/
+INSERT /*+ APPEND_VALUES */ INTO "C##NAVEEGO"."ORDER_LINES_STAGE" ("ORDER_ID", "LINE_NO", "Product Name", "QTY$", "NAVEEGO_SEQ", "NAVEEGO_ACTION", "NAVEEGO_CORRELATION_ID")
+VALUES (:p1, :p2, :p3, :p4, :seq, :action, :correlation_id)
+/
+DELETE FROM "C##NAVEEGO"."ORDER_LINES"
+WHERE ("ORDER_ID", "LINE_NO") IN (SELECT "ORDER_ID", "LINE_NO" FROM (SELECT "ORDER_ID", "LINE_NO", "Product Name", "QTY$", "NAVEEGO_ACTION" FROM (
+  SELECT r.*, ROW_NUMBER() OVER (PARTITION BY r."ORDER_ID", r."LINE_NO" ORDER BY "NAVEEGO_SEQ" DESC) AS "NAVEEGO_RANK"
+  FROM "C##NAVEEGO"."ORDER_LINES_STAGE" r
+) WHERE "NAVEEGO_RANK" = 1) WHERE "NAVEEGO_ACTION" = 'DELETE')
+/
+MERGE INTO "C##NAVEEGO"."ORDER_LINES" t
+USING (SELECT * FROM (SELECT "ORDER_ID", "LINE_NO", "Product Name", "QTY$", "NAVEEGO_ACTION" FROM (
+  SELECT r.*, ROW_NUMBER() OVER (PARTITION BY r."ORDER_ID", r."LINE_NO" ORDER BY "NAVEEGO_SEQ" DESC) AS "NAVEEGO_RANK"
+  FROM "C##NAVEEGO"."ORDER_LINES_STAGE" r
+) WHERE "NAVEEGO_RANK" = 1) WHERE "NAVEEGO_ACTION" <> 'DELETE') s
+ON (t."ORDER_ID" = s."ORDER_ID" AND t."LINE_NO" = s."LINE_NO")
+WHEN MATCHED THEN UPDATE SET t."Product Name" = s."Product Name", t."QTY$" = s."QTY$"
+WHEN NOT MATCHED THEN INSERT ("ORDER_ID", "LINE_NO", "Product Name", "QTY$") VALUES (s."ORDER_ID", s."LINE_NO", s."Product Name", s."QTY$")
+/
@@ -0,0 +1,13 @@
+CREATE TABLE "C##NAVEEGO"."LANDING_ORDERS"
+(
+  "ORDER_ID" NUMBER(16,0) NOT NULL,
+  "LINE_NO" NUMBER(16,0) NOT NULL,
+  "CUSTOMER" VARCHAR2(80) NOT NULL,
+  "NOTES" CLOB,
+  "AMOUNT" NUMBER,
+  "SHIPPED_AT" TIMESTAMP WITH TIME ZONE,
+  PRIMARY KEY ("ORDER_ID", "LINE_NO")
+)
+TABLESPACE "LANDING"
+ROW STORE COMPRESS BASIC
+/
@@ -0,0 +1,11 @@
+MERGE INTO "C##NAVEEGO"."ORDER_LINES" t
+USING (SELECT :p1 AS "ORDER_ID", :p2 AS "LINE_NO" FROM DUAL) s
+ON (t."ORDER_ID" = s."ORDER_ID" AND t."LINE_NO" = s."LINE_NO")
+WHEN NOT MATCHED THEN INSERT ("ORDER_ID", "LINE_NO") VALUES (s."ORDER_ID", s."LINE_NO")
+/
+INSERT INTO "C##NAVEEGO"."ORDER_LINES" ("ORDER_ID", "LINE_NO")
+VALUES (:p1, :p2)
+/
+DELETE FROM "C##NAVEEGO"."ORDER_LINES"
+WHERE "ORDER_ID" = :p1 AND "LINE_NO" = :p2
+/
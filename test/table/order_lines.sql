@@ -0,0 +1,16 @@
+MERGE INTO "C##NAVEEGO"."ORDER_LINES" t
+USING (SELECT :p1 AS "ORDER_ID", :p2 AS "LINE_NO", :p3 AS "Product Name", :p4 AS "QTY$", :p5 AS "SHIPPED_AT" FROM DUAL) s
+ON (t."ORDER_ID" = s."ORDER_ID" AND t."LINE_NO" = s."LINE_NO")
+WHEN MATCHED THEN UPDATE SET t."Product Name" = s."Product Name", t."QTY$" = s."QTY$", t."SHIPPED_AT" = s."SHIPPED_AT"
+WHEN NOT MATCHED THEN INSERT ("ORDER_ID", "LINE_NO", "Product Name", "QTY$", "SHIPPED_AT") VALUES (s."ORDER_ID", s."LINE_NO", s."Product Name", s."QTY$", s."SHIPPED_AT")
+/
+INSERT INTO "C##NAVEEGO"."ORDER_LINES" ("ORDER_ID", "LINE_NO", "Product Name", "QTY$", "SHIPPED_AT")
+VALUES (:p1, :p2, :p3, :p4, :p5)
+/
+UPDATE "C##NAVEEGO"."ORDER_LINES"
+SET "Product Name" = :p3, "QTY$" = :p4, "SHIPPED_AT" = :p5
+WHERE "ORDER_ID" = :p1 AND "LINE_NO" = :p2
+/
+DELETE FROM "C##NAVEEGO"."ORDER_LINES"
+WHERE "ORDER_ID" = :p1 AND "LINE_NO" = :p2
+/